@@ -0,0 +1,246 @@
+package bulkinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"slices"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/versiontype"
+)
+
+// BulkAction はBulk APIのアクションです。
+type BulkAction string
+
+const (
+	// ActionIndex はドキュメントを登録し、同じIDのドキュメントがあれば上書きします。
+	ActionIndex BulkAction = "index"
+	// ActionCreate はドキュメントを登録します。同じIDのドキュメントがある場合は409(競合)になります。
+	ActionCreate BulkAction = "create"
+	// ActionUpdate は既存のドキュメントを部分更新、またはスクリプトで更新します。
+	ActionUpdate BulkAction = "update"
+	// ActionDelete はドキュメントを削除します。
+	ActionDelete BulkAction = "delete"
+)
+
+// Script はスクリプトによる更新の内容です。
+type Script struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// BulkOperation はBulk APIで送信する1件の操作です。
+// 1回のBulkの中で、異なるアクションの操作を混在させることができます。
+type BulkOperation struct {
+	Action BulkAction
+	// Index は操作対象のインデックスです。空の場合はBulkに渡したインデックスを使用します。
+	Index      string
+	DocumentID string
+	// Routing はルーティング値です。空の場合はWithRoutingで設定した既定値を使用します。
+	Routing string
+	// Pipeline はindexとcreateで使用する取り込みパイプラインです。空の場合はWithPipelineで設定した既定値を使用します。
+	Pipeline string
+	// Document はindexとcreateではドキュメント全体、updateでは部分更新する内容です。
+	Document interface{}
+	// DocAsUpsert はupdateでドキュメントが存在しない場合に、Documentをそのまま登録します。
+	DocAsUpsert bool
+	// Script はupdateで使用するスクリプトです。
+	Script *Script
+	// Upsert はスクリプトによるupdateで、ドキュメントが存在しない場合に登録する内容です。
+	Upsert interface{}
+	// RetryOnConflict はupdateがバージョンの競合で失敗した場合に、Elasticsearch側で再試行する回数です。
+	RetryOnConflict *int
+	// IfSeqNo とIfPrimaryTerm は、ドキュメントが読み込んだ時点から変更されていない場合のみ書き込む条件です。
+	IfSeqNo       *int64
+	IfPrimaryTerm *int64
+	// Version は外部バージョン(version_type: external)です。登録済みのバージョンより大きい場合のみ書き込みます。
+	Version *int64
+}
+
+// IfMatch は_seq_noと_primary_termが一致する場合のみ書き込むよう条件を付けた操作を返します。
+func (op BulkOperation) IfMatch(seqNo, primaryTerm int64) BulkOperation {
+	op.IfSeqNo, op.IfPrimaryTerm = &seqNo, &primaryTerm
+	return op
+}
+
+// WithExternalVersion は外部バージョンを付けた操作を返します。
+func (op BulkOperation) WithExternalVersion(version int64) BulkOperation {
+	op.Version = &version
+	return op
+}
+
+// IndexOperation はドキュメントを登録(上書き)する操作を作成します。
+func IndexOperation(id string, doc interface{}) BulkOperation {
+	return BulkOperation{Action: ActionIndex, DocumentID: id, Document: doc}
+}
+
+// CreateOperation はドキュメントが存在しない場合のみ登録する操作を作成します。
+func CreateOperation(id string, doc interface{}) BulkOperation {
+	return BulkOperation{Action: ActionCreate, DocumentID: id, Document: doc}
+}
+
+// UpdateOperation はドキュメントを部分更新する操作を作成します。
+func UpdateOperation(id string, partial interface{}) BulkOperation {
+	return BulkOperation{Action: ActionUpdate, DocumentID: id, Document: partial}
+}
+
+// UpsertOperation はドキュメントを部分更新し、存在しない場合は登録する(doc_as_upsert)操作を作成します。
+func UpsertOperation(id string, doc interface{}) BulkOperation {
+	return BulkOperation{Action: ActionUpdate, DocumentID: id, Document: doc, DocAsUpsert: true}
+}
+
+// ScriptedUpdateOperation はスクリプトでドキュメントを更新する操作を作成します。
+// upsertがnilでない場合、ドキュメントが存在しなければupsertを登録します。
+func ScriptedUpdateOperation(id string, script Script, upsert interface{}) BulkOperation {
+	return BulkOperation{Action: ActionUpdate, DocumentID: id, Script: &script, Upsert: upsert}
+}
+
+// DeleteOperation はドキュメントを削除する操作を作成します。
+func DeleteOperation(id string) BulkOperation {
+	return BulkOperation{Action: ActionDelete, DocumentID: id}
+}
+
+// validateGuard は楽観的排他制御の条件の組み合わせを確認します。
+func (op BulkOperation) validateGuard() error {
+	if (op.IfSeqNo == nil) != (op.IfPrimaryTerm == nil) {
+		return fmt.Errorf("if_seq_no and if_primary_term must be set together")
+	}
+	if op.Version != nil && op.IfSeqNo != nil {
+		return fmt.Errorf("external version cannot be combined with if_seq_no")
+	}
+	if op.Version != nil && op.Action == ActionUpdate {
+		return fmt.Errorf("update operation does not support external versioning")
+	}
+	return nil
+}
+
+// body はアクションに応じたBulk APIのボディ行を返します。deleteにはボディがありません。
+func (op BulkOperation) body() ([]byte, error) {
+	if err := op.validateGuard(); err != nil {
+		return nil, err
+	}
+	switch op.Action {
+	case ActionIndex, ActionCreate:
+		if op.Document == nil {
+			return nil, fmt.Errorf("%s operation requires a document", op.Action)
+		}
+		return json.Marshal(op.Document)
+	case ActionUpdate:
+		if op.DocumentID == "" {
+			return nil, fmt.Errorf("update operation requires a document ID")
+		}
+		body := map[string]interface{}{}
+		switch {
+		case op.Script != nil:
+			body["script"] = op.Script
+			if op.Upsert != nil {
+				body["upsert"] = op.Upsert
+			}
+		case op.Document != nil:
+			body["doc"] = op.Document
+			if op.DocAsUpsert {
+				body["doc_as_upsert"] = true
+			}
+		default:
+			return nil, fmt.Errorf("update operation requires a document or a script")
+		}
+		return json.Marshal(body)
+	case ActionDelete:
+		if op.DocumentID == "" {
+			return nil, fmt.Errorf("delete operation requires a document ID")
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown bulk action %q", op.Action)
+}
+
+// ActionOutcome はアクションごとの結果の集計です。
+type ActionOutcome struct {
+	Succeeded uint64
+	Failed    uint64
+	// Conflicts はFailedのうち、バージョンの競合(409)で失敗した件数です。
+	// createで既にドキュメントが存在した場合と、楽観的排他制御の条件を満たさなかった場合が含まれます。
+	Conflicts uint64
+}
+
+// Outcome はアクションごとの成功件数と失敗件数を返します。
+func (r *BulkResult) Outcome(action BulkAction) ActionOutcome {
+	var outcome ActionOutcome
+	switch action {
+	case ActionIndex:
+		outcome.Succeeded = r.Indexed
+	case ActionCreate:
+		outcome.Succeeded = r.Created
+	case ActionUpdate:
+		outcome.Succeeded = r.Updated
+	case ActionDelete:
+		outcome.Succeeded = r.Deleted
+	}
+	for _, f := range r.Failures {
+		if f.Action != string(action) {
+			continue
+		}
+		outcome.Failed++
+		if f.Status == http.StatusConflict {
+			outcome.Conflicts++
+		}
+	}
+	for _, f := range r.Conflicts {
+		if f.Action == string(action) {
+			outcome.Failed++
+			outcome.Conflicts++
+		}
+	}
+	return outcome
+}
+
+// Bulk は異なるアクションが混在した操作をBulk APIで送信します。
+func (c *Client) Bulk(ctx context.Context, index string, ops []BulkOperation) (*BulkResult, error) {
+	return c.BulkSeq(ctx, index, slices.Values(ops))
+}
+
+// BulkSeq はイテレータから受け取った操作をBulk APIで送信します。
+func (c *Client) BulkSeq(ctx context.Context, index string, ops iter.Seq[BulkOperation]) (*BulkResult, error) {
+	collector := newBulkResultCollector(c.retryPolicy)
+	bulkCfg := esutil.BulkIndexerConfig{
+		Client:  c.baseClient,
+		Index:   index,
+		OnError: collector.onError,
+	}
+	writer := c.newBulkWriter(bulkCfg, collector)
+
+	n := 0
+	for op := range ops {
+		n++
+		data, err := op.body()
+		if err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("invalid operation %d: %w", n, err))
+		}
+
+		item := esutil.BulkIndexerItem{
+			Index:           op.Index,
+			Action:          string(op.Action),
+			DocumentID:      op.DocumentID,
+			Routing:         op.Routing,
+			RetryOnConflict: op.RetryOnConflict,
+			IfSeqNo:         op.IfSeqNo,
+			IfPrimaryTerm:   op.IfPrimaryTerm,
+		}
+		if op.Version != nil {
+			item.Version = op.Version
+			item.VersionType = versiontype.External.String()
+		}
+		if data != nil {
+			item.Body = bytes.NewReader(data)
+		}
+		if err := writer.add(ctx, op.Pipeline, item); err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("failed to add operation %d to bulk indexer: %w", n, err))
+		}
+	}
+	return c.finishBulk(ctx, writer)
+}
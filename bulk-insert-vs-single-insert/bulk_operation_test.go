@@ -0,0 +1,193 @@
+package bulkinsert
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestBulkOperationBody(t *testing.T) {
+	tests := []struct {
+		name string
+		op   BulkOperation
+		want string
+	}{
+		{
+			name: "index",
+			op:   IndexOperation("1", map[string]string{"title": "Batman"}),
+			want: `{"title":"Batman"}`,
+		},
+		{
+			name: "create",
+			op:   CreateOperation("1", map[string]string{"title": "Batman"}),
+			want: `{"title":"Batman"}`,
+		},
+		{
+			name: "partial update",
+			op:   UpdateOperation("1", map[string]string{"author": "Bob Kane"}),
+			want: `{"doc":{"author":"Bob Kane"}}`,
+		},
+		{
+			name: "upsert",
+			op:   UpsertOperation("1", map[string]string{"title": "Batman"}),
+			want: `{"doc":{"title":"Batman"},"doc_as_upsert":true}`,
+		},
+		{
+			name: "scripted update",
+			op: ScriptedUpdateOperation("1", Script{
+				Source: "ctx._source.views += params.n",
+				Params: map[string]interface{}{"n": 1},
+			}, map[string]int{"views": 1}),
+			want: `{"script":{"source":"ctx._source.views += params.n","params":{"n":1}},"upsert":{"views":1}}`,
+		},
+		{
+			name: "delete",
+			op:   DeleteOperation("1"),
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op.body()
+			if err != nil {
+				t.Fatalf("body() failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkOperationBodyInvalid(t *testing.T) {
+	for _, op := range []BulkOperation{
+		{Action: ActionIndex, DocumentID: "1"},
+		{Action: ActionUpdate, DocumentID: "1"},
+		{Action: ActionUpdate, Document: map[string]string{}},
+		{Action: ActionDelete},
+		{Action: "merge", DocumentID: "1"},
+	} {
+		if _, err := op.body(); err == nil {
+			t.Errorf("expected an error for %+v", op)
+		}
+	}
+}
+
+func TestBulkResultOutcome(t *testing.T) {
+	result := &BulkResult{
+		Created: 3,
+		Deleted: 1,
+		Failures: []BulkItemFailure{
+			{Action: "create", DocumentID: "1", Status: http.StatusConflict, ErrorType: "version_conflict_engine_exception"},
+			{Action: "create", DocumentID: "2", Status: http.StatusBadRequest, ErrorType: "mapper_parsing_exception"},
+			{Action: "delete", DocumentID: "3", Status: http.StatusNotFound, Reason: "not_found"},
+		},
+	}
+
+	if got, want := result.Outcome(ActionCreate), (ActionOutcome{Succeeded: 3, Failed: 2, Conflicts: 1}); got != want {
+		t.Errorf("Outcome(create) = %+v, want %+v", got, want)
+	}
+	if got, want := result.Outcome(ActionDelete), (ActionOutcome{Succeeded: 1, Failed: 1}); got != want {
+		t.Errorf("Outcome(delete) = %+v, want %+v", got, want)
+	}
+}
+
+func TestBulkInsertRequestFailure(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// リクエスト全体が失敗した場合も、アイテムごとに失敗が記録されます。
+	srv.Inject(esfake.Fault{Path: "/*/_bulk", Status: http.StatusInternalServerError, Times: 1})
+	result, err := client.BulkInsert(context.Background(), "test", generateDocs(5))
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Failed != 5 || uint64(len(result.Failures)) != result.Failed {
+		t.Fatalf("expected a failure for each of the 5 documents, got %+v", result)
+	}
+	for _, f := range result.Failures {
+		if f.Action != "index" || f.DocumentID == "" || f.Status != http.StatusInternalServerError {
+			t.Errorf("unexpected failure: %+v", f)
+		}
+	}
+
+	// リクエスト全体が拒否された場合は、アイテムごとに再送します。
+	srv.Inject(esfake.Fault{Path: "/*/_bulk", Status: http.StatusTooManyRequests, Times: 1})
+	client.WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	result, err = client.BulkInsert(context.Background(), "test", generateDocs(5))
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Indexed != 5 || result.Retried != 5 || result.Failed != 0 || len(result.Failures) != 0 {
+		t.Errorf("expected the rejected request to be retried, got %+v", result)
+	}
+}
+
+// blockingTransport はBulk APIのリクエストを、取り消されるかreleaseが閉じられるまで止めます。
+type blockingTransport struct {
+	started  chan struct{}
+	release  chan struct{}
+	canceled atomic.Int32
+	sent     atomic.Int32
+}
+
+func (t *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/_bulk") {
+		select {
+		case t.started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-req.Context().Done():
+			t.canceled.Add(1)
+			return nil, req.Context().Err()
+		case <-t.release:
+		}
+		t.sent.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestBulkSeqDiscardsOperationsOnError(t *testing.T) {
+	srv := esfake.New(t)
+	transport := &blockingTransport{started: make(chan struct{}, 1), release: make(chan struct{})}
+	t.Cleanup(func() { close(transport.release) })
+	cfg := srv.Config()
+	cfg.Transport = transport
+	cfg.DisableRetry = true
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// FlushBytesを超える1件目の操作は、2件目を追加したときに送信されます。
+	// 送信中に不正な操作でエラーを返す場合、送信中の操作も、それまでに追加した操作も登録しません。
+	ops := func(yield func(BulkOperation) bool) {
+		if !yield(IndexOperation("1", map[string]string{"content": strings.Repeat("x", 5*1024*1024)})) {
+			return
+		}
+		if !yield(IndexOperation("2", map[string]string{"title": "Superman"})) {
+			return
+		}
+		<-transport.started
+		yield(BulkOperation{Action: ActionDelete})
+	}
+	if _, err := client.BulkSeq(context.Background(), "test", ops); err == nil {
+		t.Fatal("expected an error for the invalid operation")
+	}
+	if transport.canceled.Load() == 0 || transport.sent.Load() != 0 {
+		t.Errorf("expected every bulk request to be canceled before BulkSeq returns, got %d canceled and %d sent",
+			transport.canceled.Load(), transport.sent.Load())
+	}
+	if idx, ok := srv.Index("test"); ok && len(idx.Documents) > 0 {
+		t.Errorf("expected no documents to be indexed, got %d", len(idx.Documents))
+	}
+}
@@ -0,0 +1,205 @@
+package bulkinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// BulkItemFailure はBulk APIで登録に失敗した1件のドキュメントを表します。
+// ErrorTypeを見ることで、マッピングの不整合(mapper_parsing_exception)と
+// クラスタの過負荷(es_rejected_execution_exception)などを区別できます。
+type BulkItemFailure struct {
+	Action     string
+	DocumentID string
+	Status     int
+	ErrorType  string
+	Reason     string
+}
+
+func (f BulkItemFailure) String() string {
+	return fmt.Sprintf("%s document %s: [%d] %s: %s", f.Action, f.DocumentID, f.Status, f.ErrorType, f.Reason)
+}
+
+// Retryable は時間をおいて再送すれば成功する可能性のある失敗かどうかを返します。
+// マッピングの不整合などの恒久的なエラーはリトライしません。
+func (f BulkItemFailure) Retryable() bool {
+	return esbulk.Retryable(f.Status, f.ErrorType)
+}
+
+// BulkResult はBulk APIによる登録結果の集計です。
+type BulkResult struct {
+	Indexed  uint64
+	Created  uint64
+	Updated  uint64
+	Deleted  uint64
+	Failed   uint64
+	Retried  uint64
+	Failures []BulkItemFailure
+	// Conflicts は楽観的排他制御の条件を満たさず、書き込まれなかったドキュメントです。
+	// Failuresとは別に集計し、Failedにも含めません。
+	Conflicts []BulkItemFailure
+}
+
+// pendingItem はリトライ待ちのアイテムと、直近の失敗内容です。
+type pendingItem struct {
+	item     esutil.BulkIndexerItem
+	failure  BulkItemFailure
+	pipeline string
+}
+
+// bulkResultCollector はBulkIndexerのコールバックから登録結果を集計します。
+// コールバックはBulkIndexerのワーカーから並行して呼ばれるため、mutexで保護します。
+type bulkResultCollector struct {
+	mu          sync.Mutex
+	policy      *RetryPolicy
+	started     time.Time
+	stats       esutil.BulkIndexerStats
+	retried     uint64
+	failures    []BulkItemFailure
+	pending     []pendingItem
+	conflicts   []pendingItem
+	flushErrors []error
+}
+
+// newBulkResultCollector はリトライポリシーを指定してcollectorを作成します。
+// policyがnilの場合、失敗したアイテムはリトライせずにそのまま記録します。
+func newBulkResultCollector(policy *RetryPolicy) *bulkResultCollector {
+	return &bulkResultCollector{
+		policy:  policy,
+		started: time.Now(),
+	}
+}
+
+// onFailureFor はpipelineで送信するアイテムのOnFailureを返します。
+// 再送するときに同じパイプラインを使うため、リトライ待ちのアイテムにはpipelineを記録します。
+func (c *bulkResultCollector) onFailureFor(pipeline string) func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem, error) {
+	return func(_ context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		c.onFailure(item, res, err, pipeline)
+	}
+}
+
+func (c *bulkResultCollector) onFailure(item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error, pipeline string) {
+	failure := BulkItemFailure{
+		Action:     item.Action,
+		DocumentID: item.DocumentID,
+		Status:     res.Status,
+		ErrorType:  res.Error.Type,
+		Reason:     res.Error.Reason,
+	}
+	if failure.DocumentID == "" {
+		failure.DocumentID = res.DocumentID
+	}
+	if failure.Reason == "" {
+		// 存在しないドキュメントのdelete(not_found)などは、エラーではなく結果のみが返されます。
+		failure.Reason = res.Result
+	}
+	if err != nil {
+		failure.Reason = err.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if failure.Status == http.StatusConflict && err == nil && isConflictGuarded(item) {
+		c.conflicts = append(c.conflicts, pendingItem{item: item, failure: failure, pipeline: pipeline})
+		return
+	}
+	if c.policy != nil && err == nil && failure.Retryable() {
+		c.pending = append(c.pending, pendingItem{item: item, failure: failure, pipeline: pipeline})
+		return
+	}
+	c.failures = append(c.failures, failure)
+}
+
+// onError はBulkIndexerのエラーを記録します。
+// リクエスト全体の失敗は、ItemizingTransportによりアイテムごとの失敗として記録済みのため数えません。
+func (c *bulkResultCollector) onError(_ context.Context, err error) {
+	if esbulk.IsRequestError(err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushErrors = append(c.flushErrors, err)
+}
+
+// addStats は1回分のBulkIndexerの統計情報を加算します。
+func (c *bulkResultCollector) addStats(stats esutil.BulkIndexerStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.NumIndexed += stats.NumIndexed
+	c.stats.NumCreated += stats.NumCreated
+	c.stats.NumUpdated += stats.NumUpdated
+	c.stats.NumDeleted += stats.NumDeleted
+	c.stats.NumFailed += stats.NumFailed
+}
+
+// takePending はリトライ待ちのアイテムを取り出します。
+// 取り出したアイテムは再送されるため、失敗件数からは除外します。
+func (c *bulkResultCollector) takePending() []pendingItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := c.pending
+	c.retried += uint64(len(items))
+	c.stats.NumFailed -= uint64(len(items))
+	c.pending = nil
+	return items
+}
+
+// takeConflicts は競合したアイテムのうち、retryableがtrueを返すものを再送のために取り出します。
+func (c *bulkResultCollector) takeConflicts(retryable func(pendingItem) bool) []pendingItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var taken, kept []pendingItem
+	for _, p := range c.conflicts {
+		if retryable(p) {
+			taken = append(taken, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	c.conflicts = kept
+	c.retried += uint64(len(taken))
+	c.stats.NumFailed -= uint64(len(taken))
+	return taken
+}
+
+// result は集計したBulkResultを返します。
+// リトライしきれなかったアイテムは失敗として扱い、
+// リクエスト単位で失敗したフラッシュがあった場合はエラーも返します。
+func (c *bulkResultCollector) result() (*BulkResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pending {
+		c.failures = append(c.failures, p.failure)
+	}
+	c.pending = nil
+
+	conflicts := make([]BulkItemFailure, 0, len(c.conflicts))
+	for _, p := range c.conflicts {
+		conflicts = append(conflicts, p.failure)
+	}
+
+	result := &BulkResult{
+		Indexed:   c.stats.NumIndexed,
+		Created:   c.stats.NumCreated,
+		Updated:   c.stats.NumUpdated,
+		Deleted:   c.stats.NumDeleted,
+		Failed:    c.stats.NumFailed - uint64(len(conflicts)),
+		Retried:   c.retried,
+		Failures:  c.failures,
+		Conflicts: conflicts,
+	}
+	if len(c.flushErrors) > 0 {
+		return result, fmt.Errorf("bulk indexer reported errors: %w", errors.Join(c.flushErrors...))
+	}
+	return result, nil
+}
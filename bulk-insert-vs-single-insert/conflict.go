@@ -0,0 +1,286 @@
+package bulkinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/get"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/optype"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/versiontype"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// ErrVersionConflict は楽観的排他制御(if_seq_no/if_primary_termまたは外部バージョン)による書き込みが競合したことを表します。
+var ErrVersionConflict = errors.New("version conflict")
+
+// VersionConflictError は1件のドキュメントの書き込みが競合したことを表します。errors.Is(err, ErrVersionConflict)で判定できます。
+type VersionConflictError struct {
+	Index      string
+	DocumentID string
+	Reason     string
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict on document %s in %s: %s", e.DocumentID, e.Index, e.Reason)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// ConflictResolution はバージョンの競合が起きた場合の扱いです。
+type ConflictResolution int
+
+const (
+	// ConflictFail は競合をエラーとして返します。
+	ConflictFail ConflictResolution = iota
+	// ConflictSkip は競合したドキュメントを書き込まずに処理を続けます。
+	ConflictSkip
+	// ConflictRetry は最新のドキュメントを読み直し、その_seq_noと_primary_termで再度書き込みます。
+	// 外部バージョンの競合は既により新しいバージョンが登録されていることを表すため、再試行せずにConflictFailと同様に扱います。
+	ConflictRetry
+)
+
+// ConflictPolicy はバージョンの競合を解決する方法を定義します。
+type ConflictPolicy struct {
+	Resolution ConflictResolution
+	// MaxRetries はConflictRetryで読み直して再送する最大回数です。省略時は3回です。
+	MaxRetries int
+	// Merge はConflictRetryで再送するドキュメントを、最新のドキュメント(current)と書き込もうとしたドキュメント(desired)から作成します。
+	// 省略時はdesiredをそのまま再送します。indexアクションにのみ適用されます。
+	Merge func(current, desired json.RawMessage) (json.RawMessage, error)
+}
+
+func (p ConflictPolicy) maxRetries() int {
+	if p.MaxRetries <= 0 {
+		return 3
+	}
+	return p.MaxRetries
+}
+
+// WithConflictPolicy は楽観的排他制御による書き込みが競合した場合の扱いを設定します。
+// 設定しない場合はConflictFailとして扱います。
+func (c *Client) WithConflictPolicy(policy ConflictPolicy) *Client {
+	c.conflictPolicy = policy
+	return c
+}
+
+// isConflictGuarded はアイテムが楽観的排他制御の条件付きで書き込まれるかどうかを返します。
+func isConflictGuarded(item esutil.BulkIndexerItem) bool {
+	return item.IfSeqNo != nil || item.Version != nil
+}
+
+// finishBulk はwriterのBulkIndexerを閉じ、リトライ可能な失敗の再送と競合の解決を行ってから結果を返します。
+// 解決できなかった競合がある場合、ConflictSkipでなければErrVersionConflictを返します。
+func (c *Client) finishBulk(ctx context.Context, writer *bulkWriter) (*BulkResult, error) {
+	cfg, collector := writer.cfg, writer.collector
+	if err := writer.close(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.retryFailed(ctx, cfg, collector); err != nil {
+		return nil, err
+	}
+	if err := c.resolveConflicts(ctx, cfg, collector); err != nil {
+		return nil, err
+	}
+
+	result, err := collector.result()
+	if len(result.Conflicts) > 0 && c.conflictPolicy.Resolution != ConflictSkip {
+		err = errors.Join(err, fmt.Errorf("%w: %d documents", ErrVersionConflict, len(result.Conflicts)))
+	}
+	return result, err
+}
+
+// finishEncodedBulk はfinishBulkと同じく登録を終え、arenaのブロックをプールに戻します。
+// 結果を返せなかった場合はBulkIndexerがBodyを参照している可能性があるため、プールに戻しません。
+func (c *Client) finishEncodedBulk(ctx context.Context, writer *bulkWriter, arena *esbulk.EncodeArena) (*BulkResult, error) {
+	result, err := c.finishBulk(ctx, writer)
+	if result != nil {
+		arena.Release()
+	}
+	return result, err
+}
+
+// resolveConflicts はConflictRetryの場合に、競合したアイテムを最新の_seq_noと_primary_termで再送します。
+func (c *Client) resolveConflicts(ctx context.Context, cfg esutil.BulkIndexerConfig, collector *bulkResultCollector) error {
+	if c.conflictPolicy.Resolution != ConflictRetry {
+		return nil
+	}
+
+	for attempt := 0; attempt < c.conflictPolicy.maxRetries(); attempt++ {
+		conflicts := collector.takeConflicts(func(p pendingItem) bool {
+			return p.item.IfSeqNo != nil
+		})
+		if len(conflicts) == 0 {
+			return nil
+		}
+
+		writer := c.newBulkWriter(cfg, collector)
+		for _, p := range conflicts {
+			index := p.item.Index
+			if index == "" {
+				index = cfg.Index
+			}
+			item, ok, err := c.refreshConflictItem(ctx, index, p.item)
+			if err != nil {
+				return writer.discard(ctx, err)
+			}
+			if !ok {
+				continue
+			}
+			if err := writer.add(ctx, p.pipeline, item); err != nil {
+				return writer.discard(ctx, fmt.Errorf("failed to add document %s to bulk indexer: %w", item.DocumentID, err))
+			}
+		}
+		if err := writer.close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshConflictItem は最新のドキュメントを読み直し、再送用のアイテムを作成します。
+// ドキュメントが削除されていた場合、indexはcreateとして再送し、deleteは目的を達成しているため再送しません(falseを返します)。
+func (c *Client) refreshConflictItem(ctx context.Context, index string, item esutil.BulkIndexerItem) (esutil.BulkIndexerItem, bool, error) {
+	current, err := c.getCurrent(ctx, index, item.DocumentID, item.Routing)
+	if err != nil {
+		return item, false, err
+	}
+
+	retry := esbulk.RetryItem(item)
+	if !current.Found {
+		if item.Action == "delete" {
+			return retry, false, nil
+		}
+		if item.Action == "index" {
+			retry.Action = "create"
+		}
+		retry.IfSeqNo, retry.IfPrimaryTerm = nil, nil
+		return retry, true, nil
+	}
+
+	retry.IfSeqNo, retry.IfPrimaryTerm = current.SeqNo_, current.PrimaryTerm_
+	if item.Action == "index" && c.conflictPolicy.Merge != nil {
+		if _, err := item.Body.Seek(0, io.SeekStart); err != nil {
+			return item, false, fmt.Errorf("failed to rewind document %s: %w", item.DocumentID, err)
+		}
+		desired, err := io.ReadAll(item.Body)
+		if err != nil {
+			return item, false, fmt.Errorf("failed to read document %s: %w", item.DocumentID, err)
+		}
+		merged, err := c.conflictPolicy.Merge(current.Source_, desired)
+		if err != nil {
+			return item, false, fmt.Errorf("failed to merge document %s: %w", item.DocumentID, err)
+		}
+		retry.Body = bytes.NewReader(merged)
+	}
+	return retry, true, nil
+}
+
+// getCurrent は競合の解決のために最新のドキュメントを_seq_noと_primary_term付きで読み直します。
+func (c *Client) getCurrent(ctx context.Context, index, id, routing string) (*get.Response, error) {
+	req := c.typedClient.Get(index, id)
+	if routing != "" {
+		req.Routing(routing)
+	}
+	res, err := req.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document %s: %w", id, err)
+	}
+	return res, nil
+}
+
+// isVersionConflictResponse はIndex APIのエラーがバージョンの競合(409)かどうかを返します。
+func isVersionConflictResponse(err error) (*types.ElasticsearchError, bool) {
+	var esErr *types.ElasticsearchError
+	if errors.As(err, &esErr) && esErr.Status == http.StatusConflict {
+		return esErr, true
+	}
+	return nil, false
+}
+
+// writeGuard は1件の書き込みに付ける楽観的排他制御の条件です。
+type writeGuard struct {
+	seqNo       *int64
+	primaryTerm *int64
+	version     *int64
+	// create はドキュメントが存在しない場合のみ登録することを表します。
+	create bool
+}
+
+// indexGuarded は楽観的排他制御の条件を付けて1件のドキュメントを登録し、競合した場合はポリシーに従って解決します。
+// routingとpipelineが空の場合はClientの既定値を使用します。
+// ConflictSkipで競合を読み飛ばした場合はnilを返します。
+func (c *Client) indexGuarded(ctx context.Context, index, id, routing, pipeline string, doc json.RawMessage, guard writeGuard) error {
+	if routing == "" {
+		routing = c.routing
+	}
+	if pipeline == "" {
+		pipeline = c.pipeline
+	}
+	for attempt := 0; ; attempt++ {
+		req := c.typedClient.Index(index).
+			Id(id).
+			Request(doc)
+		if routing != "" {
+			req.Routing(routing)
+		}
+		if pipeline != "" {
+			req.Pipeline(pipeline)
+		}
+		switch {
+		case guard.create:
+			req.OpType(optype.Create)
+		case guard.version != nil:
+			req.Version(strconv.FormatInt(*guard.version, 10)).
+				VersionType(versiontype.External)
+		case guard.seqNo != nil && guard.primaryTerm != nil:
+			req.IfSeqNo(strconv.FormatInt(*guard.seqNo, 10)).
+				IfPrimaryTerm(strconv.FormatInt(*guard.primaryTerm, 10))
+		}
+
+		_, err := req.Do(ctx)
+		esErr, conflict := isVersionConflictResponse(err)
+		if !conflict {
+			if err != nil {
+				return fmt.Errorf("failed to insert document %s: %w", id, err)
+			}
+			return nil
+		}
+
+		retry := c.conflictPolicy.Resolution == ConflictRetry && guard.version == nil && attempt < c.conflictPolicy.maxRetries()
+		if c.conflictPolicy.Resolution == ConflictSkip {
+			return nil
+		}
+		if !retry {
+			reason := esErr.ErrorCause.Type
+			if esErr.ErrorCause.Reason != nil {
+				reason = *esErr.ErrorCause.Reason
+			}
+			return &VersionConflictError{Index: index, DocumentID: id, Reason: reason}
+		}
+
+		current, err := c.getCurrent(ctx, index, id, routing)
+		if err != nil {
+			return err
+		}
+		if !current.Found {
+			guard = writeGuard{create: true}
+			continue
+		}
+		guard = writeGuard{seqNo: current.SeqNo_, primaryTerm: current.PrimaryTerm_}
+		if c.conflictPolicy.Merge != nil {
+			if doc, err = c.conflictPolicy.Merge(current.Source_, doc); err != nil {
+				return fmt.Errorf("failed to merge document %s: %w", id, err)
+			}
+		}
+	}
+}
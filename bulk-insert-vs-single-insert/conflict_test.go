@@ -0,0 +1,150 @@
+package bulkinsert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+// newConflictTestClient は、ドキュメント1を2回書き込んで_seq_noを進めた疑似サーバーとClientを作成します。
+// _seq_no 0で書き込もうとすると競合します。
+func newConflictTestClient(t *testing.T) (*esfake.Server, *Client) {
+	t.Helper()
+
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	for _, doc := range []string{`{"title":"Batman"}`, `{"title":"Batman","views":10}`} {
+		if _, err := client.typedClient.Index("books").Id("1").Raw(strings.NewReader(doc)).Do(context.Background()); err != nil {
+			t.Fatalf("failed to seed document: %v", err)
+		}
+	}
+	return srv, client
+}
+
+func storedDocument(t *testing.T, srv *esfake.Server, id string) string {
+	t.Helper()
+	idx, ok := srv.Index("books")
+	if !ok {
+		t.Fatal("index books not found")
+	}
+	return string(idx.Documents[id])
+}
+
+// mergeJSON は最新のドキュメントに書き込もうとしたフィールドを上書きします。
+func mergeJSON(current, desired json.RawMessage) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(desired, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func TestBulkConflictPolicy(t *testing.T) {
+	ops := []BulkOperation{IndexOperation("1", map[string]string{"title": "Batman Begins"}).IfMatch(0, 1)}
+
+	t.Run("fail", func(t *testing.T) {
+		srv, client := newConflictTestClient(t)
+		result, err := client.Bulk(context.Background(), "books", ops)
+		if !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected ErrVersionConflict, got %v", err)
+		}
+		if len(result.Conflicts) != 1 || result.Failed != 0 {
+			t.Errorf("expected 1 conflict and no failures, got %+v", result)
+		}
+		if got := storedDocument(t, srv, "1"); got != `{"title":"Batman","views":10}` {
+			t.Errorf("document was overwritten: %s", got)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		_, client := newConflictTestClient(t)
+		client.WithConflictPolicy(ConflictPolicy{Resolution: ConflictSkip})
+		result, err := client.Bulk(context.Background(), "books", ops)
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if len(result.Conflicts) != 1 || result.Indexed != 0 {
+			t.Errorf("expected the conflict to be skipped, got %+v", result)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		srv, client := newConflictTestClient(t)
+		client.WithConflictPolicy(ConflictPolicy{Resolution: ConflictRetry, Merge: mergeJSON})
+		result, err := client.Bulk(context.Background(), "books", ops)
+		if err != nil {
+			t.Fatalf("Bulk failed: %v", err)
+		}
+		if result.Retried != 1 || len(result.Conflicts) != 0 {
+			t.Errorf("expected the conflict to be resolved by a retry, got %+v", result)
+		}
+		if got := storedDocument(t, srv, "1"); got != `{"title":"Batman Begins","views":10}` {
+			t.Errorf("expected the merged document, got %s", got)
+		}
+	})
+
+	t.Run("external version is not retried", func(t *testing.T) {
+		_, client := newConflictTestClient(t)
+		client.WithConflictPolicy(ConflictPolicy{Resolution: ConflictRetry})
+		ops := []BulkOperation{IndexOperation("1", map[string]string{"title": "Batman Begins"}).WithExternalVersion(1)}
+		result, err := client.Bulk(context.Background(), "books", ops)
+		if !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected ErrVersionConflict, got %v", err)
+		}
+		if result.Retried != 0 {
+			t.Errorf("expected no retries, got %d", result.Retried)
+		}
+	})
+}
+
+func TestSingleInsertConflict(t *testing.T) {
+	docs := []book{{ID: "1", Title: "Batman Begins"}}
+	opts := bookOptions
+	opts.SeqNo = func(book) (int64, int64, bool) { return 0, 1, true }
+
+	t.Run("fail", func(t *testing.T) {
+		_, client := newConflictTestClient(t)
+		err := SingleInsert(context.Background(), client, "books", docs, opts)
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) || !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected *VersionConflictError, got %v", err)
+		}
+		if conflict.DocumentID != "1" {
+			t.Errorf("DocumentID = %s, want 1", conflict.DocumentID)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		srv, client := newConflictTestClient(t)
+		client.WithConflictPolicy(ConflictPolicy{Resolution: ConflictRetry, Merge: mergeJSON})
+		if err := SingleInsert(context.Background(), client, "books", docs, opts); err != nil {
+			t.Fatalf("SingleInsert failed: %v", err)
+		}
+		if got := storedDocument(t, srv, "1"); got != `{"author":"","title":"Batman Begins","views":10}` {
+			t.Errorf("expected the merged document, got %s", got)
+		}
+	})
+}
+
+func TestBulkOperationGuardInvalid(t *testing.T) {
+	seqNo := int64(1)
+	for _, op := range []BulkOperation{
+		{Action: ActionIndex, DocumentID: "1", Document: map[string]string{}, IfSeqNo: &seqNo},
+		IndexOperation("1", map[string]string{}).IfMatch(1, 1).WithExternalVersion(2),
+		UpdateOperation("1", map[string]string{}).WithExternalVersion(2),
+	} {
+		if _, err := op.body(); err == nil {
+			t.Errorf("expected an error for %+v", op)
+		}
+	}
+}
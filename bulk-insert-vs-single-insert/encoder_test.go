@@ -0,0 +1,59 @@
+package bulkinsert
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+// AppendJSON はコード生成した場合と同じく、リフレクションを使わずにbookのJSONを追記します。
+func (b book) AppendJSON(dst []byte) ([]byte, error) {
+	dst = append(dst, `{"title":`...)
+	dst = AppendJSONString(dst, b.Title)
+	dst = append(dst, `,"author":`...)
+	dst = AppendJSONString(dst, b.Author)
+	return append(dst, '}'), nil
+}
+
+// rawBooks はbooksをエンコード済みのJSONにします。
+func rawBooks(books []book) []json.RawMessage {
+	raws := make([]json.RawMessage, len(books))
+	for i, b := range books {
+		raws[i], _ = json.Marshal(b)
+	}
+	return raws
+}
+
+var rawBookOptions = DocumentOptions[json.RawMessage]{IDStrategy: ContentHashID()}
+
+func TestBulkInsertWithEncoders(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	books := generateBooks(20)
+	want, _ := json.Marshal(books[3])
+
+	client.WithEncoder(CodecEncoder{})
+	if result, err := BulkInsert(context.Background(), client, "codec-books", books, bookOptions); err != nil || result.Indexed != 20 {
+		t.Fatalf("BulkInsert with CodecEncoder failed: %+v, %v", result, err)
+	}
+	idx, _ := srv.Index("codec-books")
+	if got := idx.Documents["book-4"]; string(got) != string(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	client.WithEncoder(RawEncoder{})
+	if result, err := BulkInsert(context.Background(), client, "raw-books", rawBooks(books), rawBookOptions); err != nil || result.Indexed != 20 {
+		t.Fatalf("BulkInsert with RawEncoder failed: %+v, %v", result, err)
+	}
+
+	// CodecEncoderはJSONAppenderを実装していない型を変換できません。
+	client.WithEncoder(CodecEncoder{})
+	if _, err := client.BulkInsert(context.Background(), "maps", generateDocs(1)); err == nil {
+		t.Error("expected an error for a document without AppendJSON")
+	}
+}
@@ -0,0 +1,137 @@
+package bulkinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestIDStrategies(t *testing.T) {
+	source := json.RawMessage(`{"isbn":"978-4","edition":2,"author":{"id":42,"name":"Bob"},"tags":["a"]}`)
+	tests := []struct {
+		name     string
+		strategy IDStrategy
+		want     string
+		wantErr  bool
+	}{
+		{name: "field", strategy: FieldID("isbn"), want: "978-4"},
+		{name: "nested field", strategy: FieldID("author.id"), want: "42"},
+		{name: "missing field", strategy: FieldID("author.email"), wantErr: true},
+		{name: "array field", strategy: FieldID("tags"), wantErr: true},
+		{name: "composite", strategy: CompositeID(":", "isbn", "edition"), want: "978-4:2"},
+		{name: "composite without fields", strategy: CompositeID(":"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.strategy(source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentHashID(t *testing.T) {
+	hash := ContentHashID()
+	a, err := hash(json.RawMessage(`{"title":"Batman","year":1989,"cast":{"lead":"Keaton"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hash(json.RawMessage(`{ "cast": {"lead": "Keaton"}, "year": 1989, "title": "Batman" }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || len(a) != 64 {
+		t.Errorf("expected the same 64-character hash, got %s and %s", a, b)
+	}
+	c, err := hash(json.RawMessage(`{"title":"Batman","year":1990,"cast":{"lead":"Keaton"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == c {
+		t.Error("expected a different hash for different content")
+	}
+}
+
+func TestULID(t *testing.T) {
+	now := time.UnixMilli(1469918176385)
+	g := &ulidGenerator{now: func() time.Time { return now }, entropy: bytes.NewReader(bytes.Repeat([]byte{0xff}, 20))}
+
+	first, err := g.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 時刻部分はULIDの仕様のテストベクタと一致します。
+	if first != "01ARYZ6S41ZZZZZZZZZZZZZZZZ" {
+		t.Errorf("got %s", first)
+	}
+	// 同じミリ秒内で乱数部を使い切るとエラーになります。
+	if _, err := g.next(); err == nil {
+		t.Error("expected an entropy overflow error")
+	}
+
+	now = now.Add(time.Millisecond)
+	second, err := g.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 26 || second <= first {
+		t.Errorf("expected a greater ULID than %s, got %s", first, second)
+	}
+
+	ulid := ULID()
+	prev := ""
+	for i := 0; i < 1000; i++ {
+		id, err := ulid(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= prev {
+			t.Fatalf("ULID is not monotonic: %s after %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestBulkInsertIsIdempotentWithContentHash(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIDStrategy(ContentHashID())
+
+	docs := generateDocs(10)
+	for run := 0; run < 2; run++ {
+		if _, err := client.BulkInsert(context.Background(), "books", docs); err != nil {
+			t.Fatalf("BulkInsert failed: %v", err)
+		}
+	}
+	idx, ok := srv.Index("books")
+	if !ok || len(idx.Documents) != len(docs) {
+		t.Fatalf("expected %d documents after two runs, got %d", len(docs), len(idx.Documents))
+	}
+
+	books := generateBooks(3)
+	opts := DocumentOptions[book]{IDStrategy: CompositeID("/", "author", "title")}
+	if _, err := BulkInsert(context.Background(), client, "typed-books", books, opts); err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	idx, _ = srv.Index("typed-books")
+	if _, ok := idx.Documents["Test Author/Test Document 1"]; !ok {
+		t.Errorf("expected a composite ID, got %v", idx.Documents)
+	}
+
+	opts.ID = func(b book) string { return b.ID }
+	if err := SingleInsert(context.Background(), client, "typed-books", books, opts); err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+}
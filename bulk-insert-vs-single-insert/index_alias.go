@@ -0,0 +1,213 @@
+package bulkinsert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RebuildOptions は読み取り用エイリアスの裏にある、バージョン付きインデックスの再構築方法を定義します。
+type RebuildOptions struct {
+	// Alias は検索で使用するエイリアス名です(例: tmdb)。
+	Alias string
+	// Definition は新しいインデックスの設定とマッピングです。
+	Definition *IndexDefinition
+	// Version はインデックス名の末尾に付けるバージョンです。省略時は実行日(例: 20261018)になり、
+	// インデックス名は tmdb-v20261018 のようになります。辞書順で世代の新旧を判断します。
+	Version string
+	// Retention は残すインデックスの世代数です。0の場合は古い世代を削除しません。
+	// ロールバックできるように、2以上を指定することを推奨します。
+	Retention int
+}
+
+// RebuildResult は再構築の結果です。
+type RebuildResult struct {
+	// Index は新しく作成し、エイリアスを付け替えたインデックスです。
+	Index string
+	// Previous は付け替え前にエイリアスが指していたインデックスです。
+	Previous []string
+	// Deleted は世代数の上限を超えたため削除したインデックスです。
+	Deleted []string
+	Bulk    *BulkResult
+}
+
+// generationPrefix はエイリアスに対応するバージョン付きインデックス名の接頭辞です。
+func generationPrefix(alias string) string {
+	return alias + "-v"
+}
+
+// RebuildIndex はバージョン付きインデックスを新しく作成してドキュメントを登録し、
+// 件数を確認してから読み取り用エイリアスをアトミックに付け替えます。
+// 登録中も既存のインデックスで検索を続けられるため、ダウンタイムが発生しません。
+// 登録や件数の確認に失敗した場合はエイリアスを付け替えず、調査のために新しいインデックスを残します。
+func (c *Client) RebuildIndex(ctx context.Context, opts RebuildOptions, docs []map[string]interface{}) (*RebuildResult, error) {
+	if opts.Alias == "" {
+		return nil, fmt.Errorf("rebuild index: alias is required")
+	}
+	version := opts.Version
+	if version == "" {
+		version = time.Now().Format("20060102")
+	}
+	index := generationPrefix(opts.Alias) + version
+
+	exist, err := c.typedClient.Indices.Exists(index).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if index exists: %w", err)
+	}
+	if exist {
+		return nil, fmt.Errorf("rebuild index: %s already exists, specify another version", index)
+	}
+	if err := c.createIndex(ctx, index, opts.Definition); err != nil {
+		return nil, err
+	}
+
+	result := &RebuildResult{Index: index}
+	result.Bulk, err = c.BulkInsert(ctx, index, docs)
+	if err != nil {
+		return result, fmt.Errorf("failed to load %s: %w", index, err)
+	}
+	if result.Bulk.Failed > 0 {
+		return result, fmt.Errorf("failed to load %s: %d documents failed", index, result.Bulk.Failed)
+	}
+
+	if _, err := c.typedClient.Indices.Refresh().Index(index).Do(ctx); err != nil {
+		return result, fmt.Errorf("failed to refresh %s: %w", index, err)
+	}
+	count, err := c.typedClient.Count().Index(index).Do(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to count documents in %s: %w", index, err)
+	}
+	if count.Count != int64(len(docs)) {
+		return result, fmt.Errorf("document count mismatch in %s: expected %d, got %d", index, len(docs), count.Count)
+	}
+
+	result.Previous, err = c.aliasIndices(ctx, opts.Alias)
+	if err != nil {
+		return result, err
+	}
+	if err := c.swapAlias(ctx, opts.Alias, result.Previous, index); err != nil {
+		return result, err
+	}
+
+	if opts.Retention > 0 {
+		generations, err := c.generations(ctx, opts.Alias)
+		if err != nil {
+			return result, err
+		}
+		for _, old := range expiredGenerations(generations, index, opts.Retention) {
+			if _, err := c.typedClient.Indices.Delete(old).Do(ctx); err != nil {
+				return result, fmt.Errorf("failed to delete old generation %s: %w", old, err)
+			}
+			result.Deleted = append(result.Deleted, old)
+		}
+	}
+	return result, nil
+}
+
+// RollbackAlias は読み取り用エイリアスを、現在の1つ前の世代のインデックスに戻します。
+// 戻した先のインデックス名を返します。
+func (c *Client) RollbackAlias(ctx context.Context, alias string) (string, error) {
+	current, err := c.aliasIndices(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	if len(current) != 1 {
+		return "", fmt.Errorf("rollback alias: %s points to %d indices, expected 1", alias, len(current))
+	}
+	generations, err := c.generations(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	previous, ok := previousGeneration(generations, current[0])
+	if !ok {
+		return "", fmt.Errorf("rollback alias: no generation older than %s", current[0])
+	}
+	if err := c.swapAlias(ctx, alias, current, previous); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// aliasIndices はエイリアスが現在指しているインデックスを返します。エイリアスが存在しない場合は空です。
+func (c *Client) aliasIndices(ctx context.Context, alias string) ([]string, error) {
+	exist, err := c.typedClient.Indices.ExistsAlias(alias).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if alias exists: %w", err)
+	}
+	if !exist {
+		return nil, nil
+	}
+	res, err := c.typedClient.Indices.GetAlias().Name(alias).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alias %s: %w", alias, err)
+	}
+	indices := make([]string, 0, len(res))
+	for index := range res {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// generations はエイリアスに対応するバージョン付きインデックスを古い順に返します。
+func (c *Client) generations(ctx context.Context, alias string) ([]string, error) {
+	res, err := c.typedClient.Indices.Get(generationPrefix(alias) + "*").Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list generations of %s: %w", alias, err)
+	}
+	generations := make([]string, 0, len(res))
+	for index := range res {
+		generations = append(generations, index)
+	}
+	sort.Strings(generations)
+	return generations, nil
+}
+
+// swapAlias はエイリアスをfromから外してtoに付ける操作を、1回の _aliases リクエストでアトミックに行います。
+func (c *Client) swapAlias(ctx context.Context, alias string, from []string, to string) error {
+	actions := make([]map[string]interface{}, 0, len(from)+1)
+	for _, index := range from {
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]string{"index": index, "alias": alias},
+		})
+	}
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]string{"index": to, "alias": alias},
+	})
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alias actions: %w", err)
+	}
+	if _, err := c.typedClient.Indices.UpdateAliases().Raw(strings.NewReader(string(body))).Do(ctx); err != nil {
+		return fmt.Errorf("failed to move alias %s to %s: %w", alias, to, err)
+	}
+	return nil
+}
+
+// expiredGenerations は古い順に並んだ世代のうち、新しいものからretention個を残した残りを返します。
+// エイリアスが指しているcurrentは削除の対象にしません。
+func expiredGenerations(generations []string, current string, retention int) []string {
+	if len(generations) <= retention {
+		return nil
+	}
+	var expired []string
+	for _, index := range generations[:len(generations)-retention] {
+		if index != current {
+			expired = append(expired, index)
+		}
+	}
+	return expired
+}
+
+// previousGeneration は古い順に並んだ世代から、currentの1つ前の世代を返します。
+func previousGeneration(generations []string, current string) (string, bool) {
+	i := sort.SearchStrings(generations, current)
+	if i == 0 || i > len(generations) {
+		return "", false
+	}
+	return generations[i-1], true
+}
@@ -0,0 +1,39 @@
+package bulkinsert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpiredGenerations(t *testing.T) {
+	generations := []string{"tmdb-v20261015", "tmdb-v20261016", "tmdb-v20261017", "tmdb-v20261018"}
+
+	tests := []struct {
+		name      string
+		current   string
+		retention int
+		want      []string
+	}{
+		{"keep two", "tmdb-v20261018", 2, []string{"tmdb-v20261015", "tmdb-v20261016"}},
+		{"keep all", "tmdb-v20261018", 4, nil},
+		{"never delete current", "tmdb-v20261016", 1, []string{"tmdb-v20261015", "tmdb-v20261017"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expiredGenerations(generations, tt.current, tt.retention); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expiredGenerations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviousGeneration(t *testing.T) {
+	generations := []string{"tmdb-v20261016", "tmdb-v20261017", "tmdb-v20261018"}
+
+	if got, ok := previousGeneration(generations, "tmdb-v20261018"); !ok || got != "tmdb-v20261017" {
+		t.Errorf("previousGeneration() = %q, %v", got, ok)
+	}
+	if _, ok := previousGeneration(generations, "tmdb-v20261016"); ok {
+		t.Error("expected no generation older than the oldest one")
+	}
+}
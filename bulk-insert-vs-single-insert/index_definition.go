@@ -0,0 +1,177 @@
+package bulkinsert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// IndexDefinition はインデックスの設定(settings)とマッピング(mappings)を表します。
+// JSONの形はIndices Create APIのリクエストボディと同じです。
+type IndexDefinition struct {
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Mappings map[string]interface{} `json:"mappings,omitempty"`
+}
+
+// LoadIndexDefinition はtmdb_settings.jsonのような {"settings": ..., "mappings": ...} 形式のファイルを読み込みます。
+func LoadIndexDefinition(path string) (*IndexDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index definition: %w", err)
+	}
+	def, err := ParseIndexDefinition(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse index definition %s: %w", path, err)
+	}
+	return def, nil
+}
+
+// ParseIndexDefinition はJSONからIndexDefinitionを作成します。
+func ParseIndexDefinition(data []byte) (*IndexDefinition, error) {
+	var def IndexDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// IndexDefinitionFor は構造体Tのタグからマッピングを導出します。
+// フィールド名はjsonタグから取得し、型はesタグ(例: `es:"keyword"`, `es:"text,analyzer=my_english"`)で指定します。
+// esタグがない場合はGoの型から推測します。
+func IndexDefinitionFor[T any](settings map[string]interface{}) (*IndexDefinition, error) {
+	var zero T
+	properties, err := propertiesOf(reflect.TypeOf(zero))
+	if err != nil {
+		return nil, err
+	}
+	return &IndexDefinition{
+		Settings: settings,
+		Mappings: map[string]interface{}{
+			"properties": properties,
+		},
+	}, nil
+}
+
+func propertiesOf(t reflect.Type) (map[string]interface{}, error) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("index definition: %v is not a struct", t)
+	}
+
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || field.Tag.Get("es") == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		mapping, err := fieldMapping(field)
+		if err != nil {
+			return nil, fmt.Errorf("index definition: field %s: %w", field.Name, err)
+		}
+		properties[name] = mapping
+	}
+	return properties, nil
+}
+
+func fieldMapping(field reflect.StructField) (map[string]interface{}, error) {
+	t := field.Type
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	mapping := make(map[string]interface{})
+	if tag := field.Tag.Get("es"); tag != "" {
+		fieldType, params, _ := strings.Cut(tag, ",")
+		mapping["type"] = fieldType
+		if params != "" {
+			for _, param := range strings.Split(params, ",") {
+				key, value, ok := strings.Cut(param, "=")
+				if !ok {
+					return nil, fmt.Errorf("invalid es tag parameter %q", param)
+				}
+				mapping[key] = value
+			}
+		}
+		if fieldType != "object" && fieldType != "nested" {
+			return mapping, nil
+		}
+	}
+
+	if t.Kind() == reflect.Struct && t != timeType {
+		properties, err := propertiesOf(t)
+		if err != nil {
+			return nil, err
+		}
+		mapping["properties"] = properties
+		return mapping, nil
+	}
+
+	fieldType, err := inferFieldType(t)
+	if err != nil {
+		return nil, err
+	}
+	mapping["type"] = fieldType
+	return mapping, nil
+}
+
+func inferFieldType(t reflect.Type) (string, error) {
+	if t == timeType {
+		return "date", nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "text", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8, reflect.Uint8:
+		return "byte", nil
+	case reflect.Int16, reflect.Uint16:
+		return "short", nil
+	case reflect.Int32, reflect.Uint32:
+		return "integer", nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return "long", nil
+	case reflect.Float32:
+		return "float", nil
+	case reflect.Float64:
+		return "double", nil
+	}
+	return "", fmt.Errorf("cannot infer field type from %v, set an es tag", t)
+}
+
+// benchmarkIndexDefinition はベンチマークで使用するtitle/authorのみを持つインデックスの定義です。
+func benchmarkIndexDefinition() *IndexDefinition {
+	return &IndexDefinition{
+		Settings: map[string]interface{}{
+			"refresh_interval":     "60s",
+			"number_of_shards":     1,
+			"auto_expand_replicas": "0-all",
+		},
+		Mappings: map[string]interface{}{
+			"dynamic": "strict",
+			"properties": map[string]interface{}{
+				"title": map[string]interface{}{
+					"type": "text",
+				},
+				"author": map[string]interface{}{
+					"type": "text",
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,303 @@
+package bulkinsert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrIncompatibleIndex は既存のインデックスに対して適用できない変更が含まれていることを表します。
+var ErrIncompatibleIndex = errors.New("index definition is incompatible with the live index")
+
+// staticSettings は作成後のインデックスに対して変更できない設定です。
+var staticSettings = []string{
+	"index.number_of_shards",
+	"index.number_of_routing_shards",
+	"index.codec",
+	"index.mode",
+	"index.routing_partition_size",
+	"index.soft_deletes.",
+	"index.sort.",
+	"index.analysis.",
+}
+
+// IndexDiff は既存のインデックスと定義との差分です。
+type IndexDiff struct {
+	// Created はインデックスが存在せず、新規に作成したことを表します。
+	Created bool
+	// Recreated は互換性のない変更のため、インデックスを削除して作り直したことを表します。
+	Recreated bool
+	// AddedFields は既存のマッピングに追加されるフィールドです。
+	AddedFields []string
+	// UpdatedSettings は変更される動的な設定です。
+	UpdatedSettings map[string]interface{}
+	// Conflicts は既存のインデックスに適用できない変更です。
+	Conflicts []string
+
+	dynamicChanged bool
+}
+
+// Compatible は既存のインデックスを作り直さずに適用できるかどうかを返します。
+func (d *IndexDiff) Compatible() bool {
+	return len(d.Conflicts) == 0
+}
+
+func (d *IndexDiff) mappingChanged() bool {
+	return len(d.AddedFields) > 0 || d.dynamicChanged
+}
+
+// DiffIndex は既存のインデックスの定義(live)と適用したい定義(desired)を比較します。
+// desiredに含まれない既存のフィールドや設定は削除できないため、比較の対象外です。
+func DiffIndex(live, desired *IndexDefinition) (*IndexDiff, error) {
+	live, err := normalizeDefinition(live)
+	if err != nil {
+		return nil, err
+	}
+	desired, err = normalizeDefinition(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &IndexDiff{UpdatedSettings: map[string]interface{}{}}
+
+	liveSettings := flattenSettings(live.Settings)
+	for key, value := range flattenSettings(desired.Settings) {
+		current, ok := liveSettings[key]
+		if ok && fmt.Sprint(current) == fmt.Sprint(value) {
+			continue
+		}
+		if isStaticSetting(key) {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("setting %s: %v -> %v", key, current, value))
+			continue
+		}
+		diff.UpdatedSettings[key] = value
+	}
+
+	if dynamic, ok := desired.Mappings["dynamic"]; ok && fmt.Sprint(dynamic) != fmt.Sprint(live.Mappings["dynamic"]) {
+		diff.dynamicChanged = true
+	}
+	liveFields := flattenFields(live.Mappings)
+	for path, field := range flattenFields(desired.Mappings) {
+		current, ok := liveFields[path]
+		if !ok {
+			diff.AddedFields = append(diff.AddedFields, path)
+			continue
+		}
+		if !reflect.DeepEqual(current, field) {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("field %s: %v -> %v", path, current, field))
+		}
+	}
+
+	sort.Strings(diff.AddedFields)
+	sort.Strings(diff.Conflicts)
+	return diff, nil
+}
+
+// normalizeDefinition はJSONを経由させることで、数値の型などの表現を揃えます。
+func normalizeDefinition(def *IndexDefinition) (*IndexDefinition, error) {
+	if def == nil {
+		return &IndexDefinition{}, nil
+	}
+	data, err := json.Marshal(def)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal index definition: %w", err)
+	}
+	return ParseIndexDefinition(data)
+}
+
+// flattenSettings は設定を "index.refresh_interval" のようなドット区切りのキーに展開します。
+func flattenSettings(settings map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			key := prefix + k
+			if child, ok := v.(map[string]interface{}); ok {
+				walk(key+".", child)
+				continue
+			}
+			flat[key] = v
+		}
+	}
+	walk("", settings)
+
+	normalized := make(map[string]interface{}, len(flat))
+	for k, v := range flat {
+		if !strings.HasPrefix(k, "index.") {
+			k = "index." + k
+		}
+		normalized[k] = v
+	}
+	return normalized
+}
+
+func isStaticSetting(key string) bool {
+	for _, static := range staticSettings {
+		if key == static || (strings.HasSuffix(static, ".") && strings.HasPrefix(key, static)) {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenFields はマッピングのフィールドを "title.keyword" のようなパスに展開します。
+// properties(オブジェクトのフィールド)とfields(マルチフィールド)の両方を展開し、
+// それ以外のパラメータをフィールドの定義として返します。
+func flattenFields(mappings map[string]interface{}) map[string]map[string]interface{} {
+	flat := make(map[string]map[string]interface{})
+	var walk func(prefix string, properties interface{})
+	walk = func(prefix string, properties interface{}) {
+		fields, ok := properties.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for name, v := range fields {
+			field, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := prefix + name
+			params := make(map[string]interface{})
+			for k, p := range field {
+				if k != "properties" && k != "fields" {
+					params[k] = p
+				}
+			}
+			flat[path] = params
+			walk(path+".", field["properties"])
+			walk(path+".", field["fields"])
+		}
+	}
+	walk("", mappings["properties"])
+	return flat
+}
+
+// EnsureIndex はインデックスが定義どおりになるように作成・更新します。
+// インデックスが存在しない場合は作成し、存在する場合は差分のうち互換性のある変更(フィールドの追加と動的な設定の変更)のみを適用します。
+// 互換性のない変更がある場合はErrIncompatibleIndexを返しますが、forceがtrueの場合はインデックスを作り直します。
+// 作り直した場合、既存のドキュメントはすべて失われます。
+func (c *Client) EnsureIndex(ctx context.Context, index string, def *IndexDefinition, force bool) (*IndexDiff, error) {
+	exist, err := c.typedClient.Indices.Exists(index).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if index exists: %w", err)
+	}
+	if !exist {
+		if err := c.createIndex(ctx, index, def); err != nil {
+			return nil, err
+		}
+		return &IndexDiff{Created: true}, nil
+	}
+
+	live, err := c.GetIndexDefinition(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := DiffIndex(live, def)
+	if err != nil {
+		return nil, err
+	}
+
+	if !diff.Compatible() {
+		if !force {
+			return diff, fmt.Errorf("%w: %s", ErrIncompatibleIndex, strings.Join(diff.Conflicts, "; "))
+		}
+		if err := c.RecreateIndex(ctx, index, def); err != nil {
+			return diff, err
+		}
+		diff.Recreated = true
+		return diff, nil
+	}
+
+	if diff.mappingChanged() {
+		body, err := json.Marshal(def.Mappings)
+		if err != nil {
+			return diff, fmt.Errorf("failed to marshal mappings: %w", err)
+		}
+		if _, err := c.typedClient.Indices.PutMapping(index).Raw(strings.NewReader(string(body))).Do(ctx); err != nil {
+			return diff, fmt.Errorf("failed to update mappings: %w", err)
+		}
+	}
+	if len(diff.UpdatedSettings) > 0 {
+		if err := c.putSettings(ctx, index, diff.UpdatedSettings); err != nil {
+			return diff, fmt.Errorf("failed to update settings: %w", err)
+		}
+	}
+	return diff, nil
+}
+
+// RecreateIndex は既存のインデックスを削除してから定義どおりに作成します。既存のドキュメントはすべて失われます。
+func (c *Client) RecreateIndex(ctx context.Context, index string, def *IndexDefinition) error {
+	exist, err := c.typedClient.Indices.Exists(index).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if index exists: %w", err)
+	}
+	if exist {
+		_, err := c.typedClient.Indices.Delete(index).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete existing index: %w", err)
+		}
+	}
+	return c.createIndex(ctx, index, def)
+}
+
+func (c *Client) createIndex(ctx context.Context, index string, def *IndexDefinition) error {
+	body, err := json.Marshal(def)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index definition: %w", err)
+	}
+	_, err = c.typedClient.Indices.Create(index).Raw(strings.NewReader(string(body))).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	return nil
+}
+
+// GetIndexDefinition は既存のインデックスの設定とマッピングを取得します。
+func (c *Client) GetIndexDefinition(ctx context.Context, index string) (*IndexDefinition, error) {
+	var mappings map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	res, err := c.typedClient.Indices.GetMapping().Index(index).Perform(ctx)
+	if err := decodeResponse(res, err, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to get mappings: %w", err)
+	}
+
+	var settings map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	res, err = c.typedClient.Indices.GetSettings().Index(index).Perform(ctx)
+	if err := decodeResponse(res, err, &settings); err != nil {
+		return nil, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	// エイリアスを指定した場合もレスポンスのキーは実体のインデックス名になるため、1件目を使用します。
+	def := &IndexDefinition{}
+	for _, m := range mappings {
+		def.Mappings = m.Mappings
+		break
+	}
+	for _, s := range settings {
+		def.Settings = s.Settings
+		break
+	}
+	return def, nil
+}
+
+// decodeResponse はPerformの結果を確認し、レスポンスボディをvにデコードします。
+func decodeResponse(res *http.Response, err error, v interface{}) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("[%d] %s", res.StatusCode, body)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,128 @@
+package bulkinsert
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type movie struct {
+	ID          string    `json:"-"`
+	Title       string    `json:"title" es:"text,analyzer=english"`
+	Genres      []string  `json:"genres" es:"keyword"`
+	ReleaseDate time.Time `json:"release_date"`
+	ReleaseYear int16     `json:"release_year"`
+	VoteAverage float64   `json:"vote_average"`
+	Director    struct {
+		Name string `json:"name"`
+	} `json:"director"`
+}
+
+func TestIndexDefinitionFor(t *testing.T) {
+	def, err := IndexDefinitionFor[movie](nil)
+	if err != nil {
+		t.Fatalf("IndexDefinitionFor failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"title":        map[string]interface{}{"type": "text", "analyzer": "english"},
+		"genres":       map[string]interface{}{"type": "keyword"},
+		"release_date": map[string]interface{}{"type": "date"},
+		"release_year": map[string]interface{}{"type": "short"},
+		"vote_average": map[string]interface{}{"type": "double"},
+		"director": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{"type": "text"},
+			},
+		},
+	}
+	if got := def.Mappings["properties"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected properties:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestLoadIndexDefinition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tmdb_settings.json")
+	body := `{"settings": {"number_of_shards": 1}, "mappings": {"properties": {"title": {"type": "text"}}}}`
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := LoadIndexDefinition(path)
+	if err != nil {
+		t.Fatalf("LoadIndexDefinition failed: %v", err)
+	}
+	if def.Settings["number_of_shards"] != float64(1) {
+		t.Errorf("unexpected settings: %v", def.Settings)
+	}
+	if _, ok := def.Mappings["properties"].(map[string]interface{})["title"]; !ok {
+		t.Errorf("unexpected mappings: %v", def.Mappings)
+	}
+}
+
+func TestDiffIndex(t *testing.T) {
+	// GET /_mapping と GET /_settings が返す形の既存インデックス
+	live := &IndexDefinition{
+		Settings: map[string]interface{}{
+			"index": map[string]interface{}{
+				"refresh_interval":     "60s",
+				"number_of_shards":     "1",
+				"auto_expand_replicas": "0-all",
+				"uuid":                 "abc",
+			},
+		},
+		Mappings: map[string]interface{}{
+			"dynamic": "strict",
+			"properties": map[string]interface{}{
+				"title":  map[string]interface{}{"type": "text"},
+				"author": map[string]interface{}{"type": "text"},
+			},
+		},
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		diff, err := DiffIndex(live, benchmarkIndexDefinition())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !diff.Compatible() || diff.mappingChanged() || len(diff.UpdatedSettings) != 0 {
+			t.Errorf("expected no changes, got %+v", diff)
+		}
+	})
+
+	t.Run("compatible", func(t *testing.T) {
+		desired := benchmarkIndexDefinition()
+		desired.Settings["refresh_interval"] = "1s"
+		desired.Mappings["properties"].(map[string]interface{})["year"] = map[string]interface{}{"type": "short"}
+
+		diff, err := DiffIndex(live, desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !diff.Compatible() {
+			t.Fatalf("unexpected conflicts: %v", diff.Conflicts)
+		}
+		if !reflect.DeepEqual(diff.AddedFields, []string{"year"}) {
+			t.Errorf("unexpected added fields: %v", diff.AddedFields)
+		}
+		if !reflect.DeepEqual(diff.UpdatedSettings, map[string]interface{}{"index.refresh_interval": "1s"}) {
+			t.Errorf("unexpected updated settings: %v", diff.UpdatedSettings)
+		}
+	})
+
+	t.Run("incompatible", func(t *testing.T) {
+		desired := benchmarkIndexDefinition()
+		desired.Settings["number_of_shards"] = 3
+		desired.Mappings["properties"].(map[string]interface{})["author"] = map[string]interface{}{"type": "keyword"}
+
+		diff, err := DiffIndex(live, desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff.Compatible() || len(diff.Conflicts) != 2 {
+			t.Errorf("expected 2 conflicts, got %v", diff.Conflicts)
+		}
+	})
+}
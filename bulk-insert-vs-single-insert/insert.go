@@ -1,19 +1,29 @@
-package main
+package bulkinsert
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
+	coreindex "github.com/elastic/go-elasticsearch/v8/typedapi/core/index"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/refresh"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+	"go.opentelemetry.io/otel"
 )
 
 type Client struct {
-	baseClient  *elasticsearch.Client
-	typedClient *elasticsearch.TypedClient
+	baseClient     *elasticsearch.Client
+	typedClient    *elasticsearch.TypedClient
+	retryPolicy    *RetryPolicy
+	conflictPolicy ConflictPolicy
+	idStrategy     IDStrategy
+	pipeline       string
+	routing        string
+	telemetry      *esbulk.Telemetry
+	encoder        Encoder
 }
 
 func NewClient(cfg elasticsearch.Config) (*Client, error) {
@@ -30,138 +40,138 @@ func NewClient(cfg elasticsearch.Config) (*Client, error) {
 	return &Client{
 		baseClient:  baseClient,
 		typedClient: typedClient,
+		telemetry:   newTelemetry(otel.GetTracerProvider(), otel.GetMeterProvider()),
 	}, nil
 }
 
+// CreateIndex はベンチマーク用に、既存のインデックスを削除してから空のインデックスを作成します。
+// 既存のインデックスを残したまま定義を適用する場合はEnsureIndexを使用します。
 func (c *Client) CreateIndex(ctx context.Context, index string) error {
-	exist, err := c.typedClient.Indices.Exists(index).Do(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to check if index exists: %w", err)
-	}
-	if exist {
-		_, err := c.typedClient.Indices.Delete(index).Do(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to delete existing index: %w", err)
-		}
-	}
-
-	const body = `{
-		"settings": {
-			"refresh_interval": "60s",
-			"number_of_shards": 1,
-			"auto_expand_replicas": "0-all"
-		},
-		"mappings": {
-			"dynamic": "strict",
-			"properties": {
-				"title": {
-					"type": "text"
-				},
-				"author": {
-					"type": "text"
-				}
-			}
-		}
-	}`
-	_, err = c.typedClient.Indices.Create(index).Raw(strings.NewReader(body)).Do(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create index: %w", err)
-	}
-	return nil
+	return c.RecreateIndex(ctx, index, benchmarkIndexDefinition())
 }
 
 func (c *Client) SingleInsert(ctx context.Context, index string, docs []map[string]interface{}) error {
 	for i, doc := range docs {
-		_, err := c.typedClient.Index(index).
-			Id(fmt.Sprintf("%d", i+1)).
-			Request(doc).
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal document %d: %w", i+1, err)
+		}
+		id, err := c.documentID(i+1, data)
+		if err != nil {
+			return err
+		}
+		_, err = c.indexRequest(index, id, data).
 			Do(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to insert document %d: %w", i+1, err)
+			return fmt.Errorf("failed to insert document %s: %w", id, err)
 		}
 	}
 	return nil
 }
 
-func (c *Client) BulkInsert(ctx context.Context, index string, docs []map[string]interface{}) error {
-	bulkCfg := esutil.BulkIndexerConfig{
-		Client: c.baseClient,
-		Index:  index,
+// indexRequest はClientの既定のパイプラインとルーティングを付けた1件分のIndex APIのリクエストを作成します。
+func (c *Client) indexRequest(index, id string, data []byte) *coreindex.Index {
+	req := c.typedClient.Index(index).
+		Id(id).
+		Raw(bytes.NewReader(data))
+	if c.pipeline != "" {
+		req.Pipeline(c.pipeline)
 	}
+	if c.routing != "" {
+		req.Routing(c.routing)
+	}
+	return req
+}
 
-	indexer, err := esutil.NewBulkIndexer(bulkCfg)
-	if err != nil {
-		return fmt.Errorf("failed to create bulk indexer: %w", err)
+func (c *Client) BulkInsert(ctx context.Context, index string, docs []map[string]interface{}) (*BulkResult, error) {
+	collector := newBulkResultCollector(c.retryPolicy)
+	bulkCfg := esutil.BulkIndexerConfig{
+		Client:  c.baseClient,
+		Index:   index,
+		OnError: collector.onError,
 	}
+
+	writer := c.newBulkWriter(bulkCfg, collector)
+	arena := c.newEncodeArena()
 	for i, doc := range docs {
-		data, err := json.Marshal(doc)
+		data, body, err := arena.Encode(doc)
 		if err != nil {
-			return fmt.Errorf("failed to marshal document %d: %w", i+1, err)
+			return nil, writer.discard(ctx, fmt.Errorf("failed to marshal document %d: %w", i+1, err))
+		}
+		id, err := c.documentID(i+1, data)
+		if err != nil {
+			return nil, writer.discard(ctx, err)
 		}
-		err = indexer.Add(
+		err = writer.add(
 			ctx,
+			"",
 			esutil.BulkIndexerItem{
 				Index:      index,
 				Action:     "index",
-				DocumentID: fmt.Sprintf("%d", i+1),
-				Body:       strings.NewReader(string(data)),
+				DocumentID: id,
+				Body:       body,
 			},
 		)
 		if err != nil {
-			return fmt.Errorf("failed to add document %d to bulk indexer: %w", i+1, err)
+			return nil, writer.discard(ctx, fmt.Errorf("failed to add document %s to bulk indexer: %w", id, err))
 		}
 	}
-	if err := indexer.Close(ctx); err != nil {
-		return fmt.Errorf("failed to close bulk indexer: %w", err)
-	}
-	return nil
+	return c.finishEncodedBulk(ctx, writer, arena)
 }
 
 func (c *Client) SingleInsertWithRefresh(ctx context.Context, index string, docs []map[string]interface{}) error {
 	for i, doc := range docs {
-		_, err := c.typedClient.Index(index).
-			Id(fmt.Sprintf("%d", i+1)).
-			Request(doc).
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal document %d: %w", i+1, err)
+		}
+		id, err := c.documentID(i+1, data)
+		if err != nil {
+			return err
+		}
+		_, err = c.indexRequest(index, id, data).
 			Refresh(refresh.True).
 			Do(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to insert document %d: %w", i+1, err)
+			return fmt.Errorf("failed to insert document %s: %w", id, err)
 		}
 	}
 	return nil
 }
 
-func (c *Client) BulkInsertWithRefresh(ctx context.Context, index string, docs []map[string]interface{}) error {
+func (c *Client) BulkInsertWithRefresh(ctx context.Context, index string, docs []map[string]interface{}) (*BulkResult, error) {
+	collector := newBulkResultCollector(c.retryPolicy)
 	bulkCfg := esutil.BulkIndexerConfig{
 		Client:  c.baseClient,
 		Index:   index,
 		Refresh: "true",
+		OnError: collector.onError,
 	}
 
-	indexer, err := esutil.NewBulkIndexer(bulkCfg)
-	if err != nil {
-		return fmt.Errorf("failed to create bulk indexer: %w", err)
-	}
+	writer := c.newBulkWriter(bulkCfg, collector)
+	arena := c.newEncodeArena()
 	for i, doc := range docs {
-		data, err := json.Marshal(doc)
+		data, body, err := arena.Encode(doc)
 		if err != nil {
-			return fmt.Errorf("failed to marshal document %d: %w", i+1, err)
+			return nil, writer.discard(ctx, fmt.Errorf("failed to marshal document %d: %w", i+1, err))
+		}
+		id, err := c.documentID(i+1, data)
+		if err != nil {
+			return nil, writer.discard(ctx, err)
 		}
-		err = indexer.Add(
+		err = writer.add(
 			ctx,
+			"",
 			esutil.BulkIndexerItem{
 				Index:      index,
 				Action:     "index",
-				DocumentID: fmt.Sprintf("%d", i+1),
-				Body:       strings.NewReader(string(data)),
+				DocumentID: id,
+				Body:       body,
 			},
 		)
 		if err != nil {
-			return fmt.Errorf("failed to add document %d to bulk indexer: %w", i+1, err)
+			return nil, writer.discard(ctx, fmt.Errorf("failed to add document %s to bulk indexer: %w", id, err))
 		}
 	}
-	if err := indexer.Close(ctx); err != nil {
-		return fmt.Errorf("failed to close bulk indexer: %w", err)
-	}
-	return nil
+	return c.finishEncodedBulk(ctx, writer, arena)
 }
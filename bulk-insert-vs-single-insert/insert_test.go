@@ -1,13 +1,36 @@
-package main
+package bulkinsert
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"testing"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/kurakura967/go-elasticsearch-playground/docgen"
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
 )
 
+// esAddr はベンチマークの接続先です。空の場合はプロセス内の疑似サーバー(esfake)に接続するため、Dockerは不要です。
+// 実際のクラスタで計測する場合は go test -bench=. -es=http://localhost:9200 のように指定します。
+var esAddr = flag.String("es", "", "Elasticsearch address for benchmarks (default: in-process fake server)")
+
+// benchmarkConfig は-esで指定したクラスタ、または疑似サーバーへの接続設定を返します。
+func benchmarkConfig(b *testing.B) elasticsearch.Config {
+	if *esAddr != "" {
+		return elasticsearch.Config{Addresses: []string{*esAddr}}
+	}
+	return esfake.New(b).Config()
+}
+
+// corpusSeed はベンチマークで投入するドキュメントを生成するシードです。
+var corpusSeed = flag.Uint64("seed", 1, "seed for the synthetic benchmark corpus")
+
+// benchmarkDocs はTMDBの映画と同じ形の合成ドキュメントを生成します。同じ-seedからは常に同じドキュメントになります。
+func benchmarkDocs(num int) []map[string]interface{} {
+	return docgen.Generate(docgen.TMDB(), *corpusSeed, num)
+}
+
 // generateDocs は指定された数のダミードキュメントを生成します。
 func generateDocs(num int) []map[string]interface{} {
 	docs := make([]map[string]interface{}, num)
@@ -20,13 +43,34 @@ func generateDocs(num int) []map[string]interface{} {
 	return docs
 }
 
+// book は型付きドキュメント投入のベンチマークで使用するドキュメントです。
+type book struct {
+	ID     string `json:"-"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+}
+
+// generateBooks は指定された数の型付きダミードキュメントを生成します。
+func generateBooks(num int) []book {
+	books := make([]book, num)
+	for i := 0; i < num; i++ {
+		books[i] = book{
+			ID:     fmt.Sprintf("book-%d", i+1),
+			Title:  fmt.Sprintf("Test Document %d", i+1),
+			Author: "Test Author",
+		}
+	}
+	return books
+}
+
+var bookOptions = DocumentOptions[book]{
+	ID: func(b book) string { return b.ID },
+}
+
 // BenchmarkInsert は SingleInsert と BulkInsert のパフォーマンスを比較します。
 func BenchmarkInsert(b *testing.B) {
 	// Elasticsearch クライアントのセットアップ
-	cfg := elasticsearch.Config{
-		Addresses: []string{"http://localhost:9200"},
-	}
-	client, err := NewClient(cfg)
+	client, err := NewClient(benchmarkConfig(b))
 	if err != nil {
 		b.Fatalf("failed to create client: %v", err)
 	}
@@ -37,7 +81,7 @@ func BenchmarkInsert(b *testing.B) {
 	for _, count := range docCounts {
 		// SingleInsert のベンチマーク
 		b.Run(fmt.Sprintf("SingleInsert/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("test-single-%d", count)
 
 			b.ResetTimer()
@@ -60,7 +104,7 @@ func BenchmarkInsert(b *testing.B) {
 
 		// BulkInsert のベンチマーク
 		b.Run(fmt.Sprintf("BulkInsert/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("test-bulk-%d", count)
 
 			b.ResetTimer()
@@ -74,16 +118,37 @@ func BenchmarkInsert(b *testing.B) {
 				b.StartTimer()
 
 				// 実際の挿入処理を計測
-				err = client.BulkInsert(context.Background(), indexName, docs)
+				_, err = client.BulkInsert(context.Background(), indexName, docs)
 				if err != nil {
 					b.Fatalf("BulkInsert failed: %v", err)
 				}
 			}
 		})
 
+		// 型付きドキュメントを使用した BulkInsert のベンチマーク
+		b.Run(fmt.Sprintf("TypedBulkInsert/%d_docs", count), func(b *testing.B) {
+			books := generateBooks(count)
+			indexName := fmt.Sprintf("test-typed-bulk-%d", count)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				err := client.CreateIndex(context.Background(), indexName)
+				if err != nil {
+					b.Fatalf("failed to create index: %v", err)
+				}
+				b.StartTimer()
+
+				_, err = BulkInsert(context.Background(), client, indexName, books, bookOptions)
+				if err != nil {
+					b.Fatalf("TypedBulkInsert failed: %v", err)
+				}
+			}
+		})
+
 		// SingleInsertWithRefresh のベンチマーク
 		b.Run(fmt.Sprintf("SingleInsertWithRefresh/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("test-single-refresh-%d", count)
 
 			b.ResetTimer()
@@ -104,7 +169,7 @@ func BenchmarkInsert(b *testing.B) {
 
 		// BulkInsertWithRefresh のベンチマーク
 		b.Run(fmt.Sprintf("BulkInsertWithRefresh/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("test-bulk-refresh-%d", count)
 
 			b.ResetTimer()
@@ -116,7 +181,7 @@ func BenchmarkInsert(b *testing.B) {
 				}
 				b.StartTimer()
 
-				err = client.BulkInsertWithRefresh(context.Background(), indexName, docs)
+				_, err = client.BulkInsertWithRefresh(context.Background(), indexName, docs)
 				if err != nil {
 					b.Fatalf("BulkInsertWithRefresh failed: %v", err)
 				}
@@ -0,0 +1,120 @@
+package bulkinsert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// loadSettings は大量投入の間だけ変更する設定です。
+// リフレッシュとレプリカへの複製を止めることで、投入のスループットを上げます。
+var loadSettings = map[string]interface{}{
+	"index.refresh_interval":     "-1",
+	"index.number_of_replicas":   0,
+	"index.auto_expand_replicas": "false",
+}
+
+// LoadSessionOptions は大量投入モードの動作を定義します。
+type LoadSessionOptions struct {
+	// MaxNumSegments は終了時にforce mergeするセグメント数です。0の場合はforce mergeしません。
+	MaxNumSegments int
+	// RestoreTimeout は終了処理(設定の復元、リフレッシュ、force merge)にかけてよい時間です。省略時は1分です。
+	RestoreTimeout time.Duration
+}
+
+// LoadSession はインデックスを大量投入用の設定に切り替えている期間を表します。
+// 必ずFinishを呼び出して設定を元に戻してください。
+type LoadSession struct {
+	client   *Client
+	index    string
+	opts     LoadSessionOptions
+	saved    map[string]interface{}
+	finished bool
+}
+
+// StartLoadSession は現在の設定を保存してから、インデックスを大量投入用の設定
+// (refresh_interval: -1、レプリカ数0)に切り替えます。
+func (c *Client) StartLoadSession(ctx context.Context, index string, opts LoadSessionOptions) (*LoadSession, error) {
+	live, err := c.GetIndexDefinition(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	session := &LoadSession{
+		client: c,
+		index:  index,
+		opts:   opts,
+		saved:  savedLoadSettings(flattenSettings(live.Settings)),
+	}
+	if err := c.putSettings(ctx, index, loadSettings); err != nil {
+		return nil, fmt.Errorf("failed to switch %s to bulk load settings: %w", index, err)
+	}
+	return session, nil
+}
+
+// savedLoadSettings は大量投入の間に変更する設定の現在値を返します。
+// 明示的に設定されていない項目はnullで復元することで、デフォルト値に戻します。
+func savedLoadSettings(live map[string]interface{}) map[string]interface{} {
+	saved := make(map[string]interface{}, len(loadSettings))
+	for key := range loadSettings {
+		saved[key] = live[key]
+	}
+	return saved
+}
+
+// Finish は保存しておいた設定を復元し、明示的にリフレッシュします。
+// 投入が失敗した場合やctxがキャンセルされた場合でも設定を復元できるよう、ctxのキャンセルは引き継ぎません。
+// 2回目以降の呼び出しは何もしません。
+func (s *LoadSession) Finish(ctx context.Context) error {
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+
+	timeout := s.opts.RestoreTimeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	if err := s.client.putSettings(ctx, s.index, s.saved); err != nil {
+		return fmt.Errorf("failed to restore settings of %s: %w", s.index, err)
+	}
+	if _, err := s.client.typedClient.Indices.Refresh().Index(s.index).Do(ctx); err != nil {
+		return fmt.Errorf("failed to refresh %s: %w", s.index, err)
+	}
+	if s.opts.MaxNumSegments > 0 {
+		_, err := s.client.typedClient.Indices.Forcemerge().
+			Index(s.index).
+			MaxNumSegments(fmt.Sprintf("%d", s.opts.MaxNumSegments)).
+			Do(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to force merge %s: %w", s.index, err)
+		}
+	}
+	return nil
+}
+
+// RunLoadSession は大量投入モードに切り替えてからloadを実行し、loadの成否にかかわらず設定を復元します。
+func (c *Client) RunLoadSession(ctx context.Context, index string, opts LoadSessionOptions, load func(ctx context.Context) error) (err error) {
+	session, err := c.StartLoadSession(ctx, index, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, session.Finish(ctx))
+	}()
+	return load(ctx)
+}
+
+func (c *Client) putSettings(ctx context.Context, index string, settings map[string]interface{}) error {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+	_, err = c.typedClient.Indices.PutSettings().Indices(index).Raw(strings.NewReader(string(body))).Do(ctx)
+	return err
+}
@@ -0,0 +1,26 @@
+package bulkinsert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSavedLoadSettings(t *testing.T) {
+	// GET /_settings のレスポンスを展開したもの。number_of_replicasは明示的に設定されていない
+	live := flattenSettings(map[string]interface{}{
+		"index": map[string]interface{}{
+			"refresh_interval":     "60s",
+			"auto_expand_replicas": "0-all",
+			"number_of_shards":     "1",
+		},
+	})
+
+	want := map[string]interface{}{
+		"index.refresh_interval":     "60s",
+		"index.auto_expand_replicas": "0-all",
+		"index.number_of_replicas":   nil,
+	}
+	if got := savedLoadSettings(live); !reflect.DeepEqual(got, want) {
+		t.Errorf("savedLoadSettings() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package bulkinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithPipeline は各メソッドで使用する既定の取り込みパイプライン(ingest pipeline)を設定します。
+// DocumentOptions.PipelineやBulkOperation.Pipelineで、ドキュメントごとに上書きできます。
+// インデックスのdefault_pipelineも使わずに登録する場合は、ドキュメントのパイプラインに"_none"を指定します。
+func (c *Client) WithPipeline(pipeline string) *Client {
+	c.pipeline = pipeline
+	return c
+}
+
+// WithRouting は各メソッドで使用する既定のルーティング値を設定します。
+// DocumentOptions.RoutingやBulkOperation.Routingで、ドキュメントごとに上書きできます。
+func (c *Client) WithRouting(routing string) *Client {
+	c.routing = routing
+	return c
+}
+
+// bulkWriter はパイプラインごとにBulkIndexerを作成してアイテムを振り分けます。
+// BulkIndexerItemにはパイプラインを指定できないため、リクエスト単位で指定できるBulkIndexerConfig.Pipelineを使います。
+type bulkWriter struct {
+	client    *Client
+	cfg       esutil.BulkIndexerConfig
+	collector *bulkResultCollector
+	indexers  map[string]esutil.BulkIndexer
+	// abortCtx はdiscardで登録をやめたときに取り消され、送信中のリクエストを止めます。
+	abortCtx context.Context
+	abort    context.CancelCauseFunc
+}
+
+func (c *Client) newBulkWriter(cfg esutil.BulkIndexerConfig, collector *bulkResultCollector) *bulkWriter {
+	abortCtx, abort := context.WithCancelCause(context.Background())
+	return &bulkWriter{
+		client:    c,
+		cfg:       cfg,
+		collector: collector,
+		indexers:  make(map[string]esutil.BulkIndexer),
+		abortCtx:  abortCtx,
+		abort:     abort,
+	}
+}
+
+// add はアイテムをpipelineのBulkIndexerに追加します。pipelineとアイテムのルーティングが空の場合はClientの既定値を使用します。
+// フラッシュごとのスパンとメトリクスを記録するため、アイテムのコールバックとBulkIndexerの設定を包みます。
+func (w *bulkWriter) add(ctx context.Context, pipeline string, item esutil.BulkIndexerItem) error {
+	if pipeline == "" {
+		pipeline = w.client.pipeline
+	}
+	if item.Routing == "" {
+		item.Routing = w.client.routing
+	}
+	item.OnFailure = w.collector.onFailureFor(pipeline)
+	item = w.client.telemetry.InstrumentItem(ctx, item, w.cfg.Index)
+
+	indexer, ok := w.indexers[pipeline]
+	if !ok {
+		cfg := w.cfg
+		cfg.Pipeline = pipeline
+		cfg = w.client.telemetry.Instrument(cfg, trace.SpanContextFromContext(ctx))
+		// 登録を途中でやめたときに、送信中と未送信のリクエストを取り消します。
+		cfg = esbulk.Abortable(cfg, w.abortCtx)
+		// リクエスト単位で失敗したドキュメントもアイテムごとに集計するため、トランスポートを包みます。
+		cfg = esbulk.Itemize(cfg)
+		var err error
+		indexer, err = esutil.NewBulkIndexer(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create bulk indexer: %w", err)
+		}
+		w.indexers[pipeline] = indexer
+	}
+	return indexer.Add(ctx, item)
+}
+
+// close はすべてのBulkIndexerを閉じ、統計をcollectorに加算します。
+func (w *bulkWriter) close(ctx context.Context) error {
+	for _, indexer := range w.indexers {
+		if err := indexer.Close(ctx); err != nil {
+			return fmt.Errorf("failed to close bulk indexer: %w", err)
+		}
+		w.collector.addStats(indexer.Stats())
+	}
+	return nil
+}
+
+// discard は送信中と未送信のリクエストを取り消してからすべてのBulkIndexerを閉じ、errを返します。
+// 途中でエラーを返すメソッドが、追加済みのアイテムを登録しないまま、ワーカーを残さずに終えるために使います。
+func (w *bulkWriter) discard(ctx context.Context, err error) error {
+	w.abort(err)
+	_ = w.close(context.WithoutCancel(ctx))
+	return err
+}
+
+// PutPipeline はJSONの定義から取り込みパイプラインを作成します。同じIDのパイプラインがある場合は更新します。
+// 定義の形はIngest Put Pipeline APIのリクエストボディ({"description": ..., "processors": [...]})と同じです。
+func (c *Client) PutPipeline(ctx context.Context, id string, definition []byte) error {
+	if !json.Valid(definition) {
+		return fmt.Errorf("pipeline definition for %s is not valid JSON", id)
+	}
+	if _, err := c.typedClient.Ingest.PutPipeline(id).Raw(bytes.NewReader(definition)).Do(ctx); err != nil {
+		return fmt.Errorf("failed to put pipeline %s: %w", id, err)
+	}
+	return nil
+}
+
+// PutPipelineFile はファイルに書かれたJSONの定義から取り込みパイプラインを作成、または更新します。
+func (c *Client) PutPipelineFile(ctx context.Context, id, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read pipeline definition: %w", err)
+	}
+	return c.PutPipeline(ctx, id, data)
+}
+
+// SimulatedDocument はパイプラインを試しに通した1件のドキュメントの結果です。
+type SimulatedDocument struct {
+	// Source はパイプラインで加工された後のドキュメントです。失敗した場合は空です。
+	Source    json.RawMessage
+	ErrorType string
+	Reason    string
+}
+
+// Failed はパイプラインの処理が失敗したかどうかを返します。
+func (d SimulatedDocument) Failed() bool {
+	return d.ErrorType != ""
+}
+
+// SimulatePipeline はドキュメントを_ingest/pipeline/_simulateで登録せずにパイプラインに通し、加工後のドキュメントを返します。
+// 大量の登録の前に、パイプラインがドキュメントを期待どおりに加工するかを確認するために使用します。
+// pipelineが空の場合はWithPipelineで設定したパイプラインを使用します。結果はdocsと同じ順に並びます。
+func SimulatePipeline[T any](ctx context.Context, c *Client, pipeline string, docs []T) ([]SimulatedDocument, error) {
+	if pipeline == "" {
+		pipeline = c.pipeline
+	}
+	if pipeline == "" {
+		return nil, fmt.Errorf("pipeline is required to simulate")
+	}
+
+	sources := make([]types.Document, 0, len(docs))
+	for i, doc := range docs {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal document %d: %w", i+1, err)
+		}
+		sources = append(sources, types.Document{Source_: data})
+	}
+	res, err := c.typedClient.Ingest.Simulate().Id(pipeline).Docs(sources...).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate pipeline %s: %w", pipeline, err)
+	}
+	if len(res.Docs) != len(docs) {
+		return nil, fmt.Errorf("simulate pipeline %s returned %d documents for %d", pipeline, len(res.Docs), len(docs))
+	}
+
+	results := make([]SimulatedDocument, 0, len(res.Docs))
+	for _, doc := range res.Docs {
+		var result SimulatedDocument
+		switch {
+		case doc.Error != nil:
+			result.ErrorType = doc.Error.Type
+			if doc.Error.Reason != nil {
+				result.Reason = *doc.Error.Reason
+			}
+		case doc.Doc != nil:
+			if result.Source, err = json.Marshal(doc.Doc.Source_); err != nil {
+				return nil, fmt.Errorf("failed to encode simulated document: %w", err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
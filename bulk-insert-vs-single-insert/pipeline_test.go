@@ -0,0 +1,135 @@
+package bulkinsert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+const upperAuthorPipeline = `{"processors":[{"uppercase":{"field":"author"}},{"set":{"field":"source","value":"import"}}]}`
+
+func newPipelineTestClient(t *testing.T) (*esfake.Server, *Client) {
+	t.Helper()
+
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := client.PutPipeline(context.Background(), "upper-author", []byte(upperAuthorPipeline)); err != nil {
+		t.Fatalf("PutPipeline failed: %v", err)
+	}
+	return srv, client
+}
+
+func TestBulkInsertWithPipelineAndRouting(t *testing.T) {
+	srv, client := newPipelineTestClient(t)
+	client.WithPipeline("upper-author").
+		WithRouting("shelf-a").
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+	// 再送されたアイテムも、最初に送信したときと同じパイプラインを通ります。
+	srv.InjectItem(esfake.ItemFault{Match: func(item esfake.BulkItem) bool { return item.ID == "book-1" }, Times: 1})
+	opts := DocumentOptions[book]{
+		ID: func(b book) string { return b.ID },
+		Pipeline: func(b book) string {
+			if b.ID == "book-2" {
+				return "_none"
+			}
+			return ""
+		},
+		Routing: func(b book) string {
+			if b.ID == "book-3" {
+				return "shelf-b"
+			}
+			return ""
+		},
+	}
+	result, err := BulkInsert(context.Background(), client, "books", generateBooks(3), opts)
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Indexed != 3 || result.Retried != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if got := storedDocument(t, srv, "book-1"); got != `{"author":"TEST AUTHOR","source":"import","title":"Test Document 1"}` {
+		t.Errorf("document book-1 = %s", got)
+	}
+	if got := storedDocument(t, srv, "book-2"); got != `{"title":"Test Document 2","author":"Test Author"}` {
+		t.Errorf("expected book-2 to skip the pipeline, got %s", got)
+	}
+	for id, want := range map[string]string{"book-1": "shelf-a", "book-3": "shelf-b"} {
+		res, err := client.typedClient.Get("books", id).Routing(want).Do(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", id, err)
+		}
+		if res.Routing_ == nil || *res.Routing_ != want {
+			t.Errorf("routing of %s = %v, want %s", id, res.Routing_, want)
+		}
+	}
+}
+
+func TestSingleInsertWithPipeline(t *testing.T) {
+	srv, client := newPipelineTestClient(t)
+	client.WithPipeline("upper-author")
+
+	if err := client.SingleInsert(context.Background(), "books", generateDocs(1)); err != nil {
+		t.Fatalf("SingleInsert failed: %v", err)
+	}
+	opts := DocumentOptions[book]{ID: func(b book) string { return b.ID }, Pipeline: func(book) string { return "_none" }}
+	if err := SingleInsert(context.Background(), client, "books", generateBooks(1), opts); err != nil {
+		t.Fatalf("SingleInsert failed: %v", err)
+	}
+
+	idx, _ := srv.Index("books")
+	if got := string(idx.Documents["1"]); got != `{"author":"TEST AUTHOR","source":"import","title":"Test Document 1"}` {
+		t.Errorf("document 1 = %s", got)
+	}
+	if got := string(idx.Documents["book-1"]); got != `{"title":"Test Document 1","author":"Test Author"}` {
+		t.Errorf("expected book-1 to skip the pipeline, got %s", got)
+	}
+}
+
+func TestBulkOperationPipeline(t *testing.T) {
+	srv, client := newPipelineTestClient(t)
+
+	ops := []BulkOperation{IndexOperation("1", map[string]string{"author": "a"}), IndexOperation("2", map[string]string{"author": "b"})}
+	ops[1].Pipeline = "missing"
+	result, err := client.Bulk(context.Background(), "books", ops)
+	if err != nil || result.Indexed != 1 || len(result.Failures) != 1 || result.Failures[0].DocumentID != "2" {
+		t.Fatalf("expected document 2 to fail for a missing pipeline, got %+v, %v", result, err)
+	}
+	if got := storedDocument(t, srv, "1"); got != `{"author":"a"}` {
+		t.Errorf("document 1 = %s", got)
+	}
+}
+
+func TestSimulatePipeline(t *testing.T) {
+	_, client := newPipelineTestClient(t)
+
+	if _, err := SimulatePipeline(context.Background(), client, "", generateBooks(1)); err == nil {
+		t.Error("expected an error without a pipeline")
+	}
+	client.WithPipeline("upper-author")
+	docs := []map[string]interface{}{{"author": "a"}, {"author": 1}}
+	results, err := SimulatePipeline(context.Background(), client, "", docs)
+	if err != nil {
+		t.Fatalf("SimulatePipeline failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Failed() || string(results[0].Source) != `{"author":"A","source":"import"}` {
+		t.Errorf("unexpected result: %+v", results[0])
+	}
+	if !results[1].Failed() || results[1].Reason == "" {
+		t.Errorf("expected the second document to fail, got %+v", results[1])
+	}
+
+	if err := client.PutPipeline(context.Background(), "broken", []byte(`{"processors":`)); err == nil {
+		t.Error("expected an error for an invalid definition")
+	}
+}
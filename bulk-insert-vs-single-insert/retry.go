@@ -0,0 +1,48 @@
+package bulkinsert
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// RetryPolicy はBulk APIでリトライ可能な失敗(429など)となったアイテムの再送方法を定義します。
+type RetryPolicy = esbulk.RetryPolicy
+
+// DefaultRetryPolicy はよく使われる設定のRetryPolicyを返します。
+func DefaultRetryPolicy() RetryPolicy {
+	return esbulk.DefaultRetryPolicy()
+}
+
+// WithRetryPolicy はBulk APIの各メソッドで使用するリトライポリシーを設定します。
+func (c *Client) WithRetryPolicy(policy RetryPolicy) *Client {
+	c.retryPolicy = &policy
+	return c
+}
+
+// retryFailed はcollectorに溜まったリトライ可能なアイテムを、ポリシーに従って新しいBulkIndexerで再送します。
+// 再送するアイテムは最初に送信したときと同じパイプラインを使用します。
+// 試行回数か時間予算を使い切った場合、残ったアイテムは失敗として記録されます。
+func (c *Client) retryFailed(ctx context.Context, cfg esutil.BulkIndexerConfig, collector *bulkResultCollector) error {
+	policy := collector.policy
+	if policy == nil {
+		return nil
+	}
+
+	pending := func() int {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		return len(collector.pending)
+	}
+	return policy.Retry(ctx, collector.started, pending, func() error {
+		writer := c.newBulkWriter(cfg, collector)
+		for _, p := range collector.takePending() {
+			if err := writer.add(ctx, p.pipeline, esbulk.RetryItem(p.item)); err != nil {
+				return writer.discard(ctx, fmt.Errorf("failed to add document %s to bulk indexer: %w", p.item.DocumentID, err))
+			}
+		}
+		return writer.close(ctx)
+	})
+}
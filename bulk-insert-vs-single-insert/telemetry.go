@@ -0,0 +1,29 @@
+package bulkinsert
+
+import (
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName はスパンとメトリクスを記録するときの計装スコープ名です。
+const instrumentationName = "github.com/kurakura967/go-elasticsearch-playground/bulk-insert-vs-single-insert"
+
+// WithTracerProvider はBulk APIのフラッシュごとのスパンを記録するTracerProviderを設定します。
+// 設定しない場合はotel.GetTracerProviderが返すグローバルなTracerProviderを使用します。
+func (c *Client) WithTracerProvider(tp trace.TracerProvider) *Client {
+	c.telemetry = newTelemetry(tp, c.telemetry.MeterProvider())
+	return c
+}
+
+// WithMeterProvider は登録件数、失敗件数、フラッシュの所要時間、送信したバイト数を記録するMeterProviderを設定します。
+// 設定しない場合はotel.GetMeterProviderが返すグローバルなMeterProviderを使用します。
+func (c *Client) WithMeterProvider(mp metric.MeterProvider) *Client {
+	c.telemetry = newTelemetry(c.telemetry.TracerProvider(), mp)
+	return c
+}
+
+// newTelemetry はこのモジュールの計装スコープ名で記録するTelemetryを作成します。
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *esbulk.Telemetry {
+	return esbulk.NewTelemetry(instrumentationName, tp, mp)
+}
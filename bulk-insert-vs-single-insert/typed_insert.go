@@ -0,0 +1,172 @@
+package bulkinsert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/versiontype"
+)
+
+// DocumentOptions は型付きドキュメントからメタデータを取り出す方法を定義します。
+// IDかIDStrategyのどちらかは必須で、Routing、Pipeline、Version、SeqNoは必要な場合のみ指定します。
+type DocumentOptions[T any] struct {
+	// ID はドキュメントIDを返します。投入順に依存しない値を返すことで、再投入時も同じIDになります。
+	ID func(T) string
+	// IDStrategy はドキュメントのJSONからIDを決定します。IDの代わりにFieldIDやContentHashIDなどを使う場合に指定します。
+	IDStrategy IDStrategy
+	// Routing はルーティング値を返します。空文字の場合はWithRoutingで設定した既定値を使用します。
+	Routing func(T) string
+	// Pipeline は取り込みパイプラインを返します。空文字の場合はWithPipelineで設定した既定値を使用します。
+	Pipeline func(T) string
+	// Version は外部バージョン(version_type: external)を返します。falseの場合は指定しません。
+	Version func(T) (int64, bool)
+	// SeqNo は読み込んだ時点の_seq_noと_primary_termを返します。falseの場合は指定しません。
+	// 指定した場合、その時点から他の書き込みがあったドキュメントは競合として扱われます。
+	SeqNo func(T) (seqNo, primaryTerm int64, ok bool)
+}
+
+func (o DocumentOptions[T]) validate() error {
+	if o.ID == nil && o.IDStrategy == nil {
+		return fmt.Errorf("document options: ID extractor or IDStrategy is required")
+	}
+	if o.ID != nil && o.IDStrategy != nil {
+		return fmt.Errorf("document options: ID and IDStrategy cannot be combined")
+	}
+	if o.Version != nil && o.SeqNo != nil {
+		return fmt.Errorf("document options: Version and SeqNo cannot be combined")
+	}
+	return nil
+}
+
+func (o DocumentOptions[T]) id(doc T, data json.RawMessage, n int) (string, error) {
+	if o.IDStrategy != nil {
+		return o.IDStrategy.documentID(n, data)
+	}
+	id := o.ID(doc)
+	if id == "" {
+		return "", fmt.Errorf("document %d has an empty ID", n)
+	}
+	return id, nil
+}
+
+func (o DocumentOptions[T]) routing(doc T) string {
+	if o.Routing == nil {
+		return ""
+	}
+	return o.Routing(doc)
+}
+
+func (o DocumentOptions[T]) pipeline(doc T) string {
+	if o.Pipeline == nil {
+		return ""
+	}
+	return o.Pipeline(doc)
+}
+
+func (o DocumentOptions[T]) version(doc T) (int64, bool) {
+	if o.Version == nil {
+		return 0, false
+	}
+	return o.Version(doc)
+}
+
+// guard はドキュメントに付ける楽観的排他制御の条件を返します。
+func (o DocumentOptions[T]) guard(doc T) writeGuard {
+	var guard writeGuard
+	if version, ok := o.version(doc); ok {
+		guard.version = &version
+	}
+	if o.SeqNo != nil {
+		if seqNo, primaryTerm, ok := o.SeqNo(doc); ok {
+			guard.seqNo, guard.primaryTerm = &seqNo, &primaryTerm
+		}
+	}
+	return guard
+}
+
+// SingleInsert は型付きドキュメントのスライスを1件ずつ登録します。
+func SingleInsert[T any](ctx context.Context, c *Client, index string, docs []T, opts DocumentOptions[T]) error {
+	return SingleInsertSeq(ctx, c, index, slices.Values(docs), opts)
+}
+
+// SingleInsertSeq はイテレータから受け取った型付きドキュメントを1件ずつ登録します。
+// バージョンの競合はClientのConflictPolicyに従って解決し、解決できない場合は*VersionConflictErrorを返します。
+func SingleInsertSeq[T any](ctx context.Context, c *Client, index string, docs iter.Seq[T], opts DocumentOptions[T]) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	n := 0
+	for doc := range docs {
+		n++
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal document %d: %w", n, err)
+		}
+		id, err := opts.id(doc, data, n)
+		if err != nil {
+			return err
+		}
+		if err := c.indexGuarded(ctx, index, id, opts.routing(doc), opts.pipeline(doc), data, opts.guard(doc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BulkInsert は型付きドキュメントのスライスをBulk APIで登録します。
+func BulkInsert[T any](ctx context.Context, c *Client, index string, docs []T, opts DocumentOptions[T]) (*BulkResult, error) {
+	return BulkInsertSeq(ctx, c, index, slices.Values(docs), opts)
+}
+
+// BulkInsertSeq はイテレータから受け取った型付きドキュメントをBulk APIで登録します。
+// イテレータは逐次消費されるため、全件をメモリ上に保持する必要はありません。
+func BulkInsertSeq[T any](ctx context.Context, c *Client, index string, docs iter.Seq[T], opts DocumentOptions[T]) (*BulkResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	collector := newBulkResultCollector(c.retryPolicy)
+	bulkCfg := esutil.BulkIndexerConfig{
+		Client:  c.baseClient,
+		Index:   index,
+		OnError: collector.onError,
+	}
+	writer := c.newBulkWriter(bulkCfg, collector)
+	arena := c.newEncodeArena()
+
+	n := 0
+	for doc := range docs {
+		n++
+		data, body, err := arena.Encode(doc)
+		if err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("failed to marshal document %d: %w", n, err))
+		}
+		id, err := opts.id(doc, data, n)
+		if err != nil {
+			return nil, writer.discard(ctx, err)
+		}
+
+		item := esutil.BulkIndexerItem{
+			Index:      index,
+			Action:     "index",
+			DocumentID: id,
+			Routing:    opts.routing(doc),
+			Body:       body,
+		}
+		guard := opts.guard(doc)
+		if guard.version != nil {
+			item.Version = guard.version
+			item.VersionType = versiontype.External.String()
+		}
+		item.IfSeqNo, item.IfPrimaryTerm = guard.seqNo, guard.primaryTerm
+		if err := writer.add(ctx, opts.pipeline(doc), item); err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("failed to add document %s to bulk indexer: %w", id, err))
+		}
+	}
+	return c.finishEncodedBulk(ctx, writer, arena)
+}
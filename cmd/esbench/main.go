@@ -0,0 +1,272 @@
+// esbench は登録されている投入方法を、指定したクラスタと条件の組み合わせで計測し、結果をJSONとCSVで出力します。
+//
+//	go run . -addresses http://localhost:9200 -strategies bulk,concurrent-v3 -docs 1000,10000 -workers 1,4,8 -json report.json -csv report.csv
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/kurakura967/go-elasticsearch-playground/docgen"
+)
+
+// config はコマンドライン引数から組み立てた実行条件です。
+type config struct {
+	addresses  []string
+	username   string
+	password   string
+	index      string
+	strategies []string
+	docs       []int
+	workers    []int
+	chunkSizes []int
+	runs       int
+	seed       uint64
+	jsonPath   string
+	csvPath    string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("esbench: ")
+
+	cfg, list, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if list {
+		for _, name := range strategyNames() {
+			fmt.Printf("%-16s %s\n", name, strategies[name].Description)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// 中断された場合も、それまでに計測した結果は書き出します。
+	report, err := run(ctx, cfg)
+	if report != nil {
+		if err := writeReport(report, cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseFlags(args []string) (config, bool, error) {
+	fs := flag.NewFlagSet("esbench", flag.ContinueOnError)
+	var (
+		cfg                       config
+		addresses, names          string
+		docs, workers, chunkSizes string
+		list                      bool
+	)
+	fs.StringVar(&addresses, "addresses", "http://localhost:9200", "comma-separated Elasticsearch addresses")
+	fs.StringVar(&cfg.username, "username", "", "username for basic authentication")
+	fs.StringVar(&cfg.password, "password", "", "password for basic authentication")
+	fs.StringVar(&cfg.index, "index", "esbench", "index to load; it is recreated before every run")
+	fs.StringVar(&names, "strategies", "", "comma-separated strategies to run (default: all)")
+	fs.StringVar(&docs, "docs", "100,1000,10000", "comma-separated document counts")
+	fs.StringVar(&workers, "workers", "1,4,8", "comma-separated worker counts, for strategies that use workers")
+	fs.StringVar(&chunkSizes, "chunk-sizes", "100,1000", "comma-separated chunk sizes, for strategies that use chunks")
+	fs.IntVar(&cfg.runs, "runs", 1, "number of runs for each combination")
+	fs.Uint64Var(&cfg.seed, "seed", 1, "seed for the synthetic TMDB-shaped corpus; the same seed loads the same documents")
+	fs.StringVar(&cfg.jsonPath, "json", "", `write the JSON report to this file ("-" for stdout)`)
+	fs.StringVar(&cfg.csvPath, "csv", "", `write the CSV report to this file ("-" for stdout)`)
+	fs.BoolVar(&list, "list", false, "list the registered strategies and exit")
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	var err error
+	cfg.addresses = splitList(addresses)
+	cfg.strategies = splitList(names)
+	if cfg.docs, err = parseInts("docs", docs); err != nil {
+		return cfg, false, err
+	}
+	if cfg.workers, err = parseInts("workers", workers); err != nil {
+		return cfg, false, err
+	}
+	if cfg.chunkSizes, err = parseInts("chunk-sizes", chunkSizes); err != nil {
+		return cfg, false, err
+	}
+	if cfg.runs < 1 {
+		return cfg, false, fmt.Errorf("runs must be at least 1")
+	}
+	if cfg.jsonPath == "" && cfg.csvPath == "" {
+		cfg.jsonPath = "-"
+	}
+	return cfg, list, nil
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func parseInts(name, s string) ([]int, error) {
+	var values []int
+	for _, v := range splitList(s) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s value %q: must be a positive integer", name, v)
+		}
+		values = append(values, n)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s must not be empty", name)
+	}
+	return values, nil
+}
+
+// matrix は投入方法が参照する項目についてのみ条件を展開します。
+func matrix(s Strategy, cfg config) []Params {
+	workers, chunkSizes := []int{0}, []int{0}
+	if s.UsesWorkers {
+		workers = cfg.workers
+	}
+	if s.UsesChunkSize {
+		chunkSizes = cfg.chunkSizes
+	}
+
+	var params []Params
+	for _, docs := range cfg.docs {
+		for _, w := range workers {
+			for _, chunk := range chunkSizes {
+				params = append(params, Params{Docs: docs, Workers: w, ChunkSize: chunk})
+			}
+		}
+	}
+	return params
+}
+
+func run(ctx context.Context, cfg config) (*Report, error) {
+	selected, err := lookupStrategies(cfg.strategies)
+	if err != nil {
+		return nil, err
+	}
+
+	esCfg := elasticsearch.Config{
+		Addresses: cfg.addresses,
+		Username:  cfg.username,
+		Password:  cfg.password,
+	}
+	// インデックスの準備には記録しないクライアントを使い、計測対象のリクエストだけを集計します。
+	admin, err := elasticsearch.NewClient(esCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create elasticsearch client: %w", err)
+	}
+	rec := newRecorder(nil)
+	esCfg.Transport = rec
+
+	report := &Report{
+		StartedAt: time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		Addresses: cfg.addresses,
+		Seed:      cfg.seed,
+	}
+	for _, s := range selected {
+		insert, err := s.New(esCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up strategy %s: %w", s.Name, err)
+		}
+		for _, p := range matrix(s, cfg) {
+			docs := docgen.Generate(docgen.TMDB(), cfg.seed, p.Docs)
+			for i := 1; i <= cfg.runs; i++ {
+				if err := ctx.Err(); err != nil {
+					return report, err
+				}
+				if err := recreateIndex(ctx, admin, cfg.index); err != nil {
+					return report, err
+				}
+
+				rec.reset()
+				start := time.Now()
+				failed, err := insert(ctx, cfg.index, docs, p)
+				elapsed := time.Since(start)
+
+				result := newResult(s.Name, p, i, elapsed, rec.stats(), failed, err)
+				log.Printf("%s docs=%d workers=%d chunk_size=%d run=%d: %.0f docs/s, p99 %.1fms, %d failed",
+					s.Name, p.Docs, p.Workers, p.ChunkSize, i, result.DocsPerSec, result.LatencyP99Ms, result.FailedDocs)
+				if err != nil {
+					log.Printf("%s: %v", s.Name, err)
+				}
+				report.Results = append(report.Results, result)
+			}
+		}
+	}
+	return report, nil
+}
+
+// recreateIndex は既存のインデックスを削除してから空のインデックスを作成します。
+func recreateIndex(ctx context.Context, es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.Delete([]string{index}, es.Indices.Delete.WithContext(ctx), es.Indices.Delete.WithIgnoreUnavailable(true))
+	if err := checkResponse(res, err); err != nil {
+		return fmt.Errorf("failed to delete index %s: %w", index, err)
+	}
+	res, err = es.Indices.Create(index, es.Indices.Create.WithContext(ctx))
+	if err := checkResponse(res, err); err != nil {
+		return fmt.Errorf("failed to create index %s: %w", index, err)
+	}
+	return nil
+}
+
+// checkResponse はesapiのレスポンスを閉じ、エラーのステータスであればその内容を返します。
+func checkResponse(res *esapi.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s", res.String())
+	}
+	return nil
+}
+
+func writeReport(report *Report, cfg config) error {
+	if cfg.jsonPath != "" {
+		if err := writeTo(cfg.jsonPath, report.WriteJSON); err != nil {
+			return fmt.Errorf("failed to write JSON report: %w", err)
+		}
+	}
+	if cfg.csvPath != "" {
+		if err := writeTo(cfg.csvPath, report.WriteCSV); err != nil {
+			return fmt.Errorf("failed to write CSV report: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeTo(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMatrix(t *testing.T) {
+	cfg := config{docs: []int{100, 1000}, workers: []int{1, 4}, chunkSizes: []int{50}}
+
+	got := matrix(Strategy{UsesWorkers: true}, cfg)
+	want := []Params{
+		{Docs: 100, Workers: 1}, {Docs: 100, Workers: 4},
+		{Docs: 1000, Workers: 1}, {Docs: 1000, Workers: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("matrix(workers) = %+v, want %+v", got, want)
+	}
+
+	got = matrix(Strategy{}, cfg)
+	want = []Params{{Docs: 100}, {Docs: 1000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("matrix() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	cfg, _, err := parseFlags([]string{"-docs", "10, 20", "-strategies", "bulk,concurrent-v3", "-csv", "out.csv"})
+	if err != nil {
+		t.Fatalf("parseFlags failed: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.docs, []int{10, 20}) {
+		t.Errorf("docs = %v, want [10 20]", cfg.docs)
+	}
+	if cfg.jsonPath != "" || cfg.csvPath != "out.csv" {
+		t.Errorf("json = %q, csv = %q; want only the CSV report", cfg.jsonPath, cfg.csvPath)
+	}
+	if _, err := lookupStrategies(cfg.strategies); err != nil {
+		t.Errorf("lookupStrategies failed: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"-docs", "0"},
+		{"-workers", "four"},
+		{"-chunk-sizes", ""},
+		{"-runs", "0"},
+	} {
+		if _, _, err := parseFlags(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+	if _, err := lookupStrategies([]string{"unknown"}); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	report := &Report{Results: []Result{
+		newResult("concurrent-v3", Params{Docs: 1000, Workers: 4}, 1, 2*time.Second,
+			requestStats{Requests: 3, BytesSent: 2048, P50: time.Millisecond, P95: 3 * time.Millisecond, P99: 4 * time.Millisecond}, 10, nil),
+	}}
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read csv: %v", err)
+	}
+	want := []string{"concurrent-v3", "1000", "4", "0", "1", "2000.000", "495.000", "3", "1.000", "3.000", "4.000", "2048", "0", "10", ""}
+	if len(records) != 2 || !reflect.DeepEqual(records[1], want) {
+		t.Errorf("csv = %v, want header and %v", records, want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recorder はElasticsearchへのHTTPリクエストごとのレイテンシと送信バイト数を記録するhttp.RoundTripperです。
+// 並行に投入する方法でも正しく集計できるよう、記録はmutexで保護します。
+type recorder struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+	bytesSent atomic.Int64
+}
+
+func newRecorder(next http.RoundTripper) *recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &recorder{next: next}
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &countingBody{ReadCloser: req.Body, n: &r.bytesSent}
+	}
+
+	start := time.Now()
+	res, err := r.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, elapsed)
+	if err != nil || res.StatusCode >= http.StatusBadRequest {
+		r.errors++
+	}
+	return res, err
+}
+
+// reset はそれまでの記録を破棄します。インデックスの作成などの準備の後に呼び出します。
+func (r *recorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = nil
+	r.errors = 0
+	r.bytesSent.Store(0)
+}
+
+// requestStats はある期間に送信したリクエストの集計です。
+type requestStats struct {
+	Requests  int
+	Errors    int
+	BytesSent int64
+	P50       time.Duration
+	P95       time.Duration
+	P99       time.Duration
+}
+
+func (r *recorder) stats() requestStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sorted := make([]time.Duration, len(r.latencies))
+	copy(sorted, r.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return requestStats{
+		Requests:  len(sorted),
+		Errors:    r.errors,
+		BytesSent: r.bytesSent.Load(),
+		P50:       percentile(sorted, 50),
+		P95:       percentile(sorted, 95),
+		P99:       percentile(sorted, 99),
+	}
+}
+
+// percentile は昇順に並んだsortedのpパーセンタイルをnearest-rank法で返します。
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// countingBody はリクエストボディから読み出されたバイト数を数えます。
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
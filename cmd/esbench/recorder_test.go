@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    int
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%d) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(sorted[:1], 99); got != time.Millisecond {
+		t.Errorf("percentile of a single value = %v, want 1ms", got)
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of no values = %v, want 0", got)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	rec := newRecorder(nil)
+	client := &http.Client{Transport: rec}
+	for _, body := range []string{"0123456789", "abc"} {
+		res, err := client.Post(srv.URL+"/_bulk", "application/x-ndjson", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		res.Body.Close()
+	}
+	res, err := client.Get(srv.URL + "/missing")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	res.Body.Close()
+
+	stats := rec.stats()
+	if stats.Requests != 3 || stats.Errors != 1 || stats.BytesSent != 13 {
+		t.Errorf("stats = %+v, want 3 requests, 1 error and 13 bytes", stats)
+	}
+
+	rec.reset()
+	if stats := rec.stats(); stats.Requests != 0 || stats.BytesSent != 0 {
+		t.Errorf("stats after reset = %+v, want zero", stats)
+	}
+}
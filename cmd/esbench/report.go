@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Report はesbenchの1回の実行結果です。リリースをまたいで比較できるよう、実行環境も記録します。
+type Report struct {
+	StartedAt time.Time `json:"started_at"`
+	GoVersion string    `json:"go_version"`
+	Addresses []string  `json:"addresses"`
+	Results   []Result  `json:"results"`
+	// Seed は投入したドキュメントを生成したシードです。同じシードで実行すると同じドキュメントで比較できます。
+	Seed uint64 `json:"seed"`
+}
+
+// Result は1つの投入方法と条件の組み合わせに対する計測結果です。
+type Result struct {
+	Strategy string `json:"strategy"`
+	Params
+	Run           int     `json:"run"`
+	DurationMs    float64 `json:"duration_ms"`
+	DocsPerSec    float64 `json:"docs_per_sec"`
+	Requests      int     `json:"requests"`
+	LatencyP50Ms  float64 `json:"latency_p50_ms"`
+	LatencyP95Ms  float64 `json:"latency_p95_ms"`
+	LatencyP99Ms  float64 `json:"latency_p99_ms"`
+	BytesSent     int64   `json:"bytes_sent"`
+	RequestErrors int     `json:"request_errors"`
+	FailedDocs    uint64  `json:"failed_docs"`
+	// Error は投入自体が失敗した場合のエラーメッセージです。
+	Error string `json:"error,omitempty"`
+}
+
+// newResult は計測した時間とリクエストの集計から結果を作成します。
+func newResult(strategy string, p Params, run int, elapsed time.Duration, stats requestStats, failed uint64, err error) Result {
+	r := Result{
+		Strategy:      strategy,
+		Params:        p,
+		Run:           run,
+		DurationMs:    milliseconds(elapsed),
+		Requests:      stats.Requests,
+		LatencyP50Ms:  milliseconds(stats.P50),
+		LatencyP95Ms:  milliseconds(stats.P95),
+		LatencyP99Ms:  milliseconds(stats.P99),
+		BytesSent:     stats.BytesSent,
+		RequestErrors: stats.Errors,
+		FailedDocs:    failed,
+	}
+	if succeeded := uint64(p.Docs); elapsed > 0 && succeeded > failed {
+		r.DocsPerSec = float64(succeeded-failed) / elapsed.Seconds()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteJSON はレポートをインデント付きのJSONで書き出します。
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+var csvHeader = []string{
+	"strategy", "docs", "workers", "chunk_size", "run",
+	"duration_ms", "docs_per_sec", "requests",
+	"latency_p50_ms", "latency_p95_ms", "latency_p99_ms",
+	"bytes_sent", "request_errors", "failed_docs", "error",
+}
+
+// WriteCSV は計測結果を1行1件のCSVで書き出します。実行環境は含みません。
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, res := range r.Results {
+		record := []string{
+			res.Strategy,
+			strconv.Itoa(res.Docs),
+			strconv.Itoa(res.Workers),
+			strconv.Itoa(res.ChunkSize),
+			strconv.Itoa(res.Run),
+			formatFloat(res.DurationMs),
+			formatFloat(res.DocsPerSec),
+			strconv.Itoa(res.Requests),
+			formatFloat(res.LatencyP50Ms),
+			formatFloat(res.LatencyP95Ms),
+			formatFloat(res.LatencyP99Ms),
+			strconv.FormatInt(res.BytesSent, 10),
+			strconv.Itoa(res.RequestErrors),
+			strconv.FormatUint(res.FailedDocs, 10),
+			res.Error,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	bulkinsert "github.com/kurakura967/go-elasticsearch-playground/bulk-insert-vs-single-insert"
+	concurrentinsert "github.com/kurakura967/go-elasticsearch-playground/concurrent-bulk-insert"
+)
+
+// Params は1回の計測の条件です。投入方法が参照しない項目は0になります。
+type Params struct {
+	Docs      int `json:"docs"`
+	Workers   int `json:"workers"`
+	ChunkSize int `json:"chunk_size"`
+}
+
+// InsertFunc はdocsをindexに投入し、登録に失敗したドキュメント数を返します。
+type InsertFunc func(ctx context.Context, index string, docs []map[string]interface{}, p Params) (failed uint64, err error)
+
+// Strategy は計測対象の投入方法です。
+type Strategy struct {
+	Name        string
+	Description string
+	// UsesWorkers とUsesChunkSize は、Paramsのうちこの投入方法が参照する項目です。
+	// 参照しない項目は組み合わせを展開せずに1回だけ計測します。
+	UsesWorkers   bool
+	UsesChunkSize bool
+	// New は計測対象のクラスタに接続した投入関数を作成します。
+	New func(cfg elasticsearch.Config) (InsertFunc, error)
+}
+
+var strategies = map[string]Strategy{}
+
+// register は投入方法を登録します。同じ名前を2回登録した場合はpanicします。
+func register(s Strategy) {
+	if _, ok := strategies[s.Name]; ok {
+		panic(fmt.Sprintf("esbench: strategy %q registered twice", s.Name))
+	}
+	strategies[s.Name] = s
+}
+
+// strategyNames は登録されている投入方法の名前を昇順で返します。
+func strategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupStrategies は名前から投入方法を引きます。namesが空の場合はすべての投入方法を返します。
+func lookupStrategies(names []string) ([]Strategy, error) {
+	if len(names) == 0 {
+		names = strategyNames()
+	}
+	selected := make([]Strategy, 0, len(names))
+	for _, name := range names {
+		s, ok := strategies[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown strategy %q (available: %v)", name, strategyNames())
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// bulkClient はbulk-insert-vs-single-insertのClientを使う投入方法を作成します。
+func bulkClient(insert func(c *bulkinsert.Client, ctx context.Context, index string, docs []map[string]interface{}) (uint64, error)) func(elasticsearch.Config) (InsertFunc, error) {
+	return func(cfg elasticsearch.Config) (InsertFunc, error) {
+		client, err := bulkinsert.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, index string, docs []map[string]interface{}, _ Params) (uint64, error) {
+			return insert(client, ctx, index, docs)
+		}, nil
+	}
+}
+
+// concurrentClient はconcurrent-bulk-insertのClientを使う投入方法を作成します。
+func concurrentClient(insert func(c *concurrentinsert.Client, ctx context.Context, index string, docs []map[string]interface{}, p Params) (*concurrentinsert.BulkResult, error)) func(elasticsearch.Config) (InsertFunc, error) {
+	return func(cfg elasticsearch.Config) (InsertFunc, error) {
+		client, err := concurrentinsert.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, index string, docs []map[string]interface{}, p Params) (uint64, error) {
+			result, err := insert(client, ctx, index, docs, p)
+			if result == nil {
+				return 0, err
+			}
+			return result.Failed, err
+		}, nil
+	}
+}
+
+func init() {
+	register(Strategy{
+		Name:        "single",
+		Description: "Index APIで1件ずつ登録します",
+		New: bulkClient(func(c *bulkinsert.Client, ctx context.Context, index string, docs []map[string]interface{}) (uint64, error) {
+			return 0, c.SingleInsert(ctx, index, docs)
+		}),
+	})
+	register(Strategy{
+		Name:        "single-refresh",
+		Description: "Index APIで1件ずつ登録し、毎回refresh=trueで即時に反映します",
+		New: bulkClient(func(c *bulkinsert.Client, ctx context.Context, index string, docs []map[string]interface{}) (uint64, error) {
+			return 0, c.SingleInsertWithRefresh(ctx, index, docs)
+		}),
+	})
+	register(Strategy{
+		Name:        "bulk",
+		Description: "1つのBulkIndexerで登録します",
+		New: bulkClient(func(c *bulkinsert.Client, ctx context.Context, index string, docs []map[string]interface{}) (uint64, error) {
+			result, err := c.BulkInsert(ctx, index, docs)
+			if result == nil {
+				return 0, err
+			}
+			return result.Failed, err
+		}),
+	})
+	register(Strategy{
+		Name:        "bulk-refresh",
+		Description: "1つのBulkIndexerで登録し、フラッシュごとにrefresh=trueで即時に反映します",
+		New: bulkClient(func(c *bulkinsert.Client, ctx context.Context, index string, docs []map[string]interface{}) (uint64, error) {
+			result, err := c.BulkInsertWithRefresh(ctx, index, docs)
+			if result == nil {
+				return 0, err
+			}
+			return result.Failed, err
+		}),
+	})
+	register(Strategy{
+		Name:          "concurrent-v1",
+		Description:   "ドキュメントをチャンクに分割し、チャンクごとのBulkIndexerを並行に実行します",
+		UsesChunkSize: true,
+		New: concurrentClient(func(c *concurrentinsert.Client, ctx context.Context, index string, docs []map[string]interface{}, p Params) (*concurrentinsert.BulkResult, error) {
+			return c.BulkInsertConcurrent(ctx, index, docs, p.ChunkSize)
+		}),
+	})
+	register(Strategy{
+		Name:        "concurrent-v2",
+		Description: "1つのBulkIndexerに、ワーカーのgoroutineからチャネル経由でドキュメントを追加します",
+		UsesWorkers: true,
+		New: concurrentClient(func(c *concurrentinsert.Client, ctx context.Context, index string, docs []map[string]interface{}, p Params) (*concurrentinsert.BulkResult, error) {
+			return c.BulkInsertConcurrentV2(ctx, index, docs, p.Workers)
+		}),
+	})
+	register(Strategy{
+		Name:        "concurrent-v3",
+		Description: "1つのBulkIndexerのNumWorkersで並行にフラッシュします",
+		UsesWorkers: true,
+		New: concurrentClient(func(c *concurrentinsert.Client, ctx context.Context, index string, docs []map[string]interface{}, p Params) (*concurrentinsert.BulkResult, error) {
+			return c.BulkInsertConcurrentV3(ctx, index, docs, p.Workers)
+		}),
+	})
+}
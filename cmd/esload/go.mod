@@ -0,0 +1,25 @@
+module github.com/kurakura967/go-elasticsearch-playground/cmd/esload
+
+go 1.24.2
+
+require (
+	github.com/elastic/go-elasticsearch/v8 v8.18.1
+	github.com/kurakura967/go-elasticsearch-playground/concurrent-bulk-insert v0.0.0
+	github.com/kurakura967/go-elasticsearch-playground/esfake v0.0.0
+)
+
+require (
+	github.com/elastic/elastic-transport-go/v8 v8.7.0 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/kurakura967/go-elasticsearch-playground/esbulk v0.0.0 // indirect
+	go.opentelemetry.io/otel v1.28.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	go.opentelemetry.io/otel/trace v1.28.0 // indirect
+)
+
+replace (
+	github.com/kurakura967/go-elasticsearch-playground/concurrent-bulk-insert => ../../concurrent-bulk-insert
+	github.com/kurakura967/go-elasticsearch-playground/esbulk => ../../esbulk
+	github.com/kurakura967/go-elasticsearch-playground/esfake => ../../esfake
+)
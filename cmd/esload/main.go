@@ -0,0 +1,141 @@
+// esload はNDJSONまたはJSONのファイルを、チェックポイントを記録しながらインデックスに投入します。
+// SIGINTかSIGTERMを受けると新しいドキュメントを読み込まずに、-graceの間だけ送信中のドキュメントを待ってから終了します。
+// 同じ入力と-checkpointで再び実行すると、確定した位置の続きから投入します。
+//
+//	go run . -addresses http://localhost:9200 -index movies -checkpoint movies.checkpoint tmdb.json
+//
+// 終了コードは、すべて投入した場合は0、エラーの場合は1、シグナルを受けて送り終えた場合は3、
+// 猶予期間内に送り終えられなかった場合は4です。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	concurrentinsert "github.com/kurakura967/go-elasticsearch-playground/concurrent-bulk-insert"
+)
+
+// config はコマンドライン引数から組み立てた実行条件です。
+type config struct {
+	addresses  []string
+	username   string
+	password   string
+	index      string
+	workers    int
+	checkpoint string
+	grace      time.Duration
+	format     string
+	input      string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("esload: ")
+
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := run(context.Background(), cfg)
+	if report != nil {
+		fmt.Printf("status: %s, confirmed records: %d\n", report.Status, report.Checkpoint.Records)
+		if report.Result != nil {
+			fmt.Printf("indexed: %d, failed: %d, retried: %d\n", report.Result.Indexed, report.Result.Failed, report.Result.Retried)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	if report == nil {
+		os.Exit(concurrentinsert.ExitFailed)
+	}
+	os.Exit(report.Status.ExitCode())
+}
+
+func parseFlags(args []string) (config, error) {
+	fs := flag.NewFlagSet("esload", flag.ContinueOnError)
+	var (
+		cfg       config
+		addresses string
+	)
+	fs.StringVar(&addresses, "addresses", "http://localhost:9200", "comma-separated Elasticsearch addresses")
+	fs.StringVar(&cfg.username, "username", "", "username for basic authentication")
+	fs.StringVar(&cfg.password, "password", "", "password for basic authentication")
+	fs.StringVar(&cfg.index, "index", "", "index to load the documents into")
+	fs.IntVar(&cfg.workers, "workers", 4, "number of bulk indexer workers")
+	fs.StringVar(&cfg.checkpoint, "checkpoint", "", "checkpoint file to record progress and resume from (default: <input>.checkpoint)")
+	fs.DurationVar(&cfg.grace, "grace", 30*time.Second, "time to wait for in-flight documents after SIGINT or SIGTERM")
+	fs.StringVar(&cfg.format, "format", "", "input format: ndjson or json (default: by file extension)")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	for _, v := range strings.Split(addresses, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			cfg.addresses = append(cfg.addresses, v)
+		}
+	}
+	if fs.NArg() != 1 {
+		return cfg, fmt.Errorf("exactly one input file must be given")
+	}
+	cfg.input = fs.Arg(0)
+	if cfg.index == "" {
+		return cfg, fmt.Errorf("index must be given")
+	}
+	if cfg.workers < 1 {
+		return cfg, fmt.Errorf("workers must be at least 1")
+	}
+	if cfg.grace <= 0 {
+		return cfg, fmt.Errorf("grace must be positive")
+	}
+	if cfg.checkpoint == "" {
+		cfg.checkpoint = cfg.input + ".checkpoint"
+	}
+	if cfg.format == "" {
+		cfg.format = "ndjson"
+		if strings.EqualFold(filepath.Ext(cfg.input), ".json") {
+			cfg.format = "json"
+		}
+	}
+	if cfg.format != "ndjson" && cfg.format != "json" {
+		return cfg, fmt.Errorf("unknown format: %s", cfg.format)
+	}
+	return cfg, nil
+}
+
+// run は入力ファイルを投入し、シグナルを受けた場合は送信中のドキュメントを待ってから戻ります。
+func run(ctx context.Context, cfg config) (*concurrentinsert.RunReport, error) {
+	client, err := concurrentinsert.NewClient(elasticsearch.Config{
+		Addresses: cfg.addresses,
+		Username:  cfg.username,
+		Password:  cfg.password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(cfg.input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input: %w", err)
+	}
+	defer f.Close()
+	reader := concurrentinsert.NewNDJSONReader(f)
+	if cfg.format == "json" {
+		reader = concurrentinsert.NewJSONReader(f)
+	}
+
+	return client.Run(ctx, cfg.index, reader, concurrentinsert.RunConfig{
+		NumWorkers:  cfg.workers,
+		Checkpoint:  cfg.checkpoint,
+		GracePeriod: cfg.grace,
+		Logger:      log.Default(),
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	concurrentinsert "github.com/kurakura967/go-elasticsearch-playground/concurrent-bulk-insert"
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags([]string{"-addresses", "http://a:9200, http://b:9200", "-index", "movies", "tmdb.json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.addresses) != 2 || cfg.format != "json" || cfg.checkpoint != "tmdb.json.checkpoint" || cfg.workers != 4 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	for _, args := range [][]string{
+		{"-index", "movies"},
+		{"in.ndjson"},
+		{"-index", "movies", "-workers", "0", "in.ndjson"},
+		{"-index", "movies", "-grace", "0s", "in.ndjson"},
+		{"-index", "movies", "-format", "csv", "in.csv"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := esfake.New(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "movies.json")
+	if err := os.WriteFile(input, []byte(`{"1": {"title": "a"}, "2": {"title": "b"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := parseFlags([]string{"-addresses", srv.Config().Addresses[0], "-index", "movies", input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if report.Status.ExitCode() != concurrentinsert.ExitCompleted || report.Result.Indexed != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if cp, err := concurrentinsert.LoadCheckpoint(cfg.checkpoint); err != nil || !cp.Completed {
+		t.Errorf("expected a completed checkpoint, got %+v, %v", cp, err)
+	}
+	if idx, _ := srv.Index("movies"); string(idx.Documents["2"]) != `{"title": "b"}` {
+		t.Errorf("expected document 2 to be loaded, got %v", idx.Documents)
+	}
+}
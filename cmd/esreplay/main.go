@@ -0,0 +1,138 @@
+// esreplay はDeadLetterSinkが書き出したNDJSONファイルを読み、失敗したドキュメントを元のインデックスに再投入します。
+// マッピングなどの問題を直した後に実行します。再投入でも失敗したドキュメントは-dead-letterのファイルに記録されます。
+//
+//	go run . -addresses http://localhost:9200 -dead-letter still-failed.ndjson dead-letters.ndjson
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	concurrentinsert "github.com/kurakura967/go-elasticsearch-playground/concurrent-bulk-insert"
+)
+
+// config はコマンドライン引数から組み立てた実行条件です。
+type config struct {
+	addresses  []string
+	username   string
+	password   string
+	workers    int
+	deadLetter string
+	inputs     []string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("esreplay: ")
+
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := run(ctx, cfg)
+	if result != nil {
+		fmt.Printf("indexed: %d, failed: %d, retried: %d\n", result.Indexed, result.Failed, result.Retried)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseFlags(args []string) (config, error) {
+	fs := flag.NewFlagSet("esreplay", flag.ContinueOnError)
+	var (
+		cfg       config
+		addresses string
+	)
+	fs.StringVar(&addresses, "addresses", "http://localhost:9200", "comma-separated Elasticsearch addresses")
+	fs.StringVar(&cfg.username, "username", "", "username for basic authentication")
+	fs.StringVar(&cfg.password, "password", "", "password for basic authentication")
+	fs.IntVar(&cfg.workers, "workers", 4, "number of bulk indexer workers")
+	fs.StringVar(&cfg.deadLetter, "dead-letter", "", "append documents that fail again to this file")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	for _, v := range strings.Split(addresses, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			cfg.addresses = append(cfg.addresses, v)
+		}
+	}
+	cfg.inputs = fs.Args()
+	if len(cfg.inputs) == 0 {
+		return cfg, fmt.Errorf("no dead letter file given")
+	}
+	if cfg.workers < 1 {
+		return cfg, fmt.Errorf("workers must be at least 1")
+	}
+	for _, input := range cfg.inputs {
+		if cfg.deadLetter != "" && input == cfg.deadLetter {
+			return cfg, fmt.Errorf("dead-letter must not be one of the input files: %s", input)
+		}
+	}
+	return cfg, nil
+}
+
+// run は入力ファイルを順に再投入し、結果を合算して返します。
+func run(ctx context.Context, cfg config) (*concurrentinsert.BulkResult, error) {
+	client, err := concurrentinsert.NewClient(elasticsearch.Config{
+		Addresses: cfg.addresses,
+		Username:  cfg.username,
+		Password:  cfg.password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.deadLetter != "" {
+		sink, err := concurrentinsert.OpenDeadLetterFile(cfg.deadLetter)
+		if err != nil {
+			return nil, err
+		}
+		defer sink.Close()
+		client.WithDeadLetterSink(sink)
+	}
+
+	total := &concurrentinsert.BulkResult{}
+	for _, input := range cfg.inputs {
+		result, err := replayFile(ctx, client, input, cfg.workers)
+		if result != nil {
+			total.Indexed += result.Indexed
+			total.Created += result.Created
+			total.Updated += result.Updated
+			total.Failed += result.Failed
+			total.Retried += result.Retried
+			total.Failures = append(total.Failures, result.Failures...)
+		}
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", input, err)
+		}
+	}
+	return total, nil
+}
+
+func replayFile(ctx context.Context, client *concurrentinsert.Client, path string, workers int) (*concurrentinsert.BulkResult, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	return client.ReplayDeadLetters(ctx, r, workers)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags([]string{"-addresses", "http://a:9200, http://b:9200", "-dead-letter", "out.ndjson", "in.ndjson"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.addresses) != 2 || cfg.deadLetter != "out.ndjson" || len(cfg.inputs) != 1 || cfg.workers != 4 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-workers", "0", "in.ndjson"},
+		{"-dead-letter", "in.ndjson", "in.ndjson"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := esfake.New(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "dead-letters.ndjson")
+	letters := `{"index":"books","action":"index","document_id":"1","document":{"title":"a"},"error_type":"mapper_parsing_exception","reason":"x","timestamp":"2024-01-01T00:00:00Z"}
+{"index":"books","action":"index","error_type":"marshal_error","reason":"json: unsupported type","timestamp":"2024-01-01T00:00:00Z"}
+`
+	if err := os.WriteFile(input, []byte(letters), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{addresses: srv.Config().Addresses, workers: 1, deadLetter: filepath.Join(dir, "still-failed.ndjson"), inputs: []string{input}}
+	result, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.Indexed != 1 || result.Failed != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if idx, _ := srv.Index("books"); string(idx.Documents["1"]) != `{"title":"a"}` {
+		t.Errorf("expected document 1 to be replayed, got %v", idx.Documents)
+	}
+	data, err := os.ReadFile(cfg.deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("expected 1 dead letter, got %d", lines)
+	}
+}
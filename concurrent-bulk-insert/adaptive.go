@@ -0,0 +1,326 @@
+package concurrentinsert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// AdaptiveConfig はAdaptiveControllerの設定です。0の項目には既定値を使用します。
+type AdaptiveConfig struct {
+	// MinWorkers とMaxWorkers は同時にフラッシュするワーカー数の範囲です。既定値は1と8です。
+	MinWorkers int
+	MaxWorkers int
+	// InitialWorkers は最初のワーカー数です。既定値はMinWorkersです。
+	InitialWorkers int
+	// MinFlushBytes とMaxFlushBytes は1回のフラッシュで送信するバイト数の範囲です。既定値は64KiBと10MiBです。
+	MinFlushBytes int
+	MaxFlushBytes int
+	// InitialFlushBytes は最初のフラッシュサイズです。既定値は1MiBです。
+	InitialFlushBytes int
+	// FlushBytesStep は1回の増加でフラッシュサイズに足すバイト数です。既定値は256KiBです。
+	FlushBytesStep int
+	// LatencyTarget はフラッシュの所要時間の上限です。これを超えたフラッシュは過負荷の兆候として扱います。既定値は1秒です。
+	LatencyTarget time.Duration
+	// DecreaseFactor は過負荷の兆候があったときに、ワーカー数とフラッシュサイズに掛ける係数です。既定値は0.5です。
+	DecreaseFactor float64
+	// IncreaseAfter は増やすまでに必要な、連続した健全なフラッシュの回数です。既定値は4です。
+	IncreaseAfter int
+	// Logger は調整の判断を出力するロガーです。nilの場合はlog.Default()を使用します。
+	Logger *log.Logger
+}
+
+func (cfg AdaptiveConfig) withDefaults() AdaptiveConfig {
+	if cfg.MinWorkers <= 0 {
+		cfg.MinWorkers = 1
+	}
+	if cfg.MaxWorkers <= 0 {
+		cfg.MaxWorkers = 8
+	}
+	cfg.MaxWorkers = max(cfg.MaxWorkers, cfg.MinWorkers)
+	if cfg.InitialWorkers <= 0 {
+		cfg.InitialWorkers = cfg.MinWorkers
+	}
+	cfg.InitialWorkers = min(max(cfg.InitialWorkers, cfg.MinWorkers), cfg.MaxWorkers)
+	if cfg.MinFlushBytes <= 0 {
+		cfg.MinFlushBytes = 64 << 10
+	}
+	if cfg.MaxFlushBytes <= 0 {
+		cfg.MaxFlushBytes = 10 << 20
+	}
+	cfg.MaxFlushBytes = max(cfg.MaxFlushBytes, cfg.MinFlushBytes)
+	if cfg.InitialFlushBytes <= 0 {
+		cfg.InitialFlushBytes = 1 << 20
+	}
+	cfg.InitialFlushBytes = min(max(cfg.InitialFlushBytes, cfg.MinFlushBytes), cfg.MaxFlushBytes)
+	if cfg.FlushBytesStep <= 0 {
+		cfg.FlushBytesStep = 256 << 10
+	}
+	if cfg.LatencyTarget <= 0 {
+		cfg.LatencyTarget = time.Second
+	}
+	if cfg.DecreaseFactor <= 0 || cfg.DecreaseFactor >= 1 {
+		cfg.DecreaseFactor = 0.5
+	}
+	if cfg.IncreaseAfter <= 0 {
+		cfg.IncreaseAfter = 4
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	return cfg
+}
+
+// AdaptiveDecision はAdaptiveControllerが行った1回の調整です。
+type AdaptiveDecision struct {
+	Time time.Time
+	// Increase は増やした場合にtrue、減らした場合にfalseです。
+	Increase bool
+	// Reason は調整した理由です。
+	Reason string
+	// Workers とFlushBytes は調整後の値です。
+	Workers    int
+	FlushBytes int
+}
+
+func (d AdaptiveDecision) String() string {
+	action := "decrease"
+	if d.Increase {
+		action = "increase"
+	}
+	return fmt.Sprintf("%s to workers=%d flush_bytes=%d: %s", action, d.Workers, d.FlushBytes, d.Reason)
+}
+
+// AdaptiveController はフラッシュのレイテンシと拒否(429)を観測し、同時にフラッシュするワーカー数と
+// フラッシュサイズをAIMD(加算増加・乗算減少)で調整します。
+// 健全なフラッシュが続く間はワーカー数を1ずつ、フラッシュサイズをFlushBytesStepずつ増やし、
+// 429やLatencyTargetを超えるフラッシュがあればDecreaseFactorを掛けて減らします。
+// 調整した値は次の投入に引き継がれるため、同じControllerを使い回すと前回の調整結果から始められます。
+type AdaptiveController struct {
+	cfg AdaptiveConfig
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	workers    int
+	flushBytes int
+	inFlight   int
+	healthy    int
+	// lastDecrease は最後に減らした時刻です。それより前に始まったフラッシュの結果は、減らした効果を反映していないため無視します。
+	lastDecrease time.Time
+	decisions    []AdaptiveDecision
+}
+
+// NewAdaptiveController はcfgの初期値から始めるAdaptiveControllerを作成します。
+func NewAdaptiveController(cfg AdaptiveConfig) *AdaptiveController {
+	cfg = cfg.withDefaults()
+	a := &AdaptiveController{
+		cfg:        cfg,
+		workers:    cfg.InitialWorkers,
+		flushBytes: cfg.InitialFlushBytes,
+	}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+// Workers は現在のワーカー数を返します。投入の後に呼び出すと、調整された最終的な値になります。
+func (a *AdaptiveController) Workers() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.workers
+}
+
+// FlushBytes は現在のフラッシュサイズを返します。投入の後に呼び出すと、調整された最終的な値になります。
+func (a *AdaptiveController) FlushBytes() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flushBytes
+}
+
+// Decisions はこれまでに行った調整を古い順に返します。
+func (a *AdaptiveController) Decisions() []AdaptiveDecision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AdaptiveDecision(nil), a.decisions...)
+}
+
+// adaptiveFlushKey は1回のフラッシュの観測をcontextに格納するためのキーです。
+type adaptiveFlushKey struct{}
+
+// adaptiveFlush は1回のフラッシュの観測です。
+// フラッシュ中のコールバックはすべて同じワーカーのgoroutineから順に呼ばれるため、ロックせずに更新します。
+type adaptiveFlush struct {
+	start    time.Time
+	items    int
+	rejected int
+	err      error
+}
+
+func adaptiveFlushFrom(ctx context.Context) *adaptiveFlush {
+	f, _ := ctx.Value(adaptiveFlushKey{}).(*adaptiveFlush)
+	return f
+}
+
+// configure はcfgのBulkIndexerを、現在のフラッシュサイズとワーカー数の上限で動作させます。
+// ワーカーはMaxWorkersだけ起動し、フラッシュの開始時に現在のワーカー数を超えないよう待たせることで、
+// BulkIndexerを作り直さずに同時にフラッシュする数を変更します。
+func (a *AdaptiveController) configure(cfg esutil.BulkIndexerConfig) esutil.BulkIndexerConfig {
+	cfg.NumWorkers = a.cfg.MaxWorkers
+	cfg.FlushBytes = a.FlushBytes()
+
+	onError := cfg.OnError
+	cfg.OnFlushStart = func(ctx context.Context) context.Context {
+		a.acquire()
+		return context.WithValue(ctx, adaptiveFlushKey{}, &adaptiveFlush{start: time.Now()})
+	}
+	cfg.OnError = func(ctx context.Context, err error) {
+		if f := adaptiveFlushFrom(ctx); f != nil {
+			f.err = err
+		}
+		if onError != nil {
+			onError(ctx, err)
+		}
+	}
+	cfg.OnFlushEnd = func(ctx context.Context) {
+		a.release()
+		if f := adaptiveFlushFrom(ctx); f != nil && (f.items > 0 || f.err != nil) {
+			a.observe(f, time.Since(f.start))
+		}
+	}
+	return cfg
+}
+
+// instrumentItem はアイテムの成否をフラッシュの観測に加えるよう、アイテムのコールバックを包みます。
+func (a *AdaptiveController) instrumentItem(item *esutil.BulkIndexerItem) {
+	onSuccess, onFailure := item.OnSuccess, item.OnFailure
+	item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+		if f := adaptiveFlushFrom(ctx); f != nil {
+			f.items++
+		}
+		if onSuccess != nil {
+			onSuccess(ctx, item, res)
+		}
+	}
+	item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		if f := adaptiveFlushFrom(ctx); f != nil {
+			f.items++
+			if res.Status == http.StatusTooManyRequests {
+				f.rejected++
+			}
+		}
+		if onFailure != nil {
+			onFailure(ctx, item, res, err)
+		}
+	}
+}
+
+func (a *AdaptiveController) acquire() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.inFlight >= a.workers {
+		a.cond.Wait()
+	}
+	a.inFlight++
+}
+
+func (a *AdaptiveController) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	a.cond.Broadcast()
+}
+
+// observe は1回のフラッシュの結果から、ワーカー数とフラッシュサイズを調整します。
+// アイテムごとの429に加えて、ItemizingTransportがOnErrorに渡すRequestErrorのステータスでリクエスト全体の429を判定します。
+func (a *AdaptiveController) observe(f *adaptiveFlush, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var (
+		reason string
+		reqErr *esbulk.RequestError
+	)
+	switch {
+	case f.rejected > 0:
+		reason = fmt.Sprintf("%d of %d items rejected with 429", f.rejected, f.items)
+	case errors.As(f.err, &reqErr) && reqErr.Status == http.StatusTooManyRequests:
+		reason = fmt.Sprintf("bulk request rejected: %v", f.err)
+	case latency > a.cfg.LatencyTarget:
+		reason = fmt.Sprintf("flush took %s, above the target of %s", latency.Round(time.Millisecond), a.cfg.LatencyTarget)
+	}
+	if reason != "" {
+		a.healthy = 0
+		if f.start.Before(a.lastDecrease) {
+			return
+		}
+		a.lastDecrease = time.Now()
+		workers := max(a.cfg.MinWorkers, int(float64(a.workers)*a.cfg.DecreaseFactor))
+		flushBytes := max(a.cfg.MinFlushBytes, int(float64(a.flushBytes)*a.cfg.DecreaseFactor))
+		if workers != a.workers || flushBytes != a.flushBytes {
+			a.decide(false, workers, flushBytes, reason)
+		}
+		return
+	}
+	if f.err != nil {
+		// 429以外のリクエストの失敗は、負荷の判断に使いません。
+		return
+	}
+
+	a.healthy++
+	if a.healthy < a.cfg.IncreaseAfter {
+		return
+	}
+	a.healthy = 0
+	workers := min(a.cfg.MaxWorkers, a.workers+1)
+	flushBytes := min(a.cfg.MaxFlushBytes, a.flushBytes+a.cfg.FlushBytesStep)
+	if workers != a.workers || flushBytes != a.flushBytes {
+		a.decide(true, workers, flushBytes, fmt.Sprintf("%d consecutive healthy flushes", a.cfg.IncreaseAfter))
+		a.cond.Broadcast()
+	}
+}
+
+// decide は調整を適用して記録します。呼び出し元でa.muをロックしておく必要があります。
+func (a *AdaptiveController) decide(increase bool, workers, flushBytes int, reason string) {
+	a.workers, a.flushBytes = workers, flushBytes
+	d := AdaptiveDecision{Time: time.Now(), Increase: increase, Reason: reason, Workers: workers, FlushBytes: flushBytes}
+	a.decisions = append(a.decisions, d)
+	a.cfg.Logger.Printf("adaptive: %s", d)
+}
+
+// BulkInsertAdaptive は、AdaptiveControllerでワーカー数とフラッシュサイズを調整しながらドキュメントを登録します。
+// NumWorkersやチャンクサイズを事前に決める必要はありません。調整後の値はcontrollerから取得できます。
+func (c *Client) BulkInsertAdaptive(ctx context.Context, index string, docs []map[string]interface{}, controller *AdaptiveController) (*BulkResult, error) {
+	return c.bulkLoad(ctx, index, &docReader{docs: docs}, 0, nil, controller)
+}
+
+// BulkLoadAdaptive は、AdaptiveControllerでワーカー数とフラッシュサイズを調整しながらRecordReaderのドキュメントを登録します。
+// 入力の扱いはBulkLoadと同じです。
+func (c *Client) BulkLoadAdaptive(ctx context.Context, index string, r RecordReader, controller *AdaptiveController) (*BulkResult, error) {
+	return c.bulkLoad(ctx, index, r, 0, nil, controller)
+}
+
+// docReader はスライスのドキュメントを順にRecordとして返すRecordReaderです。
+type docReader struct {
+	docs []map[string]interface{}
+	next int
+}
+
+func (d *docReader) Next() (Record, error) {
+	if d.next >= len(d.docs) {
+		return Record{}, io.EOF
+	}
+	d.next++
+	data, err := json.Marshal(d.docs[d.next-1])
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to marshal document %d: %w", d.next, err)
+	}
+	return Record{Source: data, Number: d.next}, nil
+}
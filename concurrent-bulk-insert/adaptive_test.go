@@ -0,0 +1,159 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+func TestBulkInsertAdaptiveIncreasesWhileHealthy(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		return itemOK(fmt.Sprintf("%d-%d", call, item))
+	})
+	var logs bytes.Buffer
+	controller := NewAdaptiveController(AdaptiveConfig{
+		MinWorkers:        1,
+		MaxWorkers:        4,
+		MinFlushBytes:     512,
+		InitialFlushBytes: 512,
+		MaxFlushBytes:     2048,
+		FlushBytesStep:    512,
+		LatencyTarget:     time.Minute,
+		IncreaseAfter:     2,
+		Logger:            log.New(&logs, "", 0),
+	})
+
+	result, err := client.BulkInsertAdaptive(context.Background(), "test", generateDocs(500), controller)
+	if err != nil {
+		t.Fatalf("BulkInsertAdaptive failed: %v", err)
+	}
+	if result.Indexed != 500 || result.Failed != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// 健全なフラッシュが続くため、上限まで増やします。
+	if controller.Workers() != 4 || controller.FlushBytes() != 2048 {
+		t.Errorf("expected workers=4 flush_bytes=2048, got workers=%d flush_bytes=%d", controller.Workers(), controller.FlushBytes())
+	}
+	decisions := controller.Decisions()
+	if len(decisions) != 3 {
+		t.Fatalf("expected 3 decisions, got %v", decisions)
+	}
+	for _, d := range decisions {
+		if !d.Increase {
+			t.Errorf("unexpected decrease: %v", d)
+		}
+	}
+	if !strings.Contains(logs.String(), "adaptive: increase to workers=2 flush_bytes=1024: 2 consecutive healthy flushes") {
+		t.Errorf("expected the decision to be logged, got %q", logs.String())
+	}
+}
+
+func TestBulkInsertAdaptiveDecreasesOnRejections(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		// 3回目のリクエストだけを過負荷として拒否する
+		if call == 3 && item == 0 {
+			return itemError(fmt.Sprintf("%d-%d", call, item), http.StatusTooManyRequests, "es_rejected_execution_exception")
+		}
+		return itemOK(fmt.Sprintf("%d-%d", call, item))
+	})
+	client.WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	var logs bytes.Buffer
+	controller := NewAdaptiveController(AdaptiveConfig{
+		MinWorkers:        1,
+		MaxWorkers:        8,
+		InitialWorkers:    8,
+		MinFlushBytes:     256,
+		InitialFlushBytes: 4096,
+		LatencyTarget:     time.Minute,
+		IncreaseAfter:     1000,
+		Logger:            log.New(&logs, "", 0),
+	})
+
+	result, err := client.BulkInsertAdaptive(context.Background(), "test", generateDocs(300), controller)
+	if err != nil {
+		t.Fatalf("BulkInsertAdaptive failed: %v", err)
+	}
+	if result.Indexed != 300 || result.Failed != 0 || result.Retried != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if controller.Workers() != 4 || controller.FlushBytes() != 2048 {
+		t.Errorf("expected workers=4 flush_bytes=2048, got workers=%d flush_bytes=%d", controller.Workers(), controller.FlushBytes())
+	}
+	if !strings.Contains(logs.String(), "adaptive: decrease to workers=4 flush_bytes=2048: 1 of ") {
+		t.Errorf("expected the decrease to be logged, got %q", logs.String())
+	}
+}
+
+func TestBulkInsertAdaptiveDecreasesOnLatency(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		return itemOK(fmt.Sprintf("%d-%d", call, item))
+	})
+	controller := NewAdaptiveController(AdaptiveConfig{
+		MinWorkers:        1,
+		MaxWorkers:        4,
+		InitialWorkers:    4,
+		MinFlushBytes:     256,
+		InitialFlushBytes: 1024,
+		// すべてのフラッシュが目標を超えるため、下限まで減らします。
+		LatencyTarget: time.Nanosecond,
+		Logger:        log.New(&bytes.Buffer{}, "", 0),
+	})
+
+	result, err := client.BulkInsertAdaptive(context.Background(), "test", generateDocs(300), controller)
+	if err != nil {
+		t.Fatalf("BulkInsertAdaptive failed: %v", err)
+	}
+	if result.Indexed != 300 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if controller.Workers() != 1 || controller.FlushBytes() != 256 {
+		t.Errorf("expected workers=1 flush_bytes=256, got workers=%d flush_bytes=%d", controller.Workers(), controller.FlushBytes())
+	}
+	for _, d := range controller.Decisions() {
+		if d.Increase || !strings.Contains(d.Reason, "above the target") {
+			t.Errorf("unexpected decision: %v", d)
+		}
+	}
+}
+
+func TestAdaptiveControllerObservesRequestRejections(t *testing.T) {
+	controller := NewAdaptiveController(AdaptiveConfig{
+		MinWorkers:        1,
+		MaxWorkers:        8,
+		InitialWorkers:    8,
+		MinFlushBytes:     256,
+		InitialFlushBytes: 4096,
+		LatencyTarget:     time.Minute,
+		IncreaseAfter:     1,
+		Logger:            log.New(&bytes.Buffer{}, "", 0),
+	})
+
+	// メッセージに429を含んでいても、429以外の失敗では調整しません。
+	controller.observe(&adaptiveFlush{
+		start: time.Now(),
+		err:   &esbulk.RequestError{Status: http.StatusInternalServerError, Type: "exception", Reason: "shard 429 failed"},
+	}, time.Millisecond)
+	if decisions := controller.Decisions(); len(decisions) != 0 {
+		t.Fatalf("expected no decision for a request error other than 429, got %v", decisions)
+	}
+
+	controller.observe(&adaptiveFlush{
+		start: time.Now(),
+		err:   fmt.Errorf("flush failed: %w", &esbulk.RequestError{Status: http.StatusTooManyRequests, Type: "es_rejected_execution_exception"}),
+	}, time.Millisecond)
+	decisions := controller.Decisions()
+	if len(decisions) != 1 || decisions[0].Increase || !strings.Contains(decisions[0].Reason, "bulk request rejected") {
+		t.Fatalf("expected a decrease for the rejected request, got %v", decisions)
+	}
+	if controller.Workers() != 4 || controller.FlushBytes() != 2048 {
+		t.Errorf("expected workers=4 flush_bytes=2048, got workers=%d flush_bytes=%d", controller.Workers(), controller.FlushBytes())
+	}
+}
@@ -0,0 +1,318 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// BulkItemFailure はBulk APIで登録に失敗した1件のドキュメントを表します。
+// ErrorTypeを見ることで、マッピングの不整合(mapper_parsing_exception)と
+// クラスタの過負荷(es_rejected_execution_exception)などを区別できます。
+type BulkItemFailure struct {
+	DocumentID string
+	Status     int
+	ErrorType  string
+	Reason     string
+}
+
+func (f BulkItemFailure) String() string {
+	return fmt.Sprintf("document %s: [%d] %s: %s", f.DocumentID, f.Status, f.ErrorType, f.Reason)
+}
+
+// Retryable は時間をおいて再送すれば成功する可能性のある失敗かどうかを返します。
+// マッピングの不整合などの恒久的なエラーはリトライしません。
+func (f BulkItemFailure) Retryable() bool {
+	return esbulk.Retryable(f.Status, f.ErrorType)
+}
+
+// BulkResult はBulk APIによる登録結果の集計です。
+// Indexed、Created、Updated はesutil.BulkIndexerStatsと同じく、成功したindex、create、update操作の件数です。
+// レスポンスのresult(createdやupdated)ではないため、index操作で新規に作成したドキュメントもIndexedに数えます。
+type BulkResult struct {
+	Indexed uint64
+	Created uint64
+	Updated uint64
+	Failed  uint64
+	Retried uint64
+	// Throttled はRateLimiterの上限により投入を待った時間です。
+	// 複数のgoroutineから投入するメソッドでは、各goroutineが待った時間の合計になります。
+	Throttled time.Duration
+	Failures  []BulkItemFailure
+	// Indices はWithIndexResolverを設定した場合の、登録先のインデックスごとの集計です。
+	// 登録先を決める前に失敗したドキュメントは、メソッドに渡したインデックスに数えます。
+	Indices map[string]IndexStats
+	// Rollovers はWithDataStreamのRolloverPolicyにより、ロールオーバーしたデータストリームです。
+	Rollovers []Rollover
+}
+
+// add は別のBulkResultの集計値と失敗を加算します。
+func (r *BulkResult) add(other *BulkResult) {
+	if other == nil {
+		return
+	}
+	r.Indexed += other.Indexed
+	r.Created += other.Created
+	r.Updated += other.Updated
+	r.Failed += other.Failed
+	r.Retried += other.Retried
+	r.Throttled += other.Throttled
+	r.Failures = append(r.Failures, other.Failures...)
+	r.Rollovers = append(r.Rollovers, other.Rollovers...)
+	for index, stats := range other.Indices {
+		if r.Indices == nil {
+			r.Indices = make(map[string]IndexStats)
+		}
+		total := r.Indices[index]
+		total.Indexed += stats.Indexed
+		total.Created += stats.Created
+		total.Updated += stats.Updated
+		total.Failed += stats.Failed
+		r.Indices[index] = total
+	}
+}
+
+// pendingItem はリトライ待ちのアイテムと、直近の失敗内容です。
+type pendingItem struct {
+	item     esutil.BulkIndexerItem
+	failure  BulkItemFailure
+	pipeline string
+}
+
+// bulkResultCollector はBulkIndexerのコールバックから登録結果を集計します。
+// コールバックはBulkIndexerのワーカーから並行して呼ばれるため、mutexで保護します。
+type bulkResultCollector struct {
+	mu          sync.Mutex
+	policy      *RetryPolicy
+	started     time.Time
+	stats       esutil.BulkIndexerStats
+	retried     uint64
+	throttled   time.Duration
+	failures    []BulkItemFailure
+	pending     []pendingItem
+	flushErrors []error
+	// deadLetters とindexは失敗が確定したドキュメントの書き込み先と、アイテムにインデックスがない場合のインデックスです。
+	deadLetters      DeadLetterSink
+	index            string
+	deadLetterErrors []error
+	// indices はインデックスごとの集計です。nilの場合は集計しません。
+	indices map[string]*IndexStats
+	// rollovers とrolloverErrors はデータストリームのロールオーバーの結果です。
+	rollovers      []Rollover
+	rolloverErrors []error
+}
+
+// newBulkResultCollector はリトライポリシーを指定してcollectorを作成します。
+// policyがnilの場合、失敗したアイテムはリトライせずにそのまま記録します。
+func newBulkResultCollector(policy *RetryPolicy) *bulkResultCollector {
+	return &bulkResultCollector{
+		policy:  policy,
+		started: time.Now(),
+	}
+}
+
+// newCollector はClientのリトライポリシーとDeadLetterSinkを使うcollectorを作成します。
+func (c *Client) newCollector(index string) *bulkResultCollector {
+	collector := newBulkResultCollector(c.retryPolicy)
+	collector.deadLetters = c.deadLetters
+	collector.index = index
+	if c.indexResolver != nil {
+		collector.indices = make(map[string]*IndexStats)
+	}
+	return collector
+}
+
+// newBulkItemFailure はBulkIndexerのOnFailureに渡された内容からBulkItemFailureを作成します。
+func newBulkItemFailure(item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) BulkItemFailure {
+	failure := BulkItemFailure{
+		DocumentID: item.DocumentID,
+		Status:     res.Status,
+		ErrorType:  res.Error.Type,
+		Reason:     res.Error.Reason,
+	}
+	if failure.DocumentID == "" {
+		// IDを自動採番している場合は、レスポンスに含まれるIDを使用します。
+		failure.DocumentID = res.DocumentID
+	}
+	if err != nil {
+		failure.Reason = err.Error()
+	}
+	return failure
+}
+
+func (c *bulkResultCollector) onFailure(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+	c.onFailureFor("")(ctx, item, res, err)
+}
+
+// onFailureFor はpipelineを指定して送信するアイテムのOnFailureを返します。
+// 再送とDeadLetterで同じパイプラインを使うため、失敗したアイテムにはpipelineを記録します。
+func (c *bulkResultCollector) onFailureFor(pipeline string) func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem, error) {
+	return func(_ context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		failure := newBulkItemFailure(item, res, err)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.policy != nil && err == nil && failure.Retryable() {
+			c.pending = append(c.pending, pendingItem{item: item, failure: failure, pipeline: pipeline})
+			return
+		}
+		c.fail(item, failure, pipeline)
+	}
+}
+
+// addFailure はBulkIndexerに渡す前に失敗したドキュメントを記録します。
+func (c *bulkResultCollector) addFailure(item esutil.BulkIndexerItem, failure BulkItemFailure, pipeline string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.NumFailed++
+	c.fail(item, failure, pipeline)
+}
+
+// fail は失敗を確定し、DeadLetterSinkがあれば書き込みます。呼び出し元でc.muをロックしておく必要があります。
+func (c *bulkResultCollector) fail(item esutil.BulkIndexerItem, failure BulkItemFailure, pipeline string) {
+	c.failures = append(c.failures, failure)
+	if stats := c.indexStats(esbulk.ItemIndex(item, c.index)); stats != nil {
+		stats.Failed++
+	}
+	if c.deadLetters == nil {
+		return
+	}
+	if err := c.deadLetters.Write(newDeadLetter(c.index, item, failure, pipeline)); err != nil {
+		c.deadLetterErrors = append(c.deadLetterErrors, err)
+	}
+}
+
+// onError はBulkIndexerのエラーを記録します。
+// リクエスト全体の失敗は、ItemizingTransportによりアイテムごとの失敗として記録済みのため数えません。
+func (c *bulkResultCollector) onError(_ context.Context, err error) {
+	if esbulk.IsRequestError(err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushErrors = append(c.flushErrors, err)
+}
+
+// addSucceeded は登録に成功したアイテムを、登録先のインデックスの集計に操作ごとに加算します。
+func (c *bulkResultCollector) addSucceeded(index, action string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.indexStats(index)
+	if stats == nil {
+		return
+	}
+	switch action {
+	case "index":
+		stats.Indexed++
+	case "create":
+		stats.Created++
+	case "update":
+		stats.Updated++
+	}
+}
+
+// indexStats はindexの集計を返します。集計しない場合はnilを返します。呼び出し元でc.muをロックしておく必要があります。
+func (c *bulkResultCollector) indexStats(index string) *IndexStats {
+	if c.indices == nil {
+		return nil
+	}
+	stats, ok := c.indices[index]
+	if !ok {
+		stats = &IndexStats{}
+		c.indices[index] = stats
+	}
+	return stats
+}
+
+// addRollover はロールオーバーしたデータストリームを記録します。
+func (c *bulkResultCollector) addRollover(rollover Rollover) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rollovers = append(c.rollovers, rollover)
+}
+
+// addRolloverError はロールオーバーに失敗したエラーを記録します。
+func (c *bulkResultCollector) addRolloverError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rolloverErrors = append(c.rolloverErrors, err)
+}
+
+// addStats は1回分のBulkIndexerの統計情報を加算します。
+func (c *bulkResultCollector) addStats(stats esutil.BulkIndexerStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.NumIndexed += stats.NumIndexed
+	c.stats.NumCreated += stats.NumCreated
+	c.stats.NumUpdated += stats.NumUpdated
+	c.stats.NumFailed += stats.NumFailed
+}
+
+// addThrottled はRateLimiterで待った時間を加算します。
+func (c *bulkResultCollector) addThrottled(d time.Duration) {
+	if d == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.throttled += d
+}
+
+// takePending はリトライ待ちのアイテムを取り出します。
+// 取り出したアイテムは再送されるため、失敗件数からは除外します。
+func (c *bulkResultCollector) takePending() []pendingItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := c.pending
+	c.retried += uint64(len(items))
+	c.stats.NumFailed -= uint64(len(items))
+	c.pending = nil
+	return items
+}
+
+// result は集計したBulkResultを返します。
+// リトライしきれなかったアイテムは失敗として扱い、
+// リクエスト単位で失敗したフラッシュがあった場合はエラーも返します。
+func (c *bulkResultCollector) result() (*BulkResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pending {
+		c.fail(p.item, p.failure, p.pipeline)
+	}
+	c.pending = nil
+
+	result := &BulkResult{
+		Indexed:   c.stats.NumIndexed,
+		Created:   c.stats.NumCreated,
+		Updated:   c.stats.NumUpdated,
+		Failed:    c.stats.NumFailed,
+		Retried:   c.retried,
+		Throttled: c.throttled,
+		Failures:  c.failures,
+		Rollovers: c.rollovers,
+	}
+	if c.indices != nil {
+		result.Indices = make(map[string]IndexStats, len(c.indices))
+		for index, stats := range c.indices {
+			result.Indices[index] = *stats
+		}
+	}
+	var err error
+	if len(c.flushErrors) > 0 {
+		err = fmt.Errorf("bulk indexer reported errors: %w", errors.Join(c.flushErrors...))
+	}
+	if len(c.deadLetterErrors) > 0 {
+		err = errors.Join(err, fmt.Errorf("failed to record dead letters: %w", errors.Join(c.deadLetterErrors...)))
+	}
+	if len(c.rolloverErrors) > 0 {
+		err = errors.Join(err, fmt.Errorf("failed to roll over data streams: %w", errors.Join(c.rolloverErrors...)))
+	}
+	return result, err
+}
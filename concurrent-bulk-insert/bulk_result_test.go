@@ -0,0 +1,108 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestBulkResultCollector(t *testing.T) {
+	collector := newBulkResultCollector(nil)
+
+	var res esutil.BulkIndexerResponseItem
+	res.DocumentID = "auto-1"
+	res.Status = 400
+	res.Error.Type = "mapper_parsing_exception"
+	res.Error.Reason = "failed to parse field [year]"
+	collector.onFailure(context.Background(), esutil.BulkIndexerItem{}, res, nil)
+
+	collector.onFailure(context.Background(), esutil.BulkIndexerItem{DocumentID: "2"}, esutil.BulkIndexerResponseItem{}, errors.New("read error"))
+
+	collector.addStats(esutil.BulkIndexerStats{NumIndexed: 8, NumFailed: 2})
+	result, err := collector.result()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Indexed != 8 || result.Failed != 2 {
+		t.Errorf("unexpected counts: %+v", result)
+	}
+	if len(result.Failures) != 2 {
+		t.Fatalf("expected 2 failures, got %d", len(result.Failures))
+	}
+	if got := result.Failures[0]; got.DocumentID != "auto-1" || got.Status != 400 || got.ErrorType != "mapper_parsing_exception" {
+		t.Errorf("unexpected failure: %+v", got)
+	}
+	if got := result.Failures[1]; got.DocumentID != "2" || got.Reason != "read error" {
+		t.Errorf("unexpected failure: %+v", got)
+	}
+}
+
+func TestBulkResultCollectorFlushError(t *testing.T) {
+	collector := newBulkResultCollector(nil)
+	collector.onError(context.Background(), errors.New("flush: connection refused"))
+	collector.addStats(esutil.BulkIndexerStats{NumFailed: 3})
+
+	result, err := collector.result()
+	if err == nil {
+		t.Fatal("expected an error for the failed flush")
+	}
+	if result == nil || result.Failed != 3 {
+		t.Errorf("expected the result to be returned with the error, got %+v", result)
+	}
+}
+
+func TestBulkResultRequestFailure(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// リクエスト全体が失敗した場合も、どの方法でもアイテムごとに失敗が記録されます。
+	methods := map[string]func() (*BulkResult, error){
+		"BulkInsert": func() (*BulkResult, error) {
+			return client.BulkInsert(context.Background(), "test", generateDocs(20))
+		},
+		"BulkInsertConcurrentV2": func() (*BulkResult, error) {
+			return client.BulkInsertConcurrentV2(context.Background(), "test", generateDocs(20), 2)
+		},
+		"BulkInsertConcurrentV3": func() (*BulkResult, error) {
+			return client.BulkInsertConcurrentV3(context.Background(), "test", generateDocs(20), 2)
+		},
+		"BulkLoad": func() (*BulkResult, error) {
+			return client.BulkLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(ndjsonDocs(20))), 2)
+		},
+	}
+	for name, insert := range methods {
+		srv.Inject(esfake.Fault{Path: "/*/_bulk", Status: http.StatusInternalServerError, Times: 1})
+		result, err := insert()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if result.Failed == 0 || uint64(len(result.Failures)) != result.Failed || result.Indexed+result.Failed != 20 {
+			t.Errorf("%s: expected a failure for each failed document, got %+v", name, result)
+			continue
+		}
+		for _, f := range result.Failures {
+			if f.Status != http.StatusInternalServerError || f.ErrorType != "unavailable_shards_exception" {
+				t.Errorf("%s: unexpected failure: %+v", name, f)
+			}
+		}
+	}
+}
+
+func TestBulkResultAdd(t *testing.T) {
+	result := BulkResult{Indexed: 1, Failures: []BulkItemFailure{{DocumentID: "a"}}}
+	result.add(&BulkResult{Indexed: 2, Failed: 1, Failures: []BulkItemFailure{{DocumentID: "b"}}})
+	result.add(nil)
+
+	if result.Indexed != 3 || result.Failed != 1 || len(result.Failures) != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
@@ -0,0 +1,291 @@
+package concurrentinsert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// defaultCheckpointBatchSize はチェックポイントのウォーターマークを進める単位のレコード数です。
+const defaultCheckpointBatchSize = 1000
+
+// Checkpoint は中断した投入を再開するために、チェックポイントファイルに保存する進み具合です。
+// レコードはBatchSize件ごとのバッチにまとめ、先頭から連続してすべてのレコードが確定したバッチまでを記録します。
+// 確定とは、登録に成功したか、再送しても成功しない恒久的なエラーで失敗したことを指します。
+type Checkpoint struct {
+	// Index は投入先のインデックスです。
+	Index string `json:"index"`
+	// BatchSize は1バッチのレコード数です。読み込みを途中で止めた場合、その時点のバッチはこれより少なくなります。
+	BatchSize int `json:"batch_size"`
+	// Batches は先頭から連続して確定したバッチ数です。
+	Batches int `json:"batches"`
+	// Records は先頭から連続して確定したレコード数です。再開時はこの件数を読み飛ばします。
+	Records int `json:"records"`
+	// Line は最後に確定したレコードの入力上の行番号です。再開時に入力が同じかを確認するために使用します。
+	Line int `json:"line"`
+	// Completed は入力の終端まで、すべてのレコードが確定したかどうかです。
+	Completed bool      `json:"completed"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ErrCheckpointExists はCheckpointedLoadで、pathにチェックポイントファイルが既にあることを表します。
+var ErrCheckpointExists = errors.New("checkpoint already exists")
+
+// WithCheckpointOverwrite はCheckpointedLoadで既存のチェックポイントファイルを上書きするかどうかを設定します。
+// 既定では上書きせず、誤って新しい投入を始めて再開の位置を失わないようErrCheckpointExistsを返します。
+func (c *Client) WithCheckpointOverwrite(enabled bool) *Client {
+	c.overwriteCheckpoint = enabled
+	return c
+}
+
+// LoadCheckpoint はpathのチェックポイントファイルを読み込みます。
+// ファイルがない場合はos.ErrNotExistをラップしたエラーを返します。
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %w", path, err)
+	}
+	if cp.BatchSize <= 0 {
+		return nil, fmt.Errorf("checkpoint %s has an invalid batch size %d", path, cp.BatchSize)
+	}
+	return &cp, nil
+}
+
+// save はチェックポイントを一時ファイルに書き込んでからリネームします。
+// 書き込みの途中でプロセスが終了しても、直前のチェックポイントが壊れることはありません。
+func (cp Checkpoint) save(path string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// CheckpointedLoad はBulkLoadと同様にRecordReaderから投入し、確定した位置をpathのチェックポイントファイルに記録します。
+// pathにチェックポイントが既にある場合は、WithCheckpointOverwriteを設定していなければErrCheckpointExistsを返します。
+// 途中で失敗した場合は、同じ入力とpathを指定したResumeで続きから投入できます。
+func (c *Client) CheckpointedLoad(ctx context.Context, index string, r RecordReader, numWorkers int, path string) (*BulkResult, error) {
+	if !c.overwriteCheckpoint {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s: %w; use Resume to continue the load", path, ErrCheckpointExists)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to check checkpoint: %w", err)
+		}
+	}
+	tracker := newCheckpointTracker(path, Checkpoint{Index: index, BatchSize: defaultCheckpointBatchSize})
+	if err := tracker.cp.save(path); err != nil {
+		return nil, err
+	}
+	return c.bulkLoad(ctx, index, r, numWorkers, tracker, nil)
+}
+
+// Resume はpathのチェックポイントから投入を再開します。
+// 確定済みのレコードは入力から読み飛ばすだけで送信しません。チェックポイントがない場合は先頭から投入します。
+// 返されるBulkResultには、再開後に送信した分のみが含まれます。
+func (c *Client) Resume(ctx context.Context, index string, r RecordReader, numWorkers int, path string) (*BulkResult, error) {
+	cp, err := LoadCheckpoint(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c.CheckpointedLoad(ctx, index, r, numWorkers, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cp.Index != index {
+		return nil, fmt.Errorf("checkpoint %s is for index %s, not %s", path, cp.Index, index)
+	}
+	if cp.Completed {
+		return &BulkResult{}, nil
+	}
+	if err := skipRecords(r, cp); err != nil {
+		return nil, err
+	}
+	return c.bulkLoad(ctx, index, r, numWorkers, newCheckpointTracker(path, *cp), nil)
+}
+
+// skipRecords はチェックポイントで確定済みのレコードを読み飛ばします。
+// 最後に読み飛ばしたレコードの行番号がチェックポイントと異なる場合は、別の入力とみなしてエラーにします。
+func skipRecords(r RecordReader, cp *Checkpoint) error {
+	var last Record
+	for i := 0; i < cp.Records; i++ {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return fmt.Errorf("input ended after %d records, but the checkpoint has %d", i, cp.Records)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to skip confirmed records: %w", err)
+		}
+		last = rec
+	}
+	if cp.Records > 0 && last.Line != cp.Line {
+		return fmt.Errorf("input does not match the checkpoint: record %d is on line %d, expected line %d", cp.Records, last.Line, cp.Line)
+	}
+	return nil
+}
+
+// checkpointBatch は1バッチ分のレコードの確定状況です。
+type checkpointBatch struct {
+	added    int
+	acked    int
+	lastLine int
+	// sealed はバッチのレコードがすべて追加されたかどうかです。
+	sealed bool
+}
+
+// checkpointTracker はBulkIndexerのコールバックからレコードの確定を受け取り、ウォーターマークが進むたびにチェックポイントを保存します。
+// コールバックはBulkIndexerのワーカーから並行して呼ばれるため、mutexで保護します。
+type checkpointTracker struct {
+	mu      sync.Mutex
+	path    string
+	cp      Checkpoint
+	batches map[int]*checkpointBatch
+	// base とstart は再開した時点のバッチ数とレコード数です。バッチは再開した位置から数えます。
+	base  int
+	start int
+	ended bool
+	last  int
+	err   error
+}
+
+func newCheckpointTracker(path string, cp Checkpoint) *checkpointTracker {
+	return &checkpointTracker{
+		path:    path,
+		cp:      cp,
+		batches: make(map[int]*checkpointBatch),
+		base:    cp.Batches,
+		start:   cp.Records,
+		last:    cp.Batches - 1,
+	}
+}
+
+// track はレコードをバッチに追加し、アイテムの確定をtrackerに通知するようにコールバックを設定します。
+// レコードは入力の順に渡す必要があります。
+func (t *checkpointTracker) track(item *esutil.BulkIndexerItem, rec Record) {
+	n := t.base + (rec.Number-1-t.start)/t.cp.BatchSize
+
+	t.mu.Lock()
+	b, ok := t.batches[n]
+	if !ok {
+		b = &checkpointBatch{}
+		t.batches[n] = b
+		t.last = n
+	}
+	b.added++
+	b.lastLine = rec.Line
+	b.sealed = (rec.Number-t.start)%t.cp.BatchSize == 0
+	t.mu.Unlock()
+
+	item.OnSuccess = func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem) {
+		t.ack(n)
+	}
+	onFailure := item.OnFailure
+	item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		onFailure(ctx, item, res, err)
+		// リクエスト単位の失敗やリトライ可能な失敗は、再開時に送り直すため確定させません。
+		if err == nil && !esbulk.IsRequestFailure(res) && !newBulkItemFailure(item, res, err).Retryable() {
+			t.ack(n)
+		}
+	}
+}
+
+func (t *checkpointTracker) ack(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batches[n].acked++
+	t.advance()
+}
+
+// finish は入力の終端に達したことを記録し、最後のバッチを閉じます。
+func (t *checkpointTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ended = true
+	if b, ok := t.batches[t.last]; ok {
+		b.sealed = true
+	}
+	t.advance()
+}
+
+// interrupt は入力の途中で読み込みを止めたことを記録し、最後のバッチを閉じます。
+// 閉じたバッチはBatchSizeに満たなくても、すべてのレコードが確定すればチェックポイントに含めます。
+func (t *checkpointTracker) interrupt() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.batches[t.last]; ok {
+		b.sealed = true
+	}
+	t.advance()
+}
+
+// checkpoint は現在のチェックポイントを返します。
+func (t *checkpointTracker) checkpoint() Checkpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cp
+}
+
+// advance は先頭から連続して確定したバッチの分だけウォーターマークを進め、進んだ場合はチェックポイントを保存します。
+// 呼び出し元でt.muをロックしておく必要があります。
+func (t *checkpointTracker) advance() {
+	advanced := false
+	for {
+		b, ok := t.batches[t.cp.Batches]
+		if !ok || !b.sealed || b.acked < b.added {
+			break
+		}
+		delete(t.batches, t.cp.Batches)
+		t.cp.Batches++
+		t.cp.Records += b.added
+		t.cp.Line = b.lastLine
+		advanced = true
+	}
+	if t.ended && len(t.batches) == 0 && !t.cp.Completed {
+		t.cp.Completed = true
+		advanced = true
+	}
+	if advanced {
+		t.saveLocked()
+	}
+}
+
+func (t *checkpointTracker) saveLocked() {
+	t.cp.UpdatedAt = time.Now()
+	if err := t.cp.save(t.path); err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// close は最後のチェックポイントを保存し、保存中に起きた最初のエラーを返します。
+func (t *checkpointTracker) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.saveLocked()
+	return t.err
+}
@@ -0,0 +1,138 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func ndjsonDocs(n int) string {
+	var input strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&input, "{\"isbn\": \"%d\", \"title\": \"Test Document %d\"}\n", i, i)
+	}
+	return input.String()
+}
+
+// sentItems は疑似サーバーがBulk APIで受け取ったアイテム数を返します。
+func sentItems(srv *esfake.Server) int {
+	n := 0
+	for _, req := range srv.Requests() {
+		if strings.HasSuffix(req.Path, "/_bulk") {
+			n += bytes.Count(req.Body, []byte("\n")) / 2
+		}
+	}
+	return n
+}
+
+func TestCheckpointedLoadAndResume(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIDStrategy(FieldID("isbn"))
+	path := filepath.Join(t.TempDir(), "load.checkpoint")
+	input := ndjsonDocs(2500)
+
+	// 2件目のバッチに含まれる1700件目だけをリトライ可能なエラーで拒否します。
+	srv.InjectItem(esfake.ItemFault{
+		Match: func(item esfake.BulkItem) bool { return item.ID == "1700" },
+		Times: 1,
+	})
+	result, err := client.CheckpointedLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(input)), 2, path)
+	if err != nil {
+		t.Fatalf("CheckpointedLoad failed: %v", err)
+	}
+	if result.Indexed != 2499 || result.Failed != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Records != 1000 || cp.Line != 1000 || cp.Batches != 1 || cp.Completed {
+		t.Errorf("expected the watermark to stop before the rejected record, got %+v", cp)
+	}
+
+	srv.Reset()
+	result, err = client.Resume(context.Background(), "test", NewNDJSONReader(strings.NewReader(input)), 2, path)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if result.Indexed != 1500 || sentItems(srv) != 1500 {
+		t.Errorf("expected only the unconfirmed 1500 records to be sent, got %+v (%d sent)", result, sentItems(srv))
+	}
+	if cp, _ := LoadCheckpoint(path); cp.Records != 2500 || !cp.Completed {
+		t.Errorf("expected a completed checkpoint, got %+v", cp)
+	}
+
+	// 完了したチェックポイントから再開しても何も送信しません。
+	srv.Reset()
+	if _, err := client.Resume(context.Background(), "test", NewNDJSONReader(strings.NewReader(input)), 2, path); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if sentItems(srv) != 0 {
+		t.Errorf("expected nothing to be sent, got %d", sentItems(srv))
+	}
+}
+
+func TestCheckpointedLoadKeepsExistingCheckpoint(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "load.checkpoint")
+	saved := Checkpoint{Index: "test", BatchSize: 1000, Batches: 1, Records: 1000, Line: 1000}
+	if err := saved.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 誤って新しい投入を始めても、再開の位置は失われません。
+	_, err = client.CheckpointedLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(ndjsonDocs(10))), 1, path)
+	if !errors.Is(err, ErrCheckpointExists) {
+		t.Fatalf("expected ErrCheckpointExists, got %v", err)
+	}
+	if cp, _ := LoadCheckpoint(path); cp == nil || *cp != saved {
+		t.Errorf("expected the checkpoint to be kept, got %+v", cp)
+	}
+	if sentItems(srv) != 0 {
+		t.Errorf("expected nothing to be sent, got %d", sentItems(srv))
+	}
+
+	client.WithCheckpointOverwrite(true)
+	if _, err := client.CheckpointedLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(ndjsonDocs(10))), 1, path); err != nil {
+		t.Fatalf("CheckpointedLoad failed: %v", err)
+	}
+	if cp, _ := LoadCheckpoint(path); cp == nil || cp.Records != 10 || !cp.Completed {
+		t.Errorf("expected the checkpoint to be overwritten, got %+v", cp)
+	}
+}
+
+func TestResumeRejectsDifferentInput(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "load.checkpoint")
+	if err := (Checkpoint{Index: "test", BatchSize: 1000, Batches: 1, Records: 1000, Line: 1000}).save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Resume(context.Background(), "other", NewNDJSONReader(strings.NewReader(ndjsonDocs(10))), 1, path); err == nil {
+		t.Error("expected an error for a different index")
+	}
+	if _, err := client.Resume(context.Background(), "test", NewNDJSONReader(strings.NewReader(ndjsonDocs(10))), 1, path); err == nil {
+		t.Error("expected an error for a shorter input")
+	}
+	if _, err := client.Resume(context.Background(), "test", NewNDJSONReader(strings.NewReader("\n"+ndjsonDocs(1500))), 1, path); err == nil {
+		t.Error("expected an error for a shifted input")
+	}
+}
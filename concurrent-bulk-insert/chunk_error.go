@@ -0,0 +1,104 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// WithFailFast は並行して投入するメソッドで、最初の致命的なエラーが起きた時点で残りの処理を取り消すかどうかを設定します。
+// 致命的なエラーとは、チャンクやワーカーが返したエラーとリクエスト単位で失敗したフラッシュのことで、
+// アイテム単位の失敗はBulkResult.Failuresに記録するだけで取り消しません。
+// 取り消したドキュメントの範囲は、context.Canceledを包んだChunkErrorとして返します。
+func (c *Client) WithFailFast(enabled bool) *Client {
+	c.failFast = enabled
+	return c
+}
+
+// ChunkError は並行処理のチャンクやワーカーで発生したエラーと、対象のドキュメントの範囲です。
+// 範囲は引数のdocsの添字で、StartからEndの手前までです。
+type ChunkError struct {
+	Start int
+	End   int
+	Err   error
+}
+
+func (e *ChunkError) Error() string {
+	if e.End-e.Start == 1 {
+		return fmt.Sprintf("document %d: %v", e.Start+1, e.Err)
+	}
+	return fmt.Sprintf("documents %d-%d: %v", e.Start+1, e.End, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ChunkErrors はerrに含まれるすべてのChunkErrorを返します。
+// errors.Joinでまとめたエラーもたどるため、並行して投入するメソッドが返したエラーからすべての範囲を取り出せます。
+func ChunkErrors(err error) []*ChunkError {
+	var errs []*ChunkError
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case *ChunkError:
+			errs = append(errs, e)
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		default:
+			walk(errors.Unwrap(err))
+		}
+	}
+	walk(err)
+	return errs
+}
+
+// chunkErrors は並行して動くgoroutineのエラーを集めます。
+// WithFailFastを設定している場合は、最初のエラーでcontextを取り消します。
+type chunkErrors struct {
+	cancel   context.CancelCauseFunc
+	failFast bool
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// newChunkErrors はctxから取り消し可能なcontextを作成し、エラーを集めるchunkErrorsとともに返します。
+func (c *Client) newChunkErrors(ctx context.Context) (context.Context, *chunkErrors) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return ctx, &chunkErrors{cancel: cancel, failFast: c.failFast}
+}
+
+// add はdocs[start:end]の処理で発生したエラーを記録します。
+func (e *chunkErrors) add(start, end int, err error) {
+	e.mu.Lock()
+	e.errs = append(e.errs, &ChunkError{Start: start, End: end, Err: err})
+	e.mu.Unlock()
+	if e.failFast {
+		e.cancel(err)
+	}
+}
+
+// fatal はチャンクの範囲がわからない致命的なエラー(フラッシュのエラーなど)を受け取り、WithFailFastを設定している場合は取り消します。
+// エラー自体はcollectorが記録するため、ここでは記録しません。
+func (e *chunkErrors) fatal(err error) {
+	if e.failFast {
+		e.cancel(err)
+	}
+}
+
+// err は記録したエラーをまとめたエラーを返し、contextを解放します。
+// otherには範囲のないエラー(フラッシュのエラーなど)を渡します。
+func (e *chunkErrors) err(other error) error {
+	e.cancel(nil)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.errs) == 0 && other == nil {
+		return nil
+	}
+	return fmt.Errorf("error occurred during concurrent bulk insert: %w", errors.Join(append(e.errs, other)...))
+}
@@ -0,0 +1,108 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestBulkInsertConcurrentJoinsChunkErrors(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	docs := generateDocs(50)
+	// JSONにできない値を含むドキュメントは、そのチャンクのエラーになります。
+	docs[12]["score"] = math.Inf(1)
+	docs[37]["score"] = math.NaN()
+
+	result, err := client.BulkInsertConcurrent(context.Background(), "test", docs, 10)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if result.Indexed != 30 {
+		t.Errorf("expected the other 3 chunks to be indexed, got %+v", result)
+	}
+	ranges := map[[2]int]bool{}
+	for _, e := range ChunkErrors(err) {
+		ranges[[2]int{e.Start, e.End}] = true
+	}
+	if len(ranges) != 2 || !ranges[[2]int{10, 20}] || !ranges[[2]int{30, 40}] {
+		t.Errorf("expected errors for documents 11-20 and 31-40, got %v", err)
+	}
+}
+
+func TestBulkInsertConcurrentFailFast(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	// 上限により残りのチャンクは数秒かかりますが、最初のチャンクの失敗で取り消されます。
+	client.WithRateLimiter(NewRateLimiter(RateLimit{DocsPerSecond: 10})).WithFailFast(true)
+	docs := generateDocs(50)
+	docs[0]["score"] = math.Inf(1)
+
+	start := time.Now()
+	result, err := client.BulkInsertConcurrent(context.Background(), "test", docs, 10)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the remaining chunks to be canceled, took %v", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled chunks, got %v", err)
+	}
+	if result.Indexed >= 40 {
+		t.Errorf("expected some chunks not to be indexed, got %+v", result)
+	}
+
+	var fatal *ChunkError
+	for _, e := range ChunkErrors(err) {
+		if !errors.Is(e, context.Canceled) {
+			if fatal != nil {
+				t.Errorf("expected a single fatal error, got %v and %v", fatal, e)
+			}
+			fatal = e
+		}
+	}
+	if fatal == nil || fatal.Start != 0 || fatal.End != 10 {
+		t.Errorf("expected the first chunk to fail, got %v", err)
+	}
+}
+
+func TestBulkInsertConcurrentWithTimeSleepJoinsChunkErrors(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	docs := generateDocs(30)
+	docs[15]["score"] = math.NaN()
+
+	result, err := client.BulkInsertConcurrentWithTimeSleep(context.Background(), "test", docs, 10, 1)
+	if result.Indexed != 20 {
+		t.Errorf("expected the other 2 chunks to be indexed, got %+v", result)
+	}
+	errs := ChunkErrors(err)
+	if len(errs) != 1 || errs[0].Start != 10 || errs[0].End != 20 {
+		t.Errorf("expected an error for documents 11-20, got %v", err)
+	}
+}
+
+func TestBulkInsertConcurrentDefaultsWorkers(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	for _, numWorkers := range []int{0, -1} {
+		result, err := client.BulkInsertConcurrentV2(context.Background(), "test", generateDocs(10), numWorkers)
+		if err != nil || result.Indexed != 10 {
+			t.Errorf("V2 with %d workers: %+v, %v", numWorkers, result, err)
+		}
+		result, err = client.BulkInsertConcurrentV3(context.Background(), "test", generateDocs(10), numWorkers)
+		if err != nil || result.Indexed != 10 {
+			t.Errorf("V3 with %d workers: %+v, %v", numWorkers, result, err)
+		}
+	}
+}
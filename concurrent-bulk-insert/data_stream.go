@@ -0,0 +1,195 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// PutDataStreamTemplate はpatternsに一致するデータストリームを作成するインデックステンプレートを登録します。
+// 同じ名前のテンプレートがある場合は置き換えます。データストリームは、テンプレートに一致する名前へ最初に書き込んだときに作成されます。
+// データストリームのドキュメントには@timestampフィールドが必要です。
+func (c *Client) PutDataStreamTemplate(ctx context.Context, name string, patterns []string, template *IndexTemplate, priority int) error {
+	body := map[string]interface{}{
+		"index_patterns": patterns,
+		"data_stream":    map[string]interface{}{},
+		"priority":       priority,
+	}
+	if template != nil {
+		body["template"] = template
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index template: %w", err)
+	}
+	res, err := c.baseClient.Indices.PutIndexTemplate(name, bytes.NewReader(data),
+		c.baseClient.Indices.PutIndexTemplate.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to put index template %s: %w", name, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("failed to put index template %s: %s", name, res.String())
+	}
+	return nil
+}
+
+// RolloverPolicy はデータストリームをロールオーバーする条件です。
+// 0の条件は使わず、いずれかの条件を満たした場合にロールオーバーします。
+type RolloverPolicy struct {
+	// MaxAge は書き込み先のインデックスが作成されてからの経過時間の上限です。
+	MaxAge time.Duration
+	// MaxPrimaryShardSize はプライマリシャード1つあたりのサイズの上限(バイト)です。
+	MaxPrimaryShardSize int64
+	// MaxDocs は書き込み先のインデックスのドキュメント数の上限です。
+	MaxDocs int64
+	// CheckInterval はデータストリームごとに条件を確認する間隔です。省略した場合は1分です。
+	// 各メソッドの最後には、間隔によらず書き込んだすべてのデータストリームを確認します。
+	CheckInterval time.Duration
+}
+
+// WithDataStream は各メソッドの書き込み先をデータストリームとして扱うようにします。
+// データストリームはop_typeがcreateの書き込みしか受け付けないため、index操作をcreate操作に変えて送信します。
+// policyを指定した場合は、書き込み中にCheckIntervalごとと各メソッドの最後に条件を確認してロールオーバーします。
+// ロールオーバーした結果はBulkResult.Rolloversに入ります。
+// MaxAge、MaxPrimaryShardSize、MaxDocsのいずれも指定していないpolicyは、nilと同じくロールオーバーしません。
+// Rollover APIは条件のない要求を無条件にロールオーバーするため、確認のたびに新しいインデックスが作られるのを防ぎます。
+func (c *Client) WithDataStream(policy *RolloverPolicy) *Client {
+	if policy != nil && policy.MaxAge <= 0 && policy.MaxPrimaryShardSize <= 0 && policy.MaxDocs <= 0 {
+		policy = nil
+	}
+	c.dataStream = &dataStreamState{
+		policy:    policy,
+		lastCheck: make(map[string]time.Time),
+	}
+	return c
+}
+
+// Rollover はロールオーバーしたデータストリームと、前後の書き込み先のインデックスです。
+type Rollover struct {
+	DataStream string
+	OldIndex   string
+	NewIndex   string
+}
+
+// dataStreamState はデータストリームごとに、最後にロールオーバーの条件を確認した時刻を記録します。
+type dataStreamState struct {
+	policy *RolloverPolicy
+
+	mu        sync.Mutex
+	lastCheck map[string]time.Time
+}
+
+// due はstreamの条件を確認する時刻になっていればtrueを返し、確認した時刻を更新します。
+// 初めて書き込むデータストリームは、CheckIntervalが経過するまで確認しません。
+func (s *dataStreamState) due(stream string, now time.Time) bool {
+	if s.policy == nil {
+		return false
+	}
+	interval := s.policy.CheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.lastCheck[stream]
+	if !ok {
+		s.lastCheck[stream] = now
+		return false
+	}
+	if now.Sub(last) < interval {
+		return false
+	}
+	s.lastCheck[stream] = now
+	return true
+}
+
+// conditions はRollover APIのconditionsを返します。
+func (s *dataStreamState) conditions() map[string]interface{} {
+	conditions := make(map[string]interface{})
+	if s.policy.MaxAge > 0 {
+		conditions["max_age"] = fmt.Sprintf("%dms", s.policy.MaxAge.Milliseconds())
+	}
+	if s.policy.MaxPrimaryShardSize > 0 {
+		conditions["max_primary_shard_size"] = fmt.Sprintf("%db", s.policy.MaxPrimaryShardSize)
+	}
+	if s.policy.MaxDocs > 0 {
+		conditions["max_docs"] = s.policy.MaxDocs
+	}
+	return conditions
+}
+
+// toDataStream はデータストリームに書き込めるよう、index操作をcreate操作に変え、書き込み先を記録します。
+// 書き込み先の条件を確認する時刻になっていれば、追加する前にロールオーバーします。
+func (w *bulkWriter) toDataStream(ctx context.Context, item esutil.BulkIndexerItem) esutil.BulkIndexerItem {
+	if item.Action == "" || item.Action == "index" {
+		item.Action = "create"
+	}
+	stream := esbulk.ItemIndex(item, w.cfg.Index)
+
+	w.mu.Lock()
+	w.streams[stream] = struct{}{}
+	w.mu.Unlock()
+
+	if w.client.dataStream.due(stream, time.Now()) {
+		w.client.rollover(ctx, stream, w.collector)
+	}
+	return item
+}
+
+// rolloverStreams は書き込んだすべてのデータストリームについて、ロールオーバーの条件を確認します。
+func (w *bulkWriter) rolloverStreams(ctx context.Context) {
+	if w.client.dataStream == nil || w.client.dataStream.policy == nil {
+		return
+	}
+	now := time.Now()
+	for stream := range w.streams {
+		w.client.dataStream.mu.Lock()
+		w.client.dataStream.lastCheck[stream] = now
+		w.client.dataStream.mu.Unlock()
+		w.client.rollover(ctx, stream, w.collector)
+	}
+}
+
+// rollover はRolloverPolicyの条件でstreamのロールオーバーを要求し、結果をcollectorに記録します。
+// 失敗してもドキュメントの登録は続け、エラーはメソッドの最後に返します。
+func (c *Client) rollover(ctx context.Context, stream string, collector *bulkResultCollector) {
+	body, err := json.Marshal(map[string]interface{}{"conditions": c.dataStream.conditions()})
+	if err != nil {
+		collector.addRolloverError(fmt.Errorf("failed to marshal rollover conditions: %w", err))
+		return
+	}
+	res, err := c.baseClient.Indices.Rollover(stream,
+		c.baseClient.Indices.Rollover.WithBody(bytes.NewReader(body)),
+		c.baseClient.Indices.Rollover.WithContext(ctx),
+	)
+	if err != nil {
+		collector.addRolloverError(fmt.Errorf("failed to roll over %s: %w", stream, err))
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		collector.addRolloverError(fmt.Errorf("failed to roll over %s: %s", stream, res.String()))
+		return
+	}
+	var r struct {
+		OldIndex   string `json:"old_index"`
+		NewIndex   string `json:"new_index"`
+		RolledOver bool   `json:"rolled_over"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		collector.addRolloverError(fmt.Errorf("failed to decode rollover response for %s: %w", stream, err))
+		return
+	}
+	if r.RolledOver {
+		collector.addRollover(Rollover{DataStream: stream, OldIndex: r.OldIndex, NewIndex: r.NewIndex})
+	}
+}
@@ -0,0 +1,160 @@
+package concurrentinsert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestBulkInsertToDataStream(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx := context.Background()
+
+	err = client.PutDataStreamTemplate(ctx, "logs", []string{"logs-*"}, &IndexTemplate{
+		Settings: map[string]interface{}{"number_of_shards": 2},
+	}, 100)
+	if err != nil {
+		t.Fatalf("PutDataStreamTemplate failed: %v", err)
+	}
+
+	docs := make([]map[string]interface{}, 0, 121)
+	for i := 0; i < 120; i++ {
+		docs = append(docs, map[string]interface{}{
+			"message":    fmt.Sprintf("Log %d", i+1),
+			"@timestamp": "2026-10-18T12:00:00Z",
+		})
+	}
+	// データストリームへの書き込みには@timestampが必要です。
+	docs = append(docs, map[string]interface{}{"message": "no timestamp"})
+
+	// データストリームはindex操作を受け付けません。
+	result, err := client.BulkInsert(ctx, "logs-web", docs[:1])
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Failed != 1 || result.Failures[0].ErrorType != "illegal_argument_exception" {
+		t.Errorf("expected the index action to be rejected, got %+v", result)
+	}
+
+	client.WithDataStream(&RolloverPolicy{MaxDocs: 100, MaxAge: 24 * time.Hour})
+	result, err = client.BulkInsertConcurrentV3(ctx, "logs-app", docs, 4)
+	if err != nil {
+		t.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
+	}
+	if result.Created != 120 || result.Failed != 1 || result.Failures[0].ErrorType != "document_parsing_exception" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	ds, ok := srv.DataStream("logs-app")
+	if !ok || ds.Generation != 2 || len(ds.Indices) != 2 {
+		t.Fatalf("expected the data stream to be rolled over once, got %+v", ds)
+	}
+	want := Rollover{DataStream: "logs-app", OldIndex: ds.Indices[0], NewIndex: ds.Indices[1]}
+	if len(result.Rollovers) != 1 || result.Rollovers[0] != want {
+		t.Errorf("got rollovers %+v, want %+v", result.Rollovers, want)
+	}
+	idx, _ := srv.Index(ds.Indices[0])
+	if len(idx.Documents) != 120 || idx.Settings["index.number_of_shards"] != "2" {
+		t.Errorf("expected 120 documents in the backing index created from the template, got %d, %+v", len(idx.Documents), idx.Settings)
+	}
+
+	// 条件を満たさない場合はロールオーバーしません。
+	result, err = client.BulkInsert(ctx, "logs-app", docs[:10])
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Created != 10 || len(result.Rollovers) != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if ds, _ := srv.DataStream("logs-app"); ds.Generation != 2 {
+		t.Errorf("expected no further rollover, got %+v", ds)
+	}
+}
+
+func TestBulkInsertToDataStreamRolloverError(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := client.PutDataStreamTemplate(context.Background(), "logs", []string{"logs-*"}, nil, 0); err != nil {
+		t.Fatalf("PutDataStreamTemplate failed: %v", err)
+	}
+	srv.Inject(esfake.Fault{Path: "/logs-app/_rollover", Status: 500})
+
+	client.WithDataStream(&RolloverPolicy{MaxDocs: 1})
+	docs := []map[string]interface{}{{"@timestamp": "2026-10-18T12:00:00Z"}}
+	result, err := client.BulkInsert(context.Background(), "logs-app", docs)
+	if err == nil {
+		t.Fatal("expected the rollover error to be returned")
+	}
+	if result == nil || result.Created != 1 {
+		t.Errorf("expected the documents to be written despite the rollover error, got %+v", result)
+	}
+}
+
+func TestDataStreamRolloverCheckInterval(t *testing.T) {
+	s := &dataStreamState{
+		policy:    &RolloverPolicy{MaxDocs: 1, CheckInterval: time.Minute},
+		lastCheck: make(map[string]time.Time),
+	}
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		stream string
+		at     time.Duration
+		want   bool
+	}{
+		{"logs-a", 0, false},
+		{"logs-a", 30 * time.Second, false},
+		{"logs-b", 30 * time.Second, false},
+		{"logs-a", time.Minute, true},
+		{"logs-a", 90 * time.Second, false},
+		{"logs-b", 90 * time.Second, true},
+		{"logs-a", 2 * time.Minute, true},
+	} {
+		if got := s.due(tt.stream, start.Add(tt.at)); got != tt.want {
+			t.Errorf("%s at %s: got %v, want %v", tt.stream, tt.at, got, tt.want)
+		}
+	}
+
+	if (&dataStreamState{lastCheck: make(map[string]time.Time)}).due("logs-a", start) {
+		t.Error("expected no check without a rollover policy")
+	}
+}
+
+func TestDataStreamWithoutRolloverThresholds(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := client.PutDataStreamTemplate(context.Background(), "logs", []string{"logs-*"}, nil, 0); err != nil {
+		t.Fatalf("PutDataStreamTemplate failed: %v", err)
+	}
+
+	// 条件のないポリシーでは、メソッドの最後にもロールオーバーを要求しません。
+	client.WithDataStream(&RolloverPolicy{CheckInterval: time.Millisecond})
+	docs := []map[string]interface{}{{"@timestamp": "2026-10-18T12:00:00Z"}}
+	for i := 0; i < 3; i++ {
+		result, err := client.BulkInsert(context.Background(), "logs-app", docs)
+		if err != nil || result.Created != 1 || len(result.Rollovers) != 0 {
+			t.Fatalf("unexpected result: %+v, %v", result, err)
+		}
+	}
+	if ds, _ := srv.DataStream("logs-app"); ds.Generation != 1 {
+		t.Errorf("expected the data stream not to be rolled over, got %+v", ds)
+	}
+	for _, req := range srv.Requests() {
+		if strings.HasSuffix(req.Path, "/_rollover") {
+			t.Errorf("expected no rollover request, got %s %s", req.Method, req.Path)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package concurrentinsert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// DeadLetter は登録に失敗したドキュメントの記録です。
+// マッピングなどの問題を直した後、ReplayDeadLettersで元のドキュメントを再投入できます。
+type DeadLetter struct {
+	Index      string `json:"index"`
+	Action     string `json:"action"`
+	DocumentID string `json:"document_id,omitempty"`
+	// Routing とPipeline は送信したときのルーティング値と取り込みパイプラインです。
+	// Pipelineが空の場合は、再投入するClientの既定のパイプラインを使用します。
+	Routing  string `json:"routing,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+	// Document は元のドキュメントです。JSONにできなかったドキュメントの場合は空です。
+	Document  json.RawMessage `json:"document,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	ErrorType string          `json:"error_type"`
+	Reason    string          `json:"reason"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// DeadLetterSink は登録に失敗したドキュメントの書き込み先です。
+// BulkIndexerのワーカーから並行して呼ばれるため、実装は並行に安全である必要があります。
+type DeadLetterSink interface {
+	Write(letter DeadLetter) error
+}
+
+// WithDeadLetterSink は各メソッドで登録に失敗したドキュメントの書き込み先を設定します。
+// リトライしきれなかったアイテムや、リクエスト全体が失敗したフラッシュのドキュメント、マーシャルに失敗したドキュメントなど、
+// BulkResult.Failuresに含まれるものが対象です。
+func (c *Client) WithDeadLetterSink(sink DeadLetterSink) *Client {
+	c.deadLetters = sink
+	return c
+}
+
+// NDJSONDeadLetterSink はDeadLetterを1行に1件のJSONとして書き込みます。
+type NDJSONDeadLetterSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewNDJSONDeadLetterSink はwに書き込むNDJSONDeadLetterSinkを作成します。
+func NewNDJSONDeadLetterSink(w io.Writer) *NDJSONDeadLetterSink {
+	return &NDJSONDeadLetterSink{w: w}
+}
+
+// OpenDeadLetterFile はpathのファイルに追記するNDJSONDeadLetterSinkを作成します。ファイルがない場合は作成します。
+// 1件ごとに書き込むため、プロセスが途中で終了しても書き込み済みの記録は残ります。
+func OpenDeadLetterFile(path string) (*NDJSONDeadLetterSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	return &NDJSONDeadLetterSink{w: f, closer: f}, nil
+}
+
+func (s *NDJSONDeadLetterSink) Write(letter DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+// Close はOpenDeadLetterFileで開いたファイルを閉じます。
+func (s *NDJSONDeadLetterSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// newDeadLetter はアイテムと失敗内容からDeadLetterを作成します。indexはアイテムにインデックスがない場合に使用します。
+func newDeadLetter(index string, item esutil.BulkIndexerItem, failure BulkItemFailure, pipeline string) DeadLetter {
+	if item.Index != "" {
+		index = item.Index
+	}
+	return DeadLetter{
+		Index:      index,
+		Action:     item.Action,
+		DocumentID: failure.DocumentID,
+		Routing:    item.Routing,
+		Pipeline:   pipeline,
+		Document:   itemDocument(item),
+		Status:     failure.Status,
+		ErrorType:  failure.ErrorType,
+		Reason:     failure.Reason,
+		Timestamp:  time.Now(),
+	}
+}
+
+// itemDocument はアイテムのBodyを先頭から読み直して返します。読み直せない場合は空を返します。
+func itemDocument(item esutil.BulkIndexerItem) json.RawMessage {
+	body, ok := item.Body.(io.ReadSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil || !json.Valid(data) {
+		return nil
+	}
+	_, _ = body.Seek(0, io.SeekStart)
+	return data
+}
+
+// ReplayDeadLetters はNDJSON形式のDeadLetterを読み出し、元のインデックス、アクション、ルーティング、パイプラインで再投入します。
+// ドキュメントのない記録は再投入できないため、失敗として扱います。
+// 再投入でも失敗したドキュメントは、WithDeadLetterSinkで設定した書き込み先に記録されます。
+func (c *Client) ReplayDeadLetters(ctx context.Context, r io.Reader, numWorkers int) (*BulkResult, error) {
+	collector := c.newCollector("")
+	bulkCfg := esutil.BulkIndexerConfig{
+		Client:     c.baseClient,
+		NumWorkers: workerCount(numWorkers),
+		OnError:    collector.onError,
+	}
+	writer := c.newBulkWriter(bulkCfg, collector)
+
+	var readErr error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			readErr = &LoadError{Line: line, Number: line, Err: err}
+			break
+		}
+
+		item := esutil.BulkIndexerItem{
+			Index:      letter.Index,
+			Action:     letter.Action,
+			DocumentID: letter.DocumentID,
+			Routing:    letter.Routing,
+			Body:       bytes.NewReader(letter.Document),
+			OnFailure:  collector.onFailureFor(letter.Pipeline),
+		}
+		if len(letter.Document) == 0 {
+			collector.addFailure(item, BulkItemFailure{
+				DocumentID: letter.DocumentID,
+				ErrorType:  letter.ErrorType,
+				Reason:     "dead letter has no document: " + letter.Reason,
+			}, letter.Pipeline)
+			continue
+		}
+		if err := writer.add(ctx, letter.Pipeline, item); err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("failed to add dead letter on line %d to bulk indexer: %w", line, err))
+		}
+	}
+	if readErr == nil {
+		readErr = scanner.Err()
+	}
+
+	if err := writer.close(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.retryFailed(ctx, bulkCfg, collector); err != nil {
+		return nil, err
+	}
+	result, err := collector.result()
+	if readErr != nil {
+		return result, errors.Join(fmt.Errorf("failed to read dead letters: %w", readErr), err)
+	}
+	return result, err
+}
@@ -0,0 +1,135 @@
+package concurrentinsert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func readDeadLetters(t *testing.T, data []byte) []DeadLetter {
+	t.Helper()
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("failed to decode dead letter: %v", err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestDeadLettersAndReplay(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var out bytes.Buffer
+	client.WithIDStrategy(FieldID("isbn")).WithDeadLetterSink(NewNDJSONDeadLetterSink(&out))
+
+	// マッピングの不整合で1件を拒否し、もう1件はJSONにできないドキュメントにします。
+	srv.InjectItem(esfake.ItemFault{
+		Match:     func(item esfake.BulkItem) bool { return item.ID == "2" },
+		Times:     1,
+		Status:    http.StatusBadRequest,
+		ErrorType: "mapper_parsing_exception",
+		Reason:    "failed to parse field [year]",
+	})
+	docs := []map[string]interface{}{
+		{"isbn": "1", "title": "a"},
+		{"isbn": "2", "title": "b", "year": "unknown"},
+		{"isbn": "3", "title": make(chan int)},
+	}
+	result, err := client.BulkInsertConcurrentV2(context.Background(), "books", docs, 2)
+	if err != nil {
+		t.Fatalf("BulkInsertConcurrentV2 failed: %v", err)
+	}
+	if result.Indexed != 1 || result.Failed != 2 || len(result.Failures) != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	letters := readDeadLetters(t, out.Bytes())
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+	byType := map[string]DeadLetter{}
+	for _, letter := range letters {
+		byType[letter.ErrorType] = letter
+	}
+	mapping := byType["mapper_parsing_exception"]
+	if mapping.Index != "books" || mapping.Action != "index" || mapping.DocumentID != "2" || mapping.Status != http.StatusBadRequest ||
+		!strings.Contains(string(mapping.Document), `"year":"unknown"`) || mapping.Timestamp.IsZero() {
+		t.Errorf("unexpected dead letter: %+v", mapping)
+	}
+	if marshal := byType["marshal_error"]; marshal.Document != nil || marshal.Reason == "" {
+		t.Errorf("unexpected dead letter: %+v", marshal)
+	}
+
+	// マッピングを直した後に再投入すると、ドキュメントのある記録だけが登録されます。
+	var replayed bytes.Buffer
+	client.WithDeadLetterSink(NewNDJSONDeadLetterSink(&replayed))
+	result, err = client.ReplayDeadLetters(context.Background(), bytes.NewReader(out.Bytes()), 1)
+	if err != nil {
+		t.Fatalf("ReplayDeadLetters failed: %v", err)
+	}
+	if result.Indexed != 1 || result.Failed != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	idx, _ := srv.Index("books")
+	if _, ok := idx.Documents["2"]; !ok {
+		t.Errorf("expected document 2 to be replayed, got %v", idx.Documents)
+	}
+	if letters := readDeadLetters(t, replayed.Bytes()); len(letters) != 1 || letters[0].ErrorType != "marshal_error" {
+		t.Errorf("expected the document-less record to be dead-lettered again, got %+v", letters)
+	}
+}
+
+func TestDeadLettersForFailedRequest(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	var out bytes.Buffer
+	client.WithIDStrategy(FieldID("isbn")).WithDeadLetterSink(NewNDJSONDeadLetterSink(&out))
+
+	// リクエスト全体が失敗した場合も、そのリクエストのドキュメントはすべてDeadLetterSinkに残ります。
+	srv.Inject(esfake.Fault{Path: "/*/_bulk", Status: http.StatusInternalServerError, Times: 1})
+	docs := []map[string]interface{}{
+		{"isbn": "1", "title": "a"},
+		{"isbn": "2", "title": "b"},
+	}
+	result, err := client.BulkInsert(context.Background(), "books", docs)
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Failed != 2 || len(result.Failures) != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	letters := readDeadLetters(t, out.Bytes())
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+	for _, letter := range letters {
+		if letter.Index != "books" || letter.Action != "index" || letter.DocumentID == "" || letter.Status != http.StatusInternalServerError || letter.Document == nil {
+			t.Errorf("unexpected dead letter: %+v", letter)
+		}
+	}
+
+	result, err = client.ReplayDeadLetters(context.Background(), bytes.NewReader(out.Bytes()), 1)
+	if err != nil {
+		t.Fatalf("ReplayDeadLetters failed: %v", err)
+	}
+	if idx, _ := srv.Index("books"); result.Indexed != 2 || len(idx.Documents) != 2 {
+		t.Errorf("expected both documents to be replayed, got %+v", result)
+	}
+}
@@ -0,0 +1,36 @@
+package concurrentinsert
+
+import (
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// Encoder はBulk APIに送るドキュメントをJSONに変換します。
+type Encoder = esbulk.Encoder
+
+// JSONEncoder は標準ライブラリのencoding/jsonで変換するEncoderです。json.Marshalと同じJSONになります。
+type JSONEncoder = esbulk.JSONEncoder
+
+// RawEncoder はエンコード済みのドキュメント([]byte、json.RawMessage、string)をコピーせずにそのまま送るEncoderです。
+type RawEncoder = esbulk.RawEncoder
+
+// JSONAppender はリフレクションを使わずに、自身のJSONをdstに追記できる型です。
+type JSONAppender = esbulk.JSONAppender
+
+// CodecEncoder はJSONAppenderを実装したドキュメントを、リフレクションを使わずに変換するEncoderです。
+type CodecEncoder = esbulk.CodecEncoder
+
+// WithEncoder はBulk APIの各メソッドでドキュメントを変換するEncoderを設定します。既定値はJSONEncoderです。
+func (c *Client) WithEncoder(enc Encoder) *Client {
+	c.encoder = enc
+	return c
+}
+
+// AppendJSONString はsをJSONの文字列としてdstに追記します。JSONAppenderの実装で使用します。
+func AppendJSONString(dst []byte, s string) []byte {
+	return esbulk.AppendJSONString(dst, s)
+}
+
+// newEncodeArena はClientのEncoderで変換するEncodeArenaを作成します。
+func (c *Client) newEncodeArena() *esbulk.EncodeArena {
+	return esbulk.NewEncodeArena(c.encoder)
+}
@@ -0,0 +1,118 @@
+package concurrentinsert
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/docgen"
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+// movie はコード生成した型を想定した、JSONAppenderを実装するTMDBのドキュメントです。
+type movie struct {
+	ID          string
+	Title       string
+	Overview    string
+	Genres      []string
+	ReleaseDate string
+	VoteAverage float64
+	VoteCount   int64
+}
+
+func newMovie(doc map[string]interface{}) *movie {
+	return &movie{
+		ID:          doc["id"].(string),
+		Title:       doc["title"].(string),
+		Overview:    doc["overview"].(string),
+		Genres:      doc["genres"].([]string),
+		ReleaseDate: doc["release_date"].(string),
+		VoteAverage: doc["vote_average"].(float64),
+		VoteCount:   doc["vote_count"].(int64),
+	}
+}
+
+func (m *movie) AppendJSON(dst []byte) ([]byte, error) {
+	dst = append(dst, `{"id":`...)
+	dst = AppendJSONString(dst, m.ID)
+	dst = append(dst, `,"title":`...)
+	dst = AppendJSONString(dst, m.Title)
+	dst = append(dst, `,"overview":`...)
+	dst = AppendJSONString(dst, m.Overview)
+	dst = append(dst, `,"genres":[`...)
+	for i, genre := range m.Genres {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = AppendJSONString(dst, genre)
+	}
+	dst = append(dst, `],"release_date":`...)
+	dst = AppendJSONString(dst, m.ReleaseDate)
+	dst = append(dst, `,"vote_average":`...)
+	dst = strconv.AppendFloat(dst, m.VoteAverage, 'f', -1, 64)
+	dst = append(dst, `,"vote_count":`...)
+	dst = strconv.AppendInt(dst, m.VoteCount, 10)
+	return append(dst, '}'), nil
+}
+
+// movieDocs はTMDBの形のドキュメントを、movieが持つフィールドだけに絞って返します。
+func movieDocs(n int) []map[string]interface{} {
+	docs := docgen.Generate(docgen.TMDB(), 1, n)
+	for i, doc := range docs {
+		m := newMovie(doc)
+		docs[i] = map[string]interface{}{
+			"id": m.ID, "title": m.Title, "overview": m.Overview, "genres": m.Genres,
+			"release_date": m.ReleaseDate, "vote_average": m.VoteAverage, "vote_count": m.VoteCount,
+		}
+	}
+	return docs
+}
+
+func decode(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+	return v
+}
+
+func TestBulkInsertValues(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	docs := movieDocs(100)
+	client.WithIDStrategy(FieldID("id"))
+
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs[:50] {
+		values[i] = newMovie(doc)
+	}
+	client.WithEncoder(CodecEncoder{})
+	if result, err := client.BulkInsertValues(context.Background(), "movies", values[:50], 2); err != nil || result.Indexed != 50 {
+		t.Fatalf("BulkInsertValues with CodecEncoder failed: %+v, %v", result, err)
+	}
+
+	for i, doc := range docs[50:] {
+		values[i], _ = json.Marshal(doc)
+	}
+	client.WithEncoder(RawEncoder{})
+	if result, err := client.BulkInsertValues(context.Background(), "movies", values[:50], 2); err != nil || result.Indexed != 50 {
+		t.Fatalf("BulkInsertValues with RawEncoder failed: %+v, %v", result, err)
+	}
+
+	idx, _ := srv.Index("movies")
+	if len(idx.Documents) != 100 {
+		t.Fatalf("expected 100 documents, got %d", len(idx.Documents))
+	}
+	for _, doc := range docs {
+		want, _ := json.Marshal(doc)
+		if !reflect.DeepEqual(decode(t, idx.Documents[doc["id"].(string)]), decode(t, want)) {
+			t.Errorf("document %s: got %s, want %s", doc["id"], idx.Documents[doc["id"].(string)], want)
+		}
+	}
+}
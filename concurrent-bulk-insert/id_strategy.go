@@ -0,0 +1,209 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDStrategy はドキュメントのJSONからドキュメントIDを決定します。
+// 同じドキュメントに同じIDを返すStrategyを使うと、投入をやり直しても重複せずに上書きされます。
+type IDStrategy func(source json.RawMessage) (string, error)
+
+// WithIDStrategy は各メソッドで使用するIDの決め方を設定します。
+// 設定しない場合、IDはElasticsearchが自動で採番するため、投入をやり直すとドキュメントが重複します。
+func (c *Client) WithIDStrategy(strategy IDStrategy) *Client {
+	c.idStrategy = strategy
+	return c
+}
+
+// documentID はドキュメントのIDを返します。IDStrategyがない場合は空文字を返し、Elasticsearchに採番を任せます。
+func (c *Client) documentID(source json.RawMessage) (string, error) {
+	if c.idStrategy == nil {
+		return "", nil
+	}
+	id, err := c.idStrategy(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine document ID: %w", err)
+	}
+	if id == "" {
+		return "", fmt.Errorf("ID strategy returned an empty ID")
+	}
+	return id, nil
+}
+
+// FieldID はfieldの値をIDにします。"author.id"のようにドットで区切るとネストしたオブジェクトを参照します。
+// 値は文字列、数値、真偽値のいずれかである必要があります。
+func FieldID(field string) IDStrategy {
+	return func(source json.RawMessage) (string, error) {
+		doc, err := decodeCanonical(source)
+		if err != nil {
+			return "", err
+		}
+		return fieldValue(doc, field)
+	}
+}
+
+// CompositeID は複数のフィールドの値をseparatorで連結してIDにします。
+// 値にseparatorが含まれると別のドキュメントと同じIDになり得るため、値に現れない文字を選びます。
+func CompositeID(separator string, fields ...string) IDStrategy {
+	return func(source json.RawMessage) (string, error) {
+		if len(fields) == 0 {
+			return "", fmt.Errorf("composite ID requires at least one field")
+		}
+		doc, err := decodeCanonical(source)
+		if err != nil {
+			return "", err
+		}
+		values := make([]string, len(fields))
+		for i, field := range fields {
+			if values[i], err = fieldValue(doc, field); err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(values, separator), nil
+	}
+}
+
+// ContentHashID はドキュメントの正規化したJSONのSHA-256を16進数にしてIDにします。
+// キーの順序や空白が違っても内容が同じなら同じIDになり、内容が変わると別のIDになります。
+// 数値は書かれた表記のまま扱うため、1と1.0は別の内容とみなされます。
+func ContentHashID() IDStrategy {
+	return func(source json.RawMessage) (string, error) {
+		doc, err := decodeCanonical(source)
+		if err != nil {
+			return "", err
+		}
+		canonical, err := json.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to canonicalize document: %w", err)
+		}
+		sum := sha256.Sum256(canonical)
+		return hex.EncodeToString(sum[:]), nil
+	}
+}
+
+// ULID は時刻順に並ぶ一意なID(ULID)を新しく採番します。
+// 同じミリ秒内に採番した場合も単調増加します。内容から決まるIDではないため、投入をやり直すと別のIDになります。
+func ULID() IDStrategy {
+	g := &ulidGenerator{now: time.Now, entropy: rand.Reader}
+	return func(json.RawMessage) (string, error) {
+		return g.next()
+	}
+}
+
+// decodeCanonical はJSONを数値の表記を保ったまま読み込みます。
+// json.Marshalはmapのキーを整列するため、読み込んだ値を書き戻すと正規化したJSONになります。
+func decodeCanonical(source json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(source))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return doc, nil
+}
+
+// lookupField はドットで区切ったfieldの値を返します。
+func lookupField(doc interface{}, field string) (interface{}, error) {
+	value := doc
+	for _, key := range strings.Split(field, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s not found", field)
+		}
+		if value, ok = obj[key]; !ok {
+			return nil, fmt.Errorf("field %s not found", field)
+		}
+	}
+	return value, nil
+}
+
+func fieldValue(doc interface{}, field string) (string, error) {
+	value, err := lookupField(doc, field)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", fmt.Errorf("field %s is empty", field)
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("field %s must be a string, number or boolean", field)
+}
+
+// crockford はULIDで使用するCrockfordのBase32の文字です。
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidGenerator はミリ秒単位の時刻48ビットと乱数80ビットからULIDを作成します。
+// 同じミリ秒内では前回の乱数部に1を足して、単調増加を保ちます。
+type ulidGenerator struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	entropy  io.Reader
+	lastMS   uint64
+	lastRand [10]byte
+}
+
+func (g *ulidGenerator) next() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	if ms >= 1<<48 {
+		return "", fmt.Errorf("ULID timestamp overflow")
+	}
+	if ms == g.lastMS {
+		if !increment(g.lastRand[:]) {
+			return "", fmt.Errorf("ULID entropy overflow within millisecond %d", ms)
+		}
+	} else {
+		if _, err := io.ReadFull(g.entropy, g.lastRand[:]); err != nil {
+			return "", fmt.Errorf("failed to read ULID entropy: %w", err)
+		}
+		g.lastMS = ms
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], ms<<16)
+	copy(id[6:], g.lastRand[:])
+	return encodeULID(id), nil
+}
+
+// increment はビッグエンディアンの整数bに1を足します。桁あふれした場合はfalseを返します。
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID は128ビットの値を上位から5ビットずつ、26文字のBase32にします。
+func encodeULID(id [16]byte) string {
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	var dst [26]byte
+	for i := len(dst) - 1; i >= 0; i-- {
+		dst[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(dst[:])
+}
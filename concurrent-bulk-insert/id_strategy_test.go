@@ -0,0 +1,72 @@
+package concurrentinsert
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestIDStrategies(t *testing.T) {
+	source := json.RawMessage(`{"isbn":"978-4","edition":2,"author":{"id":42}}`)
+	for _, tt := range []struct {
+		strategy IDStrategy
+		want     string
+	}{
+		{FieldID("isbn"), "978-4"},
+		{FieldID("author.id"), "42"},
+		{CompositeID(":", "isbn", "edition"), "978-4:2"},
+	} {
+		got, err := tt.strategy(source)
+		if err != nil || got != tt.want {
+			t.Errorf("got %q (%v), want %q", got, err, tt.want)
+		}
+	}
+
+	a, _ := ContentHashID()(json.RawMessage(`{"a":1,"b":{"c":true}}`))
+	b, _ := ContentHashID()(json.RawMessage(`{"b": {"c": true}, "a": 1}`))
+	if a == "" || a != b {
+		t.Errorf("expected the same hash regardless of key order, got %s and %s", a, b)
+	}
+}
+
+func TestBulkInsertConcurrentIsIdempotent(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIDStrategy(ContentHashID())
+
+	docs := generateDocs(100)
+	for run := 0; run < 2; run++ {
+		if _, err := client.BulkInsertConcurrentV3(context.Background(), "test", docs, 4); err != nil {
+			t.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
+		}
+	}
+	if idx, _ := srv.Index("test"); len(idx.Documents) != len(docs) {
+		t.Errorf("expected %d documents after two runs, got %d", len(docs), len(idx.Documents))
+	}
+}
+
+func TestBulkLoadWithFieldID(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIDStrategy(FieldID("isbn"))
+
+	input := "{\"isbn\": \"a-1\"}\n{\"isbn\": \"a-2\"}\n{\"title\": \"no isbn\"}\n"
+	result, err := client.BulkLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(input)), 1)
+	assertLoadError(t, err, 3, 3)
+	if result == nil || result.Indexed != 2 {
+		t.Errorf("expected the records before the missing field to be indexed, got %+v", result)
+	}
+	idx, _ := srv.Index("test")
+	if _, ok := idx.Documents["a-2"]; !ok {
+		t.Errorf("expected document a-2, got %v", idx.Documents)
+	}
+}
@@ -0,0 +1,263 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+)
+
+// IndexResolver はドキュメントのJSONから登録先のインデックスを決定します。
+// indexは各メソッドに渡したインデックスで、"events-2026.10.18"のような名前の接頭辞に使います。
+type IndexResolver func(index string, source json.RawMessage) (string, error)
+
+// WithIndexResolver は各メソッドでドキュメントごとに登録先のインデックスを決める方法を設定します。
+// 設定した場合、BulkResult.Indicesに登録先のインデックスごとの集計が入ります。
+func (c *Client) WithIndexResolver(resolver IndexResolver) *Client {
+	c.indexResolver = resolver
+	return c
+}
+
+// IndexTemplate は存在しないインデックスを作成するときの設定です。JSONの形はIndices Create APIのリクエストボディと同じです。
+type IndexTemplate struct {
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Mappings map[string]interface{} `json:"mappings,omitempty"`
+	Aliases  map[string]interface{} `json:"aliases,omitempty"`
+}
+
+// WithIndexTemplate は登録先のインデックスがない場合に、templateの設定で作成するようにします。
+// インデックスごとに最初のドキュメントを追加する前に1度だけ確認するため、日付ごとのインデックスも事前に作成しておく必要はありません。
+func (c *Client) WithIndexTemplate(template *IndexTemplate) *Client {
+	c.indexTemplate = template
+	c.ensuredIndices = &ensuredIndices{calls: make(map[string]*ensureCall)}
+	return c
+}
+
+// TimeIndex はfieldの日時をUTCでlayoutの形式にし、indexの後ろに"-"で連結したインデックスに振り分けます。
+// 日時はRFC 3339の文字列("2026-10-18T09:00:00Z")、日付("2026-10-18")、またはエポックミリ秒の数値で指定します。
+func TimeIndex(field, layout string) IndexResolver {
+	return func(index string, source json.RawMessage) (string, error) {
+		doc, err := decodeCanonical(source)
+		if err != nil {
+			return "", err
+		}
+		value, err := lookupField(doc, field)
+		if err != nil {
+			return "", err
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", field, err)
+		}
+		return index + "-" + t.UTC().Format(layout), nil
+	}
+}
+
+// DailyIndex はfieldの日付ごとに"index-2026.10.18"の形のインデックスに振り分けます。
+func DailyIndex(field string) IndexResolver {
+	return TimeIndex(field, "2006.01.02")
+}
+
+// MonthlyIndex はfieldの月ごとに"index-2026.10"の形のインデックスに振り分けます。
+func MonthlyIndex(field string) IndexResolver {
+	return TimeIndex(field, "2006.01")
+}
+
+// FieldIndex はfieldの値を小文字にし、indexの後ろに"-"で連結したインデックスに振り分けます。テナントごとのインデックスなどに使います。
+// 値は文字列、数値、真偽値のいずれかである必要があります。
+func FieldIndex(field string) IndexResolver {
+	return func(index string, source json.RawMessage) (string, error) {
+		doc, err := decodeCanonical(source)
+		if err != nil {
+			return "", err
+		}
+		value, err := fieldValue(doc, field)
+		if err != nil {
+			return "", err
+		}
+		return index + "-" + strings.ToLower(value), nil
+	}
+}
+
+// parseTime は日時のフィールドの値を読み込みます。
+func parseTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("unsupported time format %q", v)
+	case json.Number:
+		ms, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("epoch milliseconds must be an integer: %s", v)
+		}
+		return time.UnixMilli(ms), nil
+	}
+	return time.Time{}, fmt.Errorf("must be a string or epoch milliseconds")
+}
+
+// validateIndexName はElasticsearchがインデックス名として受け付けない名前をエラーにします。
+func validateIndexName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("invalid index name %q", name)
+	case len(name) > 255:
+		return fmt.Errorf("index name %q is longer than 255 bytes", name)
+	case strings.ToLower(name) != name:
+		return fmt.Errorf("index name %q must be lowercase", name)
+	case strings.ContainsAny(name[:1], "-_+"):
+		return fmt.Errorf("index name %q must not start with '-', '_' or '+'", name)
+	case strings.ContainsAny(name, `\/*?"<>| ,#:`):
+		return fmt.Errorf("index name %q must not contain \\, /, *, ?, \", <, >, |, space, comma, # or :", name)
+	}
+	return nil
+}
+
+// resolveIndex はsourceの登録先のインデックスを返し、IndexTemplateを設定している場合は存在しないインデックスを作成します。
+// WithDataStreamを設定している場合、データストリームはElasticsearchがインデックステンプレートから作成するため作成しません。
+// 登録先がindexと同じ場合は、BulkIndexerの既定のインデックスを使うため空文字を返します。
+func (c *Client) resolveIndex(ctx context.Context, index string, source json.RawMessage) (string, error) {
+	target := index
+	if c.indexResolver != nil {
+		var err error
+		if target, err = c.indexResolver(index, source); err != nil {
+			return "", fmt.Errorf("failed to resolve index: %w", err)
+		}
+		if err := validateIndexName(target); err != nil {
+			return "", fmt.Errorf("failed to resolve index: %w", err)
+		}
+	}
+	if c.indexTemplate != nil && c.dataStream == nil {
+		if err := c.ensuredIndices.ensure(ctx, target, func() error { return c.createIndex(ctx, target) }); err != nil {
+			return "", err
+		}
+	}
+	if target == index {
+		return "", nil
+	}
+	return target, nil
+}
+
+// createIndex はindexが存在しない(存在確認が404を返した)場合に、IndexTemplateの設定で作成します。
+// 他のプロセスが先に作成した場合(resource_already_exists_exception)も成功とみなします。
+func (c *Client) createIndex(ctx context.Context, index string) error {
+	res, err := c.baseClient.Indices.Exists([]string{index}, c.baseClient.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to check if index %s exists: %w", index, err)
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		// 権限の不足やクラスタの障害で確認できなかった場合は、作成を試みずにエラーにします。
+		return fmt.Errorf("failed to check if index %s exists: [%d] %s", index, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+
+	body, err := json.Marshal(c.indexTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index template: %w", err)
+	}
+	res, err = c.baseClient.Indices.Create(index,
+		c.baseClient.Indices.Create.WithBody(bytes.NewReader(body)),
+		c.baseClient.Indices.Create.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if !res.IsError() {
+		return nil
+	}
+	var e struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(res.Body).Decode(&e) == nil && e.Error.Type == "resource_already_exists_exception" {
+		return nil
+	}
+	return fmt.Errorf("failed to create index %s: [%d] %s: %s", index, res.StatusCode, e.Error.Type, e.Error.Reason)
+}
+
+// ensuredIndices は作成を確認したインデックスを記録します。
+// 同じインデックスに複数のgoroutineから同時に追加しても、作成のリクエストは1度だけ送ります。
+type ensuredIndices struct {
+	mu    sync.Mutex
+	calls map[string]*ensureCall
+}
+
+type ensureCall struct {
+	done chan struct{}
+	err  error
+}
+
+// ensure はindexについて初めて呼ばれたときにcreateを呼び出し、その結果を返します。
+// 失敗した場合は記録せず、次に呼ばれたときに再び作成します。
+func (e *ensuredIndices) ensure(ctx context.Context, index string, create func() error) error {
+	e.mu.Lock()
+	call, ok := e.calls[index]
+	if !ok {
+		call = &ensureCall{done: make(chan struct{})}
+		e.calls[index] = call
+		e.mu.Unlock()
+
+		call.err = create()
+		if call.err != nil {
+			e.mu.Lock()
+			delete(e.calls, index)
+			e.mu.Unlock()
+		}
+		close(call.done)
+		return call.err
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IndexStats は登録先のインデックスごとの集計です。各項目はBulkResultの同じ名前の項目と同じく数えます。
+type IndexStats struct {
+	Indexed uint64
+	Created uint64
+	Updated uint64
+	Failed  uint64
+}
+
+// countByIndex は登録に成功したアイテムを、登録先のインデックスごとにcollectorで集計するようにコールバックを包みます。
+// 失敗は確定したときにcollectorが集計します。再送で2重に包まないよう、元のコールバックに戻してから呼び出します。
+func (w *bulkWriter) countByIndex(item esutil.BulkIndexerItem) esutil.BulkIndexerItem {
+	index := esbulk.ItemIndex(item, w.cfg.Index)
+	onSuccess, onFailure := item.OnSuccess, item.OnFailure
+	item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+		item.OnSuccess, item.OnFailure = onSuccess, onFailure
+		w.collector.addSucceeded(index, item.Action)
+		if onSuccess != nil {
+			onSuccess(ctx, item, res)
+		}
+	}
+	item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		item.OnSuccess, item.OnFailure = onSuccess, onFailure
+		if onFailure != nil {
+			onFailure(ctx, item, res, err)
+		}
+	}
+	return item
+}
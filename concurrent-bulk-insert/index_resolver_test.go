@@ -0,0 +1,159 @@
+package concurrentinsert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestIndexResolvers(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver IndexResolver
+		source   string
+		want     string
+	}{
+		{"daily RFC 3339", DailyIndex("@timestamp"), `{"@timestamp": "2026-10-18T23:30:00-02:00"}`, "events-2026.10.19"},
+		{"daily date", DailyIndex("@timestamp"), `{"@timestamp": "2026-10-18"}`, "events-2026.10.18"},
+		{"monthly epoch millis", MonthlyIndex("event.created"), `{"event": {"created": 1792281600000}}`, "events-2026.10"},
+		{"field", FieldIndex("tenant"), `{"tenant": "ACME"}`, "events-acme"},
+		{"numeric field", FieldIndex("tenant"), `{"tenant": 42}`, "events-42"},
+	}
+	for _, tt := range tests {
+		got, err := tt.resolver("events", json.RawMessage(tt.source))
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	client := &Client{}
+	for _, tt := range []struct {
+		resolver IndexResolver
+		source   string
+	}{
+		{DailyIndex("@timestamp"), `{"title": "no timestamp"}`},
+		{DailyIndex("@timestamp"), `{"@timestamp": "yesterday"}`},
+		{DailyIndex("@timestamp"), `{"@timestamp": 1.5}`},
+		{FieldIndex("tenant"), `{"tenant": "a b"}`},
+		{func(string, json.RawMessage) (string, error) { return "Events", nil }, `{}`},
+	} {
+		client.WithIndexResolver(tt.resolver)
+		if index, err := client.resolveIndex(context.Background(), "events", json.RawMessage(tt.source)); err == nil {
+			t.Errorf("expected an error for %s, got %q", tt.source, index)
+		}
+	}
+}
+
+func TestBulkInsertWithIndexResolver(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIndexResolver(DailyIndex("@timestamp")).WithIndexTemplate(&IndexTemplate{
+		Settings: map[string]interface{}{"number_of_shards": 2},
+		Mappings: map[string]interface{}{"properties": map[string]interface{}{"@timestamp": map[string]interface{}{"type": "date"}}},
+	})
+	// 既存のインデックスは作成し直さず、そのまま使います。
+	if res, err := client.baseClient.Indices.Create("events-2026.10.17"); err != nil || res.IsError() {
+		t.Fatalf("failed to create index: %v, %v", res, err)
+	}
+
+	docs := make([]map[string]interface{}, 0, 31)
+	for i := 0; i < 30; i++ {
+		docs = append(docs, map[string]interface{}{
+			"title":      fmt.Sprintf("Event %d", i+1),
+			"@timestamp": fmt.Sprintf("2026-10-%02dT12:00:00Z", 16+i%3),
+		})
+	}
+	docs = append(docs, map[string]interface{}{"title": "no timestamp"})
+
+	result, err := client.BulkInsertConcurrentV3(context.Background(), "events", docs, 4)
+	if err != nil {
+		t.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
+	}
+	if result.Indexed != 30 || result.Failed != 1 || result.Failures[0].ErrorType != "index_resolver_error" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	want := map[string]IndexStats{
+		"events-2026.10.16": {Indexed: 10},
+		"events-2026.10.17": {Indexed: 10},
+		"events-2026.10.18": {Indexed: 10},
+		"events":            {Failed: 1},
+	}
+	for index, stats := range want {
+		if result.Indices[index] != stats {
+			t.Errorf("%s: got %+v, want %+v", index, result.Indices[index], stats)
+		}
+	}
+	// インデックスごとの集計は、全体の集計と同じ定義で数えます。
+	var total IndexStats
+	for _, stats := range result.Indices {
+		total.Indexed += stats.Indexed
+		total.Created += stats.Created
+		total.Updated += stats.Updated
+		total.Failed += stats.Failed
+	}
+	if total != (IndexStats{Indexed: result.Indexed, Created: result.Created, Updated: result.Updated, Failed: result.Failed}) {
+		t.Errorf("expected the index stats to add up to %+v, got %+v", result, total)
+	}
+
+	for _, name := range []string{"events-2026.10.16", "events-2026.10.18"} {
+		idx, ok := srv.Index(name)
+		if !ok || idx.Settings["index.number_of_shards"] != "2" || len(idx.Documents) != 10 {
+			t.Errorf("expected %s to be created from the template with 10 documents, got %+v", name, idx)
+		}
+	}
+	if idx, _ := srv.Index("events-2026.10.17"); idx.Settings["index.number_of_shards"] != "1" {
+		t.Errorf("expected the existing index to be kept, got %+v", idx.Settings)
+	}
+	if _, ok := srv.Index("events"); ok {
+		t.Error("expected no document to be written to the base index")
+	}
+
+	// インデックスごとに存在の確認は1度だけ行い、作成するのはないインデックスのみです。
+	creates, checks := 0, 0
+	for _, req := range srv.Requests() {
+		if strings.HasPrefix(req.Path, "/events-") && !strings.Contains(req.Path, "/_") {
+			switch req.Method {
+			case http.MethodPut:
+				creates++
+			case http.MethodHead:
+				checks++
+			}
+		}
+	}
+	if creates != 3 || checks != 3 {
+		t.Errorf("expected 3 existence checks and 3 creations including the setup, got %d and %d", checks, creates)
+	}
+}
+
+func TestCreateIndexOnlyWhenMissing(t *testing.T) {
+	srv := esfake.New(t)
+	// 存在を確認できない場合は、ないものとみなして作成しません。
+	srv.Inject(esfake.Fault{Method: http.MethodHead, Path: "/events-*", Status: http.StatusForbidden, ErrorType: "security_exception"})
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIndexResolver(DailyIndex("@timestamp")).WithIndexTemplate(&IndexTemplate{})
+
+	docs := []map[string]interface{}{{"title": "Event", "@timestamp": "2026-10-18T12:00:00Z"}}
+	result, err := client.BulkInsertConcurrentV3(context.Background(), "events", docs, 1)
+	if err != nil {
+		t.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
+	}
+	if result.Failed != 1 || result.Failures[0].ErrorType != "index_resolver_error" || !strings.Contains(result.Failures[0].Reason, "[403]") {
+		t.Errorf("expected the existence check to fail the document, got %+v", result)
+	}
+	for _, req := range srv.Requests() {
+		if req.Method == http.MethodPut {
+			t.Errorf("expected no index to be created, got %s %s", req.Method, req.Path)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Document はIngestに投入する1件のドキュメントです。
+type Document struct {
+	// ID はドキュメントのIDです。空の場合はWithIDStrategyの設定に従います。
+	ID string
+	// Source はドキュメントのJSONです。
+	Source json.RawMessage
+	// Pipeline とRouting は空の場合、Clientの既定値を使用します。
+	Pipeline string
+	Routing  string
+	// Offset は上流のソース(Kafkaのオフセットなど)での位置です。ItemResultにそのまま返されるため、確定した位置のコミットに使えます。
+	Offset int64
+}
+
+// ItemResult はIngestに投入したドキュメント1件の結果です。
+type ItemResult struct {
+	Document Document
+	// DocumentID は登録したドキュメントのIDです。自動採番した場合はレスポンスに含まれるIDです。
+	DocumentID string
+	// Result はcreatedやupdatedなどの登録の結果です。
+	Result string
+	// Failure は登録に失敗した場合の内容です。成功した場合はnilです。
+	Failure *BulkItemFailure
+}
+
+// IngestConfig はIngestの設定です。
+type IngestConfig struct {
+	// NumWorkers とFlushBytes、FlushInterval は共有するBulkIndexerの設定です。0以下の場合はesutilの既定値を使用します。
+	NumWorkers    int
+	FlushBytes    int
+	FlushInterval time.Duration
+	// MaxInFlight は結果を返していないドキュメント数の上限です。上限に達するとdocsから読み出さず、送信側を待たせます。
+	// 既定値は10000です。
+	MaxInFlight int
+}
+
+// Ingest はdocsから読み出したドキュメントを1つの共有BulkIndexerで登録し、ドキュメントごとの結果を返すチャネルを返します。
+// 送信側は自身のgoroutineからdocsに送り、投入を終えたらdocsを閉じます。
+// 結果はドキュメントごとにちょうど1つ、登録が終わった順に返るため、投入した順とは限りません。
+// 上流のオフセットをコミットする場合は、連続して結果が返った位置までにします。
+// 結果のチャネルは、docsが閉じられるかctxが取り消され、読み出し済みのすべてのドキュメントの結果を返した後に閉じられます。
+// ctxが取り消された場合、docsに残っているドキュメントは読み出さないため、結果も返りません。
+// 呼び出し元は結果のチャネルを最後まで読み出す必要があります。読み出さない間は登録も止まります。
+// 再送は行わないため、リトライ可能な失敗(BulkItemFailure.Retryable)はオフセットをコミットせずに送り直してください。
+func (c *Client) Ingest(ctx context.Context, index string, cfg IngestConfig, docs <-chan Document) <-chan ItemResult {
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 10000
+	}
+	results := make(chan ItemResult, cfg.MaxInFlight)
+	in := &ingestion{
+		results: results,
+		slots:   make(chan struct{}, cfg.MaxInFlight),
+		pending: make(map[uint64]Document),
+	}
+	collector := c.newCollector(index)
+	writer := c.newBulkWriter(esutil.BulkIndexerConfig{
+		Client:        c.baseClient,
+		Index:         index,
+		NumWorkers:    workerCount(cfg.NumWorkers),
+		FlushBytes:    cfg.FlushBytes,
+		FlushInterval: cfg.FlushInterval,
+		OnError:       in.onError,
+	}, collector)
+
+	go func() {
+		defer close(results)
+		for seq := uint64(0); ; seq++ {
+			doc, ok := in.next(ctx, docs)
+			if !ok {
+				break
+			}
+			in.track(seq, doc)
+			c.ingest(ctx, writer, in, seq, doc)
+		}
+		// 取り消された場合も、追加済みのドキュメントの結果を返すため、BulkIndexerが送信を終えるまで待ちます。
+		_ = writer.close(context.WithoutCancel(ctx))
+		in.finish()
+	}()
+	return results
+}
+
+// ingest は1件のドキュメントをBulkIndexerに追加します。追加できなかった場合はすぐに結果を返します。
+func (c *Client) ingest(ctx context.Context, writer *bulkWriter, in *ingestion, seq uint64, doc Document) {
+	id := doc.ID
+	if id == "" {
+		var err error
+		if id, err = c.documentID(doc.Source); err != nil {
+			in.ack(seq, ItemResult{Failure: &BulkItemFailure{ErrorType: "document_id_error", Reason: err.Error()}})
+			return
+		}
+	}
+	target, err := c.resolveIndex(ctx, writer.cfg.Index, doc.Source)
+	if err != nil {
+		in.ack(seq, ItemResult{DocumentID: id, Failure: &BulkItemFailure{DocumentID: id, ErrorType: "index_resolver_error", Reason: err.Error()}})
+		return
+	}
+	item := esutil.BulkIndexerItem{
+		Index:      target,
+		Action:     "index",
+		DocumentID: id,
+		Routing:    doc.Routing,
+		Body:       bytes.NewReader(doc.Source),
+		OnSuccess: func(_ context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			in.ack(seq, ItemResult{DocumentID: res.DocumentID, Result: res.Result})
+		},
+		OnFailure: func(_ context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+			failure := newBulkItemFailure(item, res, err)
+			in.ack(seq, ItemResult{DocumentID: failure.DocumentID, Failure: &failure})
+		},
+	}
+	if err := writer.add(ctx, doc.Pipeline, item); err != nil {
+		in.ack(seq, ItemResult{DocumentID: id, Failure: &BulkItemFailure{DocumentID: id, ErrorType: "bulk_indexer_error", Reason: err.Error()}})
+	}
+}
+
+// ingestion は1回のIngestで結果を返していないドキュメントを管理します。
+type ingestion struct {
+	results chan<- ItemResult
+	// slots は結果を返していないドキュメント数をMaxInFlightまでに制限するセマフォです。
+	slots chan struct{}
+
+	mu         sync.Mutex
+	pending    map[uint64]Document
+	flushError error
+}
+
+// next は空きができるのを待ってから、次のドキュメントを読み出します。
+// docsが閉じられるかctxが取り消された場合はfalseを返します。
+func (in *ingestion) next(ctx context.Context, docs <-chan Document) (Document, bool) {
+	select {
+	case in.slots <- struct{}{}:
+	case <-ctx.Done():
+		return Document{}, false
+	}
+	select {
+	case doc, ok := <-docs:
+		if ok {
+			return doc, true
+		}
+	case <-ctx.Done():
+	}
+	<-in.slots
+	return Document{}, false
+}
+
+// track はseqのドキュメントを、結果を返していないドキュメントとして記録します。
+func (in *ingestion) track(seq uint64, doc Document) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.pending[seq] = doc
+}
+
+// ack はseqのドキュメントの結果を返し、空きを1つ増やします。
+func (in *ingestion) ack(seq uint64, result ItemResult) {
+	in.mu.Lock()
+	doc, ok := in.pending[seq]
+	delete(in.pending, seq)
+	in.mu.Unlock()
+	if !ok {
+		return
+	}
+	result.Document = doc
+	if result.Failure != nil && result.Failure.DocumentID == "" {
+		result.Failure.DocumentID = doc.ID
+	}
+	in.results <- result
+	<-in.slots
+}
+
+func (in *ingestion) onError(_ context.Context, err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.flushError = errors.Join(in.flushError, err)
+}
+
+// finish はBulkIndexerを閉じた後も結果が返っていないドキュメントを、失敗として返します。
+// レスポンスを読み取れなかったフラッシュのアイテムには、BulkIndexerがコールバックを呼ばないためです。
+func (in *ingestion) finish() {
+	in.mu.Lock()
+	reason := "no response for the document"
+	if in.flushError != nil {
+		reason = in.flushError.Error()
+	}
+	seqs := make([]uint64, 0, len(in.pending))
+	for seq := range in.pending {
+		seqs = append(seqs, seq)
+	}
+	in.mu.Unlock()
+	for _, seq := range seqs {
+		in.ack(seq, ItemResult{Failure: &BulkItemFailure{ErrorType: "flush_error", Reason: reason}})
+	}
+}
@@ -0,0 +1,115 @@
+package concurrentinsert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+// produce はn件のドキュメントをdocsに送り、送った件数をsentに加えます。
+func produce(n int, sent *atomic.Int64) <-chan Document {
+	docs := make(chan Document)
+	go func() {
+		defer close(docs)
+		for i := 0; i < n; i++ {
+			source, _ := json.Marshal(map[string]interface{}{"title": fmt.Sprintf("Test Document %d", i+1)})
+			docs <- Document{ID: fmt.Sprint(i + 1), Source: source, Offset: int64(i)}
+			if sent != nil {
+				sent.Add(1)
+			}
+		}
+	}()
+	return docs
+}
+
+func TestIngest(t *testing.T) {
+	srv := esfake.New(t)
+	// 1回のリクエスト全体が失敗しても、そのアイテムごとに結果が返ります。
+	srv.Inject(esfake.Fault{Path: "/*/_bulk", Times: 1, Status: http.StatusInternalServerError})
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	results := client.Ingest(context.Background(), "test", IngestConfig{NumWorkers: 2, FlushBytes: 512, MaxInFlight: 20}, produce(100, nil))
+
+	offsets := map[int64]bool{}
+	failed := 0
+	for r := range results {
+		if offsets[r.Document.Offset] {
+			t.Errorf("got more than one result for offset %d", r.Document.Offset)
+		}
+		offsets[r.Document.Offset] = true
+		if r.Failure != nil {
+			failed++
+			if r.Failure.Status != http.StatusInternalServerError || r.Failure.DocumentID != r.Document.ID {
+				t.Errorf("unexpected failure: %+v", r.Failure)
+			}
+			continue
+		}
+		if r.DocumentID != r.Document.ID || r.Result != "created" {
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+	if len(offsets) != 100 {
+		t.Errorf("expected a result for each of the 100 documents, got %d", len(offsets))
+	}
+	if failed == 0 || failed == 100 {
+		t.Errorf("expected the documents of a single request to fail, got %d failures", failed)
+	}
+	if idx, _ := srv.Index("test"); len(idx.Documents) != 100-failed {
+		t.Errorf("expected %d documents, got %d", 100-failed, len(idx.Documents))
+	}
+}
+
+func TestIngestBackpressure(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var sent atomic.Int64
+	results := client.Ingest(context.Background(), "test", IngestConfig{FlushInterval: 10 * time.Millisecond, MaxInFlight: 5}, produce(100, &sent))
+
+	// 結果を読み出さない間は、返していない結果と投入中のドキュメントがそれぞれMaxInFlightに達した時点で送信側が止まります。
+	time.Sleep(200 * time.Millisecond)
+	if n := sent.Load(); n > 11 {
+		t.Errorf("expected the producer to block, sent %d documents", n)
+	}
+
+	count := 0
+	for range results {
+		count++
+	}
+	if count != 100 || sent.Load() != 100 {
+		t.Errorf("expected all 100 documents to be ingested, got %d results for %d documents", count, sent.Load())
+	}
+}
+
+func TestIngestCancel(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	docs := make(chan Document)
+	results := client.Ingest(ctx, "test", IngestConfig{}, docs)
+	docs <- Document{ID: "1", Source: json.RawMessage(`{"title":"Test Document 1"}`)}
+	cancel()
+
+	// 取り消す前に読み出したドキュメントの結果は返り、その後に結果のチャネルが閉じられます。
+	var got []ItemResult
+	for r := range results {
+		got = append(got, r)
+	}
+	if len(got) != 1 || got[0].Document.ID != "1" {
+		t.Errorf("unexpected results: %+v", got)
+	}
+}
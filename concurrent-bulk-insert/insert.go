@@ -1,161 +1,261 @@
-package main
+package concurrentinsert
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"strings"
+	"runtime"
 	"sync"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+	"go.opentelemetry.io/otel"
 )
 
 type Client struct {
-	baseClient *elasticsearch.Client
+	baseClient  *elasticsearch.Client
+	retryPolicy *RetryPolicy
+	idStrategy  IDStrategy
+	deadLetters DeadLetterSink
+	pipeline    string
+	routing     string
+	rateLimiter *RateLimiter
+	telemetry   *esbulk.Telemetry
+	failFast    bool
+	encoder     Encoder
+	// overwriteCheckpoint はCheckpointedLoadで既存のチェックポイントを上書きするかどうかです。
+	overwriteCheckpoint bool
+	// indexResolver とindexTemplate はドキュメントごとの登録先と、存在しないインデックスを作成するときの設定です。
+	indexResolver  IndexResolver
+	indexTemplate  *IndexTemplate
+	ensuredIndices *ensuredIndices
+	// dataStream は書き込み先をデータストリームとして扱う場合の設定です。
+	dataStream *dataStreamState
 }
 
-func NewClient() (*Client, error) {
-	es, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{"http://localhost:9200"},
-	})
+// NewClient は指定した設定でClientを作成します。
+// cfg.Addressesを省略した場合は環境変数ELASTICSEARCH_URL、それもなければhttp://localhost:9200に接続します。
+func NewClient(cfg elasticsearch.Config) (*Client, error) {
+	es, err := elasticsearch.NewClient(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create elasticsearch client: %w", err)
 	}
 	return &Client{
 		baseClient: es,
+		telemetry:  newTelemetry(otel.GetTracerProvider(), otel.GetMeterProvider()),
 	}, nil
 }
 
-func (c *Client) BulkInsert(ctx context.Context, index string, docs []map[string]interface{}) error {
+func (c *Client) BulkInsert(ctx context.Context, index string, docs []map[string]interface{}) (*BulkResult, error) {
+	collector := c.newCollector(index)
 	bulkCfg := esutil.BulkIndexerConfig{
-		Client: c.baseClient,
-		Index:  index,
+		Client:  c.baseClient,
+		Index:   index,
+		OnError: collector.onError,
 	}
 
-	indexer, err := esutil.NewBulkIndexer(bulkCfg)
-	if err != nil {
-		return fmt.Errorf("failed to create bulk indexer: %w", err)
-	}
+	writer := c.newBulkWriter(bulkCfg, collector)
+	arena := c.newEncodeArena()
 	for i, doc := range docs {
-		data, err := json.Marshal(doc)
+		data, body, err := arena.Encode(doc)
+		if err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("failed to marshal document %d: %w", i+1, err))
+		}
+		id, err := c.documentID(data)
+		if err != nil {
+			return nil, writer.discard(ctx, fmt.Errorf("document %d: %w", i+1, err))
+		}
+		target, err := c.resolveIndex(ctx, index, data)
 		if err != nil {
-			return fmt.Errorf("failed to marshal document %d: %w", i+1, err)
+			return nil, writer.discard(ctx, fmt.Errorf("document %d: %w", i+1, err))
 		}
-		err = indexer.Add(
+		err = writer.add(
 			ctx,
+			"",
 			esutil.BulkIndexerItem{
-				Action: "index",
-				Body:   strings.NewReader(string(data)),
+				Index:      target,
+				Action:     "index",
+				DocumentID: id,
+				Body:       body,
+				OnFailure:  collector.onFailure,
 			},
 		)
 		if err != nil {
-			return fmt.Errorf("failed to add document %d to bulk indexer: %w", i+1, err)
+			return nil, writer.discard(ctx, fmt.Errorf("failed to add document %d to bulk indexer: %w", i+1, err))
 		}
 	}
-	if err := indexer.Close(ctx); err != nil {
-		return fmt.Errorf("failed to close bulk indexer: %w", err)
+	if err := writer.close(ctx); err != nil {
+		return nil, err
 	}
-	return nil
+	// BulkIndexerを閉じるまではワーカーがBodyを読み出すため、プールに戻すのはその後です。
+	defer arena.Release()
+	if err := c.retryFailed(ctx, bulkCfg, collector); err != nil {
+		return nil, err
+	}
+	return collector.result()
 }
 
-func (c *Client) BulkInsertConcurrentWithTimeSleep(ctx context.Context, index string, docs []map[string]interface{}, chunkSize int, sleepSec int) {
+// BulkInsertConcurrentWithTimeSleep は、スリープが終わるまでに完了したチャンクの結果のみを返します。
+// 失敗したチャンクと、スリープが終わるまでに完了しなかったチャンクのエラーは、範囲とともにすべて返します。
+// 完了しなかったチャンクの送信は、返す時点で取り消します。
+func (c *Client) BulkInsertConcurrentWithTimeSleep(ctx context.Context, index string, docs []map[string]interface{}, chunkSize int, sleepSec int) (*BulkResult, error) {
+	var (
+		mu       sync.Mutex
+		result   BulkResult
+		finished = make(map[int]bool)
+	)
+	ctx, errs := c.newChunkErrors(ctx)
 	for i := 0; i < len(docs); i += chunkSize {
 		end := i + chunkSize
 		if end > len(docs) {
 			end = len(docs)
 		}
-		chunk := docs[i:end]
-		go func(chunk []map[string]interface{}) {
-			if err := c.BulkInsert(ctx, index, chunk); err != nil {
-				// log.Printf("failed to bulk insert: %v", err)
+		go func(start, end int) {
+			res, err := c.BulkInsert(ctx, index, docs[start:end])
+			mu.Lock()
+			defer mu.Unlock()
+			if finished == nil {
+				// スリープが終わった後に完了したチャンクは、結果に含めません。
+				return
+			}
+			if err != nil {
+				errs.add(start, end, err)
 			}
-		}(chunk)
+			result.add(res)
+			finished[start] = true
+		}(i, end)
 	}
 
-	// log.Printf("Waiting for all goroutines to finish...")
 	time.Sleep(time.Duration(sleepSec) * time.Second)
-	// log.Println("Done.")
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < len(docs); i += chunkSize {
+		if !finished[i] {
+			errs.add(i, min(i+chunkSize, len(docs)), fmt.Errorf("chunk did not finish within %d seconds", sleepSec))
+		}
+	}
+	finished = nil
+	return &result, errs.err(nil)
 }
 
-func (c *Client) BulkInsertConcurrent(ctx context.Context, index string, docs []map[string]interface{}, chunkSize int) error {
-	var wg sync.WaitGroup
-	errCh := make(chan error, 1)
+// BulkInsertConcurrent は、docsをchunkSize件ずつに分割し、チャンクごとにBulkInsertを並行して呼び出します。
+// チャンクごとにBulkIndexerを作成するため非効率です。失敗したチャンクのエラーは、範囲とともにすべて返します。
+func (c *Client) BulkInsertConcurrent(ctx context.Context, index string, docs []map[string]interface{}, chunkSize int) (*BulkResult, error) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result BulkResult
+	)
+	// すべてのチャンクのエラーを、ドキュメントの範囲とともに集めます。
+	ctx, errs := c.newChunkErrors(ctx)
 
 	for i := 0; i < len(docs); i += chunkSize {
 		end := i + chunkSize
 		if end > len(docs) {
 			end = len(docs)
 		}
-		chunk := docs[i:end]
 
 		wg.Add(1)
-		go func(chunk []map[string]interface{}) {
+		go func(start, end int) {
 			defer wg.Done()
-			if err := c.BulkInsert(ctx, index, chunk); err != nil {
-				select {
-				case errCh <- err:
-				default:
-				}
+			if err := ctx.Err(); err != nil {
+				// 取り消された場合は送信せず、範囲だけを記録します。
+				errs.add(start, end, err)
+				return
+			}
+			res, err := c.BulkInsert(ctx, index, docs[start:end])
+			if err != nil {
+				errs.add(start, end, err)
 			}
-		}(chunk)
+			mu.Lock()
+			result.add(res)
+			mu.Unlock()
+		}(i, end)
 	}
 
 	wg.Wait()
-	close(errCh)
+	return &result, errs.err(nil)
+}
 
-	if err := <-errCh; err != nil {
-		return fmt.Errorf("error occurred during concurrent bulk insert: %w", err)
+// workerCount は0以下のワーカー数を、esutil.BulkIndexerの既定値と同じCPU数に置き換えます。
+// 負の値をそのまま使うと、BulkIndexerやワーカーごとのバッファを作成するときにpanicするためです。
+func workerCount(numWorkers int) int {
+	if numWorkers <= 0 {
+		return runtime.NumCPU()
 	}
-
-	return nil
+	return numWorkers
 }
 
 // BulkInsertConcurrentV2 は、単一のBulkIndexerを複数のgoroutineで共有する、より効率的な並行処理です。
 // BulkIndexerが内部的に並行処理を行うため、このアプローチが推奨されます。
-func (c *Client) BulkInsertConcurrentV2(ctx context.Context, index string, docs []map[string]interface{}, numWorkers int) error {
+func (c *Client) BulkInsertConcurrentV2(ctx context.Context, index string, docs []map[string]interface{}, numWorkers int) (*BulkResult, error) {
+	numWorkers = workerCount(numWorkers)
 	// esutil.BulkIndexerは内部で並行処理をサポートしています。
 	// NumWorkersを設定すると、その数だけワーカーgoroutineが起動し、リクエストを並行して送信します。
-	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+	// 追加はaddCtxで行い、WithFailFastで取り消された後も追加済みのドキュメントはctxで送信します。
+	addCtx, errs := c.newChunkErrors(ctx)
+	collector := c.newCollector(index)
+	bulkCfg := esutil.BulkIndexerConfig{
 		Client:     c.baseClient,
 		Index:      index,
 		NumWorkers: numWorkers,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create bulk indexer: %w", err)
+		OnError: func(ctx context.Context, err error) {
+			if ctx.Err() != nil && err == ctx.Err() {
+				// 取り消し後のAddが報告するエラーは、ChunkErrorとして記録します。
+				return
+			}
+			collector.onError(ctx, err)
+			errs.fatal(err)
+		},
 	}
+	writer := c.newBulkWriter(bulkCfg, collector)
 
-	// ドキュメントをチャネルに投入
-	docCh := make(chan map[string]interface{})
+	// ドキュメントの添字をチャネルに投入
+	docCh := make(chan int)
 	go func() {
-		for _, doc := range docs {
-			docCh <- doc
+		defer close(docCh)
+		for i := range docs {
+			select {
+			case docCh <- i:
+			case <-addCtx.Done():
+				// 取り消された場合は、投入していないドキュメントの範囲を記録します。
+				errs.add(i, len(docs), addCtx.Err())
+				return
+			}
 		}
-		close(docCh)
 	}()
 
 	// 複数のgoroutineでBulkIndexerにドキュメントを追加
 	var wg sync.WaitGroup
+	arenas := make([]*esbulk.EncodeArena, numWorkers)
 	for i := 0; i < numWorkers; i++ {
+		arena := c.newEncodeArena()
+		arenas[i] = arena
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for doc := range docCh {
-				data, err := json.Marshal(doc)
+			for i := range docCh {
+				// 投入できなかったドキュメントは失敗として記録し、DeadLetterSinkに残します。
+				item := esutil.BulkIndexerItem{Action: "index", OnFailure: collector.onFailure}
+				data, body, err := arena.Encode(docs[i])
 				if err != nil {
-					// log.Printf("failed to marshal document: %v", err)
+					collector.addFailure(item, BulkItemFailure{ErrorType: "marshal_error", Reason: err.Error()}, "")
 					continue
 				}
-				err = bi.Add(
-					ctx,
-					esutil.BulkIndexerItem{
-						Action: "index",
-						Body:   strings.NewReader(string(data)),
-					},
-				)
-				if err != nil {
-					// log.Printf("failed to add document to bulk indexer: %v", err)
+				item.Body = body
+				if item.DocumentID, err = c.documentID(data); err != nil {
+					collector.addFailure(item, BulkItemFailure{ErrorType: "document_id_error", Reason: err.Error()}, "")
+					continue
+				}
+				if item.Index, err = c.resolveIndex(addCtx, index, data); err != nil {
+					collector.addFailure(item, BulkItemFailure{DocumentID: item.DocumentID, ErrorType: "index_resolver_error", Reason: err.Error()}, "")
+					continue
+				}
+				if err := writer.add(addCtx, "", item); err != nil {
+					collector.addFailure(item, BulkItemFailure{DocumentID: item.DocumentID, ErrorType: "bulk_indexer_error", Reason: err.Error()}, "")
+					errs.add(i, i+1, err)
 				}
 			}
 		}()
@@ -164,47 +264,92 @@ func (c *Client) BulkInsertConcurrentV2(ctx context.Context, index string, docs
 	wg.Wait()
 
 	// BulkIndexerを閉じて、すべてのドキュメントが処理されるのを待つ
-	if err := bi.Close(ctx); err != nil {
-		return fmt.Errorf("failed to close bulk indexer: %w", err)
+	if err := writer.close(ctx); err != nil {
+		return nil, err
+	}
+	for _, arena := range arenas {
+		defer arena.Release()
 	}
 
-	// stats := bi.Stats()
-	// log.Printf("V2: Indexed [%d] documents with [%d] workers", stats.NumIndexed, numWorkers)
-	return nil
+	if addCtx.Err() == nil {
+		if err := c.retryFailed(ctx, bulkCfg, collector); err != nil {
+			return nil, err
+		}
+	}
+	result, err := collector.result()
+	return result, errs.err(err)
 }
 
 // BulkInsertConcurrentV3 は、BulkIndexerの内部並行処理に完全に任せる最もシンプルな実装です。
 // クライアント側のオーバーヘッドが最小限になります。
-func (c *Client) BulkInsertConcurrentV3(ctx context.Context, index string, docs []map[string]interface{}, numWorkers int) error {
-	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+func (c *Client) BulkInsertConcurrentV3(ctx context.Context, index string, docs []map[string]interface{}, numWorkers int) (*BulkResult, error) {
+	return c.bulkInsertV3(ctx, index, len(docs), func(i int) interface{} { return docs[i] }, numWorkers)
+}
+
+// BulkInsertValues はBulkInsertConcurrentV3と同じく登録します。docsにはWithEncoderで設定したEncoderが変換できる値を渡します。
+// RawEncoderではエンコード済みの[]byte、CodecEncoderではJSONAppenderを実装した型を、リフレクションを使わずに送信できます。
+func (c *Client) BulkInsertValues(ctx context.Context, index string, docs []interface{}, numWorkers int) (*BulkResult, error) {
+	return c.bulkInsertV3(ctx, index, len(docs), func(i int) interface{} { return docs[i] }, numWorkers)
+}
+
+// bulkInsertV3 はBulkInsertConcurrentV3の本体です。doc(i)でi番目のドキュメントを取り出します。
+func (c *Client) bulkInsertV3(ctx context.Context, index string, n int, doc func(i int) interface{}, numWorkers int) (*BulkResult, error) {
+	addCtx, errs := c.newChunkErrors(ctx)
+	collector := c.newCollector(index)
+	bulkCfg := esutil.BulkIndexerConfig{
 		Client:     c.baseClient,
 		Index:      index,
-		NumWorkers: numWorkers,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create bulk indexer: %w", err)
+		NumWorkers: workerCount(numWorkers),
+		OnError: func(ctx context.Context, err error) {
+			if ctx.Err() != nil && err == ctx.Err() {
+				// 取り消し後のAddが報告するエラーは、ChunkErrorとして記録します。
+				return
+			}
+			collector.onError(ctx, err)
+			errs.fatal(err)
+		},
 	}
+	writer := c.newBulkWriter(bulkCfg, collector)
+	arena := c.newEncodeArena()
 
-	for _, doc := range docs {
-		data, err := json.Marshal(doc)
-		if err != nil {
-			return fmt.Errorf("failed to marshal document: %w", err)
+	for i := 0; i < n; i++ {
+		if err := addCtx.Err(); err != nil {
+			// 取り消された場合は、投入していないドキュメントの範囲を記録します。
+			errs.add(i, n, err)
+			break
 		}
-		err = bi.Add(
-			ctx,
-			esutil.BulkIndexerItem{
-				Action: "index",
-				Body:   strings.NewReader(string(data)),
-			},
-		)
+		// 投入できなかったドキュメントは失敗として記録し、DeadLetterSinkに残します。
+		item := esutil.BulkIndexerItem{Action: "index", OnFailure: collector.onFailure}
+		data, body, err := arena.Encode(doc(i))
 		if err != nil {
-			return fmt.Errorf("failed to add document to bulk indexer: %w", err)
+			collector.addFailure(item, BulkItemFailure{ErrorType: "marshal_error", Reason: err.Error()}, "")
+			continue
+		}
+		item.Body = body
+		if item.DocumentID, err = c.documentID(data); err != nil {
+			collector.addFailure(item, BulkItemFailure{ErrorType: "document_id_error", Reason: err.Error()}, "")
+			continue
+		}
+		if item.Index, err = c.resolveIndex(addCtx, index, data); err != nil {
+			collector.addFailure(item, BulkItemFailure{DocumentID: item.DocumentID, ErrorType: "index_resolver_error", Reason: err.Error()}, "")
+			continue
+		}
+		if err := writer.add(addCtx, "", item); err != nil {
+			collector.addFailure(item, BulkItemFailure{DocumentID: item.DocumentID, ErrorType: "bulk_indexer_error", Reason: err.Error()}, "")
+			errs.add(i, i+1, err)
 		}
 	}
 
-	if err := bi.Close(ctx); err != nil {
-		return fmt.Errorf("failed to close bulk indexer: %w", err)
+	if err := writer.close(ctx); err != nil {
+		return nil, err
 	}
+	defer arena.Release()
 
-	return nil
+	if addCtx.Err() == nil {
+		if err := c.retryFailed(ctx, bulkCfg, collector); err != nil {
+			return nil, err
+		}
+	}
+	result, err := collector.result()
+	return result, errs.err(err)
 }
@@ -1,11 +1,36 @@
-package main
+package concurrentinsert
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/kurakura967/go-elasticsearch-playground/docgen"
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
 )
 
+// esAddr はベンチマークの接続先です。空の場合はプロセス内の疑似サーバー(esfake)に接続するため、Dockerは不要です。
+// 実際のクラスタで計測する場合は go test -bench=. -es=http://localhost:9200 のように指定します。
+var esAddr = flag.String("es", "", "Elasticsearch address for benchmarks (default: in-process fake server)")
+
+// benchmarkConfig は-esで指定したクラスタ、または疑似サーバーへの接続設定を返します。
+func benchmarkConfig(b *testing.B) elasticsearch.Config {
+	if *esAddr != "" {
+		return elasticsearch.Config{Addresses: []string{*esAddr}}
+	}
+	return esfake.New(b).Config()
+}
+
+// corpusSeed はベンチマークで投入するドキュメントを生成するシードです。
+var corpusSeed = flag.Uint64("seed", 1, "seed for the synthetic benchmark corpus")
+
+// benchmarkDocs はTMDBの映画と同じ形の合成ドキュメントを生成します。同じ-seedからは常に同じドキュメントになります。
+func benchmarkDocs(num int) []map[string]interface{} {
+	return docgen.Generate(docgen.TMDB(), *corpusSeed, num)
+}
+
 func generateDocs(num int) []map[string]interface{} {
 	docs := make([]map[string]interface{}, num)
 	for i := 0; i < num; i++ {
@@ -18,7 +43,7 @@ func generateDocs(num int) []map[string]interface{} {
 }
 
 func BenchmarkConcurrentInsert(b *testing.B) {
-	client, err := NewClient()
+	client, err := NewClient(benchmarkConfig(b))
 	if err != nil {
 		b.Fatalf("failed to create client: %v", err)
 	}
@@ -28,7 +53,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 	for _, count := range docCounts {
 		// BulkInsert のベンチマーク
 		b.Run(fmt.Sprintf("BulkInsert/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("benchmark-bulk-%d", count)
 
 			b.ResetTimer()
@@ -45,7 +70,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 				// --- セットアップ完了 ---
 				b.StartTimer()
 
-				if err := client.BulkInsert(context.Background(), indexName, docs); err != nil {
+				if _, err := client.BulkInsert(context.Background(), indexName, docs); err != nil {
 					b.Fatalf("BulkInsert failed: %v", err)
 				}
 			}
@@ -53,7 +78,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 
 		// BulkInsertConcurrent のベンチマーク
 		b.Run(fmt.Sprintf("BulkInsertConcurrent/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("benchmark-concurrent-%d", count)
 			chunkSize := 100
 
@@ -71,7 +96,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 				// --- セットアップ完了 ---
 				b.StartTimer()
 
-				if err := client.BulkInsertConcurrent(context.Background(), indexName, docs, chunkSize); err != nil {
+				if _, err := client.BulkInsertConcurrent(context.Background(), indexName, docs, chunkSize); err != nil {
 					b.Fatalf("BulkInsertConcurrent failed: %v", err)
 				}
 			}
@@ -79,7 +104,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 
 		// BulkInsertConcurrentV2 のベンチマーク
 		b.Run(fmt.Sprintf("BulkInsertConcurrentV2/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("benchmark-concurrent-v2-%d", count)
 			numWorkers := 4
 
@@ -97,7 +122,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 				// --- セットアップ完了 ---
 				b.StartTimer()
 
-				if err := client.BulkInsertConcurrentV2(context.Background(), indexName, docs, numWorkers); err != nil {
+				if _, err := client.BulkInsertConcurrentV2(context.Background(), indexName, docs, numWorkers); err != nil {
 					b.Fatalf("BulkInsertConcurrentV2 failed: %v", err)
 				}
 			}
@@ -105,7 +130,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 
 		// BulkInsertConcurrentV3 のベンチマーク
 		b.Run(fmt.Sprintf("BulkInsertConcurrentV3/%d_docs", count), func(b *testing.B) {
-			docs := generateDocs(count)
+			docs := benchmarkDocs(count)
 			indexName := fmt.Sprintf("benchmark-concurrent-v3-%d", count)
 			numWorkers := 4
 
@@ -123,7 +148,7 @@ func BenchmarkConcurrentInsert(b *testing.B) {
 				// --- セットアップ完了 ---
 				b.StartTimer()
 
-				if err := client.BulkInsertConcurrentV3(context.Background(), indexName, docs, numWorkers); err != nil {
+				if _, err := client.BulkInsertConcurrentV3(context.Background(), indexName, docs, numWorkers); err != nil {
 					b.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
 				}
 			}
@@ -0,0 +1,425 @@
+package concurrentinsert
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Record は入力から読み出した1件のドキュメントです。
+type Record struct {
+	// ID はドキュメントIDです。入力にIDが含まれない場合は空で、Elasticsearchが自動で採番します。
+	ID string
+	// Routing とPipeline はレコードのルーティング値と取り込みパイプラインです。
+	// 空の場合はWithRoutingとWithPipelineで設定した既定値を使用します。
+	Routing  string
+	Pipeline string
+	// Source はドキュメントのJSONです。
+	Source json.RawMessage
+	// Line はレコードが始まる入力上の行番号(1始まり)です。
+	Line int
+	// Number は何件目のレコードか(1始まり)を表します。
+	Number int
+}
+
+// RecordReader は入力からドキュメントを1件ずつ読み出します。
+// 全件をメモリに載せないため、巨大なダンプファイルでもメモリ使用量は一定です。
+type RecordReader interface {
+	// Next は次のレコードを返します。入力の終端に達した場合はio.EOFを返します。
+	Next() (Record, error)
+}
+
+// LoadError は入力の不正な箇所を表すエラーです。
+type LoadError struct {
+	Line   int
+	Number int
+	Err    error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("line %d (record %d): %v", e.Line, e.Number, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// ndjsonReader は1行に1件のJSONオブジェクトが書かれたNDJSONを読み出します。
+type ndjsonReader struct {
+	r      *bufio.Reader
+	line   int
+	number int
+}
+
+// NewNDJSONReader はNDJSON形式の入力を読み出すRecordReaderを作成します。空行は読み飛ばします。
+func NewNDJSONReader(r io.Reader) RecordReader {
+	return &ndjsonReader{r: bufio.NewReader(r)}
+}
+
+func (n *ndjsonReader) Next() (Record, error) {
+	for {
+		line, err := n.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Record{}, err
+		}
+		n.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		n.number++
+
+		if line[0] != '{' || !json.Valid(line) {
+			return Record{}, &LoadError{Line: n.line, Number: n.number, Err: errors.New("invalid JSON object")}
+		}
+		return Record{Source: line, Line: n.line, Number: n.number}, nil
+	}
+}
+
+// jsonReader はトップレベルが配列、またはIDをキーとするオブジェクト(tmdb.jsonの形)のJSONを読み出します。
+type jsonReader struct {
+	lines   *lineCounter
+	dec     *json.Decoder
+	started bool
+	keyed   bool
+	number  int
+	err     error
+}
+
+// NewJSONReader はJSON配列、またはIDをキーとしたJSONオブジェクトを読み出すRecordReaderを作成します。
+// オブジェクトの場合、キーをドキュメントIDとして使用します。
+// json.Decoderでトークン単位に読み進めるため、入力全体をメモリに載せることはありません。
+func NewJSONReader(r io.Reader) RecordReader {
+	lines := &lineCounter{r: r}
+	return &jsonReader{lines: lines, dec: json.NewDecoder(lines)}
+}
+
+func (j *jsonReader) Next() (Record, error) {
+	if j.err != nil {
+		return Record{}, j.err
+	}
+	rec, err := j.next()
+	if err != nil {
+		j.err = err
+	}
+	return rec, err
+}
+
+func (j *jsonReader) next() (Record, error) {
+	if !j.started {
+		j.started = true
+		tok, err := j.dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				return Record{}, j.loadError(errors.New("empty input"))
+			}
+			return Record{}, j.loadError(err)
+		}
+		switch tok {
+		case json.Delim('['):
+		case json.Delim('{'):
+			j.keyed = true
+		default:
+			return Record{}, j.loadError(fmt.Errorf("expected a JSON array or object, got %v", tok))
+		}
+	}
+
+	if !j.dec.More() {
+		// 閉じ括弧を読み、その後に余計なデータがないことを確認します。
+		if _, err := j.dec.Token(); err != nil {
+			return Record{}, j.loadError(err)
+		}
+		if _, err := j.dec.Token(); err != io.EOF {
+			return Record{}, j.loadError(errors.New("unexpected data after the top-level value"))
+		}
+		return Record{}, io.EOF
+	}
+	j.number++
+
+	// 要素の先頭の行番号を求めるため、空白と区切り文字を読み飛ばした位置を使用します。
+	rec := Record{Line: j.lines.lineAt(j.dec.InputOffset() + j.leadingSpace())}
+	if j.keyed {
+		tok, err := j.dec.Token()
+		if err != nil {
+			return Record{}, j.loadError(err)
+		}
+		rec.ID, _ = tok.(string)
+	}
+	if err := j.dec.Decode(&rec.Source); err != nil {
+		return Record{}, j.loadError(err)
+	}
+	if len(rec.Source) == 0 || rec.Source[0] != '{' {
+		return Record{}, &LoadError{Line: rec.Line, Number: j.number, Err: errors.New("expected a JSON object")}
+	}
+	rec.Number = j.number
+	return rec, nil
+}
+
+// leadingSpace はデコーダのバッファ上で、次の値の前にある空白と区切り文字のバイト数を返します。
+func (j *jsonReader) leadingSpace() int64 {
+	buf, _ := io.ReadAll(io.LimitReader(j.dec.Buffered(), 4096))
+	n := 0
+	for n < len(buf) {
+		switch buf[n] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			n++
+			continue
+		}
+		break
+	}
+	return int64(n)
+}
+
+func (j *jsonReader) loadError(err error) error {
+	offset := j.dec.InputOffset()
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	}
+	return &LoadError{Line: j.lines.lineAt(offset), Number: j.number, Err: err}
+}
+
+// lineCounter は読み出したバイト列の改行位置を記録し、オフセットから行番号を求めます。
+// 行番号の問い合わせはオフセットの昇順に行われるため、参照済みの改行位置は破棄してメモリ使用量を抑えます。
+type lineCounter struct {
+	r        io.Reader
+	read     int64
+	newlines []int64
+	line     int
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.newlines = append(l.newlines, l.read+int64(i))
+		}
+	}
+	l.read += int64(n)
+	return n, err
+}
+
+func (l *lineCounter) lineAt(offset int64) int {
+	for len(l.newlines) > 0 && l.newlines[0] < offset {
+		l.line++
+		l.newlines = l.newlines[1:]
+	}
+	return l.line + 1
+}
+
+// CSVColumn はCSVの列をドキュメントのフィールドに対応付けます。
+type CSVColumn struct {
+	// Field はドキュメントのフィールド名です。
+	Field string
+	// Parse は値を変換します。nilの場合は文字列のまま使用します。
+	Parse func(string) (interface{}, error)
+}
+
+// CSVOptions はCSVの読み込み方法を定義します。
+type CSVOptions struct {
+	// Columns はヘッダーの列名からフィールドへの対応です。含まれない列は無視します。
+	Columns map[string]CSVColumn
+	// IDColumn はドキュメントIDとして使用する列名です。空の場合はElasticsearchが自動で採番します。
+	IDColumn string
+	// Comma は区切り文字です。省略時は ',' です。
+	Comma rune
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []CSVColumn
+	id      int
+	number  int
+}
+
+// NewCSVReader は1行目をヘッダーとしてCSVを読み出すRecordReaderを作成します。
+func NewCSVReader(r io.Reader, opts CSVOptions) (RecordReader, error) {
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, &LoadError{Line: 1, Err: fmt.Errorf("failed to read CSV header: %w", err)}
+	}
+
+	reader := &csvReader{r: cr, columns: make([]CSVColumn, len(header)), id: -1}
+	for i, name := range header {
+		if name == opts.IDColumn && opts.IDColumn != "" {
+			reader.id = i
+		}
+		reader.columns[i] = opts.Columns[name]
+	}
+	if opts.IDColumn != "" && reader.id < 0 {
+		return nil, &LoadError{Line: 1, Err: fmt.Errorf("ID column %q not found in CSV header", opts.IDColumn)}
+	}
+	return reader, nil
+}
+
+func (c *csvReader) Next() (Record, error) {
+	row, err := c.r.Read()
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	c.number++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Record{}, &LoadError{Line: parseErr.StartLine, Number: c.number, Err: parseErr.Err}
+		}
+		return Record{}, &LoadError{Number: c.number, Err: err}
+	}
+	line, _ := c.r.FieldPos(0)
+
+	doc := make(map[string]interface{}, len(row))
+	for i, value := range row {
+		column := c.columns[i]
+		if column.Field == "" {
+			continue
+		}
+		if column.Parse == nil {
+			doc[column.Field] = value
+			continue
+		}
+		parsed, err := column.Parse(value)
+		if err != nil {
+			return Record{}, &LoadError{Line: line, Number: c.number, Err: fmt.Errorf("column %s: %w", column.Field, err)}
+		}
+		doc[column.Field] = parsed
+	}
+
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return Record{}, &LoadError{Line: line, Number: c.number, Err: err}
+	}
+	rec := Record{Source: source, Line: line, Number: c.number}
+	if c.id >= 0 {
+		rec.ID = row[c.id]
+	}
+	return rec, nil
+}
+
+// BulkLoad はRecordReaderから読み出したドキュメントを、単一のBulkIndexerで登録します。
+// BulkIndexerのキューが埋まるとAddがブロックするため、読み込みは送信に合わせて進み、メモリ使用量は一定に保たれます。
+// 入力が不正な場合はそこで読み込みを止め、それまでに追加したドキュメントを送信してからLoadErrorを返します。
+// 入力にIDが含まれないレコードは、ClientのIDStrategyでIDを決定します。
+func (c *Client) BulkLoad(ctx context.Context, index string, r RecordReader, numWorkers int) (*BulkResult, error) {
+	return c.bulkLoad(ctx, index, r, numWorkers, nil, nil)
+}
+
+// bulkLoad はBulkLoadの本体です。trackerを指定した場合、確定したレコードの位置をチェックポイントに記録します。
+// adaptiveを指定した場合はnumWorkersを使わず、adaptiveが調整したワーカー数とフラッシュサイズで登録します。
+func (c *Client) bulkLoad(ctx context.Context, index string, r RecordReader, numWorkers int, tracker *checkpointTracker, adaptive *AdaptiveController) (*BulkResult, error) {
+	collector := c.newCollector(index)
+	bulkCfg := esutil.BulkIndexerConfig{
+		Client:     c.baseClient,
+		Index:      index,
+		NumWorkers: workerCount(numWorkers),
+		OnError:    collector.onError,
+	}
+	newWriter := func() (*bulkWriter, int) {
+		if adaptive == nil {
+			return c.newBulkWriter(bulkCfg, collector), 0
+		}
+		cfg := adaptive.configure(bulkCfg)
+		return c.newBulkWriter(cfg, collector), cfg.FlushBytes
+	}
+	writer, flushBytes := newWriter()
+	// abort は投入を途中で終える場合も、それまでに確定した位置をチェックポイントに保存します。
+	abort := func(err error) error {
+		if tracker != nil {
+			tracker.interrupt()
+			if saveErr := tracker.close(); saveErr != nil {
+				err = errors.Join(err, saveErr)
+			}
+		}
+		return err
+	}
+
+	var readErr error
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			if tracker != nil {
+				tracker.finish()
+			}
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		if rec.ID == "" {
+			if rec.ID, err = c.documentID(rec.Source); err != nil {
+				readErr = &LoadError{Line: rec.Line, Number: rec.Number, Err: err}
+				break
+			}
+		}
+		target, err := c.resolveIndex(ctx, index, rec.Source)
+		if err != nil {
+			readErr = &LoadError{Line: rec.Line, Number: rec.Number, Err: err}
+			break
+		}
+		item := esutil.BulkIndexerItem{
+			Index:      target,
+			Action:     "index",
+			DocumentID: rec.ID,
+			Routing:    rec.Routing,
+			Body:       bytes.NewReader(rec.Source),
+			OnFailure:  collector.onFailureFor(rec.Pipeline),
+		}
+		if tracker != nil {
+			tracker.track(&item, rec)
+		}
+		if adaptive != nil {
+			adaptive.instrumentItem(&item)
+			// BulkIndexerのフラッシュサイズは作成後に変更できないため、調整された場合は閉じて作り直します。
+			if fb := adaptive.FlushBytes(); fb != flushBytes {
+				if err := writer.close(ctx); err != nil {
+					return nil, abort(err)
+				}
+				writer, flushBytes = newWriter()
+			}
+		}
+		if err := writer.add(ctx, rec.Pipeline, item); err != nil {
+			return nil, abort(writer.discard(ctx, fmt.Errorf("failed to add record %d to bulk indexer: %w", rec.Number, err)))
+		}
+	}
+	if readErr != nil && tracker != nil {
+		// 読み込みを止めた位置までのレコードを確定できるよう、途中のバッチを閉じます。
+		tracker.interrupt()
+	}
+
+	if err := writer.close(ctx); err != nil {
+		return nil, abort(err)
+	}
+	if adaptive != nil {
+		bulkCfg.NumWorkers = adaptive.Workers()
+	}
+	if err := c.retryFailed(ctx, bulkCfg, collector); err != nil {
+		return nil, abort(err)
+	}
+	result, err := collector.result()
+	if readErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to read input: %w", readErr))
+	}
+	if tracker != nil {
+		if saveErr := tracker.close(); saveErr != nil {
+			err = errors.Join(err, saveErr)
+		}
+	}
+	return result, err
+}
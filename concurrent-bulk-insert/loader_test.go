@@ -0,0 +1,181 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func readAll(t *testing.T, r RecordReader) ([]Record, error) {
+	t.Helper()
+	var records []Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+func assertLoadError(t *testing.T, err error, line, number int) {
+	t.Helper()
+	var loadErr *LoadError
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("expected a LoadError, got %v", err)
+	}
+	if loadErr.Line != line || loadErr.Number != number {
+		t.Errorf("expected line %d (record %d), got %v", line, number, loadErr)
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	input := "{\"title\": \"a\"}\n\n{\"title\": \"b\"}\n{\"title\": \"c\"}"
+	records, err := readAll(t, NewNDJSONReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	if rec := records[1]; rec.Line != 3 || rec.Number != 2 || string(rec.Source) != `{"title": "b"}` {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+
+	_, err = readAll(t, NewNDJSONReader(strings.NewReader("{\"title\": \"a\"}\n\n{\"title\": \n")))
+	assertLoadError(t, err, 3, 2)
+}
+
+func TestJSONReaderArray(t *testing.T) {
+	input := "[\n  {\"title\": \"a\"},\n  {\"title\": \"b\"}\n]\n"
+	records, err := readAll(t, NewJSONReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if rec := records[1]; rec.Line != 3 || rec.Number != 2 || rec.ID != "" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestJSONReaderObject(t *testing.T) {
+	// tmdb.json と同じく、IDをキーとしたオブジェクト
+	input := `{
+  "268": {"title": "Batman"},
+  "272": {"title": "Batman Begins"}
+}`
+	records, err := readAll(t, NewJSONReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if rec := records[1]; rec.ID != "272" || rec.Line != 3 || string(rec.Source) != `{"title": "Batman Begins"}` {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestJSONReaderMalformed(t *testing.T) {
+	input := "[\n  {\"title\": \"a\"},\n  {\"title\": \"b\",}\n]"
+	_, err := readAll(t, NewJSONReader(strings.NewReader(input)))
+	assertLoadError(t, err, 3, 2)
+
+	_, err = readAll(t, NewJSONReader(strings.NewReader("[\n  {\"title\": \"a\"},\n  42\n]")))
+	assertLoadError(t, err, 3, 2)
+}
+
+func TestCSVReader(t *testing.T) {
+	input := "id,title,release_year,ignored\n268,Batman,1989,x\n272,Batman Begins,2005,y\n"
+	r, err := NewCSVReader(strings.NewReader(input), CSVOptions{
+		IDColumn: "id",
+		Columns: map[string]CSVColumn{
+			"title": {Field: "title"},
+			"release_year": {Field: "release_year", Parse: func(s string) (interface{}, error) {
+				return strconv.Atoi(s)
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCSVReader failed: %v", err)
+	}
+	records, err := readAll(t, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	rec := records[1]
+	if rec.ID != "272" || rec.Line != 3 || string(rec.Source) != `{"release_year":2005,"title":"Batman Begins"}` {
+		t.Errorf("unexpected record: %+v (%s)", rec, rec.Source)
+	}
+
+	r, err = NewCSVReader(strings.NewReader("title,year\na,1\nb,oops\n"), CSVOptions{
+		Columns: map[string]CSVColumn{
+			"year": {Field: "year", Parse: func(s string) (interface{}, error) { return strconv.Atoi(s) }},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCSVReader failed: %v", err)
+	}
+	_, err = readAll(t, r)
+	assertLoadError(t, err, 3, 2)
+}
+
+func TestBulkLoad(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		return itemOK(fmt.Sprintf("%d-%d", call, item))
+	})
+
+	var input strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&input, "{\"title\": \"Test Document %d\"}\n", i)
+	}
+	input.WriteString("{broken\n")
+
+	result, err := client.BulkLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(input.String())), 2)
+	assertLoadError(t, err, 51, 51)
+	if result == nil || result.Indexed != 50 {
+		t.Errorf("expected the records before the malformed line to be indexed, got %+v", result)
+	}
+}
+
+// failingWriter は書き込みに必ず失敗するio.Writerです。
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestBulkLoadKeepsResultErrorsWithReadError(t *testing.T) {
+	srv := esfake.New(t)
+	srv.InjectItem(esfake.ItemFault{Status: http.StatusBadRequest, ErrorType: "mapper_parsing_exception", Times: 1})
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithDeadLetterSink(NewNDJSONDeadLetterSink(failingWriter{}))
+
+	input := "{\"title\": \"a\"}\n{\"title\": \"b\"}\n{broken\n"
+	result, err := client.BulkLoad(context.Background(), "test", NewNDJSONReader(strings.NewReader(input)), 1)
+	assertLoadError(t, err, 3, 3)
+	if err == nil || !strings.Contains(err.Error(), "failed to record dead letters") {
+		t.Errorf("expected the dead letter error to be kept with the read error, got %v", err)
+	}
+	if result == nil || result.Indexed != 1 || result.Failed != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
@@ -0,0 +1,162 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esbulk"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithPipeline は各メソッドで使用する既定の取り込みパイプライン(ingest pipeline)を設定します。
+// Record.Pipelineでレコードごとに上書きできます。インデックスのdefault_pipelineも使わない場合は"_none"を指定します。
+func (c *Client) WithPipeline(pipeline string) *Client {
+	c.pipeline = pipeline
+	return c
+}
+
+// WithRouting は各メソッドで使用する既定のルーティング値を設定します。Record.Routingでレコードごとに上書きできます。
+func (c *Client) WithRouting(routing string) *Client {
+	c.routing = routing
+	return c
+}
+
+// bulkWriter はパイプラインごとにBulkIndexerを作成してアイテムを振り分けます。
+// BulkIndexerItemにはパイプラインを指定できないため、リクエスト単位で指定できるBulkIndexerConfig.Pipelineを使います。
+// パイプラインごとにNumWorkersのワーカーが起動するため、多くのパイプラインを混在させる場合は注意が必要です。
+type bulkWriter struct {
+	client    *Client
+	cfg       esutil.BulkIndexerConfig
+	collector *bulkResultCollector
+
+	mu       sync.Mutex
+	indexers map[string]esutil.BulkIndexer
+	// abortCtx はcloseが送信を待つのを諦めたときや、discardで投入をやめたときに取り消され、送信中のリクエストを止めます。
+	abortCtx context.Context
+	abort    context.CancelCauseFunc
+	// streams はWithDataStreamを設定した場合に、書き込んだデータストリームを記録します。
+	streams map[string]struct{}
+}
+
+func (c *Client) newBulkWriter(cfg esutil.BulkIndexerConfig, collector *bulkResultCollector) *bulkWriter {
+	abortCtx, abort := context.WithCancelCause(context.Background())
+	return &bulkWriter{
+		client:    c,
+		cfg:       cfg,
+		collector: collector,
+		indexers:  make(map[string]esutil.BulkIndexer),
+		abortCtx:  abortCtx,
+		abort:     abort,
+		streams:   make(map[string]struct{}),
+	}
+}
+
+// add はアイテムをpipelineのBulkIndexerに追加します。複数のgoroutineから呼び出せます。
+// pipelineとアイテムのルーティングが空の場合はClientの既定値を使用します。
+// 再送時に同じパイプラインを使うため、アイテムのOnFailureにはcollector.onFailureFor(pipeline)を設定しておきます。
+// フラッシュごとのスパンとメトリクスを記録するため、アイテムのコールバックとBulkIndexerの設定を包みます。
+// RateLimiterを設定している場合は、上限を超えないよう追加する前に待ちます。
+// WithDataStreamを設定している場合は、index操作をcreate操作に変えます。
+func (w *bulkWriter) add(ctx context.Context, pipeline string, item esutil.BulkIndexerItem) error {
+	if pipeline == "" {
+		pipeline = w.client.pipeline
+	}
+	if item.Routing == "" {
+		item.Routing = w.client.routing
+	}
+	if w.client.dataStream != nil {
+		item = w.toDataStream(ctx, item)
+	}
+	if w.collector.indices != nil {
+		item = w.countByIndex(item)
+	}
+	item = w.client.telemetry.InstrumentItem(ctx, item, w.cfg.Index)
+	if w.client.rateLimiter != nil {
+		throttled, err := w.client.rateLimiter.wait(ctx, 1, esbulk.ItemSize(item))
+		w.collector.addThrottled(throttled)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	indexer, ok := w.indexers[pipeline]
+	if !ok {
+		cfg := w.cfg
+		cfg.Pipeline = pipeline
+		cfg = w.client.telemetry.Instrument(cfg, trace.SpanContextFromContext(ctx))
+		// closeが送信を待つのを諦めたときや、投入を途中でやめたときに送信中のリクエストを取り消します。
+		cfg = esbulk.Abortable(cfg, w.abortCtx)
+		// リクエスト単位で失敗したドキュメントもアイテムごとに集計するため、トランスポートを包みます。
+		cfg = esbulk.Itemize(cfg)
+		var err error
+		if indexer, err = esutil.NewBulkIndexer(cfg); err != nil {
+			w.mu.Unlock()
+			return fmt.Errorf("failed to create bulk indexer: %w", err)
+		}
+		w.indexers[pipeline] = indexer
+	}
+	w.mu.Unlock()
+	return indexer.Add(ctx, item)
+}
+
+// close はすべてのBulkIndexerを閉じ、統計をcollectorに加算します。
+// いずれかのBulkIndexerを閉じられなくても残りのBulkIndexerを閉じ、すべてのエラーをまとめて返します。
+// RolloverPolicyを設定している場合は、最後に書き込んだデータストリームのロールオーバーの条件を確認します。
+func (w *bulkWriter) close(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer w.abort(nil)
+	var errs []error
+	for _, indexer := range w.indexers {
+		if err := w.closeIndexer(ctx, indexer); err != nil {
+			// 残りのBulkIndexerは送信中のリクエストを取り消してから閉じます。
+			w.abort(err)
+			errs = append(errs, fmt.Errorf("failed to close bulk indexer: %w", err))
+		}
+		w.collector.addStats(indexer.Stats())
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if w.abortCtx.Err() == nil {
+		w.rolloverStreams(ctx)
+	}
+	return nil
+}
+
+// discard は送信中と未送信のリクエストを取り消してからすべてのBulkIndexerを閉じ、errを返します。
+// 途中でエラーを返すメソッドが、追加済みのアイテムを登録しないまま、ワーカーを残さずに終えるために使います。
+// 取り消したアイテムは失敗としてcollectorに記録されます。
+func (w *bulkWriter) discard(ctx context.Context, err error) error {
+	w.abort(err)
+	_ = w.close(context.WithoutCancel(ctx))
+	return err
+}
+
+// closeIndexer はindexerを閉じ、ワーカーが終わるまで待ちます。
+// BulkIndexerのCloseは呼び出した時点でしかctxを確認せず、取り消されたctxではワーカーを待たずに返るため、取り消されないctxで呼び出します。
+// ctxが取り消された場合は、送信中のリクエストを取り消し、ワーカーが終わるのを待ってからctxのエラーを返します。
+func (w *bulkWriter) closeIndexer(ctx context.Context, indexer esutil.BulkIndexer) error {
+	if err := ctx.Err(); err != nil {
+		w.abort(err)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- indexer.Close(context.WithoutCancel(ctx))
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	case <-ctx.Done():
+		w.abort(ctx.Err())
+		<-closed
+		return ctx.Err()
+	}
+}
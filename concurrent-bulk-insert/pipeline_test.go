@@ -0,0 +1,157 @@
+package concurrentinsert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+// recordSlice はスライスのレコードを順に返すRecordReaderです。
+type recordSlice []Record
+
+func (r *recordSlice) Next() (Record, error) {
+	if len(*r) == 0 {
+		return Record{}, io.EOF
+	}
+	rec := (*r)[0]
+	*r = (*r)[1:]
+	return rec, nil
+}
+
+func TestBulkLoadWithPipelineAndRouting(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	res, err := client.baseClient.Ingest.PutPipeline("tag", strings.NewReader(`{"processors":[{"set":{"field":"source","value":"import"}}]}`))
+	if err != nil {
+		t.Fatalf("failed to put pipeline: %v", err)
+	}
+	res.Body.Close()
+
+	var out bytes.Buffer
+	client.WithPipeline("tag").WithRouting("shelf-a").WithDeadLetterSink(NewNDJSONDeadLetterSink(&out))
+	records := recordSlice{
+		{ID: "1", Source: json.RawMessage(`{"title":"a"}`), Line: 1, Number: 1},
+		{ID: "2", Source: json.RawMessage(`{"title":"b"}`), Routing: "shelf-b", Pipeline: "_none", Line: 2, Number: 2},
+		{ID: "3", Source: json.RawMessage(`{"title":"c"}`), Pipeline: "missing", Line: 3, Number: 3},
+	}
+	result, err := client.BulkLoad(context.Background(), "books", &records, 2)
+	if err != nil {
+		t.Fatalf("BulkLoad failed: %v", err)
+	}
+	if result.Indexed != 2 || result.Failed != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	idx, _ := srv.Index("books")
+	if got := string(idx.Documents["1"]); got != `{"source":"import","title":"a"}` {
+		t.Errorf("document 1 = %s", got)
+	}
+	if got := string(idx.Documents["2"]); got != `{"title":"b"}` {
+		t.Errorf("expected document 2 to skip the pipeline, got %s", got)
+	}
+	routings := map[string]string{}
+	for _, req := range srv.Requests() {
+		if !strings.HasSuffix(req.Path, "/_bulk") {
+			continue
+		}
+		for _, line := range bytes.Split(req.Body, []byte("\n")) {
+			var meta map[string]struct {
+				ID      string `json:"_id"`
+				Routing string `json:"routing"`
+			}
+			if json.Unmarshal(line, &meta) == nil {
+				if m, ok := meta["index"]; ok {
+					routings[m.ID] = m.Routing
+				}
+			}
+		}
+	}
+	if routings["1"] != "shelf-a" || routings["2"] != "shelf-b" {
+		t.Errorf("unexpected routings: %v", routings)
+	}
+
+	letters := readDeadLetters(t, out.Bytes())
+	if len(letters) != 1 || letters[0].DocumentID != "3" || letters[0].Pipeline != "missing" || letters[0].Routing != "shelf-a" {
+		t.Errorf("expected the routing and pipeline to be recorded, got %+v", letters)
+	}
+}
+
+func TestBulkWriterCloseCancelsInFlightRequests(t *testing.T) {
+	srv := esfake.New(t)
+	// 応答しないクラスタを再現し、送信を待っている間にctxを取り消します。
+	srv.Inject(esfake.Fault{Path: "/*/_bulk", Delay: 2 * time.Second})
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	collector := client.newCollector("test")
+	writer := client.newBulkWriter(esutil.BulkIndexerConfig{Client: client.baseClient, Index: "test", NumWorkers: 1, OnError: collector.onError}, collector)
+	// パイプラインごとのBulkIndexerを、どれも閉じて数えることを確認します。
+	for i, pipeline := range []string{"a", "a", "a", "b", "b"} {
+		item := esutil.BulkIndexerItem{Action: "index", Body: strings.NewReader(fmt.Sprintf(`{"n":%d}`, i)), OnFailure: collector.onFailureFor(pipeline)}
+		if err := writer.add(context.Background(), pipeline, item); err != nil {
+			t.Fatalf("failed to add item: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := writer.close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected close to return after the request was canceled, took %s", elapsed)
+	}
+	// ワーカーが終わるのを待ってから統計を加算するため、取り消したリクエストのアイテムも数えます。
+	result, _ := collector.result()
+	if result.Failed != 5 || len(result.Failures) != 5 {
+		t.Errorf("expected the canceled items to be counted as failures, got %+v", result)
+	}
+}
+
+func TestBulkWriterDiscard(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	collector := client.newCollector("test")
+	writer := client.newBulkWriter(esutil.BulkIndexerConfig{Client: client.baseClient, Index: "test", NumWorkers: 1, OnError: collector.onError}, collector)
+	for i, pipeline := range []string{"a", "a", "b"} {
+		item := esutil.BulkIndexerItem{Action: "index", Body: strings.NewReader(fmt.Sprintf(`{"n":%d}`, i)), OnFailure: collector.onFailureFor(pipeline)}
+		if err := writer.add(context.Background(), pipeline, item); err != nil {
+			t.Fatalf("failed to add item: %v", err)
+		}
+	}
+
+	// 投入をやめる場合は、追加済みのアイテムを送信せずにBulkIndexerを閉じます。
+	stop := errors.New("stop")
+	if err := writer.discard(context.Background(), stop); err != stop {
+		t.Fatalf("expected the given error, got %v", err)
+	}
+	if idx, ok := srv.Index("test"); ok && len(idx.Documents) > 0 {
+		t.Errorf("expected no documents to be indexed, got %d", len(idx.Documents))
+	}
+	for _, req := range srv.Requests() {
+		if strings.HasSuffix(req.Path, "/_bulk") {
+			t.Errorf("unexpected bulk request: %s %s", req.Method, req.Path)
+		}
+	}
+	result, _ := collector.result()
+	if result.Failed != 3 || len(result.Failures) != 3 {
+		t.Errorf("expected the discarded items to be counted as failures, got %+v", result)
+	}
+}
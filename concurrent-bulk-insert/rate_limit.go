@@ -0,0 +1,145 @@
+package concurrentinsert
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit は投入速度の上限です。0以下の項目は制限しません。
+type RateLimit struct {
+	// DocsPerSecond は1秒あたりに投入するドキュメント数の上限です。
+	DocsPerSecond float64
+	// BytesPerSecond は1秒あたりに投入するドキュメントのバイト数の上限です。
+	BytesPerSecond float64
+}
+
+// RateLimiter はBulkIndexerに追加するドキュメントの件数とバイト数を、トークンバケットで制限します。
+// 検索も処理している共有クラスタを投入で飽和させないために使用します。
+// 各上限は1秒分までのバーストを許容します。SetLimitで実行中に上限を変更でき、待機中の投入にもすぐに反映されます。
+type RateLimiter struct {
+	mu    sync.Mutex
+	limit RateLimit
+	docs  tokenBucket
+	bytes tokenBucket
+	// changed は上限が変更されたときに閉じられ、待機中のgoroutineに再計算を促します。
+	changed chan struct{}
+}
+
+// NewRateLimiter はlimitを上限とするRateLimiterを作成します。
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	l := &RateLimiter{changed: make(chan struct{})}
+	l.setLimit(limit, time.Now())
+	return l
+}
+
+// WithRateLimiter はBulk APIの各メソッドで使用するRateLimiterを設定します。
+// 複数のClientで同じRateLimiterを共有すると、それらの合計を制限できます。
+func (c *Client) WithRateLimiter(limiter *RateLimiter) *Client {
+	c.rateLimiter = limiter
+	return c
+}
+
+// Limit は現在の上限を返します。
+func (l *RateLimiter) Limit() RateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit は上限を変更します。設定の再読み込みや管理用のエンドポイントから、投入中に呼び出すことができます。
+func (l *RateLimiter) SetLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setLimit(limit, time.Now())
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *RateLimiter) setLimit(limit RateLimit, now time.Time) {
+	l.limit = limit
+	l.docs.setRate(limit.DocsPerSecond, now)
+	l.bytes.setRate(limit.BytesPerSecond, now)
+}
+
+// wait はdocs件、sizeバイトを投入できるまで待ち、待った時間を返します。
+func (l *RateLimiter) wait(ctx context.Context, docs, size int) (time.Duration, error) {
+	var start time.Time
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.docs.refill(now)
+		l.bytes.refill(now)
+		delay := max(l.docs.delay(float64(docs)), l.bytes.delay(float64(size)))
+		if delay == 0 {
+			l.docs.take(float64(docs))
+			l.bytes.take(float64(size))
+			l.mu.Unlock()
+			if start.IsZero() {
+				return 0, nil
+			}
+			return time.Since(start), nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		if start.IsZero() {
+			start = now
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return time.Since(start), ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// tokenBucket は1秒分のトークンを上限とするトークンバケットです。rateが0以下の場合は制限しません。
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) setRate(rate float64, now time.Time) {
+	if b.rate > 0 {
+		b.refill(now)
+	} else {
+		// 制限していなかった場合は、満杯のバケットから始めます。
+		b.tokens = rate
+	}
+	b.rate = rate
+	b.last = now
+	b.tokens = min(b.tokens, rate)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// delay はn個のトークンを取り出せるまでの時間を返します。
+// バケットの容量より大きい要求は、バケットが満杯になった時点で取り出せるものとし、不足分は後の要求が待ちます。
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	need := min(n, b.rate)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
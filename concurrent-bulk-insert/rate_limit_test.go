@@ -0,0 +1,87 @@
+package concurrentinsert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(RateLimit{DocsPerSecond: 10, BytesPerSecond: 1000})
+	ctx := context.Background()
+
+	// 1秒分のバーストまでは待たずに投入できます。
+	for i := 0; i < 10; i++ {
+		if d, err := l.wait(ctx, 1, 10); err != nil || d != 0 {
+			t.Fatalf("wait %d = %v, %v; want no delay", i, d, err)
+		}
+	}
+	start := time.Now()
+	if _, err := l.wait(ctx, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected to wait about 100ms for the docs limit, waited %v", elapsed)
+	}
+
+	// バイト数の上限に達した場合も待ちます。
+	l.SetLimit(RateLimit{BytesPerSecond: 1000})
+	if _, err := l.wait(ctx, 1, 1000); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.wait(waitCtx, 1, 500); err == nil {
+		t.Error("expected the bytes limit to block until the context expires")
+	}
+}
+
+func TestRateLimiterSetLimitWakesWaiters(t *testing.T) {
+	l := NewRateLimiter(RateLimit{DocsPerSecond: 1})
+	ctx := context.Background()
+	if _, err := l.wait(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan time.Duration)
+	go func() {
+		d, _ := l.wait(ctx, 1, 0)
+		done <- d
+	}()
+	time.Sleep(20 * time.Millisecond)
+	l.SetLimit(RateLimit{})
+	select {
+	case d := <-done:
+		if d == 0 {
+			t.Error("expected the waiter to report the throttled time")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected removing the limit to release the waiter")
+	}
+	if got := l.Limit(); got != (RateLimit{}) {
+		t.Errorf("Limit() = %+v", got)
+	}
+}
+
+func TestBulkInsertReportsThrottledTime(t *testing.T) {
+	client, err := NewClient(esfake.New(t).Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithRateLimiter(NewRateLimiter(RateLimit{DocsPerSecond: 200}))
+
+	start := time.Now()
+	result, err := client.BulkInsertConcurrentV3(context.Background(), "test", generateDocs(300), 2)
+	if err != nil {
+		t.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
+	}
+	if result.Indexed != 300 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	// 最初の200件はバーストで投入し、残りの100件に約0.5秒かかります。
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || result.Throttled < 300*time.Millisecond {
+		t.Errorf("expected about 500ms of throttling, elapsed %v, throttled %v", elapsed, result.Throttled)
+	}
+}
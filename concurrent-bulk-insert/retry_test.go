@@ -0,0 +1,131 @@
+package concurrentinsert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// newBulkTestClient は/_bulkへのリクエストをrespondで処理するテストサーバーとClientを作成します。
+// respondは何回目のリクエストか(1始まり)と、その中の何番目のアイテムか(0始まり)を受け取り、
+// アイテムのレスポンスを返します。
+func newBulkTestClient(t *testing.T, respond func(call, item int, title string) map[string]interface{}) *Client {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+
+		var items []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			// メタデータ行を読み飛ばし、ドキュメント行を読む
+			if !scanner.Scan() {
+				break
+			}
+			var doc map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				t.Errorf("failed to decode document: %v", err)
+			}
+			title, _ := doc["title"].(string)
+			items = append(items, map[string]interface{}{"index": respond(call, i, title)})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+func itemOK(id string) map[string]interface{} {
+	return map[string]interface{}{"_id": id, "status": 201, "result": "created"}
+}
+
+func itemError(id string, status int, errType string) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":    id,
+		"status": status,
+		"error":  map[string]interface{}{"type": errType, "reason": errType},
+	}
+}
+
+func TestBulkInsertRetriesRejectedItems(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		// 1回目のリクエストでは2件目だけを過負荷として拒否する
+		if call == 1 && title == "Test Document 2" {
+			return itemError("2", http.StatusTooManyRequests, "es_rejected_execution_exception")
+		}
+		return itemOK(fmt.Sprintf("%d-%d", call, item))
+	})
+	client.WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	result, err := client.BulkInsert(context.Background(), "test", generateDocs(3))
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Indexed != 3 || result.Failed != 0 || result.Retried != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestBulkInsertDoesNotRetryPermanentErrors(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		if title == "Test Document 2" {
+			return itemError("2", http.StatusBadRequest, "mapper_parsing_exception")
+		}
+		return itemOK(fmt.Sprintf("%d-%d", call, item))
+	})
+	client.WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	result, err := client.BulkInsert(context.Background(), "test", generateDocs(3))
+	if err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+	if result.Indexed != 2 || result.Failed != 1 || result.Retried != 0 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].ErrorType != "mapper_parsing_exception" {
+		t.Errorf("unexpected failures: %+v", result.Failures)
+	}
+}
+
+func TestBulkInsertGivesUpAfterMaxAttempts(t *testing.T) {
+	client := newBulkTestClient(t, func(call, item int, title string) map[string]interface{} {
+		return itemError(fmt.Sprintf("%d", item), http.StatusTooManyRequests, "es_rejected_execution_exception")
+	})
+	client.WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	result, err := client.BulkInsertConcurrentV3(context.Background(), "test", generateDocs(2), 1)
+	if err != nil {
+		t.Fatalf("BulkInsertConcurrentV3 failed: %v", err)
+	}
+	if result.Failed != 2 || result.Retried != 4 || len(result.Failures) != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
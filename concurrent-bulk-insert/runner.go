@@ -0,0 +1,277 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RunStatus は長時間の投入を終えたときの状態です。
+type RunStatus int
+
+const (
+	// RunCompleted は入力の終端まで読み込み、BulkIndexerを閉じたことを表します。
+	RunCompleted RunStatus = iota
+	// RunFailed は入力の不正やチェックポイントの保存の失敗など、シグナル以外の理由で止まったことを表します。
+	RunFailed
+	// RunDrained はシグナルを受けて読み込みを止め、猶予期間内に追加済みのドキュメントを送り終えたことを表します。
+	RunDrained
+	// RunAbandoned はシグナルを受けた後、猶予期間内に送り終えられず、送信中のドキュメントを破棄したことを表します。
+	RunAbandoned
+)
+
+// RunStatusに対応する終了コードです。RunDrainedとRunAbandonedはどちらも同じチェックポイントで再開できますが、
+// RunAbandonedでは破棄したドキュメントが登録されたかどうかは分かりません。
+const (
+	ExitCompleted = 0
+	ExitFailed    = 1
+	ExitDrained   = 3
+	ExitAbandoned = 4
+)
+
+func (s RunStatus) String() string {
+	switch s {
+	case RunCompleted:
+		return "completed"
+	case RunFailed:
+		return "failed"
+	case RunDrained:
+		return "drained"
+	case RunAbandoned:
+		return "abandoned"
+	}
+	return fmt.Sprintf("RunStatus(%d)", int(s))
+}
+
+// ExitCode はsに対応する終了コードを返します。
+func (s RunStatus) ExitCode() int {
+	switch s {
+	case RunCompleted:
+		return ExitCompleted
+	case RunDrained:
+		return ExitDrained
+	case RunAbandoned:
+		return ExitAbandoned
+	}
+	return ExitFailed
+}
+
+// RunConfig はRunの設定です。
+type RunConfig struct {
+	// NumWorkers はBulkIndexerのワーカー数です。0の場合はesutilの既定値を使用します。
+	NumWorkers int
+	// Checkpoint はチェックポイントファイルのパスです。ファイルがある場合はその位置から再開します。
+	Checkpoint string
+	// GracePeriod はシグナルを受けてから、追加済みのドキュメントを送り終えるまで待つ時間です。既定値は30秒です。
+	GracePeriod time.Duration
+	// Signals は投入を止めるシグナルです。既定値はSIGINTとSIGTERMです。
+	Signals []os.Signal
+	// Logger はシグナルの受信と猶予期間の超過を出力します。既定値はlog.Default()です。
+	Logger *log.Logger
+}
+
+func (cfg RunConfig) withDefaults() RunConfig {
+	if cfg.GracePeriod <= 0 {
+		cfg.GracePeriod = 30 * time.Second
+	}
+	if len(cfg.Signals) == 0 {
+		cfg.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	return cfg
+}
+
+// RunReport はRunの結果です。
+type RunReport struct {
+	Status RunStatus
+	// Signal は受け取ったシグナルです。ctxが取り消された場合とシグナルを受けていない場合はnilです。
+	Signal os.Signal
+	// Result は登録の結果です。RunAbandonedの場合は集計できないためnilです。
+	Result *BulkResult
+	// Checkpoint は最後に保存したチェックポイントです。
+	Checkpoint Checkpoint
+}
+
+// errRunStopped はシグナルを受けて、入力の読み込みを止めたことを表します。
+var errRunStopped = errors.New("stopped reading input")
+
+// Run はResumeと同様にRecordReaderからチェックポイントを記録しながら投入し、シグナルを受けると安全に止めます。
+// SIGINTかSIGTERM(RunConfig.Signals)を受けるかctxが取り消されると、新しいレコードを読み込まずに、
+// 追加済みのドキュメントの送信とリトライをGracePeriodまで待ってからBulkIndexerを閉じます。
+// 猶予期間内に終わらない場合や、待っている間に2回目のシグナルを受けた場合は、送信中のドキュメントを破棄して戻ります。
+// どちらの場合も、確定したレコードまでをチェックポイントに保存するため、同じ入力とCheckpointで再び実行すると続きから投入します。
+// 終了の仕方はRunReport.Statusで返し、Status.ExitCode()をプロセスの終了コードに使えます。
+// 読み込みを止めるのはRecordReaderのNextから戻った後のため、Nextがブロックする入力では、次のレコードが届くまで止まりません。
+func (c *Client) Run(ctx context.Context, index string, r RecordReader, cfg RunConfig) (*RunReport, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Checkpoint == "" {
+		return nil, errors.New("checkpoint path is required")
+	}
+	cp, err := LoadCheckpoint(cfg.Checkpoint)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		cp = &Checkpoint{Index: index, BatchSize: defaultCheckpointBatchSize}
+		if err := cp.save(cfg.Checkpoint); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case cp.Index != index:
+		return nil, fmt.Errorf("checkpoint %s is for index %s, not %s", cfg.Checkpoint, cp.Index, index)
+	case cp.Completed:
+		return &RunReport{Status: RunCompleted, Result: &BulkResult{}, Checkpoint: *cp}, nil
+	default:
+		if err := skipRecords(r, cp); err != nil {
+			return nil, err
+		}
+	}
+
+	sd := newShutdown(ctx, cfg)
+	defer sd.close()
+	tracker := newCheckpointTracker(cfg.Checkpoint, *cp)
+	// 送信とリトライはctxの取り消しでは止めず、猶予期間が過ぎたときにのみ止めます。
+	result, err := c.bulkLoad(sd.flushCtx, index, &stoppableReader{r: r, stop: sd.stop}, cfg.NumWorkers, tracker, nil)
+	abandoned := err != nil && sd.flushCtx.Err() != nil
+	sd.close()
+
+	report := &RunReport{Signal: sd.received(), Result: result, Checkpoint: tracker.checkpoint()}
+	switch {
+	case abandoned:
+		report.Status, report.Result = RunAbandoned, nil
+		return report, fmt.Errorf("abandoned in-flight documents after %s: %w", cfg.GracePeriod, err)
+	case errors.Is(err, errRunStopped):
+		report.Status = RunDrained
+		// 読み込みを止めたこと自体はエラーにせず、送信やチェックポイントの保存で発生したエラーのみを返します。
+		err = withoutRunStopped(err)
+		if saveErr := tracker.close(); saveErr != nil && !errors.Is(err, saveErr) {
+			err = errors.Join(err, saveErr)
+		}
+		if err != nil {
+			report.Status = RunFailed
+		}
+	case err != nil:
+		report.Status = RunFailed
+	default:
+		report.Status = RunCompleted
+	}
+	return report, err
+}
+
+// withoutRunStopped はerrからerrRunStoppedを取り除いたエラーを返します。ほかのエラーがない場合はnilを返します。
+func withoutRunStopped(err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		if errors.Is(err, errRunStopped) {
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		if !errors.Is(e, errRunStopped) {
+			errs = append(errs, e)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stoppableReader はstopが閉じられた後、次のレコードを読まずにerrRunStoppedを返します。
+type stoppableReader struct {
+	r    RecordReader
+	stop <-chan struct{}
+}
+
+func (s *stoppableReader) Next() (Record, error) {
+	select {
+	case <-s.stop:
+		return Record{}, errRunStopped
+	default:
+	}
+	return s.r.Next()
+}
+
+// shutdown はシグナルとctxの取り消しを監視し、読み込みの停止と猶予期間の経過を通知します。
+type shutdown struct {
+	// stop は読み込みを止めるときに閉じられます。
+	stop chan struct{}
+	// flushCtx は猶予期間が過ぎるか、2回目のシグナルを受けると取り消されます。
+	flushCtx    context.Context
+	cancelFlush context.CancelFunc
+	signals     chan os.Signal
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	mu     sync.Mutex
+	signal os.Signal
+}
+
+func newShutdown(ctx context.Context, cfg RunConfig) *shutdown {
+	flushCtx, cancelFlush := context.WithCancel(context.WithoutCancel(ctx))
+	sd := &shutdown{
+		stop:        make(chan struct{}),
+		flushCtx:    flushCtx,
+		cancelFlush: cancelFlush,
+		signals:     make(chan os.Signal, 1),
+		done:        make(chan struct{}),
+	}
+	signal.Notify(sd.signals, cfg.Signals...)
+	sd.wg.Add(1)
+	go sd.watch(ctx, cfg)
+	return sd
+}
+
+func (sd *shutdown) watch(ctx context.Context, cfg RunConfig) {
+	defer sd.wg.Done()
+	select {
+	case sig := <-sd.signals:
+		cfg.Logger.Printf("runner: received %s, draining for up to %s", sig, cfg.GracePeriod)
+		sd.mu.Lock()
+		sd.signal = sig
+		sd.mu.Unlock()
+	case <-ctx.Done():
+		cfg.Logger.Printf("runner: %v, draining for up to %s", context.Cause(ctx), cfg.GracePeriod)
+	case <-sd.done:
+		return
+	}
+	close(sd.stop)
+
+	timer := time.NewTimer(cfg.GracePeriod)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		cfg.Logger.Printf("runner: grace period of %s expired, abandoning in-flight documents", cfg.GracePeriod)
+	case sig := <-sd.signals:
+		cfg.Logger.Printf("runner: received %s again, abandoning in-flight documents", sig)
+	case <-sd.done:
+		return
+	}
+	sd.cancelFlush()
+}
+
+// received は受け取ったシグナルを返します。シグナルを受けていない場合はnilです。
+func (sd *shutdown) received() os.Signal {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	return sd.signal
+}
+
+// close はシグナルの監視を止めます。複数回呼び出せます。
+func (sd *shutdown) close() {
+	select {
+	case <-sd.done:
+		return
+	default:
+	}
+	signal.Stop(sd.signals)
+	close(sd.done)
+	sd.wg.Wait()
+	sd.cancelFlush()
+}
@@ -0,0 +1,140 @@
+package concurrentinsert
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+// hookReader はレコードを読み出すたびにhookを呼び出します。
+type hookReader struct {
+	r    RecordReader
+	hook func(rec Record)
+}
+
+func (h *hookReader) Next() (Record, error) {
+	rec, err := h.r.Next()
+	if err == nil {
+		h.hook(rec)
+	}
+	return rec, err
+}
+
+func TestRunDrainsAndResumes(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithIDStrategy(FieldID("isbn"))
+	cfg := RunConfig{NumWorkers: 2, Checkpoint: filepath.Join(t.TempDir(), "run.checkpoint"), Logger: log.New(io.Discard, "", 0)}
+	input := ndjsonDocs(2500)
+
+	// 1500件目を読み出したところで取り消すと、それ以降のレコードは読み込まずに送り終えます。
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &hookReader{r: NewNDJSONReader(strings.NewReader(input)), hook: func(rec Record) {
+		if rec.Number == 1500 {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}}
+	report, err := client.Run(ctx, "test", r, cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Status != RunDrained || report.Status.ExitCode() != ExitDrained || report.Result.Indexed != 1500 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	// 途中のバッチも、すべてのレコードが確定していればチェックポイントに含めます。
+	if cp, _ := LoadCheckpoint(cfg.Checkpoint); cp.Records != 1500 || cp.Line != 1500 || cp.Completed {
+		t.Errorf("expected the checkpoint to cover the 1500 drained records, got %+v", cp)
+	}
+
+	srv.Reset()
+	report, err = client.Run(context.Background(), "test", NewNDJSONReader(strings.NewReader(input)), cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Status != RunCompleted || report.Status.ExitCode() != ExitCompleted || sentItems(srv) != 1000 {
+		t.Errorf("expected only the remaining 1000 records to be sent, got %+v (%d sent)", report, sentItems(srv))
+	}
+	if !report.Checkpoint.Completed || report.Checkpoint.Records != 2500 {
+		t.Errorf("expected a completed checkpoint, got %+v", report.Checkpoint)
+	}
+}
+
+func TestRunAbandonsAfterGracePeriod(t *testing.T) {
+	srv := esfake.New(t)
+	// 応答しないクラスタを再現し、猶予期間内に送り終えられないようにします。
+	srv.Inject(esfake.Fault{Path: "/*/_bulk", Status: http.StatusServiceUnavailable, Delay: 2 * time.Second})
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	cfg := RunConfig{
+		Checkpoint:  filepath.Join(t.TempDir(), "run.checkpoint"),
+		GracePeriod: 100 * time.Millisecond,
+		Signals:     []os.Signal{syscall.SIGUSR1},
+		Logger:      log.New(io.Discard, "", 0),
+	}
+	r := &hookReader{r: NewNDJSONReader(strings.NewReader(ndjsonDocs(100))), hook: func(rec Record) {
+		if rec.Number == 10 {
+			_ = syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}}
+
+	start := time.Now()
+	report, err := client.Run(context.Background(), "test", r, cfg)
+	if err == nil {
+		t.Fatal("expected an error for abandoned documents")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Run to return after the grace period, took %s", elapsed)
+	}
+	if report.Status != RunAbandoned || report.Status.ExitCode() != ExitAbandoned || report.Signal != syscall.SIGUSR1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if cp, _ := LoadCheckpoint(cfg.Checkpoint); cp.Records != 0 || cp.Completed {
+		t.Errorf("expected nothing to be confirmed, got %+v", cp)
+	}
+}
+
+func TestRunKeepsErrorsWhenDrained(t *testing.T) {
+	srv := esfake.New(t)
+	srv.InjectItem(esfake.ItemFault{Status: http.StatusBadRequest, ErrorType: "mapper_parsing_exception", Times: 1})
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithDeadLetterSink(NewNDJSONDeadLetterSink(failingWriter{}))
+	cfg := RunConfig{Checkpoint: filepath.Join(t.TempDir(), "run.checkpoint"), Logger: log.New(io.Discard, "", 0)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &hookReader{r: NewNDJSONReader(strings.NewReader(ndjsonDocs(100))), hook: func(rec Record) {
+		if rec.Number == 50 {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}}
+	report, err := client.Run(ctx, "test", r, cfg)
+	// 読み込みを止めたことはエラーにしませんが、DeadLetterを記録できなかったことは返します。
+	if err == nil || !strings.Contains(err.Error(), "failed to record dead letters") || errors.Is(err, errRunStopped) {
+		t.Fatalf("expected only the dead letter error, got %v", err)
+	}
+	if report.Status != RunFailed {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
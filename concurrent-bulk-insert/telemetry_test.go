@@ -0,0 +1,45 @@
+package concurrentinsert
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// スパンとメトリクスの内容はesbulkのテストで確認します。ここではこのモジュールの計装スコープ名で記録されることを確認します。
+func TestBulkInsertTelemetry(t *testing.T) {
+	srv := esfake.New(t)
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))).
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if _, err := client.BulkInsert(context.Background(), "books", generateDocs(3)); err != nil {
+		t.Fatalf("BulkInsert failed: %v", err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "elasticsearch.bulk.flush" {
+		t.Fatalf("expected 1 flush span, got %d", len(ended))
+	}
+	if scope := ended[0].InstrumentationScope().Name; scope != instrumentationName {
+		t.Errorf("unexpected tracer scope: %s", scope)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("failed to collect metrics: %v", err)
+	}
+	if len(rm.ScopeMetrics) != 1 || rm.ScopeMetrics[0].Scope.Name != instrumentationName {
+		t.Errorf("unexpected meter scopes: %+v", rm.ScopeMetrics)
+	}
+}
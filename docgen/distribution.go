@@ -0,0 +1,247 @@
+package docgen
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Text は語彙から選んだMinWords以上MaxWords以下の単語を、語彙の区切り文字で連結した文字列です。
+type Text struct {
+	Vocabulary *Vocabulary
+	MinWords   int
+	MaxWords   int
+}
+
+func (d Text) Generate(s *State) interface{} {
+	n := between(s, d.MinWords, d.MaxWords)
+	words := make([]string, n)
+	for i := range words {
+		words[i] = d.Vocabulary.Word(s.Rand)
+	}
+	return strings.Join(words, d.Vocabulary.separator)
+}
+
+// Keyword は語彙から選んだ1つの単語です。
+type Keyword struct {
+	Vocabulary *Vocabulary
+}
+
+func (d Keyword) Generate(s *State) interface{} {
+	return d.Vocabulary.Word(s.Rand)
+}
+
+// Terms は語彙から選んだMin以上Max以下の単語の配列です。ジャンルやタグのようなkeywordの配列に使います。
+// Uniqueを指定すると同じ単語を含めません。その場合の件数は語彙の単語数が上限です。
+type Terms struct {
+	Vocabulary *Vocabulary
+	Min        int
+	Max        int
+	Unique     bool
+}
+
+func (d Terms) Generate(s *State) interface{} {
+	n := between(s, d.Min, d.Max)
+	if d.Unique {
+		n = min(n, d.Vocabulary.Len())
+	}
+	terms := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for len(terms) < n {
+		term := d.Vocabulary.Word(s.Rand)
+		if d.Unique {
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// Join はOfで生成したMin以上Max以下の値を、Separatorで連結した文字列です。
+// 出演者の名前を1つのtextフィールドにまとめる場合などに使います。
+type Join struct {
+	Of        Distribution
+	Min       int
+	Max       int
+	Separator string
+}
+
+func (d Join) Generate(s *State) interface{} {
+	n := between(s, d.Min, d.Max)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprint(d.Of.Generate(s))
+	}
+	return strings.Join(parts, d.Separator)
+}
+
+// Template はArgsで生成した値をFormatに埋め込んだ文字列です。
+type Template struct {
+	Format string
+	Args   []Distribution
+}
+
+func (d Template) Generate(s *State) interface{} {
+	args := make([]interface{}, len(d.Args))
+	for i, arg := range d.Args {
+		args[i] = arg.Generate(s)
+	}
+	return fmt.Sprintf(d.Format, args...)
+}
+
+// Token はAlphabetの文字をLength文字並べた文字列です。Alphabetを省略した場合は英数字を使います。
+type Token struct {
+	Alphabet string
+	Length   int
+}
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func (d Token) Generate(s *State) interface{} {
+	alphabet := []rune(d.Alphabet)
+	if len(alphabet) == 0 {
+		alphabet = []rune(alphanumeric)
+	}
+	token := make([]rune, d.Length)
+	for i := range token {
+		token[i] = alphabet[s.Rand.IntN(len(alphabet))]
+	}
+	return string(token)
+}
+
+// Date はFrom以上To未満の一様な日時を、Layoutで整形した文字列です。Layoutを省略した場合はRFC 3339です。
+type Date struct {
+	From   time.Time
+	To     time.Time
+	Layout string
+}
+
+func (d Date) Generate(s *State) interface{} {
+	t := d.From
+	if span := d.To.Sub(d.From); span > 0 {
+		t = t.Add(time.Duration(s.Rand.Int64N(int64(span))))
+	}
+	layout := d.Layout
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return t.UTC().Format(layout)
+}
+
+// IntRange はMin以上Max以下の一様な整数です。
+type IntRange struct {
+	Min int64
+	Max int64
+}
+
+func (d IntRange) Generate(s *State) interface{} {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	return d.Min + s.Rand.Int64N(d.Max-d.Min+1)
+}
+
+// FloatRange はMin以上Max未満の一様な実数です。Precisionが0より大きい場合は、小数点以下をその桁数に丸めます。
+type FloatRange struct {
+	Min       float64
+	Max       float64
+	Precision int
+}
+
+func (d FloatRange) Generate(s *State) interface{} {
+	v := d.Min + s.Rand.Float64()*(d.Max-d.Min)
+	if d.Precision > 0 {
+		scale := math.Pow10(d.Precision)
+		v = math.Round(v*scale) / scale
+	}
+	return v
+}
+
+// Object はFieldsを持つ入れ子のオブジェクトです。
+type Object struct {
+	Fields []Field
+}
+
+func (d Object) Generate(s *State) interface{} {
+	obj := make(map[string]interface{}, len(d.Fields))
+	generateFields(s, d.Fields, obj)
+	return obj
+}
+
+// Array はOfで生成したMin以上Max以下の値の配列です。Objectと組み合わせると、入れ子のオブジェクトの配列になります。
+type Array struct {
+	Of  Distribution
+	Min int
+	Max int
+}
+
+func (d Array) Generate(s *State) interface{} {
+	values := make([]interface{}, between(s, d.Min, d.Max))
+	for i := range values {
+		values[i] = d.Of.Generate(s)
+	}
+	return values
+}
+
+// Sequence はドキュメントの番号にStartを足した値を、Formatで整形した文字列です。Formatを省略した場合は"%d"です。
+// 乱数を使わないため、ドキュメントIDに使うと再生成しても同じドキュメントが同じIDになります。
+type Sequence struct {
+	Format string
+	Start  int
+}
+
+func (d Sequence) Generate(s *State) interface{} {
+	format := d.Format
+	if format == "" {
+		format = "%d"
+	}
+	return fmt.Sprintf(format, d.Start+s.Number)
+}
+
+// Derived はすでに生成したトップレベルのフィールドFromの値から、Funcで作った値です。
+// Fromが省略された場合、Funcにはnilが渡されます。
+type Derived struct {
+	From string
+	Func func(interface{}) interface{}
+}
+
+func (d Derived) Generate(s *State) interface{} {
+	return d.Func(s.Doc[d.From])
+}
+
+// OneOf はChoicesの中からWeightsの比率で選んだ分布の値です。Weightsを省略した場合は等しい比率で選びます。
+// 言語の異なる語彙を混ぜて、多言語のテキストを生成する場合などに使います。
+type OneOf struct {
+	Choices []Distribution
+	Weights []float64
+}
+
+func (d OneOf) Generate(s *State) interface{} {
+	if len(d.Weights) != len(d.Choices) {
+		return d.Choices[s.Rand.IntN(len(d.Choices))].Generate(s)
+	}
+	var total float64
+	for _, w := range d.Weights {
+		total += w
+	}
+	u := s.Rand.Float64() * total
+	for i, w := range d.Weights {
+		if u < w {
+			return d.Choices[i].Generate(s)
+		}
+		u -= w
+	}
+	return d.Choices[len(d.Choices)-1].Generate(s)
+}
+
+// between はlo以上hi以下の一様な整数を返します。hiがlo以下の場合はloを返します。
+func between(s *State, lo, hi int) int {
+	if hi <= lo {
+		return max(lo, 0)
+	}
+	return lo + s.Rand.IntN(hi-lo+1)
+}
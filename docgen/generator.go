@@ -0,0 +1,86 @@
+// Package docgen はベンチマーク用の、シードで再現できる合成ドキュメントを生成します。
+//
+// Schemaにフィールドごとの値の分布(テキストの長さと語彙、Zipf分布に従う単語、日付、数値の範囲、
+// 入れ子のオブジェクト、多言語のテキストなど)を指定し、Generatorで必要な件数のドキュメントを作成します。
+// 同じSchemaと同じシードからは、常に同じドキュメントが同じ順序で生成されます。
+// TMDBはsearch-using-ltrで使用しているTMDBの映画インデックスと同じ形のドキュメントを生成するSchemaです。
+package docgen
+
+import (
+	"math/rand/v2"
+)
+
+// Schema は生成するドキュメントのフィールドです。フィールドはこの順序で生成されます。
+type Schema struct {
+	Fields []Field
+}
+
+// Field はドキュメントの1つのフィールドと、その値の分布です。
+type Field struct {
+	Name  string
+	Value Distribution
+	// Missing はフィールドを省略する確率です。0の場合は常に生成します。
+	Missing float64
+}
+
+// Distribution はフィールドの値を生成します。
+type Distribution interface {
+	Generate(s *State) interface{}
+}
+
+// State は1件のドキュメントを生成している間の状態です。
+type State struct {
+	// Rand は値の生成に使用する乱数です。シードから決まるため、ほかの乱数を使うと再現性が失われます。
+	Rand *rand.Rand
+	// Number は0から数えたドキュメントの番号です。
+	Number int
+	// Doc はここまでに生成したトップレベルのフィールドです。Derivedで別のフィールドから値を作るときに参照します。
+	Doc map[string]interface{}
+}
+
+// Generator はSchemaに従ってドキュメントを順に生成します。複数のgoroutineから同時に使うことはできません。
+type Generator struct {
+	schema Schema
+	rand   *rand.Rand
+	next   int
+}
+
+// New はseedで初期化したGeneratorを作成します。
+func New(schema Schema, seed uint64) *Generator {
+	return &Generator{
+		schema: schema,
+		rand:   rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)),
+	}
+}
+
+// Next は次のドキュメントを生成します。
+func (g *Generator) Next() map[string]interface{} {
+	s := &State{Rand: g.rand, Number: g.next, Doc: make(map[string]interface{}, len(g.schema.Fields))}
+	g.next++
+	generateFields(s, g.schema.Fields, s.Doc)
+	return s.Doc
+}
+
+// Generate はn件のドキュメントを生成します。
+func (g *Generator) Generate(n int) []map[string]interface{} {
+	docs := make([]map[string]interface{}, n)
+	for i := range docs {
+		docs[i] = g.Next()
+	}
+	return docs
+}
+
+// Generate はschemaとseedからn件のドキュメントを生成します。
+func Generate(schema Schema, seed uint64, n int) []map[string]interface{} {
+	return New(schema, seed).Generate(n)
+}
+
+// generateFields はfieldsの値を生成してdocに設定します。
+func generateFields(s *State, fields []Field, doc map[string]interface{}) {
+	for _, f := range fields {
+		if f.Missing > 0 && s.Rand.Float64() < f.Missing {
+			continue
+		}
+		doc[f.Name] = f.Value.Generate(s)
+	}
+}
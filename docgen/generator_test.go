@@ -0,0 +1,132 @@
+package docgen
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGenerateIsReproducible(t *testing.T) {
+	a, _ := json.Marshal(Generate(TMDB(), 42, 50))
+	b, _ := json.Marshal(Generate(TMDB(), 42, 50))
+	if string(a) != string(b) {
+		t.Error("expected the same seed to generate the same documents")
+	}
+	c, _ := json.Marshal(Generate(TMDB(), 43, 50))
+	if string(a) == string(c) {
+		t.Error("expected a different seed to generate different documents")
+	}
+
+	// Nextで1件ずつ生成しても、Generateと同じドキュメントになります。
+	g := New(TMDB(), 42)
+	var docs []map[string]interface{}
+	for i := 0; i < 50; i++ {
+		docs = append(docs, g.Next())
+	}
+	if d, _ := json.Marshal(docs); string(d) != string(a) {
+		t.Error("expected Next to generate the same documents as Generate")
+	}
+}
+
+func TestTMDBShape(t *testing.T) {
+	docs := Generate(TMDB(), 1, 500)
+	withTagline := 0
+	for i, doc := range docs {
+		if doc["id"] != strconv.Itoa(i+1) {
+			t.Fatalf("document %d has id %v", i, doc["id"])
+		}
+		date, _ := doc["release_date"].(string)
+		if len(date) != len("2006-01-02") || doc["release_year"] != date[:4] {
+			t.Fatalf("unexpected release date and year: %v, %v", doc["release_date"], doc["release_year"])
+		}
+		for _, field := range []string{"title", "overview", "cast"} {
+			if s, ok := doc[field].(string); !ok || s == "" {
+				t.Fatalf("expected %s to be a non-empty string, got %#v", field, doc[field])
+			}
+		}
+		if genres, ok := doc["genres"].([]string); !ok || len(genres) == 0 || len(genres) > 4 {
+			t.Fatalf("unexpected genres: %#v", doc["genres"])
+		}
+		if directors, ok := doc["directors"].([]interface{}); !ok || len(directors) == 0 {
+			t.Fatalf("unexpected directors: %#v", doc["directors"])
+		}
+		if v, ok := doc["vote_average"].(float64); !ok || v < 1 || v > 10 {
+			t.Fatalf("unexpected vote_average: %#v", doc["vote_average"])
+		}
+		if _, ok := doc["tagline"]; ok {
+			withTagline++
+		}
+	}
+	// taglineは30%の確率で省略されます。
+	if withTagline < 300 || withTagline > 400 {
+		t.Errorf("expected about 350 documents with a tagline, got %d", withTagline)
+	}
+}
+
+func TestZipfVocabulary(t *testing.T) {
+	vocab := NewVocabulary([]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"})
+	zipf := vocab.Zipf(1)
+	r := rand.New(rand.NewPCG(1, 2))
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[zipf.Word(r)]++
+	}
+	// s=1では、1位の単語は10位の単語の約10倍選ばれます。
+	if ratio := float64(counts["a"]) / float64(counts["j"]); ratio < 7 || ratio > 13 {
+		t.Errorf("expected the first word about 10 times as often as the tenth, got %v", counts)
+	}
+
+	uniform := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		uniform[vocab.Word(r)]++
+	}
+	if ratio := float64(uniform["a"]) / float64(uniform["j"]); ratio < 0.8 || ratio > 1.25 {
+		t.Errorf("expected a uniform distribution, got %v", uniform)
+	}
+}
+
+func TestNestedAndMultilingualFields(t *testing.T) {
+	words, err := LoadVocabulary(strings.NewReader("# comment\nalpha\n\nbeta\ngamma\n"))
+	if err != nil {
+		t.Fatalf("LoadVocabulary failed: %v", err)
+	}
+	if words.Len() != 3 {
+		t.Fatalf("expected 3 words, got %d", words.Len())
+	}
+	if _, err := LoadVocabulary(strings.NewReader("\n# only comments\n")); err == nil {
+		t.Error("expected an error for an empty word list")
+	}
+
+	schema := Schema{Fields: []Field{
+		{Name: "tags", Value: Terms{Vocabulary: words, Min: 5, Max: 5, Unique: true}},
+		{Name: "reviews", Value: Array{Min: 2, Max: 2, Of: Object{Fields: []Field{
+			{Name: "author", Value: Keyword{Vocabulary: words}},
+			{Name: "rating", Value: IntRange{Min: 1, Max: 5}},
+			{Name: "body", Value: Text{Vocabulary: Japanese(), MinWords: 3, MaxWords: 3}},
+		}}}},
+		{Name: "caption", Value: Text{Vocabulary: Multilingual(), MinWords: 50, MaxWords: 50}},
+	}}
+	doc := New(schema, 7).Next()
+
+	if tags := doc["tags"].([]string); len(tags) != 3 {
+		t.Errorf("expected unique tags to be limited by the vocabulary, got %v", tags)
+	}
+	reviews := doc["reviews"].([]interface{})
+	if len(reviews) != 2 {
+		t.Fatalf("expected 2 reviews, got %d", len(reviews))
+	}
+	review := reviews[0].(map[string]interface{})
+	if rating := review["rating"].(int64); rating < 1 || rating > 5 {
+		t.Errorf("unexpected rating: %d", rating)
+	}
+	if body := review["body"].(string); strings.Contains(body, " ") {
+		t.Errorf("expected Japanese words to be joined without spaces, got %q", body)
+	}
+	caption := doc["caption"].(string)
+	if !utf8.ValidString(caption) || utf8.RuneCountInString(caption) == len(caption) {
+		t.Errorf("expected a caption with multi-byte characters, got %q", caption)
+	}
+}
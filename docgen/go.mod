@@ -0,0 +1,3 @@
+module github.com/kurakura967/go-elasticsearch-playground/docgen
+
+go 1.24.2
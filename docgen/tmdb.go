@@ -0,0 +1,48 @@
+package docgen
+
+import (
+	"time"
+)
+
+// TMDB はsearch-using-ltrで使用しているTMDBの映画インデックスと同じ形のドキュメントを生成するSchemaを返します。
+// フィールドはhello-ltrのindexable_moviesが作成するドキュメントに合わせています。
+// release_yearはsearch-using-ltrの検索結果が文字列として読み込むため、release_dateの先頭4文字です。
+func TMDB() Schema {
+	words := English().Zipf(1.07)
+	person := Template{Format: "%s %s", Args: []Distribution{
+		Keyword{Vocabulary: FirstNames().Zipf(0.8)},
+		Keyword{Vocabulary: LastNames().Zipf(0.8)},
+	}}
+
+	return Schema{Fields: []Field{
+		{Name: "id", Value: Sequence{Start: 1}},
+		{Name: "title", Value: OneOf{
+			Choices: []Distribution{
+				Text{Vocabulary: words, MinWords: 1, MaxWords: 5},
+				Text{Vocabulary: Japanese().Zipf(1), MinWords: 2, MaxWords: 5},
+				Text{Vocabulary: Multilingual(), MinWords: 1, MaxWords: 4},
+			},
+			Weights: []float64{0.85, 0.1, 0.05},
+		}},
+		{Name: "overview", Value: Text{Vocabulary: words, MinWords: 20, MaxWords: 120}},
+		{Name: "tagline", Value: Text{Vocabulary: words, MinWords: 3, MaxWords: 12}, Missing: 0.3},
+		{Name: "directors", Value: Array{Of: person, Min: 1, Max: 2}},
+		{Name: "cast", Value: Join{Of: person, Min: 2, Max: 15, Separator: " "}},
+		{Name: "genres", Value: Terms{Vocabulary: Genres().Zipf(0.9), Min: 1, Max: 4, Unique: true}},
+		{Name: "release_date", Value: Date{
+			From:   time.Date(1930, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Layout: time.DateOnly,
+		}},
+		{Name: "release_year", Value: Derived{From: "release_date", Func: func(v interface{}) interface{} {
+			date, _ := v.(string)
+			return date[:min(4, len(date))]
+		}}},
+		{Name: "poster_path", Value: Template{
+			Format: "https://image.tmdb.org/t/p/w185/%s.jpg",
+			Args:   []Distribution{Token{Length: 27}},
+		}, Missing: 0.05},
+		{Name: "vote_average", Value: FloatRange{Min: 1, Max: 10, Precision: 1}},
+		{Name: "vote_count", Value: IntRange{Min: 0, Max: 20000}},
+	}}
+}
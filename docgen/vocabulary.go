@@ -0,0 +1,90 @@
+package docgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// Vocabulary は単語の一覧と、そこから単語を選ぶ分布です。作成した後は変更されないため、複数のSchemaで共有できます。
+type Vocabulary struct {
+	words []string
+	// cdf はZipf分布の累積確率です。nilの場合は一様に選びます。
+	cdf       []float64
+	separator string
+}
+
+// NewVocabulary はwordsから一様に単語を選ぶVocabularyを作成します。Textは単語を空白で連結します。
+func NewVocabulary(words []string) *Vocabulary {
+	return &Vocabulary{words: append([]string(nil), words...), separator: " "}
+}
+
+// LoadVocabulary は1行に1語のワードリストを読み込みます。空行と#で始まる行は無視します。
+// 頻度順に並んだワードリストであれば、Zipfで実際の文章に近い単語の偏りを再現できます。
+func LoadVocabulary(r io.Reader) (*Vocabulary, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read word list: %w", err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("word list is empty")
+	}
+	return NewVocabulary(words), nil
+}
+
+// Zipf は順位kの単語が1/k^sに比例する確率で選ばれるVocabularyを返します。一覧の先頭の単語ほど頻繁に選ばれます。
+// 自然言語の単語の頻度はsが1前後のZipf分布に近いことが知られています。sが0以下の場合は一様に選びます。
+func (v *Vocabulary) Zipf(s float64) *Vocabulary {
+	zipf := *v
+	zipf.cdf = nil
+	if s <= 0 {
+		return &zipf
+	}
+	zipf.cdf = make([]float64, len(v.words))
+	var total float64
+	for k := range v.words {
+		total += 1 / math.Pow(float64(k+1), s)
+		zipf.cdf[k] = total
+	}
+	for k := range zipf.cdf {
+		zipf.cdf[k] /= total
+	}
+	return &zipf
+}
+
+// WithSeparator はTextで単語を連結するときの区切り文字を変更したVocabularyを返します。
+// 日本語のように単語を空白で区切らない言語では""を指定します。
+func (v *Vocabulary) WithSeparator(separator string) *Vocabulary {
+	sep := *v
+	sep.separator = separator
+	return &sep
+}
+
+// Len は単語の数を返します。
+func (v *Vocabulary) Len() int {
+	return len(v.words)
+}
+
+// Word は分布に従って単語を1つ選びます。
+func (v *Vocabulary) Word(r *rand.Rand) string {
+	if len(v.words) == 0 {
+		return ""
+	}
+	if v.cdf == nil {
+		return v.words[r.IntN(len(v.words))]
+	}
+	i := sort.SearchFloat64s(v.cdf, r.Float64())
+	return v.words[min(i, len(v.words)-1)]
+}
@@ -0,0 +1,91 @@
+package docgen
+
+// 組み込みの語彙です。英語の単語はおおよそ頻度の高い順に並べているため、Zipfと組み合わせると実際の文章に近い偏りになります。
+
+var englishWords = []string{
+	"the", "of", "and", "to", "a", "in", "is", "his", "her", "that",
+	"with", "for", "on", "as", "by", "from", "their", "an", "who", "when",
+	"after", "into", "one", "life", "new", "love", "story", "young", "world", "family",
+	"man", "woman", "must", "two", "find", "only", "years", "home", "friends", "time",
+	"father", "mother", "war", "city", "journey", "team", "secret", "help", "school", "town",
+	"becomes", "takes", "discovers", "finds", "tries", "save", "lost", "death", "past", "night",
+	"dark", "true", "first", "last", "girl", "boy", "son", "daughter", "wife", "husband",
+	"brother", "sister", "police", "detective", "killer", "agent", "captain", "king", "queen", "prince",
+	"battle", "mission", "dream", "power", "revenge", "escape", "murder", "mystery", "adventure", "planet",
+	"earth", "space", "island", "ocean", "mountain", "forest", "desert", "river", "kingdom", "empire",
+	"ghost", "monster", "robot", "alien", "vampire", "zombie", "hero", "villain", "legend", "curse",
+	"future", "history", "memory", "truth", "lies", "fear", "hope", "courage", "betrayal", "destiny",
+	"wedding", "summer", "winter", "christmas", "holiday", "road", "train", "ship", "plane", "car",
+	"music", "band", "dance", "game", "race", "fight", "boxer", "soldier", "pilot", "doctor",
+	"teacher", "student", "lawyer", "thief", "gang", "prison", "hospital", "hotel", "castle", "village",
+	"batman", "dragon", "wizard", "knight", "pirate", "samurai", "ninja", "cowboy", "spy", "scientist",
+}
+
+var japaneseWords = []string{
+	"の", "は", "が", "を", "に", "と", "で", "も", "物語", "少年",
+	"少女", "家族", "友情", "恋", "戦い", "世界", "未来", "過去", "夏", "冬",
+	"東京", "京都", "大阪", "海", "山", "空", "星", "月", "夜", "夢",
+	"秘密", "約束", "旅", "町", "学校", "先生", "侍", "忍者", "怪獣", "魔法",
+	"刑事", "事件", "記憶", "奇跡", "運命", "時間", "桜", "雪", "風", "光",
+}
+
+var multilingualWords = []string{
+	"amour", "été", "château", "rêve", "cœur", "straße", "größe", "mädchen", "über", "frühling",
+	"corazón", "niño", "mañana", "señor", "canción", "amor", "saudade", "coração", "ação", "irmão",
+	"любовь", "война", "город", "звезда", "москва", "사랑", "영화", "서울", "친구", "바다",
+	"爱情", "电影", "北京", "朋友", "故事", "الحب", "القمر", "مدينة", "αγάπη", "θάλασσα",
+	"ødegaard", "smörgåsbord", "naïve", "café", "piñata", "crème", "brûlée", "🎬", "🍿", "⭐",
+}
+
+var firstNames = []string{
+	"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth",
+	"David", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Charles", "Karen",
+	"Christopher", "Nancy", "Daniel", "Lisa", "Matthew", "Margaret", "Anthony", "Sandra", "Mark", "Ashley",
+	"Tom", "Emma", "Harrison", "Meryl", "Denzel", "Cate", "Keanu", "Scarlett", "Morgan", "Natalie",
+	"Akira", "Hayao", "Ken", "Yuki", "Jean", "Amélie", "José", "Zoë", "Björn", "Søren",
+}
+
+var lastNames = []string{
+	"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+	"Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin",
+	"Lee", "Perez", "Thompson", "White", "Harris", "Sanchez", "Clark", "Ramirez", "Lewis", "Robinson",
+	"Hanks", "Watson", "Ford", "Streep", "Washington", "Blanchett", "Reeves", "Johansson", "Freeman", "Portman",
+	"Kurosawa", "Miyazaki", "Watanabe", "Tanaka", "Dupont", "Poulain", "Núñez", "Müller", "Andersson", "Kierkegaard",
+}
+
+// genreNames はTMDBのジャンルです。
+var genreNames = []string{
+	"Drama", "Comedy", "Thriller", "Action", "Romance", "Adventure", "Crime", "Science Fiction", "Horror", "Family",
+	"Fantasy", "Mystery", "Animation", "History", "Music", "War", "Documentary", "Western", "TV Movie",
+}
+
+// English は英語の語彙を返します。
+func English() *Vocabulary {
+	return NewVocabulary(englishWords)
+}
+
+// Japanese は日本語の語彙を返します。Textは単語を区切らずに連結します。
+func Japanese() *Vocabulary {
+	return NewVocabulary(japaneseWords).WithSeparator("")
+}
+
+// Multilingual はアクセント記号付きのラテン文字、キリル文字、ハングル、漢字、アラビア文字、ギリシャ文字、
+// 絵文字を含む語彙を返します。アナライザーやエンコーディングの処理を含めて計測する場合に使います。
+func Multilingual() *Vocabulary {
+	return NewVocabulary(multilingualWords)
+}
+
+// FirstNames は人名の名の語彙を返します。
+func FirstNames() *Vocabulary {
+	return NewVocabulary(firstNames)
+}
+
+// LastNames は人名の姓の語彙を返します。
+func LastNames() *Vocabulary {
+	return NewVocabulary(lastNames)
+}
+
+// Genres はTMDBの映画のジャンルの語彙を返します。
+func Genres() *Vocabulary {
+	return NewVocabulary(genreNames)
+}
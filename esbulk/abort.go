@@ -0,0 +1,39 @@
+package esbulk
+
+import (
+	"context"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// abortFuncKey はフラッシュのcontextに、取り消しの監視を止める関数を格納するためのキーです。
+type abortFuncKey struct{}
+
+// Abortable はabortCtxが取り消されたときに、送信中と以降のフラッシュのリクエストを取り消すよう、cfgのコールバックを設定します。
+// OnFlushStartが返すcontextはリクエストに渡されるため、フラッシュごとにabortCtxで取り消されるcontextを作ります。
+// Itemizeと組み合わせると、取り消したリクエストのアイテムはアイテムごとの失敗になります。
+func Abortable(cfg esutil.BulkIndexerConfig, abortCtx context.Context) esutil.BulkIndexerConfig {
+	onFlushStart, onFlushEnd := cfg.OnFlushStart, cfg.OnFlushEnd
+	cfg.OnFlushStart = func(ctx context.Context) context.Context {
+		if onFlushStart != nil {
+			ctx = onFlushStart(ctx)
+		}
+		ctx, cancel := context.WithCancelCause(ctx)
+		stop := context.AfterFunc(abortCtx, func() {
+			cancel(context.Cause(abortCtx))
+		})
+		return context.WithValue(ctx, abortFuncKey{}, func() {
+			stop()
+			cancel(nil)
+		})
+	}
+	cfg.OnFlushEnd = func(ctx context.Context) {
+		if onFlushEnd != nil {
+			onFlushEnd(ctx)
+		}
+		if release, ok := ctx.Value(abortFuncKey{}).(func()); ok {
+			release()
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,50 @@
+package esbulk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+func TestAbortable(t *testing.T) {
+	abortCtx, abort := context.WithCancelCause(context.Background())
+	stopped := errors.New("stopped")
+	started := make(chan struct{})
+	causes := make(chan error, 1)
+	indexer, err := esutil.NewBulkIndexer(Itemize(Abortable(esutil.BulkIndexerConfig{
+		Client: transportFunc(func(req *http.Request) (*http.Response, error) {
+			close(started)
+			<-req.Context().Done()
+			causes <- context.Cause(req.Context())
+			return nil, req.Context().Err()
+		}),
+		Index:      "movies",
+		NumWorkers: 1,
+	}, abortCtx)))
+	if err != nil {
+		t.Fatalf("failed to create bulk indexer: %v", err)
+	}
+	item := esutil.BulkIndexerItem{Action: "index", DocumentID: "1", Body: strings.NewReader(`{"title":"Movie"}`)}
+	if err := indexer.Add(context.Background(), item); err != nil {
+		t.Fatalf("failed to add item: %v", err)
+	}
+
+	// Closeはフラッシュを待つため、送信が始まってから取り消します。
+	closed := make(chan error, 1)
+	go func() { closed <- indexer.Close(context.Background()) }()
+	<-started
+	abort(stopped)
+	if err := <-closed; err != nil {
+		t.Fatalf("failed to close bulk indexer: %v", err)
+	}
+	if cause := <-causes; !errors.Is(cause, stopped) {
+		t.Errorf("expected the request to be canceled with the abort cause, got %v", cause)
+	}
+	if stats := indexer.Stats(); stats.NumFailed != 1 {
+		t.Errorf("expected the canceled item to fail, got %+v", stats)
+	}
+}
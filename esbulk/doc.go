@@ -0,0 +1,6 @@
+// Package esbulk はbulk-insert-vs-single-insertとconcurrent-bulk-insertで共有する、Bulk APIの投入の部品です。
+//
+// 失敗したアイテムの再送(RetryPolicy)、リクエスト単位の失敗をアイテムごとの失敗に置き換えるトランスポート
+// (ItemizingTransport)、フラッシュごとのスパンとメトリクスの記録(Telemetry)、
+// ドキュメントをプールしたブロックに詰めるEncoder(EncodeArena)、送信中のリクエストの取り消し(Abortable)を提供します。各モジュールはこれらを使って、Clientの公開APIを組み立てます。
+package esbulk
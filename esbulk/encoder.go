@@ -0,0 +1,235 @@
+package esbulk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+)
+
+// Encoder はBulk APIに送るドキュメントをJSONに変換します。
+type Encoder interface {
+	// Encode はdocのJSONを返します。dstの後ろに追記したスライスか、docがすでにJSONの場合はdocそのものを返します。
+	// 返したスライスは登録を終えるまで参照されるため、Encoderが書き換えてはいけません。
+	Encode(dst []byte, doc interface{}) ([]byte, error)
+}
+
+// JSONEncoder は標準ライブラリのencoding/jsonで変換するEncoderです。json.Marshalと同じJSONになります。
+// json.Encoderはプールしたものを使い、EncodeArenaでは登録を終えるまで同じものを使い続けます。
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(dst []byte, doc interface{}) ([]byte, error) {
+	enc := jsonEncoderPool.Get().(*jsonEncoder)
+	defer jsonEncoderPool.Put(enc)
+	return enc.Encode(dst, doc)
+}
+
+var jsonEncoderPool = sync.Pool{New: func() interface{} {
+	return newJSONEncoder()
+}}
+
+// jsonEncoder はdstに追記するjson.Encoderを持つEncoderです。1つのgoroutineから使用します。
+type jsonEncoder struct {
+	dst appendWriter
+	enc *json.Encoder
+}
+
+func newJSONEncoder() *jsonEncoder {
+	e := &jsonEncoder{}
+	e.enc = json.NewEncoder(&e.dst)
+	return e
+}
+
+func (e *jsonEncoder) Encode(dst []byte, doc interface{}) ([]byte, error) {
+	e.dst = dst
+	defer func() { e.dst = nil }()
+	if err := e.enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	// Encodeが末尾に付ける改行を取り除きます。
+	data := e.dst
+	return data[:len(data)-1], nil
+}
+
+// appendWriter は書き込まれたバイト列を自身に追記するio.Writerです。
+type appendWriter []byte
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	*w = append(*w, p...)
+	return len(p), nil
+}
+
+// RawEncoder はエンコード済みのドキュメント([]byte、json.RawMessage、string)をコピーせずにそのまま送るEncoderです。
+// JSONとして正しいかどうかは検証しません。
+type RawEncoder struct{}
+
+func (RawEncoder) Encode(dst []byte, doc interface{}) ([]byte, error) {
+	switch v := doc.(type) {
+	case []byte:
+		return v, nil
+	case json.RawMessage:
+		return v, nil
+	case string:
+		return append(dst, v...), nil
+	}
+	return nil, fmt.Errorf("RawEncoder: unsupported document type %T", doc)
+}
+
+// JSONAppender はリフレクションを使わずに、自身のJSONをdstに追記できる型です。
+// コード生成した型で実装し、CodecEncoderで変換します。
+type JSONAppender interface {
+	AppendJSON(dst []byte) ([]byte, error)
+}
+
+// CodecEncoder はJSONAppenderを実装したドキュメントを、リフレクションを使わずに変換するEncoderです。
+type CodecEncoder struct{}
+
+func (CodecEncoder) Encode(dst []byte, doc interface{}) ([]byte, error) {
+	appender, ok := doc.(JSONAppender)
+	if !ok {
+		return nil, fmt.Errorf("CodecEncoder: %T does not implement JSONAppender", doc)
+	}
+	return appender.AppendJSON(dst)
+}
+
+// AppendJSONString はsをJSONの文字列としてdstに追記します。JSONAppenderの実装で使用します。
+// 不正なUTF-8はencoding/jsonと同じくU+FFFDに置き換えます。
+func AppendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				dst = append(dst, s[start:i]...)
+				dst = append(dst, "\ufffd"...)
+				i += size
+				start = i
+				continue
+			}
+			i += size
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' {
+			i++
+			continue
+		}
+		dst = append(dst, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		default:
+			dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		}
+		i++
+		start = i
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// EncodeBlockSize はEncodeArenaが1つのブロックに詰めるバイト数です。
+const EncodeBlockSize = 64 << 10
+
+var encodeBlockPool = sync.Pool{New: func() interface{} {
+	block := make([]byte, 0, EncodeBlockSize)
+	return &block
+}}
+
+// EncodeArena はエンコードしたドキュメントを、プールしたブロックに詰めて保持します。
+// json.Marshalしてからstring(data)で読み出す場合と比べ、ドキュメントごとのコピーと割り当てがなくなります。
+// BulkIndexerは送信した後もリトライとDeadLetterのためにアイテムのBodyを参照するため、
+// ブロックは登録を終えてからReleaseでまとめてプールに戻します。1つのgoroutineから使用します。
+type EncodeArena struct {
+	enc     Encoder
+	json    *jsonEncoder
+	block   *[]byte
+	blocks  []*[]byte
+	readers []bytes.Reader
+}
+
+// NewEncodeArena はencで変換するEncodeArenaを作成します。encがnilの場合はJSONEncoderを使用します。
+func NewEncodeArena(enc Encoder) *EncodeArena {
+	a := &EncodeArena{enc: enc}
+	if _, ok := enc.(JSONEncoder); ok || enc == nil {
+		// ドキュメントごとにjson.Encoderを取り出さないよう、Releaseまで同じものを使います。
+		a.json = jsonEncoderPool.Get().(*jsonEncoder)
+		a.enc = a.json
+	}
+	return a
+}
+
+// Encode はdocをエンコードし、そのJSONと、JSONを読み出すアイテムのBodyを返します。
+// ブロックは書き込むときに初めて取り出すため、エンコード済みのドキュメントをそのまま返すRawEncoderではブロックを使いません。
+func (a *EncodeArena) Encode(doc interface{}) ([]byte, *bytes.Reader, error) {
+	var free []byte
+	if a.block != nil {
+		free = (*a.block)[len(*a.block):]
+	}
+	data, err := a.enc.Encode(free, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case sameArray(free, data):
+		// ブロックの空きに書き込まれた分だけ進めます。
+		*a.block = (*a.block)[:len(*a.block)+len(data)]
+	case len(data) > cap(free) && !passedThrough(doc, data):
+		// 空きに収まらなかった場合は、次のドキュメントから新しいブロックを使います。
+		a.grow()
+	}
+	return data, a.reader(data), nil
+}
+
+// sameArray はaとbが同じ位置から始まるかどうかを返します。
+func sameArray(a, b []byte) bool {
+	return cap(a) > 0 && cap(b) > 0 && &a[:1][0] == &b[:1][0]
+}
+
+// passedThrough はEncoderがdstに書き込まずに、docのバイト列をそのまま返したかどうかを返します。
+func passedThrough(doc interface{}, data []byte) bool {
+	switch v := doc.(type) {
+	case []byte:
+		return sameArray(v, data)
+	case json.RawMessage:
+		return sameArray(v, data)
+	}
+	return false
+}
+
+func (a *EncodeArena) grow() {
+	a.block = encodeBlockPool.Get().(*[]byte)
+	a.blocks = append(a.blocks, a.block)
+}
+
+// reader はdataを読み出すbytes.Readerを返します。割り当てを減らすため、まとめて確保したスライスから取り出します。
+func (a *EncodeArena) reader(data []byte) *bytes.Reader {
+	if len(a.readers) == cap(a.readers) {
+		a.readers = make([]bytes.Reader, 0, 256)
+	}
+	a.readers = append(a.readers, bytes.Reader{})
+	r := &a.readers[len(a.readers)-1]
+	r.Reset(data)
+	return r
+}
+
+// Release はブロックとjson.Encoderをプールに戻します。BulkIndexerを閉じ、リトライを終えた後に呼び出します。
+func (a *EncodeArena) Release() {
+	for _, block := range a.blocks {
+		*block = (*block)[:0]
+		encodeBlockPool.Put(block)
+	}
+	if a.json != nil {
+		jsonEncoderPool.Put(a.json)
+	}
+	a.blocks, a.block, a.readers, a.json = nil, nil, nil, nil
+}
@@ -0,0 +1,212 @@
+package esbulk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// movie はコード生成した型を想定した、JSONAppenderを実装するドキュメントです。
+type movie struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Genres      []string `json:"genres"`
+	VoteAverage float64  `json:"vote_average"`
+	VoteCount   int64    `json:"vote_count"`
+}
+
+func (m *movie) AppendJSON(dst []byte) ([]byte, error) {
+	dst = append(dst, `{"id":`...)
+	dst = AppendJSONString(dst, m.ID)
+	dst = append(dst, `,"title":`...)
+	dst = AppendJSONString(dst, m.Title)
+	dst = append(dst, `,"genres":[`...)
+	for i, genre := range m.Genres {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = AppendJSONString(dst, genre)
+	}
+	dst = append(dst, `],"vote_average":`...)
+	dst = strconv.AppendFloat(dst, m.VoteAverage, 'f', -1, 64)
+	dst = append(dst, `,"vote_count":`...)
+	dst = strconv.AppendInt(dst, m.VoteCount, 10)
+	return append(dst, '}'), nil
+}
+
+func movies(n int) []*movie {
+	movies := make([]*movie, n)
+	for i := range movies {
+		movies[i] = &movie{
+			ID:          strconv.Itoa(i + 1),
+			Title:       fmt.Sprintf("Movie <%d> \"&\" 映画", i+1),
+			Genres:      []string{"Drama", "Comedy"}[:1+i%2],
+			VoteAverage: float64(i%100) / 10,
+			VoteCount:   int64(i * 37),
+		}
+	}
+	return movies
+}
+
+func decode(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+	return v
+}
+
+func TestEncoders(t *testing.T) {
+	for _, m := range movies(50) {
+		want, _ := json.Marshal(m)
+		got, err := JSONEncoder{}.Encode(nil, m)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("JSONEncoder: got %s, %v; want %s", got, err, want)
+		}
+		got, err = CodecEncoder{}.Encode(nil, m)
+		if err != nil || !reflect.DeepEqual(decode(t, got), decode(t, want)) {
+			t.Fatalf("CodecEncoder: got %s, %v; want %s", got, err, want)
+		}
+	}
+	if _, err := (JSONEncoder{}).Encode(nil, func() {}); err == nil {
+		t.Error("expected an error for a document that cannot be encoded")
+	}
+
+	for _, s := range []string{"", `"quoted" \ back`, "tab\tnew\nline\r\x00\x1f", "日本語 🎬 café", "bad \xff utf8", "<html>&amp;"} {
+		var got string
+		if err := json.Unmarshal(AppendJSONString(nil, s), &got); err != nil {
+			t.Fatalf("AppendJSONString(%q) is not valid JSON: %v", s, err)
+		}
+		var want string
+		data, _ := json.Marshal(s)
+		_ = json.Unmarshal(data, &want)
+		if got != want {
+			t.Errorf("AppendJSONString(%q) decoded to %q, want %q", s, got, want)
+		}
+	}
+
+	raw := []byte(`{"title":"raw"}`)
+	got, err := RawEncoder{}.Encode(make([]byte, 0, 64), raw)
+	if err != nil || &got[0] != &raw[0] {
+		t.Errorf("expected RawEncoder to return the document without copying, got %s, %v", got, err)
+	}
+	if _, err := (RawEncoder{}).Encode(nil, map[string]interface{}{}); err == nil {
+		t.Error("expected an error for a document that is not encoded")
+	}
+	if _, err := (CodecEncoder{}).Encode(nil, map[string]interface{}{}); err == nil {
+		t.Error("expected an error for a document without AppendJSON")
+	}
+}
+
+func TestEncodeArena(t *testing.T) {
+	arena := NewEncodeArena(nil)
+	defer arena.Release()
+
+	// ブロックに収まらないドキュメントを含め、先にエンコードしたドキュメントが上書きされないことを確認します。
+	docs := make([]map[string]interface{}, 3000)
+	for i := range docs {
+		docs[i] = map[string]interface{}{"id": i, "title": fmt.Sprintf("Document %d", i)}
+	}
+	docs[1000]["content"] = strings.Repeat("x", 2*EncodeBlockSize)
+	bodies := make([]*bytes.Reader, len(docs))
+	for i, doc := range docs {
+		_, body, err := arena.Encode(doc)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		bodies[i] = body
+	}
+	if len(arena.blocks) < 2 {
+		t.Errorf("expected the documents to span several blocks, got %d", len(arena.blocks))
+	}
+	for i, body := range bodies {
+		got, _ := io.ReadAll(body)
+		want, _ := json.Marshal(docs[i])
+		if !bytes.Equal(got, want) {
+			t.Fatalf("document %d: got %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestEncodeArenaRawDocuments(t *testing.T) {
+	arena := NewEncodeArena(RawEncoder{})
+	defer arena.Release()
+
+	// エンコード済みのドキュメントはブロックの大きさにかかわらず、ブロックを使わずにそのまま送ります。
+	for _, raw := range [][]byte{[]byte(`{"id":1}`), bytes.Repeat([]byte("x"), 2*EncodeBlockSize)} {
+		data, body, err := arena.Encode(raw)
+		if err != nil || &data[0] != &raw[0] || body.Len() != len(raw) {
+			t.Fatalf("unexpected encoding: %v", err)
+		}
+	}
+	if len(arena.blocks) != 0 {
+		t.Errorf("expected no blocks for encoded documents, got %d", len(arena.blocks))
+	}
+
+	// 文字列はブロックにコピーします。
+	if _, _, err := arena.Encode(`{"id":2}`); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if _, _, err := arena.Encode(`{"id":3}`); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(arena.blocks) != 1 || len(*arena.block) != len(`{"id":3}`) {
+		t.Errorf("expected the second string to be copied into a single block, got %d blocks", len(arena.blocks))
+	}
+}
+
+// BenchmarkEncoders はBulk APIに渡すBodyを作るまでの、ドキュメントごとの割り当てを比較します。
+// go test -bench Encoders -benchmem で実行します。Elasticsearchは使用しません。
+func BenchmarkEncoders(b *testing.B) {
+	docs := movies(1000)
+	// 割り当てを計測しないよう、あらかじめinterface{}にしておきます。
+	values := make([]interface{}, len(docs))
+	raws := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = doc
+		raws[i], _ = json.Marshal(doc)
+	}
+
+	// json.Marshalしてからstrings.NewReader(string(data))で読み出す、以前の方法です。
+	b.Run("MarshalString", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, doc := range docs {
+				data, err := json.Marshal(doc)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = strings.NewReader(string(data))
+			}
+		}
+	})
+
+	for _, bench := range []struct {
+		name    string
+		encoder Encoder
+		docs    []interface{}
+	}{
+		{"JSONEncoder", JSONEncoder{}, values},
+		{"RawEncoder", RawEncoder{}, raws},
+		{"CodecEncoder", CodecEncoder{}, values},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				arena := NewEncodeArena(bench.encoder)
+				for _, doc := range bench.docs {
+					if _, _, err := arena.Encode(doc); err != nil {
+						b.Fatal(err)
+					}
+				}
+				arena.Release()
+			}
+		})
+	}
+}
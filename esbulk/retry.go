@@ -0,0 +1,104 @@
+package esbulk
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// RetryPolicy はBulk APIでリトライ可能な失敗(429など)となったアイテムの再送方法を定義します。
+type RetryPolicy struct {
+	// MaxAttempts は最初の送信を含めた最大試行回数です。
+	MaxAttempts int
+	// InitialBackoff は1回目のリトライまでの待ち時間の上限です。
+	InitialBackoff time.Duration
+	// MaxBackoff は待ち時間の上限です。
+	MaxBackoff time.Duration
+	// MaxElapsed は登録処理全体にかけてよい時間です。0の場合は制限しません。
+	MaxElapsed time.Duration
+}
+
+// DefaultRetryPolicy はよく使われる設定のRetryPolicyを返します。
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		MaxElapsed:     time.Minute,
+	}
+}
+
+// Backoff はattempt回目の送信が失敗した後の待ち時間を返します。
+// 指数的に増える上限値から一様にランダムな値を選ぶ(full jitter)ことで、再送のタイミングを分散させます。
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.InitialBackoff
+	for i := 1; i < attempt && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if p.MaxBackoff > 0 && ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// Retry はpendingが0になるまで、Backoffだけ待ってからresendで残ったアイテムを再送します。
+// startedは登録処理を始めた時刻で、MaxElapsedを超える場合は待たずに戻ります。
+// 試行回数か時間予算を使い切った場合も、エラーは返さずに戻ります。残ったアイテムの扱いは呼び出し元が決めます。
+func (p RetryPolicy) Retry(ctx context.Context, started time.Time, pending func() int, resend func() error) error {
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		if pending() == 0 {
+			return nil
+		}
+
+		wait := p.Backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(started)+wait > p.MaxElapsed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if err := resend(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retryable はアイテムのstatusとエラーの種類から、時間をおいて再送すれば成功する可能性のある失敗かどうかを返します。
+// マッピングの不整合などの恒久的なエラーはリトライしません。
+func Retryable(status int, errType string) bool {
+	switch errType {
+	case "es_rejected_execution_exception", "circuit_breaking_exception":
+		return true
+	}
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// RetryItem は再送用にアイテムを複製します。
+// BulkIndexerItemは送信時に内部でメタデータを保持するため、公開フィールドのみをコピーします。
+func RetryItem(item esutil.BulkIndexerItem) esutil.BulkIndexerItem {
+	return esutil.BulkIndexerItem{
+		Index:           item.Index,
+		Action:          item.Action,
+		DocumentID:      item.DocumentID,
+		Routing:         item.Routing,
+		RequireAlias:    item.RequireAlias,
+		Version:         item.Version,
+		VersionType:     item.VersionType,
+		Body:            item.Body,
+		RetryOnConflict: item.RetryOnConflict,
+		IfSeqNo:         item.IfSeqNo,
+		IfPrimaryTerm:   item.IfPrimaryTerm,
+		OnSuccess:       item.OnSuccess,
+		OnFailure:       item.OnFailure,
+	}
+}
@@ -0,0 +1,134 @@
+package esbulk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 400 * time.Millisecond}
+	for attempt, ceiling := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		8: 400 * time.Millisecond,
+	} {
+		for i := 0; i < 100; i++ {
+			if got := policy.Backoff(attempt); got < 0 || got > ceiling {
+				t.Fatalf("Backoff(%d) = %s, want within [0, %s]", attempt, got, ceiling)
+			}
+		}
+	}
+
+	// 待ち時間を分散させるため、同じ試行回数でも毎回同じ値にはなりません。
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		seen[policy.Backoff(3)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected jittered backoffs, got %v", seen)
+	}
+
+	if got := (RetryPolicy{}).Backoff(3); got != 0 {
+		t.Errorf("expected no backoff without InitialBackoff, got %s", got)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		status  int
+		errType string
+		want    bool
+	}{
+		{429, "es_rejected_execution_exception", true},
+		{429, "", true},
+		{503, "unavailable_shards_exception", true},
+		{500, "circuit_breaking_exception", true},
+		{400, "mapper_parsing_exception", false},
+		{409, "version_conflict_engine_exception", false},
+		{500, "http_error", false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.status, tt.errType); got != tt.want {
+			t.Errorf("Retryable(%d, %q) = %v, want %v", tt.status, tt.errType, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	// 残りがなくなった時点で再送をやめます。
+	remaining, resends := 5, 0
+	err := policy.Retry(context.Background(), time.Now(), func() int { return remaining }, func() error {
+		resends++
+		remaining -= 2
+		if remaining < 0 {
+			remaining = 0
+		}
+		return nil
+	})
+	if err != nil || resends != 3 || remaining != 0 {
+		t.Errorf("got %d resends, %d remaining, %v", resends, remaining, err)
+	}
+
+	// 最初の送信を含めてMaxAttempts回までしか送信しません。
+	resends = 0
+	err = policy.Retry(context.Background(), time.Now(), func() int { return 1 }, func() error {
+		resends++
+		return nil
+	})
+	if err != nil || resends != 3 {
+		t.Errorf("expected 3 resends, got %d, %v", resends, err)
+	}
+
+	// 時間予算を超える場合は待たずに戻ります。
+	resends = 0
+	budget := RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Hour, MaxBackoff: time.Hour, MaxElapsed: time.Minute}
+	err = budget.Retry(context.Background(), time.Now().Add(-59*time.Second-999*time.Millisecond), func() int { return 1 }, func() error {
+		resends++
+		return nil
+	})
+	if err != nil || resends > 0 {
+		t.Errorf("expected no resend beyond the time budget, got %d, %v", resends, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	if err := slow.Retry(ctx, time.Now(), func() int { return 1 }, func() error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	errResend := errors.New("resend failed")
+	if err := policy.Retry(context.Background(), time.Now(), func() int { return 1 }, func() error { return errResend }); !errors.Is(err, errResend) {
+		t.Errorf("expected the resend error, got %v", err)
+	}
+}
+
+func TestRetryItem(t *testing.T) {
+	var called bool
+	item := esutil.BulkIndexerItem{
+		Index:      "movies",
+		Action:     "update",
+		DocumentID: "1",
+		Routing:    "user-1",
+		Body:       strings.NewReader(`{"doc":{}}`),
+		OnSuccess: func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem) {
+			called = true
+		},
+	}
+	retry := RetryItem(item)
+	if retry.Index != item.Index || retry.Action != item.Action || retry.DocumentID != item.DocumentID || retry.Routing != item.Routing || retry.Body != item.Body {
+		t.Errorf("unexpected copy: %+v", retry)
+	}
+	retry.OnSuccess(context.Background(), retry, esutil.BulkIndexerResponseItem{})
+	if !called {
+		t.Error("expected the callbacks to be kept")
+	}
+}
@@ -0,0 +1,232 @@
+package esbulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Telemetry はBulk APIのフラッシュのスパンとメトリクスを記録します。
+// 各メソッドは複数のgoroutineから呼び出せます。
+type Telemetry struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+
+	tracer        trace.Tracer
+	indexed       metric.Int64Counter
+	failed        metric.Int64Counter
+	bytes         metric.Int64Counter
+	flushDuration metric.Float64Histogram
+}
+
+// NewTelemetry はtpとmpで記録するTelemetryを作成します。nameは計装スコープ名で、呼び出し元のモジュールのパスを指定します。
+func NewTelemetry(name string, tp trace.TracerProvider, mp metric.MeterProvider) *Telemetry {
+	t := &Telemetry{
+		tracerProvider: tp,
+		meterProvider:  mp,
+		tracer:         tp.Tracer(name),
+	}
+	if err := t.createInstruments(mp.Meter(name)); err != nil {
+		// 計装の失敗で投入を止めないよう、エラーはOpenTelemetryのエラーハンドラに渡してメトリクスを記録しません。
+		otel.Handle(err)
+		_ = t.createInstruments(metricnoop.NewMeterProvider().Meter(name))
+	}
+	return t
+}
+
+// TracerProvider はスパンを記録するTracerProviderを返します。
+func (t *Telemetry) TracerProvider() trace.TracerProvider {
+	return t.tracerProvider
+}
+
+// MeterProvider はメトリクスを記録するMeterProviderを返します。
+func (t *Telemetry) MeterProvider() metric.MeterProvider {
+	return t.meterProvider
+}
+
+func (t *Telemetry) createInstruments(meter metric.Meter) error {
+	var err, e error
+	t.indexed, e = meter.Int64Counter("elasticsearch.bulk.documents.indexed",
+		metric.WithUnit("{document}"),
+		metric.WithDescription("Number of documents successfully written by bulk requests."))
+	err = errors.Join(err, e)
+	t.failed, e = meter.Int64Counter("elasticsearch.bulk.documents.failed",
+		metric.WithUnit("{document}"),
+		metric.WithDescription("Number of documents rejected by bulk requests, counted on every attempt."))
+	err = errors.Join(err, e)
+	t.bytes, e = meter.Int64Counter("elasticsearch.bulk.bytes",
+		metric.WithUnit("By"),
+		metric.WithDescription("Size of the document bodies added to bulk requests."))
+	err = errors.Join(err, e)
+	t.flushDuration, e = meter.Float64Histogram("elasticsearch.bulk.flush.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of bulk request flushes."))
+	return errors.Join(err, e)
+}
+
+// flushStateKey はフラッシュ中の集計をcontextに格納するためのキーです。
+type flushStateKey struct{}
+
+// flushState は1回のフラッシュで処理したアイテムの集計です。
+// フラッシュ中のコールバックはすべて同じワーカーのgoroutineから順に呼ばれるため、ロックせずに更新します。
+type flushState struct {
+	start     time.Time
+	succeeded int64
+	failed    map[string]int64
+	err       error
+}
+
+func flushStateFrom(ctx context.Context) *flushState {
+	state, _ := ctx.Value(flushStateKey{}).(*flushState)
+	return state
+}
+
+// Instrument はBulkIndexerがフラッシュするたびにスパンとメトリクスを記録するよう、cfgのコールバックを設定します。
+// ワーカーのcontextには呼び出し元のスパンが含まれないため、parentのスパンを親とします。
+// 空のバッファのフラッシュを記録しないよう、スパンはフラッシュの終了時に開始時刻を指定して作成します。
+// cfgにすでに設定されているコールバックは、そのまま呼び出されます。
+func (t *Telemetry) Instrument(cfg esutil.BulkIndexerConfig, parent trace.SpanContext) esutil.BulkIndexerConfig {
+	attrs := []attribute.KeyValue{attribute.String("db.system", "elasticsearch")}
+	if cfg.Index != "" {
+		attrs = append(attrs, attribute.String("elasticsearch.index", cfg.Index))
+	}
+	if cfg.Pipeline != "" {
+		attrs = append(attrs, attribute.String("elasticsearch.pipeline", cfg.Pipeline))
+	}
+	set := attribute.NewSet(attrs...)
+
+	onFlushStart, onFlushEnd, onError := cfg.OnFlushStart, cfg.OnFlushEnd, cfg.OnError
+	cfg.OnFlushStart = func(ctx context.Context) context.Context {
+		if onFlushStart != nil {
+			ctx = onFlushStart(ctx)
+		}
+		return context.WithValue(ctx, flushStateKey{}, &flushState{start: time.Now(), failed: make(map[string]int64)})
+	}
+	cfg.OnError = func(ctx context.Context, err error) {
+		if state := flushStateFrom(ctx); state != nil {
+			state.err = err
+		}
+		if onError != nil {
+			onError(ctx, err)
+		}
+	}
+	cfg.OnFlushEnd = func(ctx context.Context) {
+		spanCtx := ctx
+		if parent.IsValid() {
+			spanCtx = trace.ContextWithSpanContext(ctx, parent)
+		}
+		t.flushEnd(spanCtx, set)
+		if onFlushEnd != nil {
+			onFlushEnd(ctx)
+		}
+	}
+	return cfg
+}
+
+func (t *Telemetry) flushEnd(ctx context.Context, set attribute.Set) {
+	state := flushStateFrom(ctx)
+	if state == nil || (state.succeeded == 0 && len(state.failed) == 0 && state.err == nil) {
+		return
+	}
+	end := time.Now()
+
+	var failed int64
+	for errType, n := range state.failed {
+		failed += n
+		t.failed.Add(ctx, n, metric.WithAttributeSet(set), metric.WithAttributes(attribute.String("error.type", errType)))
+	}
+	t.indexed.Add(ctx, state.succeeded, metric.WithAttributeSet(set))
+	t.flushDuration.Record(ctx, end.Sub(state.start).Seconds(), metric.WithAttributeSet(set))
+
+	_, span := t.tracer.Start(ctx, "elasticsearch.bulk.flush",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(state.start),
+		trace.WithAttributes(set.ToSlice()...),
+		trace.WithAttributes(
+			attribute.Int64("elasticsearch.bulk.succeeded", state.succeeded),
+			attribute.Int64("elasticsearch.bulk.failed", failed),
+		),
+	)
+	if state.err != nil {
+		span.RecordError(state.err)
+		span.SetStatus(codes.Error, state.err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// InstrumentItem はアイテムの成否をフラッシュの集計に加えるよう、コールバックを包みます。
+// 元のコールバックには包む前のコールバックを持つアイテムを渡すため、再送するアイテムが二重に包まれることはありません。
+func (t *Telemetry) InstrumentItem(ctx context.Context, item esutil.BulkIndexerItem, index string) esutil.BulkIndexerItem {
+	t.bytes.Add(ctx, int64(ItemSize(item)), metric.WithAttributes(
+		attribute.String("db.system", "elasticsearch"),
+		attribute.String("elasticsearch.index", ItemIndex(item, index)),
+	))
+
+	onSuccess, onFailure := item.OnSuccess, item.OnFailure
+	unwrap := func(item esutil.BulkIndexerItem) esutil.BulkIndexerItem {
+		item.OnSuccess, item.OnFailure = onSuccess, onFailure
+		return item
+	}
+	item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+		if state := flushStateFrom(ctx); state != nil {
+			state.succeeded++
+		}
+		if onSuccess != nil {
+			onSuccess(ctx, unwrap(item), res)
+		}
+	}
+	item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		errType := res.Error.Type
+		if err != nil {
+			errType = "client_error"
+		} else if errType == "" {
+			errType = "_OTHER"
+		}
+		if state := flushStateFrom(ctx); state != nil {
+			state.failed[errType]++
+		} else {
+			// フラッシュの前にBulkIndexerが拒否したアイテムです。
+			t.failed.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("db.system", "elasticsearch"),
+				attribute.String("elasticsearch.index", ItemIndex(item, index)),
+				attribute.String("error.type", errType),
+			))
+		}
+		if onFailure != nil {
+			onFailure(ctx, unwrap(item), res, err)
+		}
+	}
+	return item
+}
+
+// ItemIndex はアイテムの書き込み先のインデックスを返します。アイテムにない場合はindexを返します。
+func ItemIndex(item esutil.BulkIndexerItem, index string) string {
+	if item.Index != "" {
+		return item.Index
+	}
+	return index
+}
+
+// ItemSize はアイテムのボディのバイト数を返します。
+func ItemSize(item esutil.BulkIndexerItem) int {
+	if item.Body == nil {
+		return 0
+	}
+	size, err := item.Body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0
+	}
+	if _, err := item.Body.Seek(0, io.SeekStart); err != nil {
+		return 0
+	}
+	return int(size)
+}
@@ -0,0 +1,182 @@
+package esbulk
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// collectMetrics はreaderから収集したメトリクスを名前ごとに返します。
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("failed to collect metrics: %v", err)
+	}
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func sumOf(t *testing.T, data metricdata.Aggregation) map[string]int64 {
+	t.Helper()
+	sum, ok := data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("expected an int64 sum, got %T", data)
+	}
+	totals := map[string]int64{}
+	for _, dp := range sum.DataPoints {
+		errType, _ := dp.Attributes.Value("error.type")
+		totals[errType.AsString()] += dp.Value
+	}
+	return totals
+}
+
+// instrumentedBulk はtelemetryで計装したBulkIndexerで3件のアイテムをtransportに送信します。
+func instrumentedBulk(t *testing.T, telemetry *Telemetry, parent trace.SpanContext, transport transportFunc) {
+	t.Helper()
+	indexer, err := esutil.NewBulkIndexer(Itemize(telemetry.Instrument(esutil.BulkIndexerConfig{
+		Client:     transport,
+		Index:      "movies",
+		NumWorkers: 1,
+	}, parent)))
+	if err != nil {
+		t.Fatalf("failed to create bulk indexer: %v", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		item := esutil.BulkIndexerItem{Action: "index", DocumentID: id, Body: strings.NewReader(`{"title":"Movie"}`)}
+		if err := indexer.Add(context.Background(), telemetry.InstrumentItem(context.Background(), item, "movies")); err != nil {
+			t.Fatalf("failed to add item: %v", err)
+		}
+	}
+	if err := indexer.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close bulk indexer: %v", err)
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	telemetry := NewTelemetry("test", tp, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	_, parent := tp.Tracer("test").Start(context.Background(), "load")
+	instrumentedBulk(t, telemetry, parent.SpanContext(), func(*http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, `{"errors":true,"items":[
+			{"index":{"_id":"1","status":201,"result":"created"}},
+			{"index":{"_id":"2","status":201,"result":"created"}},
+			{"index":{"_id":"3","status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected"}}}]}`), nil
+	})
+	parent.End()
+
+	var flushes int
+	for _, span := range spans.Ended() {
+		if span.Name() != "elasticsearch.bulk.flush" {
+			continue
+		}
+		flushes++
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected the flush span to be a child of the caller's span")
+		}
+		if span.SpanKind() != trace.SpanKindClient || span.Status().Code == codes.Error {
+			t.Errorf("unexpected span: %v, %+v", span.SpanKind(), span.Status())
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		if v, _ := attrs.Value("elasticsearch.index"); v.AsString() != "movies" {
+			t.Errorf("unexpected index attribute: %v", v)
+		}
+		succeeded, _ := attrs.Value("elasticsearch.bulk.succeeded")
+		failed, _ := attrs.Value("elasticsearch.bulk.failed")
+		if succeeded.AsInt64() != 2 || failed.AsInt64() != 1 {
+			t.Errorf("expected 2 successes and 1 failure, got %d and %d", succeeded.AsInt64(), failed.AsInt64())
+		}
+	}
+	if flushes != 1 {
+		t.Fatalf("expected 1 flush span, got %d", flushes)
+	}
+
+	metrics := collectMetrics(t, reader)
+	if got := sumOf(t, metrics["elasticsearch.bulk.documents.indexed"]); got[""] != 2 {
+		t.Errorf("indexed = %v", got)
+	}
+	if got := sumOf(t, metrics["elasticsearch.bulk.documents.failed"]); got["es_rejected_execution_exception"] != 1 {
+		t.Errorf("failed = %v", got)
+	}
+	if got := sumOf(t, metrics["elasticsearch.bulk.bytes"]); got[""] != 3*int64(len(`{"title":"Movie"}`)) {
+		t.Errorf("bytes = %v", got)
+	}
+	hist, ok := metrics["elasticsearch.bulk.flush.duration"].(metricdata.Histogram[float64])
+	if !ok || len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 1 {
+		t.Fatalf("unexpected flush duration: %+v", metrics["elasticsearch.bulk.flush.duration"])
+	}
+	if v, _ := hist.DataPoints[0].Attributes.Value("elasticsearch.index"); v.AsString() != "movies" {
+		t.Errorf("unexpected attributes: %v", hist.DataPoints[0].Attributes)
+	}
+}
+
+func TestTelemetryRecordsRequestErrors(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	telemetry := NewTelemetry("test",
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	instrumentedBulk(t, telemetry, trace.SpanContext{}, func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected 1 flush span, got %d", len(ended))
+	}
+	if status := ended[0].Status(); status.Code != codes.Error || status.Description != "flush: connection refused" {
+		t.Errorf("unexpected span status: %+v", status)
+	}
+	if got := sumOf(t, collectMetrics(t, reader)["elasticsearch.bulk.documents.failed"]); got["transport_error"] != 3 {
+		t.Errorf("failed = %v", got)
+	}
+}
+
+func TestTelemetryRecordsFailuresOutsideFlushes(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	telemetry := NewTelemetry("test", sdktrace.NewTracerProvider(), sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	var called bool
+	item := telemetry.InstrumentItem(context.Background(), esutil.BulkIndexerItem{
+		Index: "books",
+		OnFailure: func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem, error) {
+			called = true
+		},
+	}, "movies")
+	item.OnFailure(context.Background(), item, esutil.BulkIndexerResponseItem{}, errors.New("body too large"))
+
+	if !called {
+		t.Errorf("expected the original OnFailure to be called")
+	}
+	sum := collectMetrics(t, reader)["elasticsearch.bulk.documents.failed"].(metricdata.Sum[int64])
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 1 {
+		t.Fatalf("unexpected failures: %+v", sum)
+	}
+	attrs := sum.DataPoints[0].Attributes
+	if index, _ := attrs.Value("elasticsearch.index"); index.AsString() != "books" {
+		t.Errorf("expected the item's index, got %v", attrs)
+	}
+	if errType, _ := attrs.Value("error.type"); errType.AsString() != "client_error" {
+		t.Errorf("expected a client error, got %v", attrs)
+	}
+}
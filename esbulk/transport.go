@@ -0,0 +1,139 @@
+package esbulk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// ResultRequestFailed はItemizingTransportがリクエスト単位の失敗から作成したアイテムのresultです。
+// BulkIndexerResponseItem.Resultで、アイテム自体の失敗と区別できます。
+const ResultRequestFailed = "request_failed"
+
+// RequestError はBulk APIのリクエスト全体の失敗です。
+// ItemizingTransportはOnErrorにこのエラーを渡し、同じ失敗をアイテムごとの失敗としても返します。
+type RequestError struct {
+	// Status はレスポンスのステータスです。レスポンスを受け取れなかった場合は0です。
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *RequestError) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("flush: %s", e.Reason)
+	}
+	return fmt.Sprintf("flush: [%d] %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// IsRequestError はerrがリクエスト全体の失敗かどうかを返します。
+// アイテムごとに失敗を集計している場合、このエラーは集計済みのため、別のエラーとして数えずに済みます。
+func IsRequestError(err error) bool {
+	var e *RequestError
+	return errors.As(err, &e)
+}
+
+// IsRequestFailure はresがリクエスト全体の失敗から作成したアイテムかどうかを返します。
+func IsRequestFailure(res esutil.BulkIndexerResponseItem) bool {
+	return res.Result == ResultRequestFailed
+}
+
+// Itemize はcfgのBulkIndexerが、リクエスト単位で失敗したアイテムにもOnFailureを呼び出すようにします。
+// OnErrorの設定を済ませた後に呼び出す必要があります。
+func Itemize(cfg esutil.BulkIndexerConfig) esutil.BulkIndexerConfig {
+	cfg.Client = &ItemizingTransport{Base: cfg.Client, OnError: cfg.OnError}
+	return cfg
+}
+
+// ItemizingTransport はBulk APIのリクエスト単位の失敗を、すべてのアイテムが同じエラーで失敗したレスポンスに置き換えます。
+// BulkIndexerはリクエスト単位の失敗ではアイテムのOnFailureを呼ばず、失敗した件数を数えてOnErrorを呼ぶだけのため、
+// どのドキュメントが失敗したかわかりません。置き換えたレスポンスではアイテムごとにOnFailureが呼ばれます。
+type ItemizingTransport struct {
+	Base esapi.Transport
+	// OnError はリクエスト単位で失敗したときに、*RequestErrorを渡して1度だけ呼び出します。
+	OnError func(context.Context, error)
+}
+
+func (t *ItemizingTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	res, err := t.Base.Perform(req)
+	if err != nil {
+		return t.itemize(req.Context(), body, &RequestError{Type: "transport_error", Reason: err.Error()})
+	}
+	if res.StatusCode < http.StatusMultipleChoices {
+		return res, nil
+	}
+	defer res.Body.Close()
+	var e struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	reqErr := &RequestError{Status: res.StatusCode, Type: "http_error", Reason: http.StatusText(res.StatusCode)}
+	if json.NewDecoder(res.Body).Decode(&e) == nil && e.Error.Type != "" {
+		reqErr.Type, reqErr.Reason = e.Error.Type, e.Error.Reason
+	}
+	return t.itemize(req.Context(), body, reqErr)
+}
+
+// itemize はOnErrorを呼び出し、bodyのアクションごとにreqErrで失敗したアイテムを並べたBulk APIのレスポンスを作成します。
+func (t *ItemizingTransport) itemize(ctx context.Context, body []byte, reqErr *RequestError) (*http.Response, error) {
+	if t.OnError != nil {
+		t.OnError(ctx, reqErr)
+	}
+
+	var items []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		var meta map[string]struct {
+			ID    string `json:"_id"`
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse bulk request: %w", err)
+		}
+		for action, m := range meta {
+			items = append(items, map[string]interface{}{action: map[string]interface{}{
+				"_id":    m.ID,
+				"_index": m.Index,
+				"status": reqErr.Status,
+				"result": ResultRequestFailed,
+				"error":  map[string]interface{}{"type": reqErr.Type, "reason": reqErr.Reason},
+			}})
+			if action != "delete" {
+				// ドキュメント行を読み飛ばします。
+				scanner.Scan()
+			}
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"errors": true, "items": items})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}, nil
+}
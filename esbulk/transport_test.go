@@ -0,0 +1,135 @@
+package esbulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// transportFunc は関数をesapi.Transportとして使います。
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) Perform(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// bulkWith はtransportで3件のアイテムを送信し、OnFailureとOnErrorに渡された内容を返します。
+func bulkWith(t *testing.T, transport transportFunc) ([]esutil.BulkIndexerResponseItem, []error, esutil.BulkIndexerStats) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		failures []esutil.BulkIndexerResponseItem
+		errs     []error
+	)
+	indexer, err := esutil.NewBulkIndexer(Itemize(esutil.BulkIndexerConfig{
+		Client:     transport,
+		Index:      "movies",
+		NumWorkers: 1,
+		OnError: func(_ context.Context, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	}))
+	if err != nil {
+		t.Fatalf("failed to create bulk indexer: %v", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		action := "index"
+		if id == "3" {
+			action = "delete"
+		}
+		item := esutil.BulkIndexerItem{
+			Action:     action,
+			DocumentID: id,
+			OnFailure: func(_ context.Context, _ esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failures = append(failures, res)
+			},
+		}
+		if action != "delete" {
+			item.Body = strings.NewReader(`{"title":"Movie"}`)
+		}
+		if err := indexer.Add(context.Background(), item); err != nil {
+			t.Fatalf("failed to add item: %v", err)
+		}
+	}
+	if err := indexer.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close bulk indexer: %v", err)
+	}
+	return failures, errs, indexer.Stats()
+}
+
+func TestItemizingTransport(t *testing.T) {
+	failures, errs, stats := bulkWith(t, func(*http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusTooManyRequests, `{"error":{"type":"es_rejected_execution_exception","reason":"rejected"},"status":429}`), nil
+	})
+	if len(failures) != 3 || stats.NumFailed != 3 {
+		t.Fatalf("expected every item to fail, got %d failures and %+v", len(failures), stats)
+	}
+	for _, res := range failures {
+		if res.Status != http.StatusTooManyRequests || res.Error.Type != "es_rejected_execution_exception" || !IsRequestFailure(res) {
+			t.Errorf("unexpected item: %+v", res)
+		}
+		if !Retryable(res.Status, res.Error.Type) {
+			t.Errorf("expected a rejected request to be retryable: %+v", res)
+		}
+	}
+	if len(errs) != 1 || !IsRequestError(errs[0]) || !strings.Contains(errs[0].Error(), "429") {
+		t.Errorf("expected OnError to be called once with the request error, got %v", errs)
+	}
+
+	failures, errs, _ = bulkWith(t, func(*http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusInternalServerError, `not json`), nil
+	})
+	if len(failures) != 3 || failures[0].Error.Type != "http_error" || failures[0].Error.Reason != "Internal Server Error" {
+		t.Errorf("unexpected failures: %+v", failures)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected one request error, got %v", errs)
+	}
+
+	failures, errs, _ = bulkWith(t, func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+	if len(failures) != 3 || failures[0].Status != 0 || failures[0].Error.Type != "transport_error" || failures[0].Error.Reason != "connection refused" {
+		t.Errorf("unexpected failures: %+v", failures)
+	}
+	if len(errs) != 1 || errs[0].Error() != "flush: connection refused" {
+		t.Errorf("unexpected request errors: %v", errs)
+	}
+}
+
+func TestItemizingTransportPassesResponsesThrough(t *testing.T) {
+	failures, errs, stats := bulkWith(t, func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if strings.Count(string(body), "\n") != 5 {
+			t.Errorf("unexpected request body: %s", body)
+		}
+		return jsonResponse(http.StatusOK, `{"errors":true,"items":[
+			{"index":{"_id":"1","status":201,"result":"created"}},
+			{"index":{"_id":"2","status":400,"error":{"type":"mapper_parsing_exception","reason":"bad"}}},
+			{"delete":{"_id":"3","status":200,"result":"deleted"}}]}`), nil
+	})
+	if len(errs) != 0 || stats.NumFlushed != 2 || len(failures) != 1 {
+		t.Fatalf("unexpected result: %v, %+v, %+v", errs, stats, failures)
+	}
+	if IsRequestFailure(failures[0]) || failures[0].Error.Type != "mapper_parsing_exception" {
+		t.Errorf("expected an item failure, got %+v", failures[0])
+	}
+}
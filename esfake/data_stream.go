@@ -0,0 +1,347 @@
+package esfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexTemplate はコンポーザブルインデックステンプレート(_index_template)です。
+type indexTemplate struct {
+	patterns   []string
+	dataStream bool
+	priority   int
+	settings   map[string]interface{}
+	mappings   map[string]interface{}
+	raw        json.RawMessage
+}
+
+// dataStream はデータストリームと、その世代ごとのバッキングインデックスです。最後のインデックスが書き込み先です。
+type dataStream struct {
+	template   string
+	indices    []string
+	generation int
+}
+
+// DataStream はデータストリームのスナップショットです。ドキュメントはIndexにバッキングインデックスの名前を指定して取得します。
+type DataStream struct {
+	Name string
+	// Indices は古い順のバッキングインデックスです。最後のインデックスが書き込み先です。
+	Indices    []string
+	Generation int
+}
+
+// DataStream は指定したデータストリームのスナップショットを返します。存在しない場合はfalseを返します。
+func (s *Server) DataStream(name string) (DataStream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.streams[name]
+	if !ok {
+		return DataStream{}, false
+	}
+	return DataStream{Name: name, Indices: append([]string(nil), ds.indices...), Generation: ds.generation}, true
+}
+
+func (s *Server) handleIndexTemplate(method string, segments []string, body []byte) (int, interface{}) {
+	if len(segments) != 1 {
+		return methodNotAllowed(method, "_index_template")
+	}
+	name := segments[0]
+	switch method {
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			IndexPatterns []string        `json:"index_patterns"`
+			DataStream    json.RawMessage `json:"data_stream"`
+			Priority      int             `json:"priority"`
+			Template      struct {
+				Settings map[string]interface{} `json:"settings"`
+				Mappings map[string]interface{} `json:"mappings"`
+			} `json:"template"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+		if len(req.IndexPatterns) == 0 {
+			return errorBody(http.StatusBadRequest, "action_request_validation_exception", "Validation Failed: 1: index patterns are missing;")
+		}
+		s.templates[name] = &indexTemplate{
+			patterns:   req.IndexPatterns,
+			dataStream: len(req.DataStream) > 0 && string(req.DataStream) != "null",
+			priority:   req.Priority,
+			settings:   req.Template.Settings,
+			mappings:   req.Template.Mappings,
+			raw:        append(json.RawMessage(nil), body...),
+		}
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+
+	case http.MethodHead, http.MethodGet:
+		tmpl, ok := s.templates[name]
+		if !ok {
+			return errorBody(http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("index template matching [%s] not found", name))
+		}
+		return http.StatusOK, map[string]interface{}{"index_templates": []interface{}{
+			map[string]interface{}{"name": name, "index_template": tmpl.raw},
+		}}
+
+	case http.MethodDelete:
+		if _, ok := s.templates[name]; !ok {
+			return errorBody(http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("index_template [%s] missing", name))
+		}
+		delete(s.templates, name)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+	return methodNotAllowed(method, "_index_template/"+name)
+}
+
+// matchTemplate はnameに一致するテンプレートのうち、優先度が最も高いものを返します。
+func (s *Server) matchTemplate(name string) (string, *indexTemplate) {
+	var (
+		bestName string
+		best     *indexTemplate
+	)
+	names := make([]string, 0, len(s.templates))
+	for n := range s.templates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		tmpl := s.templates[n]
+		for _, pattern := range tmpl.patterns {
+			if ok, _ := path.Match(pattern, name); ok && (best == nil || tmpl.priority > best.priority) {
+				bestName, best = n, tmpl
+				break
+			}
+		}
+	}
+	return bestName, best
+}
+
+// createDataStream はテンプレートからデータストリームと最初のバッキングインデックスを作成します。
+// 呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) createDataStream(name string) (*dataStream, error) {
+	tmplName, tmpl := s.matchTemplate(name)
+	if tmpl == nil || !tmpl.dataStream {
+		return nil, fmt.Errorf("no matching index template with data stream found for data stream [%s]", name)
+	}
+	ds := &dataStream{template: tmplName}
+	s.streams[name] = ds
+	s.rollDataStream(name, ds)
+	return ds, nil
+}
+
+// rollDataStream は次の世代のバッキングインデックスを作成し、書き込み先にします。
+func (s *Server) rollDataStream(name string, ds *dataStream) string {
+	ds.generation++
+	backing := fmt.Sprintf(".ds-%s-%s-%06d", name, time.Now().UTC().Format("2006.01.02"), ds.generation)
+	idx := newIndex(backing)
+	if tmpl := s.templates[ds.template]; tmpl != nil {
+		if tmpl.settings != nil {
+			applySettings(idx.settings, tmpl.settings)
+		}
+		if tmpl.mappings != nil {
+			idx.mappings = tmpl.mappings
+		}
+	}
+	s.indices[backing] = idx
+	ds.indices = append(ds.indices, backing)
+	return backing
+}
+
+// routeDataStream は書き込み先がデータストリーム(またはデータストリームのテンプレートに一致する名前)の場合に、
+// 書き込み先をバッキングインデックスに置き換えます。データストリームに書き込めない操作の場合はエラーの結果を返します。
+// 呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) routeDataStream(op *writeOp) *writeResult {
+	ds, ok := s.streams[op.index]
+	if !ok {
+		if _, exists := s.indices[op.index]; exists {
+			return nil
+		}
+		if _, tmpl := s.matchTemplate(op.index); tmpl == nil || !tmpl.dataStream {
+			return nil
+		}
+	}
+	if op.action != "create" {
+		res := failure(*op, http.StatusBadRequest, "illegal_argument_exception",
+			"only write ops with an op_type of create are allowed in data streams")
+		return &res
+	}
+	var doc map[string]interface{}
+	if json.Unmarshal(op.body, &doc) != nil || doc["@timestamp"] == nil {
+		res := failure(*op, http.StatusBadRequest, "document_parsing_exception",
+			"data stream timestamp field [@timestamp] is missing")
+		return &res
+	}
+	if !ok {
+		// 一致するテンプレートがある場合、最初の書き込みでデータストリームを作成します。
+		ds, _ = s.createDataStream(op.index)
+	}
+	op.index = ds.indices[len(ds.indices)-1]
+	return nil
+}
+
+// expandDataStreams はnamesに含まれるデータストリームを、そのバッキングインデックスに置き換えます。
+// 呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) expandDataStreams(names []string) []string {
+	expanded := make([]string, 0, len(names))
+	for _, name := range names {
+		if ds, ok := s.streams[name]; ok {
+			expanded = append(expanded, ds.indices...)
+			continue
+		}
+		expanded = append(expanded, name)
+	}
+	return expanded
+}
+
+func (s *Server) handleDataStream(method, name string) (int, interface{}) {
+	switch method {
+	case http.MethodPut:
+		if _, ok := s.streams[name]; ok {
+			return errorBody(http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("data_stream [%s] already exists", name))
+		}
+		if _, err := s.createDataStream(name); err != nil {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		}
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+
+	case http.MethodGet:
+		ds, ok := s.streams[name]
+		if !ok {
+			return indexNotFound(name)
+		}
+		indices := make([]interface{}, len(ds.indices))
+		for i, backing := range ds.indices {
+			indices[i] = map[string]interface{}{"index_name": backing}
+		}
+		return http.StatusOK, map[string]interface{}{"data_streams": []interface{}{map[string]interface{}{
+			"name":            name,
+			"timestamp_field": map[string]interface{}{"name": "@timestamp"},
+			"indices":         indices,
+			"generation":      ds.generation,
+			"template":        ds.template,
+			"status":          "GREEN",
+		}}}
+
+	case http.MethodDelete:
+		ds, ok := s.streams[name]
+		if !ok {
+			return indexNotFound(name)
+		}
+		for _, backing := range ds.indices {
+			delete(s.indices, backing)
+		}
+		delete(s.streams, name)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+	return methodNotAllowed(method, "_data_stream/"+name)
+}
+
+// handleRollover はデータストリームのロールオーバーを処理します。
+// 条件(max_docs、max_age、max_size、max_primary_shard_size)を指定した場合は、書き込み先のインデックスが
+// いずれかを満たすときだけロールオーバーします。サイズはドキュメントのJSONのバイト数の合計で判定します。
+func (s *Server) handleRollover(name string, body []byte) (int, interface{}) {
+	ds, ok := s.streams[name]
+	if !ok {
+		return indexNotFound(name)
+	}
+	var req struct {
+		Conditions map[string]json.RawMessage `json:"conditions"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+	}
+
+	oldIndex := ds.indices[len(ds.indices)-1]
+	idx := s.indices[oldIndex]
+	var size int64
+	for _, doc := range idx.docs {
+		size += int64(len(doc.source))
+	}
+	results := map[string]interface{}{}
+	met := len(req.Conditions) == 0
+	for key, raw := range req.Conditions {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		var (
+			ok  bool
+			err error
+		)
+		switch key {
+		case "max_docs":
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 64)
+			ok = int64(len(idx.docs)) >= n
+		case "max_age":
+			var age time.Duration
+			age, err = parseTimeValue(value)
+			ok = time.Since(idx.created) >= age
+		case "max_size", "max_primary_shard_size":
+			var n int64
+			n, err = parseByteSize(value)
+			ok = size >= n
+		default:
+			err = fmt.Errorf("unknown condition [%s]", key)
+		}
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		}
+		results[fmt.Sprintf("[%s: %s]", key, value)] = ok
+		met = met || ok
+	}
+
+	newIndex := fmt.Sprintf(".ds-%s-%s-%06d", name, time.Now().UTC().Format("2006.01.02"), ds.generation+1)
+	if met {
+		newIndex = s.rollDataStream(name, ds)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"acknowledged":        met,
+		"shards_acknowledged": met,
+		"old_index":           oldIndex,
+		"new_index":           newIndex,
+		"rolled_over":         met,
+		"dry_run":             false,
+		"conditions":          results,
+	}
+}
+
+// parseTimeValue は"7d"や"12h"のようなElasticsearchの時間の表記を読み込みます。
+func parseTimeValue(v string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{{"ms", time.Millisecond}, {"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}}
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return time.Duration(i) * u.unit, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("failed to parse time value [%s]", v)
+}
+
+// parseByteSize は"50gb"や"512b"のようなElasticsearchのサイズの表記を読み込みます。
+func parseByteSize(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		unit   int64
+	}{{"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1}}
+	v = strings.ToLower(v)
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return i * u.unit, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("failed to parse byte size value [%s]", v)
+}
@@ -0,0 +1,374 @@
+package esfake
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// document はインデックスに保存されたドキュメントです。
+type document struct {
+	source      json.RawMessage
+	routing     string
+	version     int64
+	seqNo       int64
+	primaryTerm int64
+}
+
+// writeOp はBulk APIのアイテム、またはドキュメントAPIの1回の書き込みです。
+type writeOp struct {
+	action        string
+	index         string
+	id            string
+	routing       string
+	pipeline      string
+	body          json.RawMessage
+	ifSeqNo       *int64
+	ifPrimaryTerm *int64
+	version       *int64
+	versionType   string
+}
+
+// writeResult は書き込みの結果で、Bulk APIのアイテムのレスポンスと同じ形式です。
+type writeResult struct {
+	Index       string                 `json:"_index"`
+	ID          string                 `json:"_id"`
+	Version     int64                  `json:"_version,omitempty"`
+	Result      string                 `json:"result,omitempty"`
+	SeqNo       *int64                 `json:"_seq_no,omitempty"`
+	PrimaryTerm *int64                 `json:"_primary_term,omitempty"`
+	Shards      map[string]interface{} `json:"_shards,omitempty"`
+	Status      int                    `json:"status"`
+	Error       map[string]interface{} `json:"error,omitempty"`
+}
+
+func failure(op writeOp, status int, errType, reason string) writeResult {
+	return writeResult{
+		Index:  op.index,
+		ID:     op.id,
+		Status: status,
+		Error:  map[string]interface{}{"type": errType, "reason": reason},
+	}
+}
+
+func versionConflict(op writeOp, reason string) writeResult {
+	return failure(op, http.StatusConflict, "version_conflict_engine_exception",
+		fmt.Sprintf("[%s]: version conflict, %s", op.id, reason))
+}
+
+// write はアクションに応じて書き込みを行います。呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) write(op writeOp) writeResult {
+	if res := s.routeDataStream(&op); res != nil {
+		return *res
+	}
+	idx := s.ensureIndex(op.index)
+	if op.id == "" && (op.action == "index" || op.action == "create") {
+		s.autoID++
+		op.id = fmt.Sprintf("esfake-%d", s.autoID)
+	}
+	current := idx.docs[op.id]
+
+	if op.ifSeqNo != nil || op.ifPrimaryTerm != nil {
+		if current == nil {
+			return versionConflict(op, "required seqNo ["+formatPtr(op.ifSeqNo)+"], primary term ["+formatPtr(op.ifPrimaryTerm)+"] but no document was found")
+		}
+		if op.ifSeqNo == nil || op.ifPrimaryTerm == nil || *op.ifSeqNo != current.seqNo || *op.ifPrimaryTerm != current.primaryTerm {
+			return versionConflict(op, fmt.Sprintf("required seqNo [%s], primary term [%s]. current document has seqNo [%d] and primary term [%d]",
+				formatPtr(op.ifSeqNo), formatPtr(op.ifPrimaryTerm), current.seqNo, current.primaryTerm))
+		}
+	}
+
+	version := int64(1)
+	if current != nil {
+		version = current.version + 1
+	}
+	if op.version != nil && (op.versionType == "external" || op.versionType == "external_gte") {
+		if current != nil && (*op.version < current.version || (*op.version == current.version && op.versionType == "external")) {
+			return versionConflict(op, fmt.Sprintf("current version [%d] is higher or equal to the one provided [%d]", current.version, *op.version))
+		}
+		version = *op.version
+	}
+
+	switch op.action {
+	case "index", "create":
+		if op.action == "create" && current != nil {
+			return versionConflict(op, "document already exists (current version ["+strconv.FormatInt(current.version, 10)+"])")
+		}
+		if !json.Valid(op.body) {
+			return failure(op, http.StatusBadRequest, "mapper_parsing_exception", "failed to parse")
+		}
+		source, ierr := s.runPipeline(idx, op.pipeline, op.body)
+		if ierr != nil {
+			return failure(op, ierr.status, ierr.errType, ierr.reason)
+		}
+		result := "created"
+		if current != nil {
+			result = "updated"
+		}
+		return s.store(idx, op, source, version, result)
+
+	case "update":
+		return s.update(idx, op, current, version)
+
+	case "delete":
+		if current == nil {
+			return writeResult{Index: op.index, ID: op.id, Version: 1, Result: "not_found", Status: http.StatusNotFound}
+		}
+		delete(idx.docs, op.id)
+		idx.seqNo++
+		seqNo, primaryTerm := idx.seqNo, int64(1)
+		return writeResult{
+			Index: op.index, ID: op.id, Version: version, Result: "deleted",
+			SeqNo: &seqNo, PrimaryTerm: &primaryTerm, Shards: shards()["_shards"].(map[string]interface{}),
+			Status: http.StatusOK,
+		}
+	}
+	return failure(op, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("unknown action [%s]", op.action))
+}
+
+// update はupdate APIを再現します。docは既存のドキュメントに再帰的にマージします。
+// スクリプトは実行せず、ドキュメントが存在する場合は変更なし(noop)として扱います。
+func (s *Server) update(idx *index, op writeOp, current *document, version int64) writeResult {
+	req, err := decodeObject(op.body)
+	if err != nil {
+		return failure(op, http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	doc, _ := req["doc"].(map[string]interface{})
+
+	if current == nil {
+		var source interface{}
+		switch {
+		case req["doc_as_upsert"] == true && doc != nil:
+			source = doc
+		case req["upsert"] != nil:
+			source = req["upsert"]
+		default:
+			return failure(op, http.StatusNotFound, "document_missing_exception", fmt.Sprintf("[%s]: document missing", op.id))
+		}
+		data, _ := json.Marshal(source)
+		return s.store(idx, op, data, version, "created")
+	}
+
+	if doc == nil {
+		return s.result(op, current, "noop", http.StatusOK)
+	}
+	existing, err := decodeObject(current.source)
+	if err != nil {
+		return failure(op, http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	data, _ := json.Marshal(mergeObjects(existing, doc))
+	if bytes.Equal(data, current.source) {
+		return s.result(op, current, "noop", http.StatusOK)
+	}
+	return s.store(idx, op, data, version, "updated")
+}
+
+func (s *Server) store(idx *index, op writeOp, source json.RawMessage, version int64, result string) writeResult {
+	idx.seqNo++
+	doc := &document{
+		source:      append(json.RawMessage(nil), source...),
+		routing:     op.routing,
+		version:     version,
+		seqNo:       idx.seqNo,
+		primaryTerm: 1,
+	}
+	idx.docs[op.id] = doc
+
+	status := http.StatusOK
+	if result == "created" {
+		status = http.StatusCreated
+	}
+	return s.result(op, doc, result, status)
+}
+
+func (s *Server) result(op writeOp, doc *document, result string, status int) writeResult {
+	seqNo, primaryTerm := doc.seqNo, doc.primaryTerm
+	return writeResult{
+		Index:       op.index,
+		ID:          op.id,
+		Version:     doc.version,
+		Result:      result,
+		SeqNo:       &seqNo,
+		PrimaryTerm: &primaryTerm,
+		Shards:      shards()["_shards"].(map[string]interface{}),
+		Status:      status,
+	}
+}
+
+func formatPtr(v *int64) string {
+	if v == nil {
+		return "-2"
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+// handleDocument はドキュメント単位のAPI(_doc、_create、_update)を処理します。
+func (s *Server) handleDocument(method, endpoint, name, id string, q url.Values, body []byte) (int, interface{}) {
+	if endpoint == "_doc" && (method == http.MethodGet || method == http.MethodHead) {
+		return s.getDocument(name, id)
+	}
+
+	op := writeOp{index: name, id: id, routing: q.Get("routing"), pipeline: q.Get("pipeline"), body: body, versionType: q.Get("version_type")}
+	switch {
+	case endpoint == "_update" && method == http.MethodPost:
+		op.action = "update"
+	case endpoint == "_create" && (method == http.MethodPut || method == http.MethodPost):
+		op.action = "create"
+	case endpoint == "_doc" && method == http.MethodDelete:
+		op.action = "delete"
+	case endpoint == "_doc" && (method == http.MethodPut || method == http.MethodPost):
+		op.action = "index"
+		if q.Get("op_type") == "create" {
+			op.action = "create"
+		}
+	default:
+		return methodNotAllowed(method, name+"/"+endpoint)
+	}
+	if id == "" && op.action != "index" {
+		return errorBody(http.StatusBadRequest, "action_request_validation_exception", "Validation Failed: 1: id is missing;")
+	}
+
+	var err error
+	if op.ifSeqNo, err = parseInt(q, "if_seq_no"); err != nil {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	}
+	if op.ifPrimaryTerm, err = parseInt(q, "if_primary_term"); err != nil {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	}
+	if op.version, err = parseInt(q, "version"); err != nil {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	}
+
+	res := s.write(op)
+	if res.Error != nil {
+		return errorBody(res.Status, res.Error["type"].(string), res.Error["reason"].(string))
+	}
+	return res.Status, res
+}
+
+func (s *Server) getDocument(name, id string) (int, interface{}) {
+	idx, ok := s.indices[name]
+	if !ok {
+		return indexNotFound(name)
+	}
+	doc, ok := idx.docs[id]
+	if !ok {
+		return http.StatusNotFound, map[string]interface{}{"_index": name, "_id": id, "found": false}
+	}
+	res := map[string]interface{}{
+		"_index":        name,
+		"_id":           id,
+		"_version":      doc.version,
+		"_seq_no":       doc.seqNo,
+		"_primary_term": doc.primaryTerm,
+		"found":         true,
+		"_source":       doc.source,
+	}
+	if doc.routing != "" {
+		res["_routing"] = doc.routing
+	}
+	return http.StatusOK, res
+}
+
+func parseInt(q url.Values, key string) (*int64, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse [%s]: %w", key, err)
+	}
+	return &n, nil
+}
+
+// BulkItem は注入する障害を選ぶために、ItemFaultのMatchに渡されるBulk APIのアイテムです。
+type BulkItem struct {
+	// N はサーバーが受け取ったBulkのアイテムの通し番号(1始まり)です。
+	N      int
+	Action string
+	Index  string
+	ID     string
+	// Routing とPipeline は、アイテムまたはリクエストで指定されたルーティング値とパイプラインです。
+	Routing  string
+	Pipeline string
+	Source   json.RawMessage
+}
+
+type bulkMeta struct {
+	Index         string `json:"_index"`
+	ID            string `json:"_id"`
+	Routing       string `json:"routing"`
+	Pipeline      string `json:"pipeline"`
+	IfSeqNo       *int64 `json:"if_seq_no"`
+	IfPrimaryTerm *int64 `json:"if_primary_term"`
+	Version       *int64 `json:"version"`
+	VersionType   string `json:"version_type"`
+}
+
+// handleBulk はBulk APIを処理します。アイテム単位の障害が注入されている場合は、書き込まずにそのエラーを返します。
+// クエリのroutingとpipelineは、アイテムで指定されていない場合の既定値として使用します。
+func (s *Server) handleBulk(defaultIndex string, q url.Values, body []byte) (int, interface{}) {
+	var (
+		items     []map[string]writeResult
+		hasErrors bool
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var meta map[string]bulkMeta
+		if err := json.Unmarshal(line, &meta); err != nil || len(meta) != 1 {
+			return errorBody(http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Malformed action/metadata line: %s", line))
+		}
+		for action, m := range meta {
+			op := writeOp{
+				action:        action,
+				index:         m.Index,
+				id:            m.ID,
+				routing:       m.Routing,
+				pipeline:      m.Pipeline,
+				ifSeqNo:       m.IfSeqNo,
+				ifPrimaryTerm: m.IfPrimaryTerm,
+				version:       m.Version,
+				versionType:   m.VersionType,
+			}
+			if op.index == "" {
+				op.index = defaultIndex
+			}
+			if op.routing == "" {
+				op.routing = q.Get("routing")
+			}
+			if op.pipeline == "" {
+				op.pipeline = q.Get("pipeline")
+			}
+			if action != "delete" {
+				if !scanner.Scan() {
+					return errorBody(http.StatusBadRequest, "illegal_argument_exception", "The bulk request must be terminated by a newline [\\n]")
+				}
+				op.body = append(json.RawMessage(nil), scanner.Bytes()...)
+			}
+
+			s.bulkItems++
+			item := BulkItem{N: s.bulkItems, Action: action, Index: op.index, ID: op.id, Routing: op.routing, Pipeline: op.pipeline, Source: op.body}
+			var res writeResult
+			if fault := s.matchItemFault(item); fault != nil {
+				res = failure(op, fault.Status, fault.ErrorType, fault.Reason)
+			} else {
+				res = s.write(op)
+			}
+			if res.Error != nil {
+				hasErrors = true
+			}
+			items = append(items, map[string]writeResult{action: res})
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"took": 1, "errors": hasErrors, "items": items}
+}
@@ -0,0 +1,136 @@
+package esfake
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// Fault はリクエスト単位で注入する障害です。
+// 一致したリクエストは処理されず、StatusとErrorTypeのエラーレスポンスが返されます。
+type Fault struct {
+	// Method は対象のメソッドです。空の場合はすべてのメソッドに一致します。
+	Method string
+	// Path は対象のパスのpath.Matchのパターンです("/_bulk"、"/*/_search"など)。空の場合はすべてのパスに一致します。
+	Path string
+	// Skip は一致したリクエストのうち、障害を注入せずに通す最初の件数です。
+	Skip int
+	// Times は障害を注入する回数です。0の場合は無制限です。
+	Times int
+	// Status はレスポンスのステータスです。省略時は503です。
+	Status int
+	// ErrorType とReason はエラーレスポンスの内容です。
+	ErrorType string
+	Reason    string
+	// Delay はレスポンスを返す前に待つ時間です。タイムアウトの再現に使用します。
+	Delay time.Duration
+}
+
+// ItemFault はBulk APIのアイテム単位で注入する障害です。
+// 一致したアイテムは書き込まれず、StatusとErrorTypeのエラーがアイテムのレスポンスとして返されます。
+type ItemFault struct {
+	// Match は障害を注入するアイテムを選びます。nilの場合はすべてのアイテムに一致します。
+	Match func(BulkItem) bool
+	// Skip は一致したアイテムのうち、障害を注入せずに通す最初の件数です。
+	Skip int
+	// Times は障害を注入する回数です。0の場合は無制限です。
+	Times int
+	// Status はアイテムのステータスです。省略時は429です。
+	Status int
+	// ErrorType とReason はアイテムのエラーの内容です。省略時はes_rejected_execution_exceptionです。
+	ErrorType string
+	Reason    string
+}
+
+type faultState struct {
+	Fault
+	matched int
+}
+
+type itemFaultState struct {
+	ItemFault
+	matched int
+}
+
+// Inject はリクエスト単位の障害を追加します。複数の障害は追加した順に評価され、最初に注入できたものが使われます。
+func (s *Server) Inject(f Fault) {
+	if f.Status == 0 {
+		f.Status = http.StatusServiceUnavailable
+	}
+	if f.ErrorType == "" {
+		f.ErrorType = "unavailable_shards_exception"
+	}
+	if f.Reason == "" {
+		f.Reason = "injected fault"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &faultState{Fault: f})
+}
+
+// InjectItem はBulk APIのアイテム単位の障害を追加します。複数の障害は追加した順に評価されます。
+func (s *Server) InjectItem(f ItemFault) {
+	if f.Status == 0 {
+		f.Status = http.StatusTooManyRequests
+	}
+	if f.ErrorType == "" {
+		f.ErrorType = "es_rejected_execution_exception"
+	}
+	if f.Reason == "" {
+		f.Reason = "injected fault"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.itemFaults = append(s.itemFaults, &itemFaultState{ItemFault: f})
+}
+
+// active は一致した回数がSkipを超え、Times回に達していないかを返します。一致した回数も数えます。
+func active(matched *int, skip, times int) bool {
+	*matched++
+	n := *matched - skip
+	return n > 0 && (times == 0 || n <= times)
+}
+
+// matchFault はリクエストに注入する障害を返します。呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for _, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if f.Path != "" {
+			if ok, _ := path.Match(f.Path, r.URL.Path); !ok {
+				continue
+			}
+		}
+		if active(&f.matched, f.Skip, f.Times) {
+			fault := f.Fault
+			return &fault
+		}
+	}
+	return nil
+}
+
+// matchItemFault はアイテムに注入する障害を返します。呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) matchItemFault(item BulkItem) *ItemFault {
+	for _, f := range s.itemFaults {
+		if f.Match != nil && !f.Match(item) {
+			continue
+		}
+		if active(&f.matched, f.Skip, f.Times) {
+			fault := f.ItemFault
+			return &fault
+		}
+	}
+	return nil
+}
+
+func (f *Fault) serve(w http.ResponseWriter, r *http.Request) {
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	writeError(w, f.Status, f.ErrorType, f.Reason)
+}
@@ -0,0 +1,14 @@
+module github.com/kurakura967/go-elasticsearch-playground/esfake
+
+go 1.24.2
+
+require github.com/elastic/go-elasticsearch/v8 v8.18.1
+
+require (
+	github.com/elastic/elastic-transport-go/v8 v8.7.0 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel v1.28.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	go.opentelemetry.io/otel/trace v1.28.0 // indirect
+)
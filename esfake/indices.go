@@ -0,0 +1,285 @@
+package esfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// index はメモリ上のインデックスです。
+type index struct {
+	// settings は"index.refresh_interval"のようなドット区切りのキーで保持します。値はElasticsearchと同じく文字列です。
+	settings map[string]string
+	mappings map[string]interface{}
+	docs     map[string]*document
+	seqNo    int64
+	created  time.Time
+}
+
+func newIndex(name string) *index {
+	return &index{
+		settings: map[string]string{
+			"index.number_of_shards":   "1",
+			"index.number_of_replicas": "1",
+			"index.provided_name":      name,
+		},
+		mappings: map[string]interface{}{},
+		docs:     make(map[string]*document),
+		created:  time.Now(),
+	}
+}
+
+// Index はインデックスの内容のスナップショットです。
+type Index struct {
+	Settings  map[string]string
+	Mappings  map[string]interface{}
+	Documents map[string]json.RawMessage
+}
+
+// Index は指定したインデックスのスナップショットを返します。存在しない場合はfalseを返します。
+func (s *Server) Index(name string) (Index, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, ok := s.indices[name]
+	if !ok {
+		return Index{}, false
+	}
+	snapshot := Index{
+		Settings:  make(map[string]string, len(idx.settings)),
+		Mappings:  idx.mappings,
+		Documents: make(map[string]json.RawMessage, len(idx.docs)),
+	}
+	for k, v := range idx.settings {
+		snapshot.Settings[k] = v
+	}
+	for id, doc := range idx.docs {
+		snapshot.Documents[id] = append(json.RawMessage(nil), doc.source...)
+	}
+	return snapshot, true
+}
+
+// ensureIndex はドキュメントの書き込み時に、存在しないインデックスを自動で作成します。
+func (s *Server) ensureIndex(name string) *index {
+	idx, ok := s.indices[name]
+	if !ok {
+		idx = newIndex(name)
+		s.indices[name] = idx
+	}
+	return idx
+}
+
+func (s *Server) handleIndex(method, names string, q url.Values, body []byte) (int, interface{}) {
+	switch method {
+	case http.MethodPut:
+		if _, ok := s.indices[names]; ok {
+			return errorBody(http.StatusBadRequest, "resource_already_exists_exception",
+				fmt.Sprintf("index [%s] already exists", names))
+		}
+		req, err := decodeObject(body)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+		idx := newIndex(names)
+		if settings, ok := req["settings"].(map[string]interface{}); ok {
+			applySettings(idx.settings, settings)
+		}
+		if mappings, ok := req["mappings"].(map[string]interface{}); ok {
+			idx.mappings = mappings
+		}
+		s.indices[names] = idx
+		return http.StatusOK, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": names}
+
+	case http.MethodHead:
+		for _, name := range strings.Split(names, ",") {
+			if _, ok := s.indices[name]; !ok {
+				return http.StatusNotFound, nil
+			}
+		}
+		return http.StatusOK, nil
+
+	case http.MethodDelete:
+		for _, name := range strings.Split(names, ",") {
+			if _, ok := s.indices[name]; !ok && q.Get("ignore_unavailable") != "true" {
+				return indexNotFound(name)
+			}
+		}
+		for _, name := range strings.Split(names, ",") {
+			delete(s.indices, name)
+		}
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+
+	case http.MethodGet:
+		res := map[string]interface{}{}
+		for _, name := range strings.Split(names, ",") {
+			idx, ok := s.indices[name]
+			if !ok {
+				return indexNotFound(name)
+			}
+			res[name] = map[string]interface{}{
+				"aliases":  map[string]interface{}{},
+				"mappings": idx.mappings,
+				"settings": nestSettings(idx.settings),
+			}
+		}
+		return http.StatusOK, res
+	}
+	return methodNotAllowed(method, names)
+}
+
+func (s *Server) handleSettings(method, names string, body []byte) (int, interface{}) {
+	switch method {
+	case http.MethodGet:
+		res := map[string]interface{}{}
+		for _, name := range strings.Split(names, ",") {
+			idx, ok := s.indices[name]
+			if !ok {
+				return indexNotFound(name)
+			}
+			res[name] = map[string]interface{}{"settings": nestSettings(idx.settings)}
+		}
+		return http.StatusOK, res
+
+	case http.MethodPut:
+		settings, err := decodeObject(body)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+		if nested, ok := settings["settings"].(map[string]interface{}); ok {
+			settings = nested
+		}
+		for _, name := range strings.Split(names, ",") {
+			idx, ok := s.indices[name]
+			if !ok {
+				return indexNotFound(name)
+			}
+			applySettings(idx.settings, settings)
+		}
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+	return methodNotAllowed(method, names+"/_settings")
+}
+
+func (s *Server) handleMapping(method, names string, body []byte) (int, interface{}) {
+	switch method {
+	case http.MethodGet:
+		res := map[string]interface{}{}
+		for _, name := range strings.Split(names, ",") {
+			idx, ok := s.indices[name]
+			if !ok {
+				return indexNotFound(name)
+			}
+			res[name] = map[string]interface{}{"mappings": idx.mappings}
+		}
+		return http.StatusOK, res
+
+	case http.MethodPut, http.MethodPost:
+		mappings, err := decodeObject(body)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+		for _, name := range strings.Split(names, ",") {
+			idx, ok := s.indices[name]
+			if !ok {
+				return indexNotFound(name)
+			}
+			idx.mappings = mergeObjects(idx.mappings, mappings)
+		}
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+	return methodNotAllowed(method, names+"/_mapping")
+}
+
+func (s *Server) handleCount(name string) (int, interface{}) {
+	count := 0
+	for _, target := range s.expandDataStreams([]string{name}) {
+		idx, ok := s.indices[target]
+		if !ok {
+			return indexNotFound(name)
+		}
+		count += len(idx.docs)
+	}
+	res := shards()
+	res["count"] = count
+	return http.StatusOK, res
+}
+
+func indexNotFound(name string) (int, interface{}) {
+	return errorBody(http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+}
+
+func methodNotAllowed(method, path string) (int, interface{}) {
+	return errorBody(http.StatusMethodNotAllowed, "illegal_argument_exception",
+		fmt.Sprintf("method [%s] is not allowed for [/%s]", method, path))
+}
+
+// applySettings は入れ子またはドット区切りの設定を、"index."で始まるドット区切りのキーに展開して反映します。
+// nullを指定した設定は削除し、デフォルト値に戻したものとして扱います。
+func applySettings(dst map[string]string, settings map[string]interface{}) {
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			key := prefix + k
+			if child, ok := v.(map[string]interface{}); ok {
+				walk(key+".", child)
+				continue
+			}
+			if !strings.HasPrefix(key, "index.") {
+				key = "index." + key
+			}
+			if v == nil {
+				delete(dst, key)
+				continue
+			}
+			dst[key] = fmt.Sprint(v)
+		}
+	}
+	walk("", settings)
+}
+
+// nestSettings はドット区切りの設定を、GET _settingsと同じ入れ子の形式に戻します。
+func nestSettings(flat map[string]string) map[string]interface{} {
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	nested := map[string]interface{}{}
+	for _, key := range keys {
+		parts := strings.Split(key, ".")
+		m := nested
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := m[part].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				m[part] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = flat[key]
+	}
+	return nested
+}
+
+// mergeObjects はsrcをdstに再帰的にマージした新しいオブジェクトを返します。
+// マッピングの追加と、部分更新(update API のdoc)に使用します。
+func mergeObjects(dst, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		if child, ok := v.(map[string]interface{}); ok {
+			if current, ok := merged[k].(map[string]interface{}); ok {
+				merged[k] = mergeObjects(current, child)
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,340 @@
+package esfake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pipelineDefinition は取り込みパイプラインの定義のうち、疑似サーバーが実行する部分です。
+type pipelineDefinition struct {
+	Processors []map[string]processorConfig `json:"processors"`
+}
+
+// processorConfig はプロセッサの設定です。フィールドはプロセッサの種類ごとに使うものだけを参照します。
+type processorConfig struct {
+	// Field はremoveでは文字列の配列も指定できるため、使う側でデコードします。
+	Field         json.RawMessage `json:"field"`
+	TargetField   string          `json:"target_field"`
+	Value         json.RawMessage `json:"value"`
+	Override      *bool           `json:"override"`
+	IgnoreMissing bool            `json:"ignore_missing"`
+	Message       string          `json:"message"`
+}
+
+// ingestError はパイプラインの実行に失敗したことを表します。
+type ingestError struct {
+	status  int
+	errType string
+	reason  string
+}
+
+func (e *ingestError) response() (int, interface{}) {
+	return errorBody(e.status, e.errType, e.reason)
+}
+
+// Pipeline は登録された取り込みパイプラインの定義を返します。存在しない場合はfalseを返します。
+func (s *Server) Pipeline(id string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	def, ok := s.pipelines[id]
+	return def, ok
+}
+
+// handleIngest は/_ingest/pipeline以下のエンドポイントを処理します。
+func (s *Server) handleIngest(method string, segments []string, body []byte) (int, interface{}) {
+	if len(segments) == 0 || segments[0] != "pipeline" {
+		return methodNotAllowed(method, "_ingest/"+strings.Join(segments, "/"))
+	}
+	segments = segments[1:]
+
+	switch {
+	case len(segments) == 1 && segments[0] == "_simulate":
+		return s.simulate("", body)
+	case len(segments) == 2 && segments[1] == "_simulate":
+		return s.simulate(segments[0], body)
+	case len(segments) == 1:
+		return s.handlePipeline(method, segments[0], body)
+	}
+	return methodNotAllowed(method, "_ingest/pipeline/"+strings.Join(segments, "/"))
+}
+
+func (s *Server) handlePipeline(method, id string, body []byte) (int, interface{}) {
+	switch method {
+	case http.MethodPut:
+		if _, err := parsePipeline(body); err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+		s.pipelines[id] = append(json.RawMessage(nil), body...)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	case http.MethodGet:
+		def, ok := s.pipelines[id]
+		if !ok {
+			return http.StatusNotFound, map[string]interface{}{}
+		}
+		return http.StatusOK, map[string]json.RawMessage{id: def}
+	case http.MethodDelete:
+		if _, ok := s.pipelines[id]; !ok {
+			return errorBody(http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("pipeline [%s] is missing", id))
+		}
+		delete(s.pipelines, id)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	}
+	return methodNotAllowed(method, "_ingest/pipeline/"+id)
+}
+
+func parsePipeline(data []byte) (*pipelineDefinition, error) {
+	var def pipelineDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+	for _, processor := range def.Processors {
+		if len(processor) != 1 {
+			return nil, fmt.Errorf("processor must have exactly one type")
+		}
+	}
+	return &def, nil
+}
+
+// simulate は_simulate APIを処理します。idが空の場合はボディのpipelineを使用します。
+func (s *Server) simulate(id string, body []byte) (int, interface{}) {
+	var req struct {
+		Pipeline json.RawMessage `json:"pipeline"`
+		Docs     []struct {
+			Index  string          `json:"_index"`
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+		} `json:"docs"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	if len(req.Docs) == 0 {
+		return errorBody(http.StatusBadRequest, "parse_exception", "[docs] required property is missing")
+	}
+
+	var def *pipelineDefinition
+	if id != "" {
+		data, ok := s.pipelines[id]
+		if !ok {
+			return pipelineNotFound(id).response()
+		}
+		def, _ = parsePipeline(data)
+	} else {
+		var err error
+		if def, err = parsePipeline(req.Pipeline); err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+	}
+
+	docs := make([]interface{}, 0, len(req.Docs))
+	for _, doc := range req.Docs {
+		source, ierr := def.run(doc.Source)
+		if ierr != nil {
+			_, res := ierr.response()
+			docs = append(docs, res)
+			continue
+		}
+		index, docID := doc.Index, doc.ID
+		if index == "" {
+			index = "_index"
+		}
+		if docID == "" {
+			docID = "_id"
+		}
+		docs = append(docs, map[string]interface{}{
+			"doc": map[string]interface{}{
+				"_index":   index,
+				"_id":      docID,
+				"_version": "-3",
+				"_source":  source,
+				"_ingest":  map[string]interface{}{"timestamp": time.Now().UTC().Format(time.RFC3339Nano)},
+			},
+		})
+	}
+	return http.StatusOK, map[string]interface{}{"docs": docs}
+}
+
+func pipelineNotFound(id string) *ingestError {
+	return illegalArgument(fmt.Sprintf("pipeline with id [%s] does not exist", id))
+}
+
+// runPipeline は書き込むドキュメントをパイプラインに通します。
+// idが空の場合はインデックスのindex.default_pipelineを使用し、"_none"の場合はパイプラインを使用しません。
+// 呼び出し元でs.muをロックしておく必要があります。
+func (s *Server) runPipeline(idx *index, id string, source json.RawMessage) (json.RawMessage, *ingestError) {
+	if id == "" {
+		id = idx.settings["index.default_pipeline"]
+	}
+	if id == "" || id == "_none" {
+		return source, nil
+	}
+	data, ok := s.pipelines[id]
+	if !ok {
+		return nil, pipelineNotFound(id)
+	}
+	def, _ := parsePipeline(data)
+	return def.run(source)
+}
+
+// run はプロセッサを順に実行します。
+// 実行するのはset、remove、rename、lowercase、uppercase、failのみで、それ以外のプロセッサは何もせずに読み飛ばします。
+func (p *pipelineDefinition) run(source json.RawMessage) (json.RawMessage, *ingestError) {
+	doc, err := decodeObject(source)
+	if err != nil {
+		return nil, &ingestError{status: http.StatusBadRequest, errType: "parse_exception", reason: err.Error()}
+	}
+
+	for _, processor := range p.Processors {
+		for kind, cfg := range processor {
+			if err := cfg.apply(kind, doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, &ingestError{status: http.StatusInternalServerError, errType: "exception", reason: err.Error()}
+	}
+	return data, nil
+}
+
+func (c processorConfig) apply(kind string, doc map[string]interface{}) *ingestError {
+	fields := c.fields()
+	field := ""
+	if len(fields) > 0 {
+		field = fields[0]
+	}
+
+	switch kind {
+	case "set":
+		if _, exists := getField(doc, field); exists && c.Override != nil && !*c.Override {
+			return nil
+		}
+		var value interface{}
+		dec := json.NewDecoder(bytes.NewReader(c.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil {
+			return illegalArgument(fmt.Sprintf("[value] required property is missing for field [%s]", field))
+		}
+		setField(doc, field, value)
+
+	case "remove":
+		for _, f := range fields {
+			if !removeField(doc, f) && !c.IgnoreMissing {
+				return fieldMissing(f)
+			}
+		}
+
+	case "rename":
+		value, ok := getField(doc, field)
+		if !ok {
+			if c.IgnoreMissing {
+				return nil
+			}
+			return fieldMissing(field)
+		}
+		if _, exists := getField(doc, c.TargetField); exists {
+			return illegalArgument(fmt.Sprintf("field [%s] already exists", c.TargetField))
+		}
+		removeField(doc, field)
+		setField(doc, c.TargetField, value)
+
+	case "lowercase", "uppercase":
+		value, ok := getField(doc, field)
+		if !ok {
+			if c.IgnoreMissing {
+				return nil
+			}
+			return fieldMissing(field)
+		}
+		str, ok := value.(string)
+		if !ok {
+			return illegalArgument(fmt.Sprintf("field [%s] of type [%T] cannot be cast to [java.lang.String]", field, value))
+		}
+		if kind == "lowercase" {
+			str = strings.ToLower(str)
+		} else {
+			str = strings.ToUpper(str)
+		}
+		target := c.TargetField
+		if target == "" {
+			target = field
+		}
+		setField(doc, target, str)
+
+	case "fail":
+		return &ingestError{status: http.StatusInternalServerError, errType: "fail_processor_exception", reason: c.Message}
+	}
+	return nil
+}
+
+// fields はfieldを文字列、または文字列の配列として読み取ります。
+func (c processorConfig) fields() []string {
+	var field string
+	if err := json.Unmarshal(c.Field, &field); err == nil {
+		return []string{field}
+	}
+	var fields []string
+	_ = json.Unmarshal(c.Field, &fields)
+	return fields
+}
+
+func illegalArgument(reason string) *ingestError {
+	return &ingestError{status: http.StatusBadRequest, errType: "illegal_argument_exception", reason: reason}
+}
+
+func fieldMissing(field string) *ingestError {
+	return illegalArgument(fmt.Sprintf("field [%s] not present as part of path [%s]", field, field))
+}
+
+// getField はドット区切りのパスで入れ子のフィールドを参照します。
+func getField(doc map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	current := doc
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := current[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = child
+	}
+	value, ok := current[keys[len(keys)-1]]
+	return value, ok
+}
+
+// setField はドット区切りのパスにフィールドを設定します。途中のオブジェクトがない場合は作成します。
+func setField(doc map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := doc
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := current[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			current[key] = child
+		}
+		current = child
+	}
+	current[keys[len(keys)-1]] = value
+}
+
+// removeField はドット区切りのパスのフィールドを削除します。フィールドがない場合はfalseを返します。
+func removeField(doc map[string]interface{}, path string) bool {
+	keys := strings.Split(path, ".")
+	current := doc
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := current[key].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = child
+	}
+	if _, ok := current[keys[len(keys)-1]]; !ok {
+		return false
+	}
+	delete(current, keys[len(keys)-1])
+	return true
+}
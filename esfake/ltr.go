@@ -0,0 +1,216 @@
+package esfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// defaultLTRStore はLearning to Rankプラグインのデフォルトのfeature storeのインデックス名です。
+const defaultLTRStore = ".ltrstore"
+
+func ltrStoreIndex(store string) string {
+	if store == "" {
+		return defaultLTRStore
+	}
+	return defaultLTRStore + "_" + store
+}
+
+// LTRObject はfeature storeに登録されたfeature、featureset、modelを返します。
+// storeが空の場合はデフォルトのfeature storeを参照します。kindは"feature"、"featureset"、"model"のいずれかです。
+func (s *Server) LTRObject(store, kind, name string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.ltrStores[ltrStoreIndex(store)]
+	if !ok {
+		return nil, false
+	}
+	obj, ok := objects[kind+"-"+name]
+	return obj, ok
+}
+
+// handleLTR は/_ltr以下のfeature storeのエンドポイントを処理します。
+// /_ltr/{store}/... のようにstoreを指定しない場合はデフォルトのfeature storeを使用します。
+func (s *Server) handleLTR(method string, segments []string, body []byte) (int, interface{}) {
+	store := ""
+	if len(segments) > 0 && segments[0] != "" && segments[0][0] != '_' {
+		store, segments = segments[0], segments[1:]
+	}
+	storeIndex := ltrStoreIndex(store)
+
+	if len(segments) == 0 {
+		return s.handleLTRStore(method, store, storeIndex)
+	}
+	objects, ok := s.ltrStores[storeIndex]
+	if !ok {
+		return indexNotFound(storeIndex)
+	}
+	if len(segments) < 2 {
+		return methodNotAllowed(method, "_ltr/"+segments[0])
+	}
+
+	kind, name := segments[0][1:], segments[1]
+	if kind != "feature" && kind != "featureset" && kind != "model" {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("unknown ltr object type [%s]", kind))
+	}
+	if len(segments) == 3 && kind == "featureset" && method == http.MethodPost {
+		switch segments[2] {
+		case "_addfeatures":
+			return addFeatures(objects, storeIndex, name, body)
+		case "_createmodel":
+			return createModel(objects, storeIndex, name, body)
+		}
+	}
+	if len(segments) != 2 {
+		return methodNotAllowed(method, "_ltr/"+segments[0]+"/"+name)
+	}
+
+	id := kind + "-" + name
+	switch method {
+	case http.MethodPut, http.MethodPost:
+		if _, exists := objects[id]; exists {
+			return errorBody(http.StatusBadRequest, "version_conflict_engine_exception",
+				fmt.Sprintf("[%s]: version conflict, document already exists", id))
+		}
+		req, err := decodeObject(body)
+		if err != nil {
+			return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+		obj, _ := req[kind].(map[string]interface{})
+		if obj == nil {
+			return errorBody(http.StatusBadRequest, "parsing_exception", fmt.Sprintf("[%s] is required", kind))
+		}
+		return putLTRObject(objects, storeIndex, kind, name, obj, "created")
+	case http.MethodGet:
+		obj, ok := objects[id]
+		if !ok {
+			return http.StatusNotFound, map[string]interface{}{"_index": storeIndex, "_id": id, "found": false}
+		}
+		return http.StatusOK, map[string]interface{}{"_index": storeIndex, "_id": id, "_version": 1, "found": true, "_source": obj}
+	case http.MethodDelete:
+		if _, ok := objects[id]; !ok {
+			return http.StatusNotFound, map[string]interface{}{"_index": storeIndex, "_id": id, "result": "not_found"}
+		}
+		delete(objects, id)
+		return http.StatusOK, map[string]interface{}{"_index": storeIndex, "_id": id, "result": "deleted"}
+	}
+	return methodNotAllowed(method, "_ltr/"+segments[0]+"/"+name)
+}
+
+// handleLTRStore はfeature storeの作成、削除、一覧を処理します。
+func (s *Server) handleLTRStore(method, store, storeIndex string) (int, interface{}) {
+	switch method {
+	case http.MethodPut:
+		if _, ok := s.ltrStores[storeIndex]; ok {
+			return errorBody(http.StatusBadRequest, "resource_already_exists_exception",
+				fmt.Sprintf("index [%s] already exists", storeIndex))
+		}
+		s.ltrStores[storeIndex] = make(map[string]json.RawMessage)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": storeIndex}
+	case http.MethodDelete:
+		if _, ok := s.ltrStores[storeIndex]; !ok {
+			return indexNotFound(storeIndex)
+		}
+		delete(s.ltrStores, storeIndex)
+		return http.StatusOK, map[string]interface{}{"acknowledged": true}
+	case http.MethodGet:
+		if store != "" {
+			return methodNotAllowed(method, "_ltr/"+store)
+		}
+		stores := map[string]interface{}{}
+		names := make([]string, 0, len(s.ltrStores))
+		for name := range s.ltrStores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			label := name
+			if name == defaultLTRStore {
+				label = "_default_"
+			}
+			stores[name] = map[string]interface{}{"store": label, "index": name, "version": 2}
+		}
+		return http.StatusOK, map[string]interface{}{"stores": stores}
+	}
+	return methodNotAllowed(method, "_ltr")
+}
+
+func putLTRObject(objects map[string]json.RawMessage, storeIndex, kind, name string, obj map[string]interface{}, result string) (int, interface{}) {
+	obj["name"] = name
+	data, err := json.Marshal(map[string]interface{}{"name": name, "type": kind, kind: obj})
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	id := kind + "-" + name
+	objects[id] = data
+
+	status := http.StatusOK
+	if result == "created" {
+		status = http.StatusCreated
+	}
+	return status, map[string]interface{}{"_index": storeIndex, "_id": id, "_version": 1, "result": result}
+}
+
+// loadLTRObject は保存したオブジェクトから、kindの内容を取り出します。
+func loadLTRObject(objects map[string]json.RawMessage, kind, name string) (map[string]interface{}, bool) {
+	data, ok := objects[kind+"-"+name]
+	if !ok {
+		return nil, false
+	}
+	stored, err := decodeObject(data)
+	if err != nil {
+		return nil, false
+	}
+	obj, ok := stored[kind].(map[string]interface{})
+	return obj, ok
+}
+
+// addFeatures は既存のfeaturesetの末尾にfeatureを追加します。featuresetが存在しない場合は作成します。
+func addFeatures(objects map[string]json.RawMessage, storeIndex, name string, body []byte) (int, interface{}) {
+	req, err := decodeObject(body)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	added, _ := req["features"].([]interface{})
+	if len(added) == 0 {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", "features must not be empty")
+	}
+
+	featureset, exists := loadLTRObject(objects, "featureset", name)
+	if !exists {
+		featureset = map[string]interface{}{}
+	}
+	features, _ := featureset["features"].([]interface{})
+	featureset["features"] = append(features, added...)
+
+	result := "created"
+	if exists {
+		result = "updated"
+	}
+	return putLTRObject(objects, storeIndex, "featureset", name, featureset, result)
+}
+
+// createModel はfeaturesetを元にmodelを作成します。モデル自体の検証は行いません。
+func createModel(objects map[string]json.RawMessage, storeIndex, featuresetName string, body []byte) (int, interface{}) {
+	featureset, ok := loadLTRObject(objects, "featureset", featuresetName)
+	if !ok {
+		return errorBody(http.StatusNotFound, "resource_not_found_exception",
+			fmt.Sprintf("Unknown featureset [%s]", featuresetName))
+	}
+	req, err := decodeObject(body)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	model, _ := req["model"].(map[string]interface{})
+	name, _ := model["name"].(string)
+	if name == "" {
+		return errorBody(http.StatusBadRequest, "illegal_argument_exception", "model name is required")
+	}
+	if _, exists := objects["model-"+name]; exists {
+		return errorBody(http.StatusBadRequest, "version_conflict_engine_exception",
+			fmt.Sprintf("[model-%s]: version conflict, document already exists", name))
+	}
+	model["feature_set"] = featureset
+	return putLTRObject(objects, storeIndex, "model", name, model, "created")
+}
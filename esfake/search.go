@@ -0,0 +1,103 @@
+package esfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Search は疑似サーバーが受け取った検索リクエストの記録です。
+type Search struct {
+	Index string
+	Body  json.RawMessage
+	// Rescore はリクエストのrescoreです。rescoreがない場合はnilです。
+	Rescore json.RawMessage
+}
+
+// Searches は受け取った検索リクエストを順に返します。
+func (s *Server) Searches() []Search {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Search(nil), s.searches...)
+}
+
+// handleSearch はクエリを実行せずに記録し、インデックス内のドキュメントをIDの順にヒットとして返します。
+// すべてのヒットのスコアは1です。sizeとfromは反映します。
+func (s *Server) handleSearch(names string, body []byte) (int, interface{}) {
+	req, err := decodeObject(body)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+	}
+	search := Search{Index: names, Body: append(json.RawMessage(nil), body...)}
+	if rescore, ok := req["rescore"]; ok {
+		search.Rescore, _ = json.Marshal(rescore)
+	}
+	s.searches = append(s.searches, search)
+
+	var targets []string
+	if names == "" || names == "_all" {
+		for name := range s.indices {
+			targets = append(targets, name)
+		}
+	} else {
+		targets = s.expandDataStreams(strings.Split(names, ","))
+	}
+	sort.Strings(targets)
+
+	type hit struct {
+		Index  string          `json:"_index"`
+		ID     string          `json:"_id"`
+		Score  float64         `json:"_score"`
+		Source json.RawMessage `json:"_source"`
+	}
+	hits := []hit{}
+	for _, name := range targets {
+		idx, ok := s.indices[name]
+		if !ok {
+			return indexNotFound(name)
+		}
+		ids := make([]string, 0, len(idx.docs))
+		for id := range idx.docs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			hits = append(hits, hit{Index: name, ID: id, Score: 1, Source: idx.docs[id].source})
+		}
+	}
+
+	total := len(hits)
+	from, size := intParam(req["from"], 0), intParam(req["size"], 10)
+	if from > len(hits) {
+		from = len(hits)
+	}
+	hits = hits[from:]
+	if size < len(hits) {
+		hits = hits[:size]
+	}
+
+	var maxScore interface{}
+	if len(hits) > 0 {
+		maxScore = 1.0
+	}
+	res := shards()
+	res["took"] = 1
+	res["timed_out"] = false
+	res["hits"] = map[string]interface{}{
+		"total":     map[string]interface{}{"value": total, "relation": "eq"},
+		"max_score": maxScore,
+		"hits":      hits,
+	}
+	return http.StatusOK, res
+}
+
+func intParam(v interface{}, def int) int {
+	if n, ok := v.(json.Number); ok {
+		if i, err := strconv.Atoi(n.String()); err == nil {
+			return i
+		}
+	}
+	return def
+}
@@ -0,0 +1,240 @@
+// Package esfake はテストとベンチマーク用の、プロセス内で動作するElasticsearchの疑似サーバーです。
+//
+// このリポジトリのクライアントが呼び出すエンドポイント(_bulk、ドキュメントのindex/get/delete、
+// インデックスの作成・存在確認・削除・設定・マッピング、_search、_ltrのfeature store、
+// _ingest/pipelineの登録と_simulate、_index_template、_data_streamと_rollover)を、メモリ上のデータで再現します。
+// 検索クエリとrescoreは実行せずに記録し、
+// インデックス内のドキュメントをそのままヒットとして返します。
+// 取り込みパイプラインはset、remove、rename、lowercase、uppercase、failのプロセッサのみを実行します。
+//
+// InjectとInjectItemで、リクエスト単位とBulkのアイテム単位の障害を順番に注入できます。
+package esfake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Version は疑似サーバーが名乗るElasticsearchのバージョンです。
+const Version = "8.18.1"
+
+// Request は疑似サーバーが受け取ったリクエストの記録です。
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   []byte
+}
+
+// Server は疑似Elasticsearchサーバーです。
+type Server struct {
+	// URL はサーバーのベースURLです。
+	URL string
+
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	indices    map[string]*index
+	ltrStores  map[string]map[string]json.RawMessage
+	pipelines  map[string]json.RawMessage
+	templates  map[string]*indexTemplate
+	streams    map[string]*dataStream
+	faults     []*faultState
+	itemFaults []*itemFaultState
+	requests   []Request
+	searches   []Search
+	bulkItems  int
+	autoID     int
+}
+
+// New は疑似サーバーを起動し、テストの終了時に停止するよう登録します。
+func New(tb testing.TB) *Server {
+	tb.Helper()
+	s := &Server{
+		indices:   make(map[string]*index),
+		ltrStores: make(map[string]map[string]json.RawMessage),
+		pipelines: make(map[string]json.RawMessage),
+		templates: make(map[string]*indexTemplate),
+		streams:   make(map[string]*dataStream),
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	tb.Cleanup(s.srv.Close)
+	return s
+}
+
+// Close はサーバーを停止します。Newで登録した停止処理と重複して呼び出しても問題ありません。
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Config は疑似サーバーに接続するためのクライアントの設定を返します。
+// 各モジュールのNewClientにそのまま渡すことができます。
+func (s *Server) Config() elasticsearch.Config {
+	return elasticsearch.Config{Addresses: []string{s.URL}}
+}
+
+// Requests は受け取ったリクエストを順に返します。
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset はデータ、障害、記録をすべて破棄します。
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indices = make(map[string]*index)
+	s.ltrStores = make(map[string]map[string]json.RawMessage)
+	s.pipelines = make(map[string]json.RawMessage)
+	s.templates = make(map[string]*indexTemplate)
+	s.streams = make(map[string]*dataStream)
+	s.faults = nil
+	s.itemFaults = nil
+	s.requests = nil
+	s.searches = nil
+	s.bulkItems = 0
+	s.autoID = 0
+}
+
+// ServeHTTP はリクエストを記録し、注入された障害があればそれを返し、なければエンドポイントに振り分けます。
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Body: body})
+	fault := s.matchFault(r)
+	s.mu.Unlock()
+	if fault != nil {
+		fault.serve(w, r)
+		return
+	}
+
+	status, res := s.route(r, body)
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead || res == nil {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// route はパスのセグメントからエンドポイントを選び、ステータスとレスポンスボディを返します。
+func (s *Server) route(r *http.Request, body []byte) (int, interface{}) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if segments[0] == "" {
+		segments = nil
+	}
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case len(segments) == 0:
+		return http.StatusOK, map[string]interface{}{
+			"name":         "esfake",
+			"cluster_name": "esfake",
+			"version":      map[string]interface{}{"number": Version},
+			"tagline":      "You Know, for Search",
+		}
+	case segments[0] == "_ltr":
+		return s.handleLTR(r.Method, segments[1:], body)
+	case segments[0] == "_ingest":
+		return s.handleIngest(r.Method, segments[1:], body)
+	case segments[0] == "_index_template":
+		return s.handleIndexTemplate(r.Method, segments[1:], body)
+	case segments[0] == "_data_stream" && len(segments) == 2:
+		return s.handleDataStream(r.Method, segments[1])
+	case segments[0] == "_bulk":
+		return s.handleBulk("", q, body)
+	case segments[0] == "_search":
+		return s.handleSearch("", body)
+	case segments[0] == "_refresh":
+		return http.StatusOK, shards()
+	case len(segments) == 1:
+		return s.handleIndex(r.Method, segments[0], q, body)
+	}
+
+	name := segments[0]
+	switch segments[1] {
+	case "_bulk":
+		return s.handleBulk(name, q, body)
+	case "_search":
+		return s.handleSearch(name, body)
+	case "_refresh":
+		return http.StatusOK, shards()
+	case "_count":
+		return s.handleCount(name)
+	case "_settings":
+		return s.handleSettings(r.Method, name, body)
+	case "_mapping":
+		return s.handleMapping(r.Method, name, body)
+	case "_rollover":
+		return s.handleRollover(name, body)
+	case "_doc", "_create", "_update":
+		id := ""
+		if len(segments) > 2 {
+			id = segments[2]
+		}
+		return s.handleDocument(r.Method, segments[1], name, id, q, body)
+	}
+	return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+		fmt.Sprintf("no handler found for uri [%s] and method [%s]", r.URL.Path, r.Method))
+}
+
+// errorBody はElasticsearchと同じ形式のエラーレスポンスを返します。
+func errorBody(status int, errType, reason string) (int, interface{}) {
+	cause := map[string]interface{}{"type": errType, "reason": reason}
+	return status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"root_cause": []interface{}{cause},
+			"type":       errType,
+			"reason":     reason,
+		},
+		"status": status,
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, errType, reason string) {
+	status, body := errorBody(status, errType, reason)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func shards() map[string]interface{} {
+	return map[string]interface{}{
+		"_shards": map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+	}
+}
+
+// decodeObject はボディをJSONオブジェクトとしてデコードします。空のボディは空のオブジェクトとして扱います。
+func decodeObject(body []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return obj, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
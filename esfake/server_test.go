@@ -0,0 +1,499 @@
+package esfake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/versiontype"
+)
+
+func newClients(t *testing.T) (*Server, *elasticsearch.Client, *elasticsearch.TypedClient) {
+	t.Helper()
+	srv := New(t)
+	es, err := elasticsearch.NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	typed, err := elasticsearch.NewTypedClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create typed client: %v", err)
+	}
+	return srv, es, typed
+}
+
+func TestIndices(t *testing.T) {
+	srv, _, typed := newClients(t)
+	ctx := context.Background()
+
+	if _, err := typed.Indices.Create("books").Raw(strings.NewReader(
+		`{"settings":{"number_of_shards":2,"index":{"refresh_interval":"1s"}},"mappings":{"properties":{"title":{"type":"text"}}}}`,
+	)).Do(ctx); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := typed.Indices.Create("books").Do(ctx); err == nil {
+		t.Error("expected an error when creating an existing index")
+	}
+	if exists, err := typed.Indices.Exists("books").Do(ctx); err != nil || !exists {
+		t.Fatalf("exists = %v, %v; want true", exists, err)
+	}
+
+	if _, err := typed.Indices.PutSettings().Indices("books").Raw(strings.NewReader(
+		`{"index.refresh_interval":"-1","index.number_of_replicas":null}`,
+	)).Do(ctx); err != nil {
+		t.Fatalf("put settings failed: %v", err)
+	}
+	if _, err := typed.Indices.PutMapping("books").Raw(strings.NewReader(
+		`{"properties":{"author":{"type":"keyword"}}}`,
+	)).Do(ctx); err != nil {
+		t.Fatalf("put mapping failed: %v", err)
+	}
+
+	idx, ok := srv.Index("books")
+	if !ok {
+		t.Fatal("index not found")
+	}
+	if idx.Settings["index.number_of_shards"] != "2" || idx.Settings["index.refresh_interval"] != "-1" {
+		t.Errorf("settings = %v", idx.Settings)
+	}
+	if _, ok := idx.Settings["index.number_of_replicas"]; ok {
+		t.Error("expected number_of_replicas to be reset by null")
+	}
+	props := idx.Mappings["properties"].(map[string]interface{})
+	if _, ok := props["title"]; !ok {
+		t.Errorf("mappings lost the title field: %v", props)
+	}
+	if _, ok := props["author"]; !ok {
+		t.Errorf("mappings missing the added author field: %v", props)
+	}
+
+	res, err := typed.Indices.GetSettings().Index("books").Perform(ctx)
+	if err != nil {
+		t.Fatalf("get settings failed: %v", err)
+	}
+	defer res.Body.Close()
+	var settings map[string]struct {
+		Settings struct {
+			Index map[string]interface{} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&settings); err != nil {
+		t.Fatalf("failed to decode settings: %v", err)
+	}
+	if got := settings["books"].Settings.Index["refresh_interval"]; got != "-1" {
+		t.Errorf("refresh_interval = %v, want -1", got)
+	}
+
+	if _, err := typed.Indices.Delete("books").Do(ctx); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if exists, _ := typed.Indices.Exists("books").Do(ctx); exists {
+		t.Error("expected the index to be deleted")
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	_, _, typed := newClients(t)
+	ctx := context.Background()
+
+	res, err := typed.Index("books").Id("1").Request(map[string]string{"title": "Batman"}).Do(ctx)
+	if err != nil {
+		t.Fatalf("index failed: %v", err)
+	}
+	if res.Result.Name != "created" || res.SeqNo_ == nil {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+
+	// 古い_seq_noでの書き込みは競合する
+	_, err = typed.Index("books").Id("1").Request(map[string]string{"title": "Robin"}).
+		IfSeqNo("0").IfPrimaryTerm("1").Do(ctx)
+	var esErr *types.ElasticsearchError
+	if !errors.As(err, &esErr) || esErr.Status != http.StatusConflict {
+		t.Fatalf("expected a version conflict, got %v", err)
+	}
+
+	got, err := typed.Get("books", "1").Do(ctx)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if !got.Found || string(got.Source_) != `{"title":"Batman"}` || *got.SeqNo_ != *res.SeqNo_ {
+		t.Errorf("unexpected document: found=%v source=%s", got.Found, got.Source_)
+	}
+
+	if _, err := typed.Index("books").Id("1").Request(map[string]string{"title": "Robin"}).
+		Version("5").VersionType(versiontype.External).Do(ctx); err != nil {
+		t.Fatalf("external version failed: %v", err)
+	}
+	if _, err := typed.Index("books").Id("1").Request(map[string]string{"title": "Joker"}).
+		Version("4").VersionType(versiontype.External).Do(ctx); !errors.As(err, &esErr) || esErr.Status != http.StatusConflict {
+		t.Errorf("expected a conflict for an older external version, got %v", err)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	srv, es, _ := newClients(t)
+	ctx := context.Background()
+
+	// 2件目のアイテムを1回だけ過負荷として拒否する
+	srv.InjectItem(ItemFault{Match: func(item BulkItem) bool { return item.ID == "2" }, Times: 1})
+
+	body := strings.Join([]string{
+		`{"index":{"_id":"1"}}`, `{"title":"Batman"}`,
+		`{"index":{"_id":"2"}}`, `{"title":"Robin"}`,
+		`{"create":{"_id":"1"}}`, `{"title":"Batman"}`,
+		`{"update":{"_id":"1"}}`, `{"doc":{"author":"Bob Kane"}}`,
+		`{"update":{"_id":"9"}}`, `{"doc":{"title":"Joker"},"doc_as_upsert":true}`,
+		`{"delete":{"_id":"404"}}`,
+		"",
+	}, "\n")
+	res, err := es.Bulk(strings.NewReader(body), es.Bulk.WithIndex("books"), es.Bulk.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("bulk failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	var bulk struct {
+		Errors bool
+		Items  []map[string]struct {
+			Status int
+			Result string
+			Error  struct{ Type string }
+		}
+	}
+	if err := json.NewDecoder(res.Body).Decode(&bulk); err != nil {
+		t.Fatalf("failed to decode bulk response: %v", err)
+	}
+	want := []struct {
+		status int
+		result string
+		err    string
+	}{
+		{201, "created", ""},
+		{429, "", "es_rejected_execution_exception"},
+		{409, "", "version_conflict_engine_exception"},
+		{200, "updated", ""},
+		{201, "created", ""},
+		{404, "not_found", ""},
+	}
+	if !bulk.Errors || len(bulk.Items) != len(want) {
+		t.Fatalf("unexpected bulk response: %+v", bulk)
+	}
+	for i, item := range bulk.Items {
+		for _, got := range item {
+			if got.Status != want[i].status || got.Result != want[i].result || got.Error.Type != want[i].err {
+				t.Errorf("item %d = %+v, want %+v", i, got, want[i])
+			}
+		}
+	}
+
+	idx, _ := srv.Index("books")
+	if len(idx.Documents) != 2 {
+		t.Errorf("documents = %v, want 1 and 9", idx.Documents)
+	}
+	if got := string(idx.Documents["1"]); got != `{"author":"Bob Kane","title":"Batman"}` {
+		t.Errorf("document 1 = %s", got)
+	}
+}
+
+func TestBulkIndexerItemFaults(t *testing.T) {
+	srv, es, _ := newClients(t)
+	srv.InjectItem(ItemFault{Skip: 1, Times: 1})
+
+	var failed []string
+	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{Client: es, Index: "books"})
+	if err != nil {
+		t.Fatalf("failed to create bulk indexer: %v", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		err := indexer.Add(context.Background(), esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: id,
+			Body:       strings.NewReader(`{"title":"Batman"}`),
+			OnFailure: func(_ context.Context, item esutil.BulkIndexerItem, _ esutil.BulkIndexerResponseItem, _ error) {
+				failed = append(failed, item.DocumentID)
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to add item: %v", err)
+		}
+	}
+	if err := indexer.Close(context.Background()); err != nil {
+		t.Fatalf("failed to close bulk indexer: %v", err)
+	}
+	if len(failed) != 1 || failed[0] != "2" {
+		t.Errorf("failed = %v, want [2]", failed)
+	}
+	if stats := indexer.Stats(); stats.NumIndexed != 2 || stats.NumFailed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestInject(t *testing.T) {
+	srv, _, typed := newClients(t)
+	srv.Inject(Fault{Method: http.MethodPut, Path: "/*", Skip: 1, Times: 1, Status: http.StatusTooManyRequests})
+
+	ctx := context.Background()
+	if _, err := typed.Indices.Create("a").Do(ctx); err != nil {
+		t.Fatalf("first request should pass: %v", err)
+	}
+	var esErr *types.ElasticsearchError
+	if _, err := typed.Indices.Create("b").Do(ctx); !errors.As(err, &esErr) || esErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("expected the injected fault, got %v", err)
+	}
+	if _, err := typed.Indices.Create("c").Do(ctx); err != nil {
+		t.Fatalf("fault should be exhausted: %v", err)
+	}
+	if _, ok := srv.Index("b"); ok {
+		t.Error("faulted request should not be applied")
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("recorded %d requests, want 3", n)
+	}
+}
+
+func TestSearchRecordsRescore(t *testing.T) {
+	srv, _, typed := newClients(t)
+	ctx := context.Background()
+	for _, id := range []string{"2", "1", "3"} {
+		if _, err := typed.Index("tmdb").Id(id).Request(map[string]string{"title": "Batman " + id}).Do(ctx); err != nil {
+			t.Fatalf("index failed: %v", err)
+		}
+	}
+
+	query := `{"size":2,"query":{"match":{"title":"batman"}},"rescore":{"window_size":10,"query":{"rescore_query":{"sltr":{"model":"latest"}}}}}`
+	res, err := typed.Search().Index("tmdb").Raw(strings.NewReader(query)).Do(ctx)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if res.Hits.Total.Value != 3 || len(res.Hits.Hits) != 2 || *res.Hits.Hits[0].Id_ != "1" {
+		t.Errorf("unexpected hits: total=%d hits=%d", res.Hits.Total.Value, len(res.Hits.Hits))
+	}
+
+	searches := srv.Searches()
+	if len(searches) != 1 || searches[0].Index != "tmdb" {
+		t.Fatalf("searches = %+v", searches)
+	}
+	var rescore struct {
+		WindowSize int `json:"window_size"`
+		Query      struct {
+			RescoreQuery struct {
+				Sltr struct{ Model string } `json:"sltr"`
+			} `json:"rescore_query"`
+		}
+	}
+	if err := json.Unmarshal(searches[0].Rescore, &rescore); err != nil {
+		t.Fatalf("failed to decode rescore: %v", err)
+	}
+	if rescore.WindowSize != 10 || rescore.Query.RescoreQuery.Sltr.Model != "latest" {
+		t.Errorf("rescore = %s", searches[0].Rescore)
+	}
+}
+
+func TestLTRFeatureStore(t *testing.T) {
+	srv, es, _ := newClients(t)
+
+	do := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		res, err := es.Perform(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer res.Body.Close()
+		_, _ = io.Copy(io.Discard, res.Body)
+		return res.StatusCode
+	}
+
+	if status := do(http.MethodPost, "/_ltr/_featureset/movies", `{"featureset":{"features":[]}}`); status != http.StatusNotFound {
+		t.Errorf("featureset without a store: status %d, want 404", status)
+	}
+	if status := do(http.MethodPut, "/_ltr", ""); status != http.StatusOK {
+		t.Fatalf("create store: status %d", status)
+	}
+	if status := do(http.MethodPost, "/_ltr/_featureset/movies", `{"featureset":{"features":[{"name":"title_bm25"}]}}`); status != http.StatusCreated {
+		t.Fatalf("create featureset: status %d", status)
+	}
+	if status := do(http.MethodPost, "/_ltr/_featureset/movies/_addfeatures", `{"features":[{"name":"overview_bm25"}]}`); status != http.StatusOK {
+		t.Fatalf("add features: status %d", status)
+	}
+	if status := do(http.MethodPost, "/_ltr/_featureset/movies/_createmodel", `{"model":{"name":"latest","model":{"type":"model/ranklib"}}}`); status != http.StatusCreated {
+		t.Fatalf("create model: status %d", status)
+	}
+
+	data, ok := srv.LTRObject("", "model", "latest")
+	if !ok {
+		t.Fatal("model not found")
+	}
+	var model struct {
+		Model struct {
+			FeatureSet struct {
+				Features []struct{ Name string }
+			} `json:"feature_set"`
+		}
+	}
+	if err := json.Unmarshal(data, &model); err != nil {
+		t.Fatalf("failed to decode model: %v", err)
+	}
+	if features := model.Model.FeatureSet.Features; len(features) != 2 || features[1].Name != "overview_bm25" {
+		t.Errorf("model features = %+v", features)
+	}
+}
+
+func TestIngestPipeline(t *testing.T) {
+	srv, es, typed := newClients(t)
+	ctx := context.Background()
+
+	if _, err := typed.Ingest.PutPipeline("normalize").Raw(strings.NewReader(
+		`{"processors":[{"lowercase":{"field":"genre"}},{"rename":{"field":"name","target_field":"title"}},{"set":{"field":"meta.source","value":"import"}},{"remove":{"field":"tmp","ignore_missing":true}}]}`,
+	)).Do(ctx); err != nil {
+		t.Fatalf("put pipeline failed: %v", err)
+	}
+	if _, ok := srv.Pipeline("normalize"); !ok {
+		t.Fatal("expected the pipeline to be stored")
+	}
+
+	// Bulkのクエリで指定したパイプラインは、アイテムのpipelineで上書きできます。
+	body := strings.Join([]string{
+		`{"index":{"_id":"1"}}`, `{"name":"Batman","genre":"HERO","tmp":1}`,
+		`{"index":{"_id":"2","pipeline":"_none"}}`, `{"name":"Robin"}`,
+		`{"index":{"_id":"3","pipeline":"missing"}}`, `{"name":"Joker"}`,
+		`{"index":{"_id":"4"}}`, `{"genre":"villain"}`,
+		"",
+	}, "\n")
+	res, err := es.Bulk(strings.NewReader(body), es.Bulk.WithIndex("books"), es.Bulk.WithPipeline("normalize"), es.Bulk.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("bulk failed: %v", err)
+	}
+	res.Body.Close()
+
+	idx, _ := srv.Index("books")
+	if got := string(idx.Documents["1"]); got != `{"genre":"hero","meta":{"source":"import"},"title":"Batman"}` {
+		t.Errorf("document 1 = %s", got)
+	}
+	if got := string(idx.Documents["2"]); got != `{"name":"Robin"}` {
+		t.Errorf("document 2 = %s", got)
+	}
+	if _, ok := idx.Documents["3"]; ok {
+		t.Error("expected document 3 to be rejected for a missing pipeline")
+	}
+	if _, ok := idx.Documents["4"]; ok {
+		t.Error("expected document 4 to be rejected for a missing field")
+	}
+
+	sim, err := typed.Ingest.Simulate().Id("normalize").Docs(
+		types.Document{Source_: json.RawMessage(`{"name":"Batman","genre":"HERO"}`)},
+		types.Document{Source_: json.RawMessage(`{"genre":"HERO"}`)},
+	).Do(ctx)
+	if err != nil {
+		t.Fatalf("simulate failed: %v", err)
+	}
+	if len(sim.Docs) != 2 || sim.Docs[0].Doc == nil || string(sim.Docs[0].Doc.Source_["title"]) != `"Batman"` {
+		t.Fatalf("unexpected simulate response: %+v", sim.Docs)
+	}
+	if sim.Docs[1].Error == nil || sim.Docs[1].Error.Type != "illegal_argument_exception" {
+		t.Errorf("expected the second document to fail, got %+v", sim.Docs[1])
+	}
+	if _, ok := srv.Index("_index"); ok {
+		t.Error("simulate must not write documents")
+	}
+
+	if _, err := typed.Ingest.DeletePipeline("normalize").Do(ctx); err != nil {
+		t.Fatalf("delete pipeline failed: %v", err)
+	}
+	if _, err := typed.Ingest.Simulate().Id("normalize").Docs(types.Document{Source_: json.RawMessage(`{}`)}).Do(ctx); err == nil {
+		t.Error("expected an error for a deleted pipeline")
+	}
+}
+
+func TestDataStreams(t *testing.T) {
+	srv, es, _ := newClients(t)
+	ctx := context.Background()
+
+	res, err := es.Indices.PutIndexTemplate("logs", strings.NewReader(`{"index_patterns":["logs-*"],"data_stream":{},"template":{"settings":{"number_of_shards":2}}}`))
+	if err != nil || res.IsError() {
+		t.Fatalf("failed to put index template: %v, %v", res, err)
+	}
+
+	body := strings.Join([]string{
+		`{"index":{}}`, `{"@timestamp":"2026-10-18T00:00:00Z"}`,
+		`{"create":{}}`, `{"message":"no timestamp"}`,
+		`{"create":{}}`, `{"@timestamp":"2026-10-18T00:00:00Z","message":"a"}`,
+		`{"create":{}}`, `{"@timestamp":"2026-10-18T00:00:01Z","message":"b"}`,
+		"",
+	}, "\n")
+	res, err = es.Bulk(strings.NewReader(body), es.Bulk.WithIndex("logs-app"), es.Bulk.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("bulk failed: %v", err)
+	}
+	var bulk struct {
+		Items []map[string]struct {
+			Index  string `json:"_index"`
+			Status int
+			Error  struct{ Type string }
+		}
+	}
+	if err := json.NewDecoder(res.Body).Decode(&bulk); err != nil {
+		t.Fatalf("failed to decode bulk response: %v", err)
+	}
+	res.Body.Close()
+	wantErrors := []string{"illegal_argument_exception", "document_parsing_exception", "", ""}
+	for i, item := range bulk.Items {
+		for _, got := range item {
+			if got.Error.Type != wantErrors[i] {
+				t.Errorf("item %d = %+v, want error %q", i, got, wantErrors[i])
+			}
+			if got.Error.Type == "" && !strings.HasPrefix(got.Index, ".ds-logs-app-") {
+				t.Errorf("item %d was written to %s, want a backing index", i, got.Index)
+			}
+		}
+	}
+
+	ds, ok := srv.DataStream("logs-app")
+	if !ok || ds.Generation != 1 {
+		t.Fatalf("expected the data stream to be created on the first write, got %+v", ds)
+	}
+	if idx, _ := srv.Index(ds.Indices[0]); len(idx.Documents) != 2 || idx.Settings["index.number_of_shards"] != "2" {
+		t.Errorf("unexpected backing index: %+v", idx)
+	}
+
+	rollover := func(conditions string) bool {
+		t.Helper()
+		res, err := es.Indices.Rollover("logs-app", es.Indices.Rollover.WithBody(strings.NewReader(conditions)))
+		if err != nil || res.IsError() {
+			t.Fatalf("rollover failed: %v, %v", res, err)
+		}
+		defer res.Body.Close()
+		var r struct {
+			RolledOver bool `json:"rolled_over"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&r)
+		return r.RolledOver
+	}
+	if rollover(`{"conditions":{"max_docs":3,"max_primary_shard_size":"1kb"}}`) {
+		t.Error("expected no rollover before the conditions are met")
+	}
+	if !rollover(`{"conditions":{"max_docs":2}}`) {
+		t.Error("expected a rollover when max_docs is met")
+	}
+	if ds, _ := srv.DataStream("logs-app"); ds.Generation != 2 || len(ds.Indices) != 2 {
+		t.Errorf("expected a second backing index, got %+v", ds)
+	}
+
+	res, err = es.Count(es.Count.WithIndex("logs-app"))
+	if err != nil || res.IsError() {
+		t.Fatalf("count failed: %v, %v", res, err)
+	}
+	defer res.Body.Close()
+	var count struct{ Count int }
+	if err := json.NewDecoder(res.Body).Decode(&count); err != nil || count.Count != 2 {
+		t.Errorf("expected 2 documents across the backing indices, got %d (%v)", count.Count, err)
+	}
+}
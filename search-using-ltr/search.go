@@ -3,15 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client struct {
 	baseClient  *elasticsearch.Client
 	typedClient *elasticsearch.TypedClient
+	telemetry   *telemetry
 }
 
 func NewClient(cfg elasticsearch.Config) (*Client, error) {
@@ -28,6 +37,7 @@ func NewClient(cfg elasticsearch.Config) (*Client, error) {
 	return &Client{
 		baseClient:  baseClient,
 		typedClient: typedClient,
+		telemetry:   newTelemetry(otel.GetTracerProvider(), otel.GetMeterProvider()),
 	}, nil
 }
 
@@ -38,8 +48,32 @@ type result struct {
 	Score       float64 `json:"_score"`
 }
 
-func (c *Client) Search(ctx context.Context, index string, queryBuilder QueryBuilder) ([]result, error) {
-	query, err := queryBuilder.Build()
+// Search builds the query with queryBuilder and runs it against index.
+// It records a span for the search with a child span for the query build,
+// and the search latency labelled with the query builder type.
+func (c *Client) Search(ctx context.Context, index string, queryBuilder QueryBuilder) (results []result, err error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "elasticsearch"),
+		attribute.String("elasticsearch.index", index),
+		attribute.String("query_builder.type", queryBuilderType(queryBuilder)),
+	}
+	start := time.Now()
+	ctx, span := c.telemetry.tracer.Start(ctx, "elasticsearch.search",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			attrs = append(attrs, attribute.String("error.type", errorType(err)))
+		} else {
+			span.SetAttributes(attribute.Int("elasticsearch.search.hits", len(results)))
+		}
+		span.End()
+		c.telemetry.searchDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	}()
+
+	query, err := c.buildQuery(ctx, queryBuilder)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
 	}
@@ -52,7 +86,6 @@ func (c *Client) Search(ctx context.Context, index string, queryBuilder QueryBui
 		return nil, fmt.Errorf("failed to execute search query: %w", err)
 	}
 
-	var results []result
 	for _, hit := range res.Hits.Hits {
 		var r result
 		if err := json.Unmarshal(hit.Source_, &r); err != nil {
@@ -64,3 +97,28 @@ func (c *Client) Search(ctx context.Context, index string, queryBuilder QueryBui
 	}
 	return results, nil
 }
+
+// buildQuery runs queryBuilder.Build inside its own span.
+func (c *Client) buildQuery(ctx context.Context, queryBuilder QueryBuilder) ([]byte, error) {
+	_, span := c.telemetry.tracer.Start(ctx, "ltr.query.build",
+		trace.WithAttributes(attribute.String("query_builder.type", queryBuilderType(queryBuilder))))
+	defer span.End()
+
+	query, err := queryBuilder.Build()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("ltr.query.size", len(query)))
+	return query, nil
+}
+
+// errorType returns the Elasticsearch error type of err, or "_OTHER" if it did not come from Elasticsearch.
+func errorType(err error) string {
+	var esErr *types.ElasticsearchError
+	if errors.As(err, &esErr) && esErr.ErrorCause.Type != "" {
+		return esErr.ErrorCause.Type
+	}
+	return "_OTHER"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+)
+
+func TestSearchSendsLTRRescore(t *testing.T) {
+	srv := esfake.New(t)
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	ctx := context.Background()
+	for id, doc := range map[string]string{
+		"268": `{"id":"268","title":"Batman","release_year":"1989"}`,
+		"272": `{"id":"272","title":"Batman Begins","release_year":"2005"}`,
+	} {
+		if _, err := client.typedClient.Index("tmdb").Id(id).Raw(strings.NewReader(doc)).Do(ctx); err != nil {
+			t.Fatalf("failed to index document: %v", err)
+		}
+	}
+
+	builder := NewLTRQueryBuilder(CreateMatchQuery("title", "batman"), "latest").WithWindowSize(500)
+	res, err := client.Search(ctx, "tmdb", builder)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(res) != 2 || res[0].Title != "Batman" {
+		t.Errorf("unexpected results: %+v", res)
+	}
+
+	searches := srv.Searches()
+	if len(searches) != 1 {
+		t.Fatalf("expected 1 search request, got %d", len(searches))
+	}
+	var rescore struct {
+		WindowSize int `json:"window_size"`
+		Query      struct {
+			RescoreQuery struct {
+				SLTR struct {
+					Model string `json:"model"`
+				} `json:"sltr"`
+			} `json:"rescore_query"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(searches[0].Rescore, &rescore); err != nil {
+		t.Fatalf("failed to decode rescore: %v", err)
+	}
+	if rescore.WindowSize != 500 || rescore.Query.RescoreQuery.SLTR.Model != "latest" {
+		t.Errorf("unexpected rescore: %s", searches[0].Rescore)
+	}
+}
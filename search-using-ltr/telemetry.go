@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the instrumentation scope used for spans and metrics.
+const instrumentationName = "github.com/kurakura967/go-elasticsearch-playground/search-using-ltr"
+
+// WithTracerProvider sets the TracerProvider used to record search and query build spans.
+// By default the global TracerProvider returned by otel.GetTracerProvider is used.
+func (c *Client) WithTracerProvider(tp trace.TracerProvider) *Client {
+	c.telemetry = newTelemetry(tp, c.telemetry.meterProvider)
+	return c
+}
+
+// WithMeterProvider sets the MeterProvider used to record search latency.
+// By default the global MeterProvider returned by otel.GetMeterProvider is used.
+func (c *Client) WithMeterProvider(mp metric.MeterProvider) *Client {
+	c.telemetry = newTelemetry(c.telemetry.tracerProvider, mp)
+	return c
+}
+
+// telemetry holds the instruments used by Client.Search.
+type telemetry struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+
+	tracer         trace.Tracer
+	searchDuration metric.Float64Histogram
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	t := &telemetry{
+		tracerProvider: tp,
+		meterProvider:  mp,
+		tracer:         tp.Tracer(instrumentationName),
+	}
+	var err error
+	t.searchDuration, err = mp.Meter(instrumentationName).Float64Histogram("elasticsearch.search.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of search requests, including building the query."))
+	if err != nil {
+		// A broken instrument must not break searching, so report it and record nothing.
+		otel.Handle(err)
+		t.searchDuration, _ = metricnoop.NewMeterProvider().Meter(instrumentationName).Float64Histogram("elasticsearch.search.duration")
+	}
+	return t
+}
+
+// queryBuilderType returns the value of the query_builder.type attribute for the builder.
+// Known builders are reported with their QueryType; others fall back to their Go type name.
+func queryBuilderType(queryBuilder QueryBuilder) string {
+	switch queryBuilder.(type) {
+	case *LTRQueryBuilder:
+		return string(QueryTypeLTR)
+	case SimpleLTRQueryBuilder, *SimpleLTRQueryBuilder:
+		return string(QueryTypeSimpleLTR)
+	case *StringLTRQueryBuilder:
+		return string(QueryTypeStringLTR)
+	}
+	return fmt.Sprintf("%T", queryBuilder)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kurakura967/go-elasticsearch-playground/esfake"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingQueryBuilder is a QueryBuilder whose Build always fails.
+type failingQueryBuilder struct{}
+
+func (failingQueryBuilder) Build() ([]byte, error) {
+	return nil, errors.New("broken query")
+}
+
+func TestSearchTelemetry(t *testing.T) {
+	srv := esfake.New(t)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	client, err := NewClient(srv.Config())
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	client.WithTracerProvider(tp).WithMeterProvider(mp)
+
+	ctx := context.Background()
+	if _, err := client.typedClient.Index("tmdb").Id("268").Raw(strings.NewReader(`{"id":"268","title":"Batman"}`)).Do(ctx); err != nil {
+		t.Fatalf("failed to index document: %v", err)
+	}
+
+	builders := []QueryBuilder{
+		NewLTRQueryBuilder(CreateMatchQuery("title", "batman"), "latest"),
+		NewSimpleLTRQueryBuilder(CreateMatchQuery("title", "batman"), "latest"),
+		NewStringLTRQueryBuilder("batman", "latest"),
+	}
+	for _, builder := range builders {
+		if _, err := client.Search(ctx, "tmdb", builder); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+	}
+	if _, err := client.Search(ctx, "tmdb", failingQueryBuilder{}); err == nil {
+		t.Fatal("expected an error from the failing builder")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 8 {
+		t.Fatalf("expected a search and a query build span per search, got %d spans", len(spans))
+	}
+	for i := 0; i < len(spans); i += 2 {
+		build, search := spans[i], spans[i+1]
+		if build.Name != "ltr.query.build" || search.Name != "elasticsearch.search" {
+			t.Fatalf("unexpected span names: %s, %s", build.Name, search.Name)
+		}
+		if build.Parent.SpanID() != search.SpanContext.SpanID() {
+			t.Errorf("expected the query build span to be a child of the search span")
+		}
+	}
+	if spans[7].Status.Description == "" || len(spans[7].Events) == 0 {
+		t.Errorf("expected the failed search to record the error, got %+v", spans[7].Status)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("failed to collect metrics: %v", err)
+	}
+	counts := map[string]uint64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "elasticsearch.search.duration" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints {
+				builder, _ := dp.Attributes.Value("query_builder.type")
+				counts[builder.AsString()] += dp.Count
+			}
+		}
+	}
+	want := map[string]uint64{"ltr": 1, "simple-ltr": 1, "string-ltr": 1, "main.failingQueryBuilder": 1}
+	for builder, n := range want {
+		if counts[builder] != n {
+			t.Errorf("search count for %s = %d, want %d (all: %v)", builder, counts[builder], n, counts)
+		}
+	}
+}